@@ -8,10 +8,15 @@ package crowd
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 import (
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ////////////////////////////////////////////////////////////////////////////////// //
@@ -22,10 +27,44 @@ const (
 	GROUP_NESTED = "nested"
 )
 
+// GROUP_TYPE_GROUP is type of regular groups
+const GROUP_TYPE_GROUP = "GROUP"
+
+// Attributes merge strategies
+const (
+	// MERGE_REPLACE replaces values of existing attributes with new values
+	MERGE_REPLACE MergeStrategy = iota
+
+	// MERGE_KEEP keeps values of existing attributes
+	MERGE_KEEP
+
+	// MERGE_UNION merges values of existing attributes with new values
+	MERGE_UNION
+)
+
+// TIME_UNIX_MILLI is layout for time values stored as milliseconds since epoch
+// (Crowd uses it for lastAuthenticated, passwordLastChanged and lastActive
+// attributes)
+const TIME_UNIX_MILLI = "unixmilli"
+
+// FETCH_PAGE_SIZE is number of entities requested per page by FetchAll
+const FETCH_PAGE_SIZE = 1000
+
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// MergeStrategy is strategy for merging attributes
+type MergeStrategy uint8
+
+// AttributeError is error of reading typed attribute value
+type AttributeError struct {
+	Name  string // Attribute name
+	Value string // Raw attribute value
+	Err   error  // Parsing error
+}
+
 // crowdError is crowd error struct
 type crowdError struct {
+	Reason  string `xml:"reason"`
 	Message string `xml:"message"`
 }
 
@@ -84,6 +123,13 @@ type UserAttributes struct {
 	Attributes []*Attribute `xml:"attribute"`
 }
 
+// AttributesDiff contains difference between two sets of attributes
+type AttributesDiff struct {
+	Added   Attributes // Attributes which exist only in new set
+	Changed Attributes // Attributes with changed values (with new values)
+	Removed Attributes // Attributes which exist only in old set
+}
+
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 // ListingOptions contains options for request with listing objects
@@ -94,6 +140,28 @@ type ListingOptions struct {
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// Attribute errors
+var (
+	ErrAttrNotFound    = errors.New("Attribute not found")
+	ErrAttrMultiValued = errors.New("Attribute has more than one value")
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// AttributesFromMap creates attributes from map name → values. Attributes are
+// sorted by name.
+func AttributesFromMap(m map[string][]string) Attributes {
+	result := make(Attributes, 0, len(m))
+
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		result = append(result, &Attribute{Name: name, Values: slices.Clone(m[name])})
+	}
+
+	return result
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
 // String convert user info to string
 func (u *UserInfo) String() string {
 	return u.Name
@@ -145,6 +213,255 @@ func (a Attributes) Get(name string) string {
 	return ""
 }
 
+// GetInt returns value of single-valued attribute as int
+func (a Attributes) GetInt(name string) (int, error) {
+	value, err := a.getSingle(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := strconv.Atoi(strings.TrimSpace(value))
+
+	if err != nil {
+		return 0, &AttributeError{name, value, err}
+	}
+
+	return result, nil
+}
+
+// GetBool returns value of single-valued attribute as bool
+func (a Attributes) GetBool(name string) (bool, error) {
+	value, err := a.getSingle(name)
+
+	if err != nil {
+		return false, err
+	}
+
+	result, err := strconv.ParseBool(strings.TrimSpace(value))
+
+	if err != nil {
+		return false, &AttributeError{name, value, err}
+	}
+
+	return result, nil
+}
+
+// GetTime returns value of single-valued attribute as time. If layout is
+// empty, value is decoded as RFC3339. Use TIME_UNIX_MILLI layout for values
+// stored as milliseconds since epoch (i.e. lastAuthenticated).
+func (a Attributes) GetTime(name, layout string) (time.Time, error) {
+	value, err := a.getSingle(name)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	result, err := parseTime(strings.TrimSpace(value), layout)
+
+	if err != nil {
+		return time.Time{}, &AttributeError{name, value, err}
+	}
+
+	return result, nil
+}
+
+// GetDuration returns value of single-valued attribute as duration
+func (a Attributes) GetDuration(name string) (time.Duration, error) {
+	value, err := a.getSingle(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := time.ParseDuration(strings.TrimSpace(value))
+
+	if err != nil {
+		return 0, &AttributeError{name, value, err}
+	}
+
+	return result, nil
+}
+
+// GetJSON decodes JSON-encoded value of single-valued attribute into v
+func (a Attributes) GetJSON(name string, v any) error {
+	value, err := a.getSingle(name)
+
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal([]byte(value), v)
+
+	if err != nil {
+		return &AttributeError{name, value, err}
+	}
+
+	return nil
+}
+
+// Names returns names of all attributes
+func (a Attributes) Names() []string {
+	var result []string
+
+	for _, attr := range a {
+		result = append(result, attr.Name)
+	}
+
+	return result
+}
+
+// Set sets values of attribute with given name (attribute will be created if
+// it doesn't exist)
+func (a *Attributes) Set(name string, values ...string) {
+	index := a.index(name)
+
+	if index == -1 {
+		a.append(&Attribute{Name: name, Values: slices.Clone(values)})
+	} else {
+		a.replace(index, &Attribute{Name: name, Values: slices.Clone(values)})
+	}
+}
+
+// Add adds value to attribute with given name (attribute will be created if it
+// doesn't exist). Value is not added if attribute already contains it.
+func (a *Attributes) Add(name, value string) {
+	index := a.index(name)
+
+	switch {
+	case index == -1:
+		a.append(&Attribute{Name: name, Values: []string{value}})
+	case !slices.Contains((*a)[index].Values, value):
+		a.replace(index, &Attribute{
+			Name:   name,
+			Values: append(slices.Clone((*a)[index].Values), value),
+		})
+	}
+}
+
+// RemoveValue removes value from attribute with given name. Attribute is removed
+// if it doesn't contain any values anymore.
+func (a *Attributes) RemoveValue(name, value string) {
+	index := a.index(name)
+
+	if index == -1 {
+		return
+	}
+
+	values := slices.DeleteFunc(
+		slices.Clone((*a)[index].Values),
+		func(v string) bool { return v == value },
+	)
+
+	if len(values) == 0 {
+		a.Delete(name)
+	} else {
+		a.replace(index, &Attribute{Name: name, Values: values})
+	}
+}
+
+// Delete removes attribute with given name
+func (a *Attributes) Delete(name string) {
+	if a.index(name) == -1 {
+		return
+	}
+
+	result := make(Attributes, 0, len(*a)-1)
+
+	for _, attr := range *a {
+		if attr.Name != name {
+			result = append(result, attr)
+		}
+	}
+
+	*a = result
+}
+
+// Clone returns deep copy of attributes
+func (a Attributes) Clone() Attributes {
+	if a == nil {
+		return nil
+	}
+
+	result := make(Attributes, 0, len(a))
+
+	for _, attr := range a {
+		result = append(result, &Attribute{Name: attr.Name, Values: slices.Clone(attr.Values)})
+	}
+
+	return result
+}
+
+// Merge returns new set of attributes which contains attributes from both sets
+// merged using given strategy
+func (a Attributes) Merge(other Attributes, strategy MergeStrategy) Attributes {
+	result := a.Clone()
+
+	for _, attr := range other {
+		switch {
+		case !result.Has(attr.Name), strategy == MERGE_REPLACE:
+			result.Set(attr.Name, attr.Values...)
+		case strategy == MERGE_UNION:
+			for _, value := range attr.Values {
+				result.Add(attr.Name, value)
+			}
+		}
+	}
+
+	return result
+}
+
+// ToMap converts attributes to map name → values
+func (a Attributes) ToMap() map[string][]string {
+	result := make(map[string][]string, len(a))
+
+	for _, attr := range a {
+		result[attr.Name] = slices.Clone(attr.Values)
+	}
+
+	return result
+}
+
+// Diff returns difference between current attributes and given attributes. Order
+// of values is ignored.
+func (a Attributes) Diff(other Attributes) AttributesDiff {
+	var diff AttributesDiff
+
+	for _, attr := range other {
+		if !a.Has(attr.Name) {
+			diff.Added = append(diff.Added, attr)
+		} else if !isSameValues(a.GetList(attr.Name), attr.Values) {
+			diff.Changed = append(diff.Changed, attr)
+		}
+	}
+
+	for _, attr := range a {
+		if !other.Has(attr.Name) {
+			diff.Removed = append(diff.Removed, attr)
+		}
+	}
+
+	return diff
+}
+
+// IsEmpty returns true if there are no differences
+func (d AttributesDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// Names returns names of all affected attributes
+func (d AttributesDiff) Names() []string {
+	var result []string
+
+	for _, attrs := range []Attributes{d.Added, d.Changed, d.Removed} {
+		for _, attr := range attrs {
+			result = append(result, attr.Name)
+		}
+	}
+
+	return result
+}
+
 // Encode encodes listing options to URL query string part
 func (l ListingOptions) Encode() string {
 	var result string
@@ -160,7 +477,84 @@ func (l ListingOptions) Encode() string {
 	return result
 }
 
+// Error returns error message
+func (e *AttributeError) Error() string {
+	switch e.Err {
+	case ErrAttrNotFound:
+		return fmt.Sprintf("Attribute %q not found", e.Name)
+	case ErrAttrMultiValued:
+		return fmt.Sprintf("Attribute %q has more than one value", e.Name)
+	}
+
+	return fmt.Sprintf("Can't parse value %q of attribute %q: %v", e.Value, e.Name, e.Err)
+}
+
+// Unwrap returns underlying error
+func (e *AttributeError) Unwrap() error {
+	return e.Err
+}
+
 // Error returns crowd error as standard error struct
 func (e crowdError) Error() error {
 	return errors.New(e.Message)
 }
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getSingle returns value of single-valued attribute
+func (a Attributes) getSingle(name string) (string, error) {
+	if !a.Has(name) {
+		return "", &AttributeError{Name: name, Err: ErrAttrNotFound}
+	}
+
+	values := a.GetList(name)
+
+	switch len(values) {
+	case 0:
+		return "", &AttributeError{Name: name, Err: ErrAttrNotFound}
+	case 1:
+		return values[0], nil
+	}
+
+	return "", &AttributeError{Name: name, Err: ErrAttrMultiValued}
+}
+
+// updated returns added and changed attributes
+func (d AttributesDiff) updated() Attributes {
+	return append(slices.Clone(d.Added), d.Changed...)
+}
+
+// append adds new attribute. Attributes may share backing array with other
+// slices, so new array is always allocated.
+func (a *Attributes) append(attr *Attribute) {
+	*a = append(slices.Clip(*a), attr)
+}
+
+// replace replaces attribute with given index. Attributes and their values may
+// be shared with other slices, so they are never modified in place.
+func (a *Attributes) replace(index int, attr *Attribute) {
+	result := slices.Clone(*a)
+	result[index] = attr
+	*a = result
+}
+
+// index returns index of attribute with given name
+func (a *Attributes) index(name string) int {
+	return slices.IndexFunc(*a, func(attr *Attribute) bool {
+		return attr.Name == name
+	})
+}
+
+// isSameValues returns true if both slices contain the same values in any order
+func isSameValues(v1, v2 []string) bool {
+	if len(v1) != len(v2) {
+		return false
+	}
+
+	v1, v2 = slices.Clone(v1), slices.Clone(v2)
+
+	slices.Sort(v1)
+	slices.Sort(v2)
+
+	return slices.Equal(v1, v2)
+}
@@ -0,0 +1,484 @@
+// Package authz provides group-based authorization policies backed by Crowd
+package authz
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/essentialkaos/go-crowd/v3"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Rule effects
+const (
+	EFFECT_ALLOW Effect = iota
+	EFFECT_DENY
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Effect is effect of matched rule
+type Effect uint8
+
+// Authorizer evaluates policies for Crowd users
+type Authorizer struct {
+	api *crowd.API
+}
+
+// Policy is set of rules. Deny rules take precedence over allow rules, if no
+// rule matches, access is denied.
+type Policy struct {
+	Rules []*Rule
+}
+
+// Rule is named condition with effect
+type Rule struct {
+	Name      string
+	Effect    Effect
+	Condition Condition
+}
+
+// Decision is result of policy evaluation
+type Decision struct {
+	Allowed bool   // Access is allowed
+	Rule    string // Name of matched rule (empty if no rule matched)
+	Reason  string // Human-readable explanation
+}
+
+// Condition is condition for subject
+type Condition interface {
+	// Check checks if subject matches condition
+	Check(s *Subject) (bool, error)
+
+	// String returns human-readable condition description
+	String() string
+}
+
+// Subject is user which is authorized. Subject memoizes groups and attributes
+// lookups, so it must be created once per request.
+type Subject struct {
+	Name string
+
+	api *crowd.API
+	mu  sync.Mutex
+
+	directGroups []string
+	nestedGroups []string
+	attributes   crowd.Attributes
+
+	hasDirectGroups bool
+	hasNestedGroups bool
+	hasAttributes   bool
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// funcCondition is condition defined by function
+type funcCondition struct {
+	desc string
+	fn   func(s *Subject) (bool, error)
+}
+
+// logicCondition is AND/OR composition of conditions
+type logicCondition struct {
+	op         string
+	conditions []Condition
+}
+
+// notCondition is negated condition
+type notCondition struct {
+	condition Condition
+}
+
+// contextKey is type for context keys
+type contextKey uint8
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// subjectKey is context key for subject
+const subjectKey contextKey = 0
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// New creates new authorizer
+func New(api *crowd.API) *Authorizer {
+	return &Authorizer{api: api}
+}
+
+// NewPolicy creates new policy with given rules
+func NewPolicy(rules ...*Rule) *Policy {
+	return &Policy{Rules: rules}
+}
+
+// Allow creates rule which allows access if condition matches
+func Allow(name string, cond Condition) *Rule {
+	return &Rule{Name: name, Effect: EFFECT_ALLOW, Condition: cond}
+}
+
+// Deny creates rule which denies access if condition matches
+func Deny(name string, cond Condition) *Rule {
+	return &Rule{Name: name, Effect: EFFECT_DENY, Condition: cond}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// MemberOf returns condition which matches direct or nested members of any
+// of given groups
+func MemberOf(groups ...string) Condition {
+	return Func(
+		fmt.Sprintf("member of any of %q", groups),
+		func(s *Subject) (bool, error) {
+			return s.isMember(groups, true, false)
+		},
+	)
+}
+
+// DirectMemberOf returns condition which matches direct members of any of
+// given groups
+func DirectMemberOf(groups ...string) Condition {
+	return Func(
+		fmt.Sprintf("direct member of any of %q", groups),
+		func(s *Subject) (bool, error) {
+			return s.isMember(groups, false, false)
+		},
+	)
+}
+
+// MemberOfAll returns condition which matches direct or nested members of all
+// given groups
+func MemberOfAll(groups ...string) Condition {
+	return Func(
+		fmt.Sprintf("member of all of %q", groups),
+		func(s *Subject) (bool, error) {
+			return s.isMember(groups, true, true)
+		},
+	)
+}
+
+// AttrEquals returns condition which matches users with attribute which has
+// given value
+func AttrEquals(name, value string) Condition {
+	return AttrIn(name, value)
+}
+
+// AttrIn returns condition which matches users with attribute which has any
+// of given values
+func AttrIn(name string, values ...string) Condition {
+	desc := fmt.Sprintf("attribute %q == %q", name, values)
+
+	if len(values) == 1 {
+		desc = fmt.Sprintf("attribute %q == %q", name, values[0])
+	}
+
+	return Func(desc, func(s *Subject) (bool, error) {
+		attrs, err := s.Attributes()
+
+		if err != nil {
+			return false, err
+		}
+
+		for _, value := range attrs.GetList(name) {
+			for _, v := range values {
+				if value == v {
+					return true, nil
+				}
+			}
+		}
+
+		return false, nil
+	})
+}
+
+// HasAttr returns condition which matches users with given attribute
+func HasAttr(name string) Condition {
+	return Func(
+		fmt.Sprintf("has attribute %q", name),
+		func(s *Subject) (bool, error) {
+			attrs, err := s.Attributes()
+
+			if err != nil {
+				return false, err
+			}
+
+			return attrs.Has(name), nil
+		},
+	)
+}
+
+// And returns condition which matches if all given conditions match
+func And(conditions ...Condition) Condition {
+	return &logicCondition{"AND", conditions}
+}
+
+// Or returns condition which matches if any of given conditions matches
+func Or(conditions ...Condition) Condition {
+	return &logicCondition{"OR", conditions}
+}
+
+// Negate returns negated condition
+func Negate(cond Condition) Condition {
+	return &notCondition{cond}
+}
+
+// Func creates condition with given description from function
+func Func(desc string, fn func(s *Subject) (bool, error)) Condition {
+	return &funcCondition{desc, fn}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Subject creates subject for user with given name. Subject must be created
+// once per request and can be used for evaluating multiple policies.
+func (a *Authorizer) Subject(ctx context.Context, userName string) *Subject {
+	return &Subject{Name: userName, api: a.api.WithContext(ctx)}
+}
+
+// WithSubject returns context with subject for user with given name. Authorize
+// calls with returned context share memoized lookups.
+func (a *Authorizer) WithSubject(ctx context.Context, userName string) context.Context {
+	return context.WithValue(ctx, subjectKey, a.Subject(ctx, userName))
+}
+
+// Authorize evaluates policy for user with given name. Subject from context
+// (see WithSubject) is used if it exists.
+func (a *Authorizer) Authorize(ctx context.Context, userName string, policy *Policy) (*Decision, error) {
+	subject, _ := ctx.Value(subjectKey).(*Subject)
+
+	if subject == nil || subject.Name != userName {
+		subject = a.Subject(ctx, userName)
+	}
+
+	return subject.Authorize(policy)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Authorize evaluates policy for subject
+func (s *Subject) Authorize(policy *Policy) (*Decision, error) {
+	if policy == nil {
+		return &Decision{Reason: "no policy"}, nil
+	}
+
+	for _, effect := range []Effect{EFFECT_DENY, EFFECT_ALLOW} {
+		for _, rule := range policy.Rules {
+			if rule.Effect != effect {
+				continue
+			}
+
+			ok, err := rule.Condition.Check(s)
+
+			if err != nil {
+				return nil, fmt.Errorf("Can't evaluate rule %q: %w", rule.Name, err)
+			}
+
+			if ok {
+				return &Decision{
+					Allowed: effect == EFFECT_ALLOW,
+					Rule:    rule.Name,
+					Reason:  fmt.Sprintf("%s rule %q matched: %s", effect, rule.Name, rule.Condition),
+				}, nil
+			}
+		}
+	}
+
+	return &Decision{Reason: "no rule matched"}, nil
+}
+
+// DirectGroups returns names of groups where subject is a direct member
+func (s *Subject) DirectGroups() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasDirectGroups {
+		groups, err := s.fetchGroups(s.api.GetUserDirectGroups)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.directGroups, s.hasDirectGroups = groups, true
+	}
+
+	return s.directGroups, nil
+}
+
+// NestedGroups returns names of groups where subject is a direct or nested
+// member
+func (s *Subject) NestedGroups() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasNestedGroups {
+		groups, err := s.fetchGroups(s.api.GetUserNestedGroups)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.nestedGroups, s.hasNestedGroups = groups, true
+	}
+
+	return s.nestedGroups, nil
+}
+
+// Attributes returns subject attributes
+func (s *Subject) Attributes() (crowd.Attributes, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasAttributes {
+		attrs, err := s.api.GetUserAttributes(s.Name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.attributes, s.hasAttributes = attrs, true
+	}
+
+	return s.attributes, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// String returns effect name
+func (e Effect) String() string {
+	if e == EFFECT_DENY {
+		return "deny"
+	}
+
+	return "allow"
+}
+
+// String returns decision description
+func (d *Decision) String() string {
+	if d.Allowed {
+		return "allowed (" + d.Reason + ")"
+	}
+
+	return "denied (" + d.Reason + ")"
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Check checks if subject matches condition
+func (c *funcCondition) Check(s *Subject) (bool, error) {
+	return c.fn(s)
+}
+
+// String returns condition description
+func (c *funcCondition) String() string {
+	return c.desc
+}
+
+// Check checks if subject matches all (AND) or any (OR) of conditions
+func (c *logicCondition) Check(s *Subject) (bool, error) {
+	isAnd := c.op == "AND"
+
+	for _, cond := range c.conditions {
+		ok, err := cond.Check(s)
+
+		if err != nil {
+			return false, err
+		}
+
+		if ok != isAnd {
+			return ok, nil
+		}
+	}
+
+	return isAnd, nil
+}
+
+// String returns condition description
+func (c *logicCondition) String() string {
+	var descs []string
+
+	for _, cond := range c.conditions {
+		descs = append(descs, cond.String())
+	}
+
+	return "(" + strings.Join(descs, " "+c.op+" ") + ")"
+}
+
+// Check checks if subject doesn't match condition
+func (c *notCondition) Check(s *Subject) (bool, error) {
+	ok, err := c.condition.Check(s)
+	return !ok, err
+}
+
+// String returns condition description
+func (c *notCondition) String() string {
+	return "NOT " + c.condition.String()
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// isMember checks subject membership in given groups
+func (s *Subject) isMember(groups []string, nested, all bool) (bool, error) {
+	var err error
+	var memberOf []string
+
+	if nested {
+		memberOf, err = s.NestedGroups()
+	} else {
+		memberOf, err = s.DirectGroups()
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, group := range groups {
+		isMember := containsGroup(memberOf, group)
+
+		if isMember != all {
+			return isMember, nil
+		}
+	}
+
+	return all && len(groups) != 0, nil
+}
+
+// fetchGroups fetches names of all groups using given function
+func (s *Subject) fetchGroups(fetchFunc func(string, ...crowd.ListingOptions) ([]*crowd.Group, error)) ([]string, error) {
+	groups, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+		return fetchFunc(s.Name, opts)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+
+	for _, g := range groups {
+		result = append(result, g.Name)
+	}
+
+	return result, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// containsGroup returns true if slice contains group with given name. Crowd
+// group names are case-insensitive.
+func containsGroup(groups []string, name string) bool {
+	for _, group := range groups {
+		if strings.EqualFold(group, name) {
+			return true
+		}
+	}
+
+	return false
+}
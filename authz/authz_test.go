@@ -0,0 +1,179 @@
+package authz
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type AuthzSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&AuthzSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *AuthzSuite) TestPolicy(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	a := New(api)
+	policy := NewPolicy(
+		Allow("ops", And(MemberOf("admins", "ops"), AttrEquals("department", "ops"))),
+		Deny("contractors", MemberOf("contractors")),
+		Allow("leads", DirectMemberOf("leads")),
+	)
+
+	d, err := a.Authorize(context.Background(), "john", policy)
+
+	c.Assert(err, IsNil)
+	c.Assert(d.Allowed, Equals, true)
+	c.Assert(d.Rule, Equals, "ops")
+	c.Assert(d.String(), Equals, `allowed (allow rule "ops" matched: (member of any of ["admins" "ops"] AND attribute "department" == "ops"))`)
+
+	// Deny rules take precedence over allow rules
+	d, err = a.Authorize(context.Background(), "bob", policy)
+
+	c.Assert(err, IsNil)
+	c.Assert(d.Allowed, Equals, false)
+	c.Assert(d.Rule, Equals, "contractors")
+	c.Assert(d.Reason, Equals, `deny rule "contractors" matched: member of any of ["contractors"]`)
+
+	// Mary is a nested member of "leads" and has wrong department
+	d, err = a.Authorize(context.Background(), "mary", policy)
+
+	c.Assert(err, IsNil)
+	c.Assert(d.Allowed, Equals, false)
+	c.Assert(d.Rule, Equals, "")
+	c.Assert(d.String(), Equals, "denied (no rule matched)")
+
+	d, err = a.Authorize(context.Background(), "mary", nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(d.Allowed, Equals, false)
+
+	_, err = a.Authorize(context.Background(), "unknown", policy)
+
+	c.Assert(errors.Is(err, crowd.ErrUserNoFound), Equals, true)
+	c.Assert(err, ErrorMatches, `Can't evaluate rule "contractors": User could not be found`)
+}
+
+func (s *AuthzSuite) TestConditions(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	sub := New(api).Subject(context.Background(), "mary")
+
+	check := func(cond Condition) bool {
+		ok, err := cond.Check(sub)
+		c.Assert(err, IsNil)
+		return ok
+	}
+
+	c.Assert(check(MemberOf("LEADS")), Equals, true)
+	c.Assert(check(DirectMemberOf("leads")), Equals, false)
+	c.Assert(check(DirectMemberOf("devs")), Equals, true)
+	c.Assert(check(MemberOfAll("devs", "leads")), Equals, true)
+	c.Assert(check(MemberOfAll("devs", "admins")), Equals, false)
+	c.Assert(check(MemberOfAll()), Equals, false)
+	c.Assert(check(AttrIn("department", "ops", "dev")), Equals, true)
+	c.Assert(check(AttrEquals("department", "ops")), Equals, false)
+	c.Assert(check(HasAttr("department")), Equals, true)
+	c.Assert(check(HasAttr("phone")), Equals, false)
+	c.Assert(check(Or(MemberOf("admins"), HasAttr("department"))), Equals, true)
+	c.Assert(check(Or()), Equals, false)
+	c.Assert(check(And()), Equals, true)
+	c.Assert(check(Negate(MemberOf("admins"))), Equals, true)
+	c.Assert(check(Func("is mary", func(s *Subject) (bool, error) {
+		return s.Name == "mary", nil
+	})), Equals, true)
+
+	c.Assert(
+		Or(Negate(HasAttr("a")), AttrIn("b", "1", "2")).String(), Equals,
+		`(NOT has attribute "a" OR attribute "b" == ["1" "2"])`,
+	)
+
+	// Lookups are memoized
+	c.Assert(srv.Requests(), DeepEquals, []string{
+		"GET user/group/nested", "GET user/group/direct", "GET user/attribute",
+	})
+
+	srv.ResetRequests()
+
+	a := New(api)
+	ctx := a.WithSubject(context.Background(), "john")
+
+	for range 3 {
+		d, err := a.Authorize(ctx, "john", NewPolicy(Allow("ops", MemberOf("ops"))))
+		c.Assert(err, IsNil)
+		c.Assert(d.Allowed, Equals, true)
+	}
+
+	c.Assert(srv.Requests(), HasLen, 1)
+
+	d, err := a.Authorize(ctx, "bob", NewPolicy(Allow("ops", MemberOf("ops"))))
+	c.Assert(err, IsNil)
+	c.Assert(d.Allowed, Equals, true)
+	c.Assert(srv.Requests(), HasLen, 2)
+
+	c.Assert(EFFECT_ALLOW.String(), Equals, "allow")
+	c.Assert(EFFECT_DENY.String(), Equals, "deny")
+}
+
+func (s *AuthzSuite) TestErrors(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	sub := New(api).Subject(context.Background(), "unknown")
+
+	for _, cond := range []Condition{
+		DirectMemberOf("a"), AttrEquals("a", "b"), HasAttr("a"),
+		And(HasAttr("a")), Negate(HasAttr("a")),
+	} {
+		_, err := cond.Check(sub)
+		c.Assert(err, Equals, crowd.ErrUserNoFound)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func newTestServer() (*crowdtest.Server, *crowd.API) {
+	srv := crowdtest.NewServer()
+
+	srv.AddUser(&crowdtest.User{
+		Name: "john", Attributes: map[string][]string{"department": {"ops"}},
+	})
+	srv.AddUser(&crowdtest.User{
+		Name: "bob", Attributes: map[string][]string{"department": {"ops"}},
+	})
+	srv.AddUser(&crowdtest.User{
+		Name: "mary", Attributes: map[string][]string{"department": {"dev"}},
+	})
+
+	srv.AddGroup(&crowdtest.Group{Name: "admins", Groups: []string{"ops"}})
+	srv.AddGroup(&crowdtest.Group{Name: "ops", Users: []string{"john", "bob"}})
+	srv.AddGroup(&crowdtest.Group{Name: "contractors", Users: []string{"bob"}})
+	srv.AddGroup(&crowdtest.Group{Name: "leads", Groups: []string{"devs"}})
+	srv.AddGroup(&crowdtest.Group{Name: "devs", Users: []string{"mary"}})
+
+	api, _ := crowd.NewAPI(srv.URL(), "app", "test")
+
+	return srv, api
+}
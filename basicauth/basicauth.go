@@ -0,0 +1,295 @@
+// Package basicauth provides HTTP Basic authentication middleware for net/http
+// and fasthttp backed by Crowd
+package basicauth
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/cache"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DEFAULT_CACHE_TTL is default TTL for successful authentications
+const DEFAULT_CACHE_TTL = 30 * time.Second
+
+// DEFAULT_REALM is default authentication realm
+const DEFAULT_REALM = "Restricted"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Authenticator checks Basic auth credentials against Crowd
+type Authenticator struct {
+	// Realm is authentication realm (default: DEFAULT_REALM)
+	Realm string
+
+	// Groups is list of groups, user must be a member of any of them (if empty,
+	// membership isn't checked)
+	Groups []string
+
+	// NestedGroups enables checking nested membership
+	NestedGroups bool
+
+	// CacheTTL is TTL for successful authentications (0 disables caching)
+	CacheTTL time.Duration
+
+	// OnCacheLookup is called on every cache lookup with its result (i.e. to
+	// collect metrics with metrics.CacheObserver)
+	OnCacheLookup func(hit bool)
+
+	api   *crowd.API
+	salt  []byte
+	cache cache.Cache[*crowd.User]
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// contextKey is type for context keys
+type contextKey uint8
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// userKey is context key for authenticated user
+const userKey contextKey = 0
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ErrAccessDenied is returned if user isn't a member of any of required groups
+var ErrAccessDenied = errors.New("User is not a member of any of required groups")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// New creates new authenticator which requires membership in any of given groups
+func New(api *crowd.API, groups ...string) *Authenticator {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+
+	return &Authenticator{
+		Realm:    DEFAULT_REALM,
+		Groups:   groups,
+		CacheTTL: DEFAULT_CACHE_TTL,
+
+		api:  api,
+		salt: salt,
+	}
+}
+
+// UserFromContext returns authenticated user from request context. Context can
+// be a context of net/http request or *fasthttp.RequestCtx.
+func UserFromContext(ctx context.Context) *crowd.User {
+	user, _ := ctx.Value(userKey).(*crowd.User)
+	return user
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Authenticate checks user credentials and group membership
+func (a *Authenticator) Authenticate(ctx context.Context, userName, password string) (*crowd.User, error) {
+	if userName == "" || password == "" {
+		return nil, crowd.ErrInvalidCredentials
+	}
+
+	key := a.getCacheKey(userName, password)
+	user := a.getCached(key)
+
+	if user != nil {
+		return user, nil
+	}
+
+	api := a.api.WithContext(ctx)
+	user, err := api.Login(userName, password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.checkGroups(api, user.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.putCached(key, user)
+
+	return user, nil
+}
+
+// Handler wraps net/http handler with Basic authentication
+func (a *Authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userName, password, _ := r.BasicAuth()
+		user, err := a.Authenticate(r.Context(), userName, password)
+
+		if err != nil {
+			statusCode := getStatusCode(err)
+
+			if statusCode == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", a.getChallenge())
+			}
+
+			http.Error(w, http.StatusText(statusCode), statusCode)
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
+	})
+}
+
+// FastHTTPHandler wraps fasthttp handler with Basic authentication
+func (a *Authenticator) FastHTTPHandler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		userName, password, _ := parseBasicAuth(string(ctx.Request.Header.Peek("Authorization")))
+		user, err := a.Authenticate(ctx, userName, password)
+
+		if err != nil {
+			statusCode := getStatusCode(err)
+
+			// Error resets response, so header must be set after it
+			ctx.Error(http.StatusText(statusCode), statusCode)
+
+			if statusCode == http.StatusUnauthorized {
+				ctx.Response.Header.Set("WWW-Authenticate", a.getChallenge())
+			}
+
+			return
+		}
+
+		ctx.SetUserValue(userKey, user)
+
+		next(ctx)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// checkGroups checks if user is a member of any of required groups
+func (a *Authenticator) checkGroups(api *crowd.API, userName string) error {
+	if len(a.Groups) == 0 {
+		return nil
+	}
+
+	fetchFunc := api.GetUserDirectGroups
+
+	if a.NestedGroups {
+		fetchFunc = api.GetUserNestedGroups
+	}
+
+	groups, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+		return fetchFunc(userName, opts)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		for _, allowed := range a.Groups {
+			// Crowd group names are case-insensitive
+			if strings.EqualFold(group.Name, allowed) {
+				return nil
+			}
+		}
+	}
+
+	return ErrAccessDenied
+}
+
+// getChallenge returns value for WWW-Authenticate header
+func (a *Authenticator) getChallenge() string {
+	realm := a.Realm
+
+	if realm == "" {
+		realm = DEFAULT_REALM
+	}
+
+	return "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+}
+
+// getCacheKey returns salted hash of credentials
+func (a *Authenticator) getCacheKey(userName, password string) [32]byte {
+	hasher := sha256.New()
+	hasher.Write(a.salt)
+	hasher.Write([]byte(userName))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(password))
+
+	var key [32]byte
+
+	hasher.Sum(key[:0])
+
+	return key
+}
+
+// getCached returns cached user
+func (a *Authenticator) getCached(key [32]byte) *crowd.User {
+	if a.CacheTTL <= 0 {
+		return nil
+	}
+
+	user, ok := a.cache.Get(key)
+
+	if a.OnCacheLookup != nil {
+		a.OnCacheLookup(ok)
+	}
+
+	return user
+}
+
+// putCached adds user to cache
+func (a *Authenticator) putCached(key [32]byte, user *crowd.User) {
+	if a.CacheTTL <= 0 {
+		return
+	}
+
+	a.cache.Put(key, user, time.Now().Add(a.CacheTTL))
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getStatusCode returns HTTP status code for authentication error
+func getStatusCode(err error) int {
+	switch {
+	case errors.Is(err, crowd.ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrAccessDenied):
+		return http.StatusForbidden
+	}
+
+	return http.StatusServiceUnavailable
+}
+
+// parseBasicAuth parses value of Authorization header
+func parseBasicAuth(header string) (string, string, bool) {
+	scheme, credentials, ok := strings.Cut(header, " ")
+
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(data), ":")
+}
@@ -0,0 +1,209 @@
+package basicauth
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type BasicAuthSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&BasicAuthSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *BasicAuthSuite) TestHTTPHandler(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	a := New(api, "admins")
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserFromContext(r.Context()).Name))
+	}))
+
+	w := serveHTTP(h, "john", "test1234")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Body.String(), Equals, "john")
+
+	srv.ResetRequests()
+
+	w = serveHTTP(h, "john", "test1234")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(srv.Requests(), HasLen, 0)
+
+	w = serveHTTP(h, "john", "wrong")
+	c.Assert(w.Code, Equals, 401)
+	c.Assert(w.Header().Get("WWW-Authenticate"), Equals, `Basic realm="Restricted", charset="UTF-8"`)
+
+	w = serveHTTP(h, "", "")
+	c.Assert(w.Code, Equals, 401)
+
+	// Bob is a nested member only
+	w = serveHTTP(h, "bob", "qwerty")
+	c.Assert(w.Code, Equals, 403)
+
+	a.NestedGroups = true
+	w = serveHTTP(h, "bob", "qwerty")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Body.String(), Equals, "bob")
+
+	w = serveHTTP(h, "mary", "mary1")
+	c.Assert(w.Code, Equals, 403)
+
+	// Crowd group names are case-insensitive
+	a.Groups = []string{"DEVS"}
+	w = serveHTTP(h, "mary", "mary1")
+	c.Assert(w.Code, Equals, 200)
+
+	a.Groups = nil
+	w = serveHTTP(h, "mary", "mary1")
+	c.Assert(w.Code, Equals, 200)
+}
+
+func (s *BasicAuthSuite) TestFastHTTPHandler(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	a := New(api, "admins")
+	a.Realm = "Tools"
+
+	h := a.FastHTTPHandler(func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString(UserFromContext(ctx).Name)
+	})
+
+	ctx := serveFastHTTP(h, "Basic "+encode("john", "test1234"))
+	c.Assert(ctx.Response.StatusCode(), Equals, 200)
+	c.Assert(string(ctx.Response.Body()), Equals, "john")
+
+	ctx = serveFastHTTP(h, "Basic "+encode("john", "wrong"))
+	c.Assert(ctx.Response.StatusCode(), Equals, 401)
+	c.Assert(string(ctx.Response.Header.Peek("WWW-Authenticate")), Equals, `Basic realm="Tools", charset="UTF-8"`)
+
+	ctx = serveFastHTTP(h, "Basic "+encode("mary", "mary1"))
+	c.Assert(ctx.Response.StatusCode(), Equals, 403)
+
+	ctx = serveFastHTTP(h, "Bearer abcd")
+	c.Assert(ctx.Response.StatusCode(), Equals, 401)
+
+	ctx = serveFastHTTP(h, "Basic ???")
+	c.Assert(ctx.Response.StatusCode(), Equals, 401)
+}
+
+func (s *BasicAuthSuite) TestCache(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	a := New(api)
+	a.CacheTTL = 0
+
+	_, err := a.Authenticate(context.Background(), "john", "test1234")
+	c.Assert(err, IsNil)
+	_, err = a.Authenticate(context.Background(), "john", "test1234")
+	c.Assert(err, IsNil)
+	c.Assert(srv.Requests(), HasLen, 2)
+
+	// Cache keys are salted
+	c.Assert(a.getCacheKey("john", "test1234"), Not(Equals), New(api).getCacheKey("john", "test1234"))
+	c.Assert(a.getCacheKey("john", "test1234"), Not(Equals), a.getCacheKey("john", "test123"))
+
+	a.CacheTTL = time.Minute
+
+	var lookups []bool
+
+	a.OnCacheLookup = func(hit bool) { lookups = append(lookups, hit) }
+
+	_, err = a.Authenticate(context.Background(), "john", "test1234")
+	c.Assert(err, IsNil)
+	_, err = a.Authenticate(context.Background(), "john", "test1234")
+	c.Assert(err, IsNil)
+	c.Assert(lookups, DeepEquals, []bool{false, true})
+	c.Assert(a.cache.Len(), Equals, 1)
+	c.Assert(a.getCached(a.getCacheKey("john", "test1234")), NotNil)
+
+	a.CacheTTL = 0
+	c.Assert(a.getCached(a.getCacheKey("john", "test1234")), IsNil)
+}
+
+func (s *BasicAuthSuite) TestErrors(c *C) {
+	api, _ := crowd.NewAPI("http://127.0.0.1:1/", "app", "test")
+
+	a := New(api, "admins")
+	a.Realm = ""
+
+	c.Assert(a.getChallenge(), Equals, `Basic realm="Restricted", charset="UTF-8"`)
+
+	w := serveHTTP(a.Handler(http.NotFoundHandler()), "john", "test1234")
+	c.Assert(w.Code, Equals, 503)
+
+	c.Assert(UserFromContext(context.Background()), IsNil)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func newTestServer() (*crowdtest.Server, *crowd.API) {
+	srv := crowdtest.NewServer()
+
+	srv.AddUser(&crowdtest.User{Name: "john", Password: "test1234", IsActive: true})
+	srv.AddUser(&crowdtest.User{Name: "bob", Password: "qwerty", IsActive: true})
+	srv.AddUser(&crowdtest.User{Name: "mary", Password: "mary1", IsActive: true})
+
+	srv.AddGroup(&crowdtest.Group{Name: "admins", Users: []string{"john"}, Groups: []string{"ops"}})
+	srv.AddGroup(&crowdtest.Group{Name: "ops", Users: []string{"bob"}})
+	srv.AddGroup(&crowdtest.Group{Name: "devs", Users: []string{"mary"}})
+
+	api, _ := crowd.NewAPI(srv.URL(), "app", "test")
+
+	return srv, api
+}
+
+func serveHTTP(h http.Handler, userName, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	if userName != "" {
+		req.SetBasicAuth(userName, password)
+	}
+
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func serveFastHTTP(h fasthttp.RequestHandler, authHeader string) *fasthttp.RequestCtx {
+	req := &fasthttp.Request{}
+	req.Header.Set("Authorization", authHeader)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(req, nil, nil)
+
+	h(ctx)
+
+	return ctx
+}
+
+func encode(userName, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(userName + ":" + password))
+}
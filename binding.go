@@ -0,0 +1,383 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ErrInvalidTarget is returned if target for unmarshaling isn't a pointer to struct
+var ErrInvalidTarget = errors.New("Target must be a non-nil pointer to struct")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// fieldTag contains parsed struct field tag
+type fieldTag struct {
+	name      string
+	layout    string
+	omitEmpty bool
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// UnmarshalAttributes decodes attributes into struct fields with "crowd" tags.
+//
+// Tag format is `crowd:"name[,omitempty][,layout=<time layout>]"`. Layout must
+// be the last option, because it may contain commas. Supported field types are
+// strings, integers, floats, booleans, time.Time (RFC3339 by default, use
+// TIME_UNIX_MILLI layout for milliseconds since epoch), time.Duration, types
+// which implement encoding.TextUnmarshaler and slices of these types for
+// multi-valued attributes.
+func UnmarshalAttributes(attrs Attributes, v any) error {
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidTarget
+	}
+
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := range rt.NumField() {
+		tag, ok := parseFieldTag(rt.Field(i))
+
+		if !ok || !attrs.Has(tag.name) {
+			continue
+		}
+
+		err := decodeField(rv.Field(i), attrs.GetList(tag.name), tag)
+
+		if err != nil {
+			return fmt.Errorf(
+				"Can't decode attribute %q into field %s: %w",
+				tag.name, rt.Field(i).Name, err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// MarshalAttributes encodes struct fields with "crowd" tags into attributes
+func MarshalAttributes(v any) (Attributes, error) {
+	rv := reflect.ValueOf(v)
+
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, ErrInvalidTarget
+		}
+
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil, ErrInvalidTarget
+	}
+
+	var result Attributes
+
+	rt := rv.Type()
+
+	for i := range rt.NumField() {
+		tag, ok := parseFieldTag(rt.Field(i))
+
+		if !ok || (tag.omitEmpty && rv.Field(i).IsZero()) ||
+			(rv.Field(i).Kind() == reflect.Pointer && rv.Field(i).IsNil()) {
+			continue
+		}
+
+		values, err := encodeField(rv.Field(i), tag)
+
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Can't encode field %s into attribute %q: %w",
+				rt.Field(i).Name, tag.name, err,
+			)
+		}
+
+		result = append(result, &Attribute{Name: tag.name, Values: values})
+	}
+
+	return result, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseFieldTag parses "crowd" tag of struct field
+func parseFieldTag(field reflect.StructField) (fieldTag, bool) {
+	tagData, ok := field.Tag.Lookup("crowd")
+
+	if !ok || tagData == "-" || !field.IsExported() {
+		return fieldTag{}, false
+	}
+
+	parts := strings.Split(tagData, ",")
+	tag := fieldTag{name: parts[0]}
+
+	if tag.name == "" {
+		tag.name = field.Name
+	}
+
+	for i, opt := range parts[1:] {
+		switch {
+		case opt == "omitempty":
+			tag.omitEmpty = true
+		case strings.HasPrefix(opt, "layout="):
+			// Layout is the last option and may contain commas (i.e. RFC1123)
+			tag.layout = strings.TrimPrefix(strings.Join(parts[i+1:], ","), "layout=")
+			return tag, true
+		}
+	}
+
+	return tag, true
+}
+
+// decodeField decodes attribute values into field
+func decodeField(field reflect.Value, values []string, tag fieldTag) error {
+	if field.Kind() == reflect.Slice && !isScalarType(field.Type()) {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+
+		for i, value := range values {
+			err := decodeValue(slice.Index(i), value, tag)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		field.Set(slice)
+
+		return nil
+	}
+
+	if len(values) == 0 {
+		field.SetZero()
+		return nil
+	}
+
+	if len(values) > 1 {
+		return fmt.Errorf("Attribute has %d values, but field isn't a slice", len(values))
+	}
+
+	return decodeValue(field, values[0], tag)
+}
+
+// decodeValue decodes single value
+func decodeValue(field reflect.Value, value string, tag fieldTag) error {
+	if field.Kind() == reflect.Pointer {
+		ptr := reflect.New(field.Type().Elem())
+		err := decodeValue(ptr.Elem(), value, tag)
+
+		if err != nil {
+			return err
+		}
+
+		field.Set(ptr)
+
+		return nil
+	}
+
+	if field.Type() == timeType {
+		t, err := parseTime(value, tag.layout)
+
+		if err != nil {
+			return err
+		}
+
+		field.Set(reflect.ValueOf(t))
+
+		return nil
+	}
+
+	if field.Addr().Type().Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Bool:
+		v, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(v)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.Type() == durationType {
+			d, err := time.ParseDuration(value)
+
+			if err != nil {
+				return err
+			}
+
+			field.SetInt(int64(d))
+
+			return nil
+		}
+
+		v, err := strconv.ParseInt(value, 10, field.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(v)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(value, 10, field.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		field.SetUint(v)
+
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(value, field.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		field.SetFloat(v)
+
+	default:
+		return fmt.Errorf("Unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
+
+// encodeField encodes field into attribute values
+func encodeField(field reflect.Value, tag fieldTag) ([]string, error) {
+	if field.Kind() == reflect.Slice && !isScalarType(field.Type()) {
+		values := make([]string, 0, field.Len())
+
+		for i := range field.Len() {
+			value, err := encodeValue(field.Index(i), tag)
+
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, value)
+		}
+
+		return values, nil
+	}
+
+	value, err := encodeValue(field, tag)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{value}, nil
+}
+
+// encodeValue encodes single value
+func encodeValue(field reflect.Value, tag fieldTag) (string, error) {
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return "", nil
+		}
+
+		field = field.Elem()
+	}
+
+	if field.Type() == timeType {
+		return formatTime(field.Interface().(time.Time), tag.layout), nil
+	}
+
+	if field.Type().Implements(textMarshalerType) {
+		data, err := field.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(data), err
+	}
+
+	if field.CanAddr() && field.Addr().Type().Implements(textMarshalerType) {
+		data, err := field.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		return string(data), err
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		return field.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(field.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.Type() == durationType {
+			return time.Duration(field.Int()).String(), nil
+		}
+
+		return strconv.FormatInt(field.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(field.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(field.Float(), 'g', -1, field.Type().Bits()), nil
+	}
+
+	return "", fmt.Errorf("Unsupported field type %s", field.Type())
+}
+
+// isScalarType returns true if slice type must be handled as single value
+// (i.e. net.IP)
+func isScalarType(t reflect.Type) bool {
+	return reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// parseTime parses time using given layout (RFC3339 by default)
+func parseTime(value, layout string) (time.Time, error) {
+	if layout == TIME_UNIX_MILLI {
+		ms, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return time.UnixMilli(ms), nil
+	}
+
+	if layout == "" {
+		layout = time.RFC3339
+	}
+
+	return time.Parse(layout, value)
+}
+
+// formatTime formats time using given layout (RFC3339 by default)
+func formatTime(t time.Time, layout string) string {
+	switch layout {
+	case TIME_UNIX_MILLI:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case "":
+		layout = time.RFC3339
+	}
+
+	return t.Format(layout)
+}
@@ -0,0 +1,263 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"sync"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Circuit breaker states
+const (
+	BREAKER_CLOSED BreakerState = iota
+	BREAKER_OPEN
+	BREAKER_HALF_OPEN
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// BreakerState is circuit breaker state
+type BreakerState uint8
+
+// CircuitBreaker is circuit breaker for requests to Crowd
+type CircuitBreaker struct {
+	// FailureRate is failure rate (0.0 - 1.0) which opens the circuit (default: 0.5)
+	FailureRate float64
+
+	// MinRequests is minimum number of requests in window required for opening
+	// the circuit (default: 10)
+	MinRequests int
+
+	// Window is period for counting failures (default: 30s)
+	Window time.Duration
+
+	// Cooldown is period while circuit stays open (default: 15s)
+	Cooldown time.Duration
+
+	// HalfOpenRequests is maximum number of trial requests in half-open state
+	// (default: 1)
+	HalfOpenRequests int
+
+	// OnStateChange is callback executed on every state change
+	OnStateChange func(from, to BreakerState)
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	openedAt    time.Time
+	successes   int
+	failures    int
+	trials      int
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// String returns name of the state
+func (s BreakerState) String() string {
+	switch s {
+	case BREAKER_CLOSED:
+		return "closed"
+	case BREAKER_OPEN:
+		return "open"
+	case BREAKER_HALF_OPEN:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// State returns current state of circuit breaker
+func (b *CircuitBreaker) State() BreakerState {
+	if b == nil {
+		return BREAKER_CLOSED
+	}
+
+	b.mu.Lock()
+	state, from := b.state, b.state
+
+	if state == BREAKER_OPEN && time.Since(b.openedAt) >= b.getCooldown() {
+		state = b.setState(BREAKER_HALF_OPEN)
+	}
+
+	b.mu.Unlock()
+
+	b.notify(from, state)
+
+	return state
+}
+
+// Reset resets circuit breaker to closed state
+func (b *CircuitBreaker) Reset() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	from := b.state
+	state := b.setState(BREAKER_CLOSED)
+	b.mu.Unlock()
+
+	b.notify(from, state)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// allow returns true if request can be sent
+func (b *CircuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+
+	from, allowed := b.state, true
+
+	switch b.state {
+	case BREAKER_OPEN:
+		if time.Since(b.openedAt) < b.getCooldown() {
+			allowed = false
+			break
+		}
+
+		b.setState(BREAKER_HALF_OPEN)
+		b.trials++
+
+	case BREAKER_HALF_OPEN:
+		if b.trials >= max(b.HalfOpenRequests, 1) {
+			allowed = false
+			break
+		}
+
+		b.trials++
+	}
+
+	state := b.state
+	b.mu.Unlock()
+
+	b.notify(from, state)
+
+	return allowed
+}
+
+// release releases request slot taken by allow without registering result
+// (i.e. if request was cancelled by caller)
+func (b *CircuitBreaker) release() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+
+	if b.state == BREAKER_HALF_OPEN && b.trials > 0 {
+		b.trials--
+	}
+
+	b.mu.Unlock()
+}
+
+// report registers result of request
+func (b *CircuitBreaker) report(success bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+
+	from := b.state
+
+	switch b.state {
+	case BREAKER_HALF_OPEN:
+		if success {
+			b.setState(BREAKER_CLOSED)
+		} else {
+			b.setState(BREAKER_OPEN)
+		}
+
+	case BREAKER_CLOSED:
+		if time.Since(b.windowStart) > b.getWindow() {
+			b.windowStart, b.successes, b.failures = time.Now(), 0, 0
+		}
+
+		if success {
+			b.successes++
+		} else {
+			b.failures++
+		}
+
+		total := b.successes + b.failures
+
+		if total >= b.getMinRequests() &&
+			float64(b.failures)/float64(total) >= b.getFailureRate() {
+			b.setState(BREAKER_OPEN)
+		}
+	}
+
+	state := b.state
+	b.mu.Unlock()
+
+	b.notify(from, state)
+}
+
+// setState changes state and resets counters
+func (b *CircuitBreaker) setState(state BreakerState) BreakerState {
+	b.state = state
+	b.windowStart, b.successes, b.failures, b.trials = time.Now(), 0, 0, 0
+
+	if state == BREAKER_OPEN {
+		b.openedAt = time.Now()
+	}
+
+	return state
+}
+
+// notify executes state change callback
+func (b *CircuitBreaker) notify(from, to BreakerState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
+
+// getFailureRate returns failure rate threshold
+func (b *CircuitBreaker) getFailureRate() float64 {
+	if b.FailureRate <= 0 {
+		return 0.5
+	}
+
+	return b.FailureRate
+}
+
+// getMinRequests returns minimum number of requests in window
+func (b *CircuitBreaker) getMinRequests() int {
+	if b.MinRequests <= 0 {
+		return 10
+	}
+
+	return b.MinRequests
+}
+
+// getWindow returns window duration
+func (b *CircuitBreaker) getWindow() time.Duration {
+	if b.Window <= 0 {
+		return 30 * time.Second
+	}
+
+	return b.Window
+}
+
+// getCooldown returns cooldown duration
+func (b *CircuitBreaker) getCooldown() time.Duration {
+	if b.Cooldown <= 0 {
+		return 15 * time.Second
+	}
+
+	return b.Cooldown
+}
@@ -0,0 +1,223 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Node selection strategies
+const (
+	// SELECT_ROUND_ROBIN distributes requests between all healthy nodes
+	SELECT_ROUND_ROBIN NodeSelection = iota
+
+	// SELECT_PRIORITY sends requests to the first healthy node in the list
+	SELECT_PRIORITY
+)
+
+// healthProbePath is path used for checking node health
+const healthProbePath = "rest/usermanagement/1/config/cookie"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NodeSelection is strategy for selecting cluster node
+type NodeSelection uint8
+
+// NodeStatus contains info about cluster node status
+type NodeStatus struct {
+	URL       string
+	IsHealthy bool
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// nodePool is pool with cluster nodes
+type nodePool struct {
+	nodes   []*node
+	counter atomic.Uint64
+
+	mu        sync.Mutex
+	probeStop chan struct{}
+}
+
+// node contains info about cluster node
+type node struct {
+	url       string
+	isHealthy atomic.Bool
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewClusterAPI creates new API struct for Crowd Data Center cluster with
+// given nodes
+func NewClusterAPI(urls []string, app, password string) (*API, error) {
+	if len(urls) == 0 {
+		return nil, ErrInitEmptyURL
+	}
+
+	api, err := NewAPI(urls[0], app, password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, url := range urls[1:] {
+		if url == "" {
+			return nil, ErrInitEmptyURL
+		}
+
+		api.pool.add(url)
+	}
+
+	return api, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Nodes returns status of all cluster nodes
+func (api *API) Nodes() []NodeStatus {
+	var result []NodeStatus
+
+	for _, n := range api.pool.nodes {
+		result = append(result, NodeStatus{n.url, n.isHealthy.Load()})
+	}
+
+	return result
+}
+
+// StartHealthProbe starts background checks of cluster nodes health with given
+// interval. Recovered nodes are returned back into rotation.
+func (api *API) StartHealthProbe(interval time.Duration) {
+	api.pool.mu.Lock()
+	defer api.pool.mu.Unlock()
+
+	if api.pool.probeStop != nil || interval <= 0 {
+		return
+	}
+
+	api.pool.probeStop = make(chan struct{})
+
+	go api.runHealthProbe(interval, api.pool.probeStop)
+}
+
+// StopHealthProbe stops background checks of cluster nodes health
+func (api *API) StopHealthProbe() {
+	api.pool.mu.Lock()
+	defer api.pool.mu.Unlock()
+
+	if api.pool.probeStop == nil {
+		return
+	}
+
+	close(api.pool.probeStop)
+	api.pool.probeStop = nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// runHealthProbe periodically checks health of all cluster nodes
+func (api *API) runHealthProbe(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, n := range api.pool.nodes {
+				n.isHealthy.Store(api.checkNode(n))
+			}
+		}
+	}
+}
+
+// checkNode returns true if node is healthy
+func (api *API) checkNode(n *node) bool {
+	req := api.acquireRequest(n.url, "GET", healthProbePath)
+	resp := fasthttp.AcquireResponse()
+
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := api.Client.Do(req, resp)
+
+	return err == nil && resp.StatusCode() == 200
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// newNodePool creates new pool with given node
+func newNodePool(url string) *nodePool {
+	p := &nodePool{}
+	p.add(url)
+	return p
+}
+
+// add adds new node to pool
+func (p *nodePool) add(url string) {
+	n := &node{url: url}
+	n.isHealthy.Store(true)
+	p.nodes = append(p.nodes, n)
+}
+
+// pick returns nodes in order they should be used for the next request. Healthy
+// nodes always go first, unhealthy nodes are used as last resort.
+func (p *nodePool) pick(selection NodeSelection) []*node {
+	if len(p.nodes) == 1 {
+		return p.nodes
+	}
+
+	var healthy, unhealthy []*node
+
+	offset := 0
+
+	if selection == SELECT_ROUND_ROBIN {
+		offset = int(p.counter.Add(1) % uint64(len(p.nodes)))
+	}
+
+	for i := range p.nodes {
+		n := p.nodes[(i+offset)%len(p.nodes)]
+
+		if n.isHealthy.Load() {
+			healthy = append(healthy, n)
+		} else {
+			unhealthy = append(unhealthy, n)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// canFailover returns true if request can be sent to another node
+func canFailover(method string, resp *fasthttp.Response, err error) bool {
+	if err == nil {
+		return resp.StatusCode() >= 500 && isIdempotentMethod(method)
+	}
+
+	if isIdempotentMethod(method) {
+		return true
+	}
+
+	// Non-idempotent requests can be sent to another node only if
+	// we are sure that request wasn't sent at all
+	var opErr *net.OpError
+
+	return err == fasthttp.ErrDialTimeout ||
+		(errors.As(err, &opErr) && opErr.Op == "dial")
+}
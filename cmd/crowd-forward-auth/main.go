@@ -0,0 +1,187 @@
+// Command crowd-forward-auth is forward-auth server for reverse proxies (nginx
+// auth_request, Traefik ForwardAuth) backed by Atlassian Crowd
+package main
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/forwardauth"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ENV_PASSWORD is name of environment variable with application password
+const ENV_PASSWORD = "CROWD_APP_PASSWORD"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func main() {
+	err := run()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// run parses options and starts server
+func run() error {
+	crowdURL := flag.String("url", "", "Crowd URL")
+	app := flag.String("app", "", "Application name")
+	password := flag.String("password", "", "Application password (default: $"+ENV_PASSWORD+")")
+	listen := flag.String("listen", ":8080", "Address to listen on")
+	rulesFile := flag.String("rules", "", "Path to JSON file with access rules")
+	loginURL := flag.String("login-url", "", "Login page URL for redirecting unauthenticated users")
+	cookie := flag.String("cookie", crowd.SSO_COOKIE_NAME, "Name of SSO cookie")
+	realm := flag.String("realm", "", "Basic authentication realm")
+	noSSO := flag.Bool("no-sso", false, "Disable SSO cookie authentication")
+	noBasic := flag.Bool("no-basic", false, "Disable Basic authentication")
+	cacheTTL := flag.Duration("cache-ttl", forwardauth.DEFAULT_GROUPS_CACHE_TTL, "TTL for cached sessions, credentials and groups")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated list of addresses and networks of trusted reverse proxies")
+
+	flag.Parse()
+
+	if *password == "" {
+		*password = os.Getenv(ENV_PASSWORD)
+	}
+
+	if *crowdURL == "" || *app == "" || *password == "" {
+		flag.Usage()
+		return errors.New("Crowd URL, application name and password are required")
+	}
+
+	api, err := crowd.NewAPI(*crowdURL, *app, *password)
+
+	if err != nil {
+		return err
+	}
+
+	api.SetUserAgent("crowd-forward-auth", "1")
+
+	h := forwardauth.New(api)
+	h.LoginURL = *loginURL
+	h.GroupsCacheTTL = *cacheTTL
+	h.TrustedProxies, err = parseNetworks(*trustedProxies)
+
+	if err != nil {
+		return err
+	}
+
+	if *rulesFile != "" {
+		h.Rules, err = forwardauth.ReadRules(*rulesFile)
+
+		if err != nil {
+			return err
+		}
+
+		// Rules are matched against original URL which is read only from
+		// requests of trusted proxies
+		if len(h.TrustedProxies) == 0 {
+			return errors.New("Trusted proxies (-trusted-proxies) are required for access rules")
+		}
+	}
+
+	if *noSSO {
+		h.SSO = nil
+	} else {
+		h.SSO.CookieName = *cookie
+		h.SSO.CacheTTL = *cacheTTL
+	}
+
+	if *noBasic {
+		h.Basic = nil
+	} else {
+		h.Basic.CacheTTL = *cacheTTL
+
+		if *realm != "" {
+			h.Basic.Realm = *realm
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/", h)
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err = server.ListenAndServe()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// parseNetworks parses comma-separated list of addresses and networks
+func parseNetworks(data string) ([]netip.Prefix, error) {
+	var result []netip.Prefix
+
+	for _, item := range strings.Split(data, ",") {
+		item = strings.TrimSpace(item)
+
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid trusted proxy address %q", item)
+			}
+
+			result = append(result, netip.PrefixFrom(addr, addr.BitLen()))
+
+			continue
+		}
+
+		network, err := netip.ParsePrefix(item)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy network %q", item)
+		}
+
+		result = append(result, network.Masked())
+	}
+
+	return result, nil
+}
@@ -0,0 +1,132 @@
+// Command crowd-ldap is read-only LDAP front end for Atlassian Crowd
+package main
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/ldap"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ENV_PASSWORD is name of environment variable with application password
+const ENV_PASSWORD = "CROWD_APP_PASSWORD"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func main() {
+	err := run()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// run parses options and starts server
+func run() error {
+	crowdURL := flag.String("url", "", "Crowd URL")
+	app := flag.String("app", "", "Application name")
+	password := flag.String("password", "", "Application password (default: $"+ENV_PASSWORD+")")
+	listen := flag.String("listen", ":389", "Address to listen on")
+	baseDN := flag.String("base-dn", "dc=example,dc=com", "Base DN")
+	tlsCert := flag.String("tls-cert", "", "Path to TLS certificate (enables LDAPS)")
+	tlsKey := flag.String("tls-key", "", "Path to TLS private key")
+	anonymous := flag.Bool("anonymous", false, "Allow searches without bind")
+	inactive := flag.Bool("include-inactive", false, "Expose inactive users and groups")
+	sizeLimit := flag.Int("size-limit", ldap.DEFAULT_SIZE_LIMIT, "Maximum number of entries returned by search")
+	idleTimeout := flag.Duration("idle-timeout", ldap.DEFAULT_IDLE_TIMEOUT, "Timeout for idle connections")
+
+	flag.Parse()
+
+	if *password == "" {
+		*password = os.Getenv(ENV_PASSWORD)
+	}
+
+	if *crowdURL == "" || *app == "" || *password == "" {
+		flag.Usage()
+		return errors.New("Crowd URL, application name and password are required")
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("Both TLS certificate and private key must be set")
+	}
+
+	api, err := crowd.NewAPI(*crowdURL, *app, *password)
+
+	if err != nil {
+		return err
+	}
+
+	api.SetUserAgent("crowd-ldap", "1")
+
+	server, err := ldap.New(api, *baseDN)
+
+	if err != nil {
+		return fmt.Errorf("Invalid base DN: %w", err)
+	}
+
+	server.AllowAnonymous = *anonymous
+	server.IncludeInactive = *inactive
+	server.SizeLimit = *sizeLimit
+	server.IdleTimeout = *idleTimeout
+
+	var l net.Listener
+
+	if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+
+		if err != nil {
+			return err
+		}
+
+		l, err = tls.Listen("tcp", *listen, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+
+		if err != nil {
+			return err
+		}
+	} else {
+		l, err = net.Listen("tcp", *listen)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	err = server.Serve(l)
+
+	if errors.Is(err, ldap.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,120 @@
+// Command crowd-ssh-keys prints SSH public keys of Crowd user. It is intended
+// to be used as sshd AuthorizedKeysCommand:
+//
+//	AuthorizedKeysCommand /usr/bin/crowd-ssh-keys -url https://crowd.domain.com -app sshd -password-file /etc/crowd-ssh-keys.pass -cache-dir /var/cache/crowd-ssh-keys %u
+//	AuthorizedKeysCommandUser crowd-ssh-keys
+package main
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/sshkeys"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ENV_PASSWORD is name of environment variable with application password
+const ENV_PASSWORD = "CROWD_APP_PASSWORD"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func main() {
+	err := run()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// run parses options and prints keys
+func run() error {
+	crowdURL := flag.String("url", "", "Crowd URL")
+	app := flag.String("app", "", "Application name")
+	password := flag.String("password", "", "Application password (default: $"+ENV_PASSWORD+")")
+	passwordFile := flag.String("password-file", "", "Path to file with application password")
+	attribute := flag.String("attribute", sshkeys.DEFAULT_ATTRIBUTE, "Name of attribute with SSH public keys")
+	groups := flag.String("groups", "", "Comma-separated list of groups allowed to use SSH")
+	cacheDir := flag.String("cache-dir", "", "Path to directory for cached keys")
+	cacheMaxAge := flag.Duration("cache-max-age", sshkeys.DEFAULT_CACHE_MAX_AGE, "Maximum age of cached keys")
+	timeout := flag.Duration("timeout", 5*time.Second, "Timeout for Crowd requests")
+
+	flag.Parse()
+
+	if *password == "" && *passwordFile != "" {
+		data, err := os.ReadFile(*passwordFile)
+
+		if err != nil {
+			return err
+		}
+
+		*password = strings.TrimSpace(string(data))
+	}
+
+	if *password == "" {
+		*password = os.Getenv(ENV_PASSWORD)
+	}
+
+	if *crowdURL == "" || *app == "" || *password == "" {
+		flag.Usage()
+		return errors.New("Crowd URL, application name and password are required")
+	}
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		return errors.New("User name is required")
+	}
+
+	api, err := crowd.NewAPI(*crowdURL, *app, *password)
+
+	if err != nil {
+		return err
+	}
+
+	api.SetUserAgent("crowd-ssh-keys", "1")
+
+	l := sshkeys.New(api)
+	l.Attribute = *attribute
+	l.CacheDir = *cacheDir
+	l.CacheMaxAge = *cacheMaxAge
+	l.OnInvalidKey = func(userName, key string, err error) {
+		fmt.Fprintf(os.Stderr, "Warning: invalid key of user %q skipped: %v\n", userName, err)
+	}
+
+	for _, group := range strings.Split(*groups, ",") {
+		if strings.TrimSpace(group) != "" {
+			l.Groups = append(l.Groups, strings.TrimSpace(group))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	keys, err := l.Keys(ctx, flag.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+// Command crowd-token-webhook is Kubernetes webhook token authenticator backed
+// by Atlassian Crowd
+package main
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/tokenreview"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ENV_PASSWORD is name of environment variable with application password
+const ENV_PASSWORD = "CROWD_APP_PASSWORD"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func main() {
+	err := run()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// run parses options and starts server
+func run() error {
+	crowdURL := flag.String("url", "", "Crowd URL")
+	app := flag.String("app", "", "Application name")
+	password := flag.String("password", "", "Application password (default: $"+ENV_PASSWORD+")")
+	listen := flag.String("listen", ":8443", "Address to listen on")
+	tlsCert := flag.String("tls-cert", "", "Path to TLS certificate")
+	tlsKey := flag.String("tls-key", "", "Path to TLS private key")
+	clientCA := flag.String("client-ca", "", "Path to CA certificate for verifying client certificates of API server")
+	groupPrefix := flag.String("group-prefix", "", "Prefix of groups exposed to Kubernetes")
+	trimPrefix := flag.Bool("trim-group-prefix", false, "Remove prefix from exposed group names")
+	userPrefix := flag.String("username-prefix", "", "Prefix added to user names")
+	audiences := flag.String("audiences", "", "Comma-separated list of audiences tokens are valid for")
+	passwordTokens := flag.Bool("password-tokens", false, "Enable username:password tokens (requires -client-ca)")
+	cacheTTL := flag.Duration("cache-ttl", tokenreview.DEFAULT_CACHE_TTL, "TTL for successful reviews")
+
+	flag.Parse()
+
+	if *password == "" {
+		*password = os.Getenv(ENV_PASSWORD)
+	}
+
+	if *crowdURL == "" || *app == "" || *password == "" {
+		flag.Usage()
+		return errors.New("Crowd URL, application name and password are required")
+	}
+
+	if *tlsCert == "" || *tlsKey == "" {
+		return errors.New("TLS certificate and private key are required")
+	}
+
+	// Password tokens allow checking any password, so only API server can use them
+	if *passwordTokens && *clientCA == "" {
+		return errors.New("Password tokens require CA certificate for verifying clients (-client-ca)")
+	}
+
+	api, err := crowd.NewAPI(*crowdURL, *app, *password)
+
+	if err != nil {
+		return err
+	}
+
+	api.SetUserAgent("crowd-token-webhook", "1")
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if *clientCA != "" {
+		tlsConfig.ClientCAs, err = readCertPool(*clientCA)
+
+		if err != nil {
+			return err
+		}
+
+		// Health checks can be done without certificate, so certificate
+		// is required by handler
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	a := tokenreview.New(api)
+	a.GroupPrefix = *groupPrefix
+	a.TrimGroupPrefix = *trimPrefix
+	a.UsernamePrefix = *userPrefix
+	a.PasswordTokens = *passwordTokens
+	a.CacheTTL = *cacheTTL
+
+	for _, audience := range strings.Split(*audiences, ",") {
+		if strings.TrimSpace(audience) != "" {
+			a.Audiences = append(a.Audiences, strings.TrimSpace(audience))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if *clientCA != "" {
+		mux.Handle("/", requireClientCert(a))
+	} else {
+		mux.Handle("/", a)
+	}
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err = server.ListenAndServeTLS(*tlsCert, *tlsKey)
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// readCertPool reads pool with CA certificates from PEM file
+func readCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("File %q doesn't contain valid CA certificates", file)
+	}
+
+	return pool, nil
+}
+
+// requireClientCert rejects requests without verified client certificate
+func requireClientCert(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "Client certificate is required", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -8,10 +8,12 @@ package crowd
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"runtime"
 	"strings"
@@ -24,10 +26,20 @@ import (
 
 // API is Confluence API struct
 type API struct {
-	Client *fasthttp.Client // Client is client for http requests
-
-	url       string // confluence URL
-	basicAuth string // basic auth
+	Client         *fasthttp.Client // Client is client for http requests
+	RetryPolicy    *RetryPolicy     // RetryPolicy is policy for retrying failed requests
+	CircuitBreaker *CircuitBreaker  // CircuitBreaker is circuit breaker for requests
+	NodeSelection  NodeSelection    // NodeSelection is strategy for selecting cluster nodes
+	ReadLimiter    *RateLimiter     // ReadLimiter is rate limiter for read (GET) requests
+	WriteLimiter   *RateLimiter     // WriteLimiter is rate limiter for write requests
+	Interceptors   []Interceptor    // Interceptors is chain of request interceptors
+	Dumper         *Dumper          // Dumper is debug dumper for requests and responses
+	UserSchema     *AttributeSchema // UserSchema is schema for user attributes
+	GroupSchema    *AttributeSchema // GroupSchema is schema for group attributes
+
+	ctx       context.Context // context for requests
+	pool      *nodePool       // pool with Crowd nodes
+	basicAuth string          // basic auth
 }
 
 // ////////////////////////////////////////////////////////////////////////////////// //
@@ -36,16 +48,55 @@ type password struct {
 	Value string `xml:"value"`
 }
 
+// entityRef is reference to user or group used in membership requests
+type entityRef struct {
+	XMLName xml.Name
+	Name    string `xml:"name,attr"`
+}
+
+// userEntity is user used in create and update requests
+type userEntity struct {
+	XMLName     xml.Name  `xml:"user"`
+	Name        string    `xml:"name,attr"`
+	FirstName   string    `xml:"first-name"`
+	LastName    string    `xml:"last-name"`
+	DisplayName string    `xml:"display-name"`
+	Email       string    `xml:"email"`
+	Password    *password `xml:"password,omitempty"`
+	IsActive    bool      `xml:"active"`
+}
+
+// groupEntity is wrapper for group used in create and update requests
+type groupEntity struct {
+	XMLName xml.Name `xml:"group"`
+	*Group
+}
+
+// response contains basic info about response
+type response struct {
+	statusCode int
+	body       []byte
+	retryAfter time.Duration
+}
+
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 // API errors
 var (
-	ErrInitEmptyURL      = errors.New("URL can't be empty")
-	ErrInitEmptyApp      = errors.New("App can't be empty")
-	ErrInitEmptyPassword = errors.New("Password can't be empty")
-	ErrNoPerms           = errors.New("Application does not have permission to use Crowd")
-	ErrUserNoFound       = errors.New("User could not be found")
-	ErrGroupNoFound      = errors.New("Group could not be found")
+	ErrInitEmptyURL       = errors.New("URL can't be empty")
+	ErrInitEmptyApp       = errors.New("App can't be empty")
+	ErrInitEmptyPassword  = errors.New("Password can't be empty")
+	ErrNoPerms            = errors.New("Application does not have permission to use Crowd")
+	ErrUserNoFound        = errors.New("User could not be found")
+	ErrGroupNoFound       = errors.New("Group could not be found")
+	ErrCircuitOpen        = errors.New("Circuit breaker is open")
+	ErrAttrsConflict      = errors.New("Attributes were modified since they were read")
+	ErrMembershipExists   = errors.New("Membership already exists")
+	ErrMembershipNoFound  = errors.New("Membership could not be found")
+	ErrInvalidCredentials = errors.New("Invalid username or password, or account is inactive")
+	ErrInvalidUser        = errors.New("User already exists or contains invalid data")
+	ErrInvalidGroup       = errors.New("Group already exists or contains invalid data")
+	ErrInvalidPassword    = errors.New("Password does not satisfy password policy")
 )
 
 // ////////////////////////////////////////////////////////////////////////////////// //
@@ -70,11 +121,22 @@ func NewAPI(url, app, password string) (*API, error) {
 			MaxConnsPerHost:     150,
 		},
 
-		url:       url,
+		pool:      newNodePool(url),
 		basicAuth: genBasicAuthHeader(app, password),
 	}, nil
 }
 
+// ErrorReason returns reason (i.e. USER_NOT_FOUND) from xml-encoded Crowd error
+func ErrorReason(data []byte) string {
+	ce := &crowdError{}
+
+	if xml.Unmarshal(data, ce) != nil {
+		return ""
+	}
+
+	return ce.Reason
+}
+
 // SimplifyAttributes converts slice with attributes to map name->value
 func SimplifyAttributes(attrs Attributes) map[string]string {
 	result := make(map[string]string)
@@ -86,6 +148,26 @@ func SimplifyAttributes(attrs Attributes) map[string]string {
 	return result
 }
 
+// FetchAll fetches all pages of listing. Fetch function is called with listing
+// options for every page until it returns incomplete page.
+func FetchAll[T any](fetchFunc func(opts ListingOptions) ([]T, error)) ([]T, error) {
+	var result []T
+
+	for start := 0; ; start += FETCH_PAGE_SIZE {
+		items, err := fetchFunc(ListingOptions{StartIndex: start, MaxResults: FETCH_PAGE_SIZE})
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, items...)
+
+		if len(items) < FETCH_PAGE_SIZE {
+			return result, nil
+		}
+	}
+}
+
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 // SetUserAgent configures user-agent string based on app name and version
@@ -93,6 +175,20 @@ func (api *API) SetUserAgent(app, version string) {
 	api.Client.Name = getUserAgent(app, version)
 }
 
+// WithContext returns shallow copy of API which uses given context for all
+// requests. Copy shares client, limiters, circuit breaker and nodes with the
+// original API.
+func (api *API) WithContext(ctx context.Context) *API {
+	if ctx == nil {
+		panic("nil context")
+	}
+
+	apiCopy := *api
+	apiCopy.ctx = ctx
+
+	return &apiCopy
+}
+
 // GetUser returns a user
 func (api *API) GetUser(userName string, withAttributes bool) (*User, error) {
 	url := "rest/usermanagement/1/user?username=" + esc(userName)
@@ -113,11 +209,111 @@ func (api *API) GetUser(userName string, withAttributes bool) (*User, error) {
 		return result, nil
 	case 403:
 		return nil, ErrNoPerms
+	case 404:
+		return nil, ErrUserNoFound
 	default:
 		return nil, makeUnknownError(statusCode)
 	}
 }
 
+// CreateUser creates new user. Password is optional, user without password
+// can't authenticate until it is set.
+func (api *API) CreateUser(user *User) error {
+	statusCode, err := api.doRequest(
+		"POST", "rest/usermanagement/1/user",
+		nil, newUserEntity(user),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 201:
+		return nil
+	case 400:
+		return ErrInvalidUser
+	case 403:
+		return ErrNoPerms
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
+// UpdateUser updates user info (names, email and status). User can't be renamed
+// and password can't be changed using this method.
+func (api *API) UpdateUser(user *User) error {
+	statusCode, err := api.doRequest(
+		"PUT", "rest/usermanagement/1/user?username="+esc(user.Name),
+		nil, newUserEntity(user),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 204:
+		return nil
+	case 400:
+		return ErrInvalidUser
+	case 403:
+		return ErrNoPerms
+	case 404:
+		return ErrUserNoFound
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
+// SetUserPassword sets new password for user
+func (api *API) SetUserPassword(userName, passWord string) error {
+	statusCode, err := api.doRequest(
+		"PUT", "rest/usermanagement/1/user/password?username="+esc(userName),
+		nil, &password{Value: passWord},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 204:
+		return nil
+	case 400:
+		return ErrInvalidPassword
+	case 403:
+		return ErrNoPerms
+	case 404:
+		return ErrUserNoFound
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
+// DeleteUser deletes user
+func (api *API) DeleteUser(userName string) error {
+	statusCode, err := api.doRequest(
+		"DELETE", "rest/usermanagement/1/user?username="+esc(userName),
+		nil, nil,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 204:
+		return nil
+	case 403:
+		return ErrNoPerms
+	case 404:
+		return ErrUserNoFound
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
 // Login attempts to authenticate a user with the given username and password.
 // It constructs a URL with the given username and sends a POST request to the usermanagement authentication API with the provided password.
 // It returns a pointer to a User object with the user's information on successful authentication, or an error if authentication failed or an unknown error occurred.
@@ -137,6 +333,10 @@ func (api *API) Login(userName, passWord string) (*User, error) {
 	switch statusCode {
 	case 200:
 		return result, nil
+	case 400:
+		return nil, ErrInvalidCredentials
+	case 403:
+		return nil, ErrNoPerms
 	default:
 		return nil, makeUnknownError(statusCode)
 	}
@@ -168,6 +368,14 @@ func (api *API) GetUserAttributes(userName string) (Attributes, error) {
 
 // SetUserAttributes stores all the user attributes for an existing user
 func (api *API) SetUserAttributes(userName string, attrs *UserAttributes) error {
+	if attrs != nil {
+		err := api.UserSchema.validateChanges(attrs.Attributes)
+
+		if err != nil {
+			return err
+		}
+	}
+
 	statusCode, err := api.doRequest(
 		"POST", "rest/usermanagement/1/user/attribute?username="+esc(userName),
 		nil, attrs,
@@ -191,6 +399,12 @@ func (api *API) SetUserAttributes(userName string, attrs *UserAttributes) error
 
 // DeleteUserAttributes deletes a user attribute
 func (api *API) DeleteUserAttributes(userName, attrName string) error {
+	err := api.UserSchema.validateChanges(nil, attrName)
+
+	if err != nil {
+		return err
+	}
+
 	url := fmt.Sprintf(
 		"rest/usermanagement/1/user/attribute?username=%s&attributename=%s",
 		esc(userName), esc(attrName),
@@ -218,6 +432,34 @@ func (api *API) DeleteUserAttributes(userName, attrName string) error {
 	}
 }
 
+// PatchUserAttributes applies given changes to user attributes. Only attributes
+// which differ from current values are written. If base attributes (previously
+// read values) are passed, patch is rejected with ErrAttrsConflict if any of
+// affected attributes was modified since they were read.
+func (api *API) PatchUserAttributes(userName string, diff AttributesDiff, base ...Attributes) error {
+	err := api.UserSchema.validateChanges(diff.updated(), diff.Removed.Names()...)
+
+	if err != nil {
+		return err
+	}
+
+	current, err := api.GetUserAttributes(userName)
+
+	if err != nil {
+		return err
+	}
+
+	return applyAttributesPatch(
+		current, diff, base,
+		func(attrs Attributes) error {
+			return api.SetUserAttributes(userName, &UserAttributes{Attributes: attrs})
+		},
+		func(attrName string) error {
+			return api.DeleteUserAttributes(userName, attrName)
+		},
+	)
+}
+
 // GetUserGroups returns the groups that the user is a member of
 func (api *API) GetUserGroups(userName, groupType string, options ...ListingOptions) ([]*Group, error) {
 	result := &struct {
@@ -244,6 +486,8 @@ func (api *API) GetUserGroups(userName, groupType string, options ...ListingOpti
 		return result.Groups, nil
 	case 403:
 		return nil, ErrNoPerms
+	case 404:
+		return nil, ErrUserNoFound
 	default:
 		return nil, makeUnknownError(statusCode)
 	}
@@ -279,11 +523,85 @@ func (api *API) GetGroup(groupName string, withAttributes bool) (*Group, error)
 		return result, nil
 	case 403:
 		return nil, ErrNoPerms
+	case 404:
+		return nil, ErrGroupNoFound
 	default:
 		return nil, makeUnknownError(statusCode)
 	}
 }
 
+// CreateGroup creates new group. If group type is empty, GROUP_TYPE_GROUP is used.
+func (api *API) CreateGroup(group *Group) error {
+	statusCode, err := api.doRequest(
+		"POST", "rest/usermanagement/1/group",
+		nil, newGroupEntity(group),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 201:
+		return nil
+	case 400:
+		return ErrInvalidGroup
+	case 403:
+		return ErrNoPerms
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
+// UpdateGroup updates group info (description and status). Group can't be
+// renamed using this method.
+func (api *API) UpdateGroup(group *Group) error {
+	statusCode, err := api.doRequest(
+		"PUT", "rest/usermanagement/1/group?groupname="+esc(group.Name),
+		nil, newGroupEntity(group),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 204:
+		return nil
+	case 400:
+		return ErrInvalidGroup
+	case 403:
+		return ErrNoPerms
+	case 404:
+		return ErrGroupNoFound
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
+// DeleteGroup deletes group
+func (api *API) DeleteGroup(groupName string) error {
+	statusCode, err := api.doRequest(
+		"DELETE", "rest/usermanagement/1/group?groupname="+esc(groupName),
+		nil, nil,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 204:
+		return nil
+	case 403:
+		return ErrNoPerms
+	case 404:
+		return ErrGroupNoFound
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
 // GetGroupAttributes returns a list of group attributes
 func (api *API) GetGroupAttributes(groupName string) (Attributes, error) {
 	result := &GroupAttributes{}
@@ -310,6 +628,14 @@ func (api *API) GetGroupAttributes(groupName string) (Attributes, error) {
 
 // SetGroupAttributes stores all the group attributes
 func (api *API) SetGroupAttributes(groupName string, attrs *GroupAttributes) error {
+	if attrs != nil {
+		err := api.GroupSchema.validateChanges(attrs.Attributes)
+
+		if err != nil {
+			return err
+		}
+	}
+
 	statusCode, err := api.doRequest(
 		"POST", "rest/usermanagement/1/group/attribute?groupname="+esc(groupName),
 		nil, attrs,
@@ -333,6 +659,12 @@ func (api *API) SetGroupAttributes(groupName string, attrs *GroupAttributes) err
 
 // DeleteGroupAttributes deletes a group attribute
 func (api *API) DeleteGroupAttributes(groupName, attrName string) error {
+	err := api.GroupSchema.validateChanges(nil, attrName)
+
+	if err != nil {
+		return err
+	}
+
 	url := fmt.Sprintf(
 		"rest/usermanagement/1/group/attribute?groupname=%s&attributename=%s",
 		esc(groupName), esc(attrName),
@@ -360,6 +692,34 @@ func (api *API) DeleteGroupAttributes(groupName, attrName string) error {
 	}
 }
 
+// PatchGroupAttributes applies given changes to group attributes. Only attributes
+// which differ from current values are written. If base attributes (previously
+// read values) are passed, patch is rejected with ErrAttrsConflict if any of
+// affected attributes was modified since they were read.
+func (api *API) PatchGroupAttributes(groupName string, diff AttributesDiff, base ...Attributes) error {
+	err := api.GroupSchema.validateChanges(diff.updated(), diff.Removed.Names()...)
+
+	if err != nil {
+		return err
+	}
+
+	current, err := api.GetGroupAttributes(groupName)
+
+	if err != nil {
+		return err
+	}
+
+	return applyAttributesPatch(
+		current, diff, base,
+		func(attrs Attributes) error {
+			return api.SetGroupAttributes(groupName, &GroupAttributes{Attributes: attrs})
+		},
+		func(attrName string) error {
+			return api.DeleteGroupAttributes(groupName, attrName)
+		},
+	)
+}
+
 // GetGroupUsers returns the users that are members of the specified group
 func (api *API) GetGroupUsers(groupName, groupType string, options ...ListingOptions) ([]*User, error) {
 	result := &struct {
@@ -401,6 +761,151 @@ func (api *API) GetGroupNestedUsers(groupName string, options ...ListingOptions)
 	return api.GetGroupUsers(groupName, GROUP_NESTED, options...)
 }
 
+// AddUserToGroup adds user as direct member of the group
+func (api *API) AddUserToGroup(userName, groupName string) error {
+	statusCode, err := api.doRequest(
+		"POST", "rest/usermanagement/1/group/user/direct?groupname="+esc(groupName),
+		nil, &entityRef{XMLName: xml.Name{Local: "user"}, Name: userName},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 201:
+		return nil
+	case 400:
+		return ErrUserNoFound
+	case 403:
+		return ErrNoPerms
+	case 404:
+		return ErrGroupNoFound
+	case 409:
+		return ErrMembershipExists
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
+// RemoveUserFromGroup removes user from direct members of the group
+func (api *API) RemoveUserFromGroup(userName, groupName string) error {
+	url := fmt.Sprintf(
+		"rest/usermanagement/1/group/user/direct?groupname=%s&username=%s",
+		esc(groupName), esc(userName),
+	)
+
+	statusCode, err := api.doRequest("DELETE", url, nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 204:
+		return nil
+	case 403:
+		return ErrNoPerms
+	case 404:
+		return ErrMembershipNoFound
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
+// GetGroupChildGroups returns the child groups of the specified group
+func (api *API) GetGroupChildGroups(groupName, groupType string, options ...ListingOptions) ([]*Group, error) {
+	result := &struct {
+		Groups []*Group `xml:"group"`
+	}{}
+
+	url := fmt.Sprintf(
+		"rest/usermanagement/1/group/child-group/%s?expand=group&groupname=%s",
+		esc(groupType), esc(groupName),
+	)
+
+	if len(options) > 0 {
+		url += options[0].Encode()
+	}
+
+	statusCode, err := api.doRequest("GET", url, result, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case 200:
+		return result.Groups, nil
+	case 403:
+		return nil, ErrNoPerms
+	case 404:
+		return nil, ErrGroupNoFound
+	default:
+		return nil, makeUnknownError(statusCode)
+	}
+}
+
+// GetGroupDirectChildGroups returns the direct child groups of the specified group
+func (api *API) GetGroupDirectChildGroups(groupName string, options ...ListingOptions) ([]*Group, error) {
+	return api.GetGroupChildGroups(groupName, GROUP_DIRECT, options...)
+}
+
+// GetGroupNestedChildGroups returns the nested child groups of the specified group
+func (api *API) GetGroupNestedChildGroups(groupName string, options ...ListingOptions) ([]*Group, error) {
+	return api.GetGroupChildGroups(groupName, GROUP_NESTED, options...)
+}
+
+// AddChildGroup adds group as direct child of another group
+func (api *API) AddChildGroup(groupName, childGroupName string) error {
+	statusCode, err := api.doRequest(
+		"POST", "rest/usermanagement/1/group/child-group/direct?groupname="+esc(groupName),
+		nil, &entityRef{XMLName: xml.Name{Local: "group"}, Name: childGroupName},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 201:
+		return nil
+	case 400, 404:
+		return ErrGroupNoFound
+	case 403:
+		return ErrNoPerms
+	case 409:
+		return ErrMembershipExists
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
+// RemoveChildGroup removes group from direct children of another group
+func (api *API) RemoveChildGroup(groupName, childGroupName string) error {
+	url := fmt.Sprintf(
+		"rest/usermanagement/1/group/child-group/direct?groupname=%s&child-groupname=%s",
+		esc(groupName), esc(childGroupName),
+	)
+
+	statusCode, err := api.doRequest("DELETE", url, nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case 204:
+		return nil
+	case 403:
+		return ErrNoPerms
+	case 404:
+		return ErrMembershipNoFound
+	default:
+		return makeUnknownError(statusCode)
+	}
+}
+
 // GetMemberships returns full details of all group memberships, with users and
 // nested groups
 func (api *API) GetMemberships() ([]*Membership, error) {
@@ -488,11 +993,12 @@ func (api *API) SearchGroups(cql string, options ...ListingOptions) ([]*Group, e
 
 // doRequest create and execute request
 func (api *API) doRequest(method, uri string, result, body interface{}) (int, error) {
-	req := api.acquireRequest(method, uri)
-	resp := fasthttp.AcquireResponse()
-
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
+	call := &Call{
+		Context: api.getContext(),
+		Method:  method,
+		Path:    uri,
+		Header:  http.Header{},
+	}
 
 	if body != nil {
 		bodyData, err := xml.Marshal(body)
@@ -501,36 +1007,195 @@ func (api *API) doRequest(method, uri string, result, body interface{}) (int, er
 			return -1, err
 		}
 
-		req.SetBody(append([]byte(xml.Header), bodyData...))
+		call.RequestBody = append([]byte(xml.Header), bodyData...)
 	}
 
-	err := api.Client.Do(req, resp)
+	err := api.getInvoker()(call)
 
 	if err != nil {
 		return -1, err
 	}
 
-	statusCode := resp.StatusCode()
-
-	if statusCode != 200 && statusCode >= 500 {
-		return statusCode, decodeInternalError(resp.Body())
+	if call.StatusCode != 200 && call.StatusCode >= 500 {
+		return call.StatusCode, decodeInternalError(call.ResponseBody)
 	}
 
-	if result == nil {
-		return statusCode, nil
+	// Body of non-2xx response contains error info (or isn't XML at all, if
+	// response was sent by proxy), so it must not be decoded as result. Callers
+	// map status codes to errors.
+	if result == nil || call.StatusCode < 200 || call.StatusCode > 299 {
+		return call.StatusCode, nil
 	}
 
-	err = xml.Unmarshal(resp.Body(), result)
+	err = xml.Unmarshal(call.ResponseBody, result)
 
-	return statusCode, err
+	return call.StatusCode, err
 }
 
 // codebeat:enable[ARITY]
 
+// getInvoker returns invoker with all interceptors
+func (api *API) getInvoker() Invoker {
+	invoker := api.execRequest
+
+	for i := len(api.Interceptors) - 1; i >= 0; i-- {
+		invoker = wrapInvoker(api.Interceptors[i], invoker)
+	}
+
+	return invoker
+}
+
+// execRequest executes request and retries it if required
+func (api *API) execRequest(call *Call) error {
+	start := time.Now()
+
+	defer func() { call.Latency = time.Since(start) }()
+
+	for call.Attempts = 1; ; call.Attempts++ {
+		err := api.getLimiter(call.Method).Wait(call.Context)
+
+		if err != nil {
+			return err
+		}
+
+		resp, err := api.sendRequest(call)
+
+		if err == ErrCircuitOpen || isContextError(err) ||
+			!api.RetryPolicy.canRetry(call.Method, call.Attempts, resp, err) {
+			if resp != nil {
+				call.StatusCode, call.ResponseBody = resp.statusCode, resp.body
+			}
+
+			return err
+		}
+
+		var retryAfter time.Duration
+
+		if resp != nil {
+			retryAfter = resp.retryAfter
+		}
+
+		err = sleep(call.Context, api.RetryPolicy.getDelay(call.Attempts, retryAfter))
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sendRequest sends request to Crowd
+func (api *API) sendRequest(call *Call) (*response, error) {
+	if !api.CircuitBreaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	var err error
+
+	nodes := api.pool.pick(api.NodeSelection)
+
+	for i, n := range nodes {
+		err = api.sendNodeRequest(n.url, call, resp)
+
+		// Caller gave up waiting, so neither node nor Crowd is to blame
+		if isContextError(err) {
+			api.CircuitBreaker.release()
+			return nil, err
+		}
+
+		n.isHealthy.Store(err == nil && resp.StatusCode() < 500)
+
+		if i+1 == len(nodes) || !canFailover(call.Method, resp, err) {
+			break
+		}
+
+		resp.Reset()
+	}
+
+	api.CircuitBreaker.report(err == nil && resp.StatusCode() < 500)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &response{
+		statusCode: resp.StatusCode(),
+		body:       append([]byte(nil), resp.Body()...),
+		retryAfter: parseRetryAfter(string(resp.Header.Peek("Retry-After"))),
+	}, nil
+}
+
+// sendNodeRequest sends request to given Crowd node
+func (api *API) sendNodeRequest(baseURL string, call *Call, resp *fasthttp.Response) error {
+	req := api.acquireRequest(baseURL, call.Method, call.Path)
+	defer fasthttp.ReleaseRequest(req)
+
+	for name, values := range call.Header {
+		req.Header.Del(name)
+
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	if call.RequestBody != nil {
+		req.SetBody(call.RequestBody)
+	}
+
+	if call.Context.Err() != nil {
+		return call.Context.Err()
+	}
+
+	var err error
+
+	start := time.Now()
+	deadline, ok := call.Context.Deadline()
+
+	if ok {
+		err = api.Client.DoDeadline(req, resp, deadline)
+	} else {
+		err = api.Client.Do(req, resp)
+	}
+
+	api.Dumper.dump(call.Context, req, resp, time.Since(start), err)
+
+	// Client reports expired deadline as timeout
+	switch {
+	case err == nil:
+		return nil
+	case call.Context.Err() != nil:
+		return call.Context.Err()
+	case ok && !time.Now().Before(deadline):
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
+// getContext returns context for requests
+func (api *API) getContext() context.Context {
+	if api.ctx == nil {
+		return context.Background()
+	}
+
+	return api.ctx
+}
+
+// getLimiter returns rate limiter for given method
+func (api *API) getLimiter(method string) *RateLimiter {
+	if method == "GET" || method == "HEAD" {
+		return api.ReadLimiter
+	}
+
+	return api.WriteLimiter
+}
+
 // acquireRequest acquire new request with given params
-func (api *API) acquireRequest(method, uri string) *fasthttp.Request {
+func (api *API) acquireRequest(baseURL, method, uri string) *fasthttp.Request {
 	req := fasthttp.AcquireRequest()
-	req.SetRequestURI(api.url + uri)
+	req.SetRequestURI(baseURL + uri)
 
 	if method != "GET" {
 		req.Header.SetMethod(method)
@@ -549,6 +1214,57 @@ func (api *API) acquireRequest(method, uri string) *fasthttp.Request {
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// codebeat:disable[ARITY]
+
+// applyAttributesPatch applies only effective changes to current attributes
+func applyAttributesPatch(
+	current Attributes, diff AttributesDiff, base []Attributes,
+	setFunc func(attrs Attributes) error, deleteFunc func(attrName string) error,
+) error {
+	if len(base) != 0 {
+		for _, name := range diff.Names() {
+			if current.Has(name) != base[0].Has(name) ||
+				!isSameValues(current.GetList(name), base[0].GetList(name)) {
+				return ErrAttrsConflict
+			}
+		}
+	}
+
+	var update Attributes
+
+	for _, attrs := range []Attributes{diff.Added, diff.Changed} {
+		for _, attr := range attrs {
+			if !current.Has(attr.Name) || !isSameValues(current.GetList(attr.Name), attr.Values) {
+				update = append(update, attr)
+			}
+		}
+	}
+
+	if len(update) != 0 {
+		err := setFunc(update)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, attr := range diff.Removed {
+		if !current.Has(attr.Name) {
+			continue
+		}
+
+		err := deleteFunc(attr.Name)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// codebeat:enable[ARITY]
+
 // decodeInternalError decode xml-encoded error
 func decodeInternalError(data []byte) error {
 	ce := &crowdError{}
@@ -583,11 +1299,47 @@ func genBasicAuthHeader(username, password string) string {
 	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
 }
 
+// newUserEntity creates user entity for create and update requests
+func newUserEntity(user *User) *userEntity {
+	result := &userEntity{
+		Name:        user.Name,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		DisplayName: user.DisplayName,
+		Email:       user.Email,
+		IsActive:    user.IsActive,
+	}
+
+	if user.Password != "" {
+		result.Password = &password{Value: user.Password}
+	}
+
+	return result
+}
+
+// newGroupEntity creates group entity for create and update requests
+func newGroupEntity(group *Group) *groupEntity {
+	if group.Type != "" {
+		return &groupEntity{Group: group}
+	}
+
+	groupCopy := *group
+	groupCopy.Type = GROUP_TYPE_GROUP
+
+	return &groupEntity{Group: &groupCopy}
+}
+
 // makeUnknownError create error struct for unknown error
 func makeUnknownError(statusCode int) error {
 	return fmt.Errorf("Unknown error occurred (status code %d)", statusCode)
 }
 
+// isContextError returns true if error is caused by cancelled or expired
+// context
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 // esc escapes the string so it can be safely placed inside a URL query
 func esc(s string) string {
 	return url.QueryEscape(s)
@@ -8,7 +8,23 @@ package crowd
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
 
 	. "github.com/essentialkaos/check"
 )
@@ -49,6 +65,144 @@ func (s *CrowdSuite) TestAttributesHelpers(c *C) {
 	c.Assert(attrs.Get("magic"), Equals, "ABCD")
 }
 
+func (s *CrowdSuite) TestErrorReason(c *C) {
+	c.Assert(ErrorReason(nil), Equals, "")
+	c.Assert(ErrorReason([]byte(`<error><reason>USER_NOT_FOUND</reason><message>User &lt;bob&gt; does not exist</message></error>`)), Equals, "USER_NOT_FOUND")
+}
+
+func (s *CrowdSuite) TestErrorResponses(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.RawQuery, "username=forbidden") {
+			// Proxies may respond without body or with HTML page
+			w.WriteHeader(403)
+			return
+		}
+
+		w.WriteHeader(404)
+		w.Write([]byte(`<error><reason>USER_NOT_FOUND</reason><message>User &lt;bob&gt; does not exist</message></error>`))
+	}))
+
+	defer srv.Close()
+
+	api, _ := NewAPI(srv.URL+"/", "app", "test")
+
+	// Error body of non-2xx response isn't decoded as result, so status code
+	// mapping is used instead of XML decoding error
+	_, err := api.GetUserAttributes("bob")
+	c.Assert(err, Equals, ErrUserNoFound)
+	_, err = api.GetGroupAttributes("devs")
+	c.Assert(err, Equals, ErrGroupNoFound)
+	_, err = api.GetUser("forbidden", false)
+	c.Assert(err, Equals, ErrNoPerms)
+}
+
+func (s *CrowdSuite) TestAttributesBinding(c *C) {
+	type Profile struct {
+		EmployeeID int           `crowd:"employeeId"`
+		Department string        `crowd:"department"`
+		IsManager  bool          `crowd:"manager"`
+		HireDate   time.Time     `crowd:"hireDate,layout=2006-01-02"`
+		Updated    *time.Time    `crowd:"updated,omitempty"`
+		Phones     []string      `crowd:"phone,omitempty"`
+		Rating     float64       `crowd:"rating"`
+		Level      uint8         `crowd:"level"`
+		Timeout    time.Duration `crowd:"timeout"`
+		IP         net.IP        `crowd:"ip"`
+		Skipped    string        `crowd:"-"`
+		NoTag      string
+	}
+
+	attrs := Attributes{
+		&Attribute{"employeeId", []string{"1234"}},
+		&Attribute{"department", []string{"Ops Team"}},
+		&Attribute{"manager", []string{"true"}},
+		&Attribute{"hireDate", []string{"2021-03-15"}},
+		&Attribute{"phone", []string{"+1 555 0100", "+1 555 0101"}},
+		&Attribute{"rating", []string{"4.5"}},
+		&Attribute{"level", []string{"3"}},
+		&Attribute{"timeout", []string{"1m30s"}},
+		&Attribute{"ip", []string{"192.168.1.1"}},
+		&Attribute{"-", []string{"abcd"}},
+		&Attribute{"NoTag", []string{"abcd"}},
+	}
+
+	p := &Profile{}
+
+	c.Assert(UnmarshalAttributes(attrs, p), IsNil)
+	c.Assert(p.EmployeeID, Equals, 1234)
+	c.Assert(p.Department, Equals, "Ops Team")
+	c.Assert(p.IsManager, Equals, true)
+	c.Assert(p.HireDate.Format("2006-01-02"), Equals, "2021-03-15")
+	c.Assert(p.Updated, IsNil)
+	c.Assert(p.Phones, DeepEquals, []string{"+1 555 0100", "+1 555 0101"})
+	c.Assert(p.Rating, Equals, 4.5)
+	c.Assert(p.Level, Equals, uint8(3))
+	c.Assert(p.Timeout, Equals, 90*time.Second)
+	c.Assert(p.IP.String(), Equals, "192.168.1.1")
+	c.Assert(p.Skipped, Equals, "")
+	c.Assert(p.NoTag, Equals, "")
+
+	encoded, err := MarshalAttributes(p)
+
+	c.Assert(err, IsNil)
+	c.Assert(encoded, HasLen, 9)
+	c.Assert(encoded.GetList("phone"), DeepEquals, []string{"+1 555 0100", "+1 555 0101"})
+	c.Assert(encoded.Get("hireDate"), Equals, "2021-03-15")
+	c.Assert(encoded.Get("ip"), Equals, "192.168.1.1")
+	c.Assert(encoded.Get("timeout"), Equals, "1m30s")
+	c.Assert(encoded.Has("updated"), Equals, false)
+
+	p2 := &Profile{}
+	c.Assert(UnmarshalAttributes(encoded, p2), IsNil)
+	c.Assert(p2, DeepEquals, p)
+
+	c.Assert(UnmarshalAttributes(attrs, nil), Equals, ErrInvalidTarget)
+	c.Assert(UnmarshalAttributes(attrs, *p), Equals, ErrInvalidTarget)
+	_, err = MarshalAttributes("test")
+	c.Assert(err, Equals, ErrInvalidTarget)
+	_, err = MarshalAttributes((*Profile)(nil))
+	c.Assert(err, Equals, ErrInvalidTarget)
+
+	err = UnmarshalAttributes(Attributes{&Attribute{"employeeId", []string{"ABC"}}}, p)
+	c.Assert(err, ErrorMatches, `Can't decode attribute "employeeId" into field EmployeeID: .*invalid syntax`)
+	err = UnmarshalAttributes(Attributes{&Attribute{"department", []string{"A", "B"}}}, p)
+	c.Assert(err, ErrorMatches, `Can't decode attribute "department" into field Department: Attribute has 2 values, but field isn't a slice`)
+
+	var dates struct {
+		Created  time.Time  `crowd:"created,omitempty,layout=Mon, 02 Jan 2006 15:04:05 MST"`
+		Modified *time.Time `crowd:"modified"`
+	}
+
+	err = UnmarshalAttributes(Attributes{&Attribute{"created", []string{"Mon, 15 Mar 2021 10:00:00 UTC"}}}, &dates)
+	c.Assert(err, IsNil)
+	c.Assert(dates.Created.Format("2006-01-02"), Equals, "2021-03-15")
+
+	encoded, err = MarshalAttributes(dates)
+	c.Assert(err, IsNil)
+	c.Assert(encoded, HasLen, 1)
+	c.Assert(encoded.Get("created"), Equals, "Mon, 15 Mar 2021 10:00:00 UTC")
+
+	var login struct {
+		LastLogin time.Time `crowd:"lastAuthenticated,layout=unixmilli"`
+	}
+
+	err = UnmarshalAttributes(Attributes{&Attribute{"lastAuthenticated", []string{"1700000000123"}}}, &login)
+	c.Assert(err, IsNil)
+	c.Assert(login.LastLogin.UnixMilli(), Equals, int64(1700000000123))
+
+	err = UnmarshalAttributes(Attributes{&Attribute{"created", []string{"1700000000123"}}}, &dates)
+	c.Assert(err, ErrorMatches, `Can't decode attribute "created" into field Created: .*`)
+
+	var bad struct {
+		Data map[string]string `crowd:"data"`
+	}
+
+	err = UnmarshalAttributes(Attributes{&Attribute{"data", []string{"A"}}}, &bad)
+	c.Assert(err, ErrorMatches, `.*Unsupported field type map\[string\]string`)
+	_, err = MarshalAttributes(bad)
+	c.Assert(err, ErrorMatches, `.*Unsupported field type map\[string\]string`)
+}
+
 func (s *CrowdSuite) TestListingOptionsEncoder(c *C) {
 	l1 := ListingOptions{}
 	l2 := ListingOptions{MaxResults: 3}
@@ -62,3 +216,1022 @@ func (s *CrowdSuite) TestListingOptionsEncoder(c *C) {
 	c.Assert(l4.Encode(), Equals, "&start-index=5&max-results=7")
 	c.Assert(l5.Encode(), Equals, "")
 }
+
+func (s *CrowdSuite) TestFetchAll(c *C) {
+	var pages []ListingOptions
+
+	items, err := FetchAll(func(opts ListingOptions) ([]int, error) {
+		pages = append(pages, opts)
+
+		if opts.StartIndex < 2*FETCH_PAGE_SIZE {
+			return make([]int, FETCH_PAGE_SIZE), nil
+		}
+
+		return make([]int, 3), nil
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 2*FETCH_PAGE_SIZE+3)
+	c.Assert(pages, DeepEquals, []ListingOptions{
+		{StartIndex: 0, MaxResults: FETCH_PAGE_SIZE},
+		{StartIndex: FETCH_PAGE_SIZE, MaxResults: FETCH_PAGE_SIZE},
+		{StartIndex: 2 * FETCH_PAGE_SIZE, MaxResults: FETCH_PAGE_SIZE},
+	})
+
+	items, err = FetchAll(func(opts ListingOptions) ([]int, error) {
+		if opts.StartIndex > 0 {
+			return nil, errors.New("error")
+		}
+
+		return make([]int, FETCH_PAGE_SIZE), nil
+	})
+
+	c.Assert(err, NotNil)
+	c.Assert(items, IsNil)
+}
+
+func (s *CrowdSuite) TestRetry(c *C) {
+	var hits atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(503)
+			return
+		}
+
+		w.Write([]byte(`<user name="john"><active>true</active></user>`))
+	}))
+
+	defer srv.Close()
+
+	api, _ := NewAPI(srv.URL+"/", "app", "test")
+	api.RetryPolicy = &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	user, err := api.GetUser("john", false)
+
+	c.Assert(err, IsNil)
+	c.Assert(user.Name, Equals, "john")
+	c.Assert(hits.Load(), Equals, int32(3))
+
+	hits.Store(0)
+	_, err = api.Login("john", "test")
+
+	c.Assert(err, NotNil)
+	c.Assert(hits.Load(), Equals, int32(1))
+
+	hits.Store(0)
+	api.RetryPolicy.RetryNonIdempotent = true
+	_, err = api.Login("john", "test")
+
+	c.Assert(err, IsNil)
+	c.Assert(hits.Load(), Equals, int32(3))
+}
+
+func (s *CrowdSuite) TestRetryPolicy(c *C) {
+	var p *RetryPolicy
+
+	c.Assert(p.canRetry("GET", 1, nil, http.ErrHandlerTimeout), Equals, false)
+
+	p = &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 3 * time.Second}
+
+	c.Assert(p.canRetry("GET", 1, nil, http.ErrHandlerTimeout), Equals, true)
+	c.Assert(p.canRetry("GET", 5, nil, http.ErrHandlerTimeout), Equals, false)
+	c.Assert(p.canRetry("POST", 1, nil, http.ErrHandlerTimeout), Equals, false)
+	c.Assert(p.canRetry("GET", 1, &response{statusCode: 503}, nil), Equals, true)
+	c.Assert(p.canRetry("GET", 1, &response{statusCode: 500}, nil), Equals, false)
+	c.Assert(p.canRetry("GET", 1, &response{statusCode: 429, retryAfter: 3 * time.Second}, nil), Equals, true)
+	c.Assert(p.canRetry("GET", 1, &response{statusCode: 429, retryAfter: time.Hour}, nil), Equals, false)
+
+	c.Assert(p.getDelay(1, 0), Equals, time.Second)
+	c.Assert(p.getDelay(2, 0), Equals, 2*time.Second)
+	c.Assert(p.getDelay(3, 0), Equals, 3*time.Second)
+	c.Assert(p.getDelay(1, 2*time.Second), Equals, 2*time.Second)
+	c.Assert(p.getDelay(3, time.Second), Equals, 3*time.Second)
+
+	p.MaxDelay = 0
+
+	c.Assert(p.canRetry("GET", 1, &response{statusCode: 429, retryAfter: time.Hour}, nil), Equals, true)
+	c.Assert(p.getDelay(1, time.Hour), Equals, time.Hour)
+
+	c.Assert(parseRetryAfter(""), Equals, time.Duration(0))
+	c.Assert(parseRetryAfter("abcd"), Equals, time.Duration(0))
+	c.Assert(parseRetryAfter("10"), Equals, 10*time.Second)
+	c.Assert(parseRetryAfter("Mon, 01 Jan 2001 00:00:00 GMT"), Equals, time.Duration(0))
+
+	future := time.Now().Add(time.Hour).UTC()
+
+	for _, layout := range []string{http.TimeFormat, time.RFC850, time.ANSIC} {
+		delay := parseRetryAfter(future.Format(layout))
+		c.Assert(delay > 59*time.Minute && delay <= time.Hour, Equals, true, Commentf("Layout: %s", layout))
+	}
+}
+
+func (s *CrowdSuite) TestCircuitBreaker(c *C) {
+	var hits atomic.Int32
+	var failing atomic.Bool
+	var changes []string
+
+	failing.Store(true)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+
+		if failing.Load() {
+			w.WriteHeader(503)
+			return
+		}
+
+		w.Write([]byte(`<user name="john"><active>true</active></user>`))
+	}))
+
+	defer srv.Close()
+
+	api, _ := NewAPI(srv.URL+"/", "app", "test")
+	api.CircuitBreaker = &CircuitBreaker{
+		MinRequests: 2,
+		Cooldown:    50 * time.Millisecond,
+		OnStateChange: func(from, to BreakerState) {
+			changes = append(changes, from.String()+"→"+to.String())
+		},
+	}
+
+	_, err := api.GetUser("john", false)
+	c.Assert(err, NotNil)
+	_, err = api.GetUser("john", false)
+	c.Assert(err, NotNil)
+	c.Assert(api.CircuitBreaker.State(), Equals, BREAKER_OPEN)
+
+	_, err = api.GetUser("john", false)
+	c.Assert(err, Equals, ErrCircuitOpen)
+	c.Assert(hits.Load(), Equals, int32(2))
+
+	time.Sleep(60 * time.Millisecond)
+	failing.Store(false)
+
+	_, err = api.GetUser("john", false)
+	c.Assert(err, IsNil)
+	c.Assert(api.CircuitBreaker.State(), Equals, BREAKER_CLOSED)
+	c.Assert(changes, DeepEquals, []string{"closed→open", "open→half-open", "half-open→closed"})
+
+	var b *CircuitBreaker
+
+	c.Assert(b.allow(), Equals, true)
+	c.Assert(b.State(), Equals, BREAKER_CLOSED)
+	c.Assert(BreakerState(10).String(), Equals, "unknown")
+}
+
+func (s *CrowdSuite) TestClusterFailover(c *C) {
+	var failing atomic.Bool
+	var hits1, hits2 atomic.Int32
+
+	failing.Store(true)
+
+	srv1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits1.Add(1)
+
+		if failing.Load() {
+			w.WriteHeader(503)
+			return
+		}
+
+		w.Write([]byte(`<user name="john"><active>true</active></user>`))
+	}))
+
+	srv2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+healthProbePath {
+			return
+		}
+
+		hits2.Add(1)
+		w.Write([]byte(`<user name="john"><active>true</active></user>`))
+	}))
+
+	srv3 := httptest.NewServer(http.NotFoundHandler())
+	srv3.Close()
+
+	defer srv1.Close()
+	defer srv2.Close()
+
+	_, err := NewClusterAPI(nil, "app", "test")
+	c.Assert(err, Equals, ErrInitEmptyURL)
+	_, err = NewClusterAPI([]string{srv1.URL, ""}, "app", "test")
+	c.Assert(err, Equals, ErrInitEmptyURL)
+
+	api, err := NewClusterAPI([]string{srv3.URL + "/", srv1.URL + "/", srv2.URL + "/"}, "app", "test")
+
+	c.Assert(err, IsNil)
+	api.NodeSelection = SELECT_PRIORITY
+
+	user, err := api.GetUser("john", false)
+
+	c.Assert(err, IsNil)
+	c.Assert(user.Name, Equals, "john")
+	c.Assert(hits1.Load(), Equals, int32(1))
+	c.Assert(hits2.Load(), Equals, int32(1))
+	c.Assert(api.Nodes(), DeepEquals, []NodeStatus{
+		{srv3.URL + "/", false}, {srv1.URL + "/", false}, {srv2.URL + "/", true},
+	})
+
+	_, err = api.GetUser("john", false)
+
+	c.Assert(err, IsNil)
+	c.Assert(hits1.Load(), Equals, int32(1))
+	c.Assert(hits2.Load(), Equals, int32(2))
+
+	failing.Store(false)
+	api.StartHealthProbe(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	api.StopHealthProbe()
+
+	c.Assert(api.Nodes()[1].IsHealthy, Equals, true)
+
+	_, err = api.GetUser("john", false)
+
+	c.Assert(err, IsNil)
+	c.Assert(hits1.Load() > 1, Equals, true)
+	c.Assert(hits2.Load(), Equals, int32(2))
+}
+
+func (s *CrowdSuite) TestClusterCancellation(c *C) {
+	var hits atomic.Int32
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`<user name="john"><active>true</active></user>`))
+	})
+
+	srv1, srv2 := httptest.NewServer(handler), httptest.NewServer(handler)
+
+	defer srv1.Close()
+	defer srv2.Close()
+
+	api, _ := NewClusterAPI([]string{srv1.URL + "/", srv2.URL + "/"}, "app", "test")
+	api.NodeSelection = SELECT_PRIORITY
+	api.CircuitBreaker = &CircuitBreaker{MinRequests: 1, HalfOpenRequests: 1}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Caller's deadline doesn't affect node health and circuit breaker
+	_, err := api.WithContext(ctx).GetUser("john", false)
+
+	c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+	c.Assert(hits.Load(), Equals, int32(1))
+	c.Assert(api.Nodes(), DeepEquals, []NodeStatus{
+		{srv1.URL + "/", true}, {srv2.URL + "/", true},
+	})
+	c.Assert(api.CircuitBreaker.State(), Equals, BREAKER_CLOSED)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	_, err = api.WithContext(ctx).GetUser("john", false)
+
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+	c.Assert(api.CircuitBreaker.State(), Equals, BREAKER_CLOSED)
+
+	// Cancelled trial request releases slot in half-open state
+	api.CircuitBreaker.mu.Lock()
+	api.CircuitBreaker.setState(BREAKER_HALF_OPEN)
+	api.CircuitBreaker.mu.Unlock()
+
+	_, err = api.WithContext(ctx).GetUser("john", false)
+
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+	c.Assert(api.CircuitBreaker.allow(), Equals, true)
+}
+
+func (s *CrowdSuite) TestRateLimiter(c *C) {
+	var l *RateLimiter
+
+	c.Assert(l.Wait(context.Background()), IsNil)
+	c.Assert(NewRateLimiter(0, 10), IsNil)
+
+	l = NewRateLimiter(20, 2)
+	start := time.Now()
+
+	for range 3 {
+		c.Assert(l.Wait(context.Background()), IsNil)
+	}
+
+	c.Assert(time.Since(start) >= 40*time.Millisecond, Equals, true)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<user name="john"><active>true</active></user>`))
+	}))
+
+	defer srv.Close()
+
+	api, _ := NewAPI(srv.URL+"/", "app", "test")
+	api.ReadLimiter = NewRateLimiter(1, 1)
+
+	_, err := api.GetUser("john", false)
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = api.WithContext(ctx).GetUser("john", false)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+
+	c.Assert(func() { api.WithContext(nil) }, PanicMatches, "nil context")
+}
+
+func (s *CrowdSuite) TestInterceptors(c *C) {
+	var hits atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte(`<user name="` + r.Header.Get("X-Request-Id") + `"><active>true</active></user>`))
+	}))
+
+	defer srv.Close()
+
+	var calls []string
+
+	api, _ := NewAPI(srv.URL+"/", "app", "test")
+	api.Interceptors = []Interceptor{
+		func(call *Call, next Invoker) error {
+			err := next(call)
+			calls = append(calls, fmt.Sprintf("%s %s %d %d", call.Method, call.Endpoint(), call.StatusCode, call.Attempts))
+			return err
+		},
+		func(call *Call, next Invoker) error {
+			call.Header.Set("X-Request-Id", "req1")
+
+			if strings.Contains(call.Path, "username=fake") {
+				call.StatusCode = 200
+				call.ResponseBody = []byte(`<user name="fake"><active>true</active></user>`)
+				return nil
+			}
+
+			return next(call)
+		},
+	}
+
+	user, err := api.GetUser("john", false)
+
+	c.Assert(err, IsNil)
+	c.Assert(user.Name, Equals, "req1")
+
+	user, err = api.GetUser("fake", false)
+
+	c.Assert(err, IsNil)
+	c.Assert(user.Name, Equals, "fake")
+	c.Assert(hits.Load(), Equals, int32(1))
+	c.Assert(calls, DeepEquals, []string{
+		"GET rest/usermanagement/1/user 200 1",
+		"GET rest/usermanagement/1/user 200 0",
+	})
+}
+
+func (s *CrowdSuite) TestDumper(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<user name="john"><attributes><attribute name="pin"><values><value>1234</value></values></attribute></attributes></user>`))
+	}))
+
+	defer srv.Close()
+
+	buf := &bytes.Buffer{}
+
+	api, _ := NewAPI(srv.URL+"/", "app", "SuperSecret")
+	api.Dumper = &Dumper{
+		Logger:              slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		SensitiveAttributes: []string{"PIN"},
+	}
+
+	_, err := api.Login("john", "MyPassword")
+
+	c.Assert(err, IsNil)
+
+	data := buf.String()
+
+	c.Assert(strings.Contains(data, `msg="Crowd request"`), Equals, true)
+	c.Assert(strings.Contains(data, `msg="Crowd response"`), Equals, true)
+	c.Assert(strings.Contains(data, "MyPassword"), Equals, false)
+	c.Assert(strings.Contains(data, "1234"), Equals, false)
+	c.Assert(strings.Contains(data, genBasicAuthHeader("app", "SuperSecret")), Equals, false)
+	c.Assert(strings.Contains(data, "Authorization:Basic [REDACTED]"), Equals, true)
+
+	d := &Dumper{SensitiveAttributes: []string{"token"}}
+
+	c.Assert(d.Redact(""), Equals, "")
+	c.Assert(
+		d.Redact(`<attributes><attribute name="token"><values><value>A</value><value>B</value></values></attribute><attribute name="dep"><values><value>C</value></values></attribute></attributes>`),
+		Equals,
+		`<attributes><attribute name="token"><values><value>[REDACTED]</value><value>[REDACTED]</value></values></attribute><attribute name="dep"><values><value>C</value></values></attribute></attributes>`,
+	)
+	c.Assert(
+		(*Dumper)(nil).Redact(`<password><value>test</value></password>`),
+		Equals, `<password><value>[REDACTED]</value></password>`,
+	)
+}
+
+func (s *CrowdSuite) TestAttributesTypedAccessors(c *C) {
+	attrs := AttributesFromMap(map[string][]string{
+		"lastAuthenticated":       {"1700000000123"},
+		"invalidPasswordAttempts": {"3"},
+		"requiresPasswordChange":  {"false"},
+		"hireDate":                {"2021-03-15"},
+		"updated":                 {"2024-01-02T03:04:05Z"},
+		"ttl":                     {"1h30m"},
+		"settings":                {`{"theme":"dark","size":3}`},
+		"title":                   {"Senior Engineer"},
+		"phones":                  {"1", "2"},
+		"empty":                   {},
+	})
+
+	_, err := attrs.GetTime("lastAuthenticated", "")
+	c.Assert(err, NotNil)
+
+	ts, err := attrs.GetTime("lastAuthenticated", TIME_UNIX_MILLI)
+	c.Assert(err, IsNil)
+	c.Assert(ts.UnixMilli(), Equals, int64(1700000000123))
+
+	ts, err = attrs.GetTime("hireDate", "2006-01-02")
+	c.Assert(err, IsNil)
+	c.Assert(ts.Format("02.01.2006"), Equals, "15.03.2021")
+
+	ts, err = attrs.GetTime("updated", "")
+	c.Assert(err, IsNil)
+	c.Assert(ts.Unix(), Equals, int64(1704164645))
+
+	i, err := attrs.GetInt("invalidPasswordAttempts")
+	c.Assert(err, IsNil)
+	c.Assert(i, Equals, 3)
+
+	b, err := attrs.GetBool("requiresPasswordChange")
+	c.Assert(err, IsNil)
+	c.Assert(b, Equals, false)
+
+	d, err := attrs.GetDuration("ttl")
+	c.Assert(err, IsNil)
+	c.Assert(d, Equals, 90*time.Minute)
+
+	settings := struct {
+		Theme string `json:"theme"`
+		Size  int    `json:"size"`
+	}{}
+
+	c.Assert(attrs.GetJSON("settings", &settings), IsNil)
+	c.Assert(settings.Theme, Equals, "dark")
+	c.Assert(settings.Size, Equals, 3)
+
+	_, err = attrs.GetInt("title")
+	c.Assert(err, ErrorMatches, `Can't parse value "Senior Engineer" of attribute "title": .*invalid syntax`)
+	_, err = attrs.GetBool("title")
+	c.Assert(err, NotNil)
+	_, err = attrs.GetDuration("title")
+	c.Assert(err, NotNil)
+	_, err = attrs.GetTime("title", "")
+	c.Assert(err, NotNil)
+	c.Assert(attrs.GetJSON("title", &settings), NotNil)
+
+	_, err = attrs.GetInt("unknown")
+	c.Assert(err, ErrorMatches, `Attribute "unknown" not found`)
+	c.Assert(errors.Is(err, ErrAttrNotFound), Equals, true)
+	_, err = attrs.GetInt("empty")
+	c.Assert(errors.Is(err, ErrAttrNotFound), Equals, true)
+	_, err = attrs.GetInt("phones")
+	c.Assert(err, ErrorMatches, `Attribute "phones" has more than one value`)
+	c.Assert(errors.Is(err, ErrAttrMultiValued), Equals, true)
+}
+
+func (s *CrowdSuite) TestAttributesModification(c *C) {
+	var attrs Attributes
+
+	attrs.Set("a", "1", "2")
+	attrs.Set("b")
+	attrs.Add("c", "1")
+	attrs.Add("c", "2")
+	attrs.Add("c", "2")
+	attrs.Add("a", "3")
+
+	c.Assert(attrs.Names(), DeepEquals, []string{"a", "b", "c"})
+	c.Assert(attrs.GetList("a"), DeepEquals, []string{"1", "2", "3"})
+	c.Assert(attrs.GetList("c"), DeepEquals, []string{"1", "2"})
+
+	clone := attrs.Clone()
+
+	attrs.Set("a", "10")
+	attrs.RemoveValue("c", "1")
+	attrs.RemoveValue("c", "unknown")
+	attrs.RemoveValue("unknown", "1")
+	attrs.Delete("b")
+
+	c.Assert(attrs.ToMap(), DeepEquals, map[string][]string{"a": {"10"}, "c": {"2"}})
+	c.Assert(clone.GetList("a"), DeepEquals, []string{"1", "2", "3"})
+	c.Assert(clone.GetList("c"), DeepEquals, []string{"1", "2"})
+	c.Assert(Attributes(nil).Clone(), IsNil)
+
+	attrs.RemoveValue("c", "2")
+	c.Assert(attrs.Has("c"), Equals, false)
+
+	m := map[string][]string{"x": {"1 2", "3"}, "a": {"4"}}
+	c.Assert(AttributesFromMap(m).Names(), DeepEquals, []string{"a", "x"})
+	c.Assert(AttributesFromMap(m).ToMap(), DeepEquals, m)
+
+	a1 := AttributesFromMap(map[string][]string{"a": {"1"}, "b": {"1", "2"}})
+	a2 := AttributesFromMap(map[string][]string{"b": {"2", "3"}, "c": {"1"}})
+
+	c.Assert(a1.Merge(a2, MERGE_REPLACE).ToMap(), DeepEquals, map[string][]string{
+		"a": {"1"}, "b": {"2", "3"}, "c": {"1"},
+	})
+	c.Assert(a1.Merge(a2, MERGE_KEEP).ToMap(), DeepEquals, map[string][]string{
+		"a": {"1"}, "b": {"1", "2"}, "c": {"1"},
+	})
+	c.Assert(a1.Merge(a2, MERGE_UNION).ToMap(), DeepEquals, map[string][]string{
+		"a": {"1"}, "b": {"1", "2", "3"}, "c": {"1"},
+	})
+	c.Assert(a1.GetList("b"), DeepEquals, []string{"1", "2"})
+
+	values := make([]string, 1, 4)
+	values[0] = "1"
+	shared := Attributes{&Attribute{"a", values}}
+
+	shared.Add("a", "2")
+	shared.RemoveValue("a", "1")
+
+	c.Assert(shared.GetList("a"), DeepEquals, []string{"2"})
+	c.Assert(values[:2], DeepEquals, []string{"1", ""})
+
+	// Modification of copy doesn't affect original attributes
+	orig := AttributesFromMap(map[string][]string{"a": {"1"}, "b": {"2"}})
+
+	cp := orig
+	cp.Add("a", "3")
+	cp.Set("b", "4")
+	cp.RemoveValue("a", "1")
+	cp.Add("c", "5")
+
+	c.Assert(orig.ToMap(), DeepEquals, map[string][]string{"a": {"1"}, "b": {"2"}})
+	c.Assert(cp.ToMap(), DeepEquals, map[string][]string{"a": {"3"}, "b": {"4"}, "c": {"5"}})
+
+	cp = orig
+	cp.Delete("a")
+
+	c.Assert(orig.Has("b"), Equals, true)
+	c.Assert(orig.Names(), DeepEquals, []string{"a", "b"})
+	c.Assert(cp.Names(), DeepEquals, []string{"b"})
+
+	// Appending to copies with spare capacity doesn't overwrite each other
+	base := make(Attributes, 0, 4)
+	cp1, cp2 := base, base
+	cp1.Add("a", "1")
+	cp2.Add("b", "1")
+
+	c.Assert(cp1.Names(), DeepEquals, []string{"a"})
+	c.Assert(cp2.Names(), DeepEquals, []string{"b"})
+}
+
+func (s *CrowdSuite) TestAttributesDiff(c *C) {
+	a1 := Attributes{
+		&Attribute{"same", []string{"A", "B"}},
+		&Attribute{"changed", []string{"A"}},
+		&Attribute{"removed", []string{"A"}},
+	}
+
+	a2 := Attributes{
+		&Attribute{"same", []string{"B", "A"}},
+		&Attribute{"changed", []string{"A", "C"}},
+		&Attribute{"added", []string{"D"}},
+	}
+
+	diff := a1.Diff(a2)
+
+	c.Assert(diff.IsEmpty(), Equals, false)
+	c.Assert(diff.Added, DeepEquals, Attributes{a2[2]})
+	c.Assert(diff.Changed, DeepEquals, Attributes{a2[1]})
+	c.Assert(diff.Removed, DeepEquals, Attributes{a1[2]})
+	c.Assert(diff.Names(), DeepEquals, []string{"added", "changed", "removed"})
+	c.Assert(a1.Diff(a1).IsEmpty(), Equals, true)
+}
+
+func (s *CrowdSuite) TestAttributesPatch(c *C) {
+	srv := crowdtest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser(&crowdtest.User{
+		Name:       "john",
+		Attributes: map[string][]string{"a": {"1"}, "b": {"2"}, "c": {"3"}},
+	})
+
+	srv.AddGroup(&crowdtest.Group{
+		Name:       "ops",
+		Attributes: map[string][]string{"a": {"1"}},
+	})
+
+	api, _ := NewAPI(srv.URL(), "app", "test")
+
+	base, err := api.GetUserAttributes("john")
+	c.Assert(err, IsNil)
+
+	desired := Attributes{
+		&Attribute{"a", []string{"1"}},
+		&Attribute{"b", []string{"20"}},
+		&Attribute{"d", []string{"4"}},
+	}
+
+	srv.ResetRequests()
+
+	c.Assert(api.PatchUserAttributes("john", base.Diff(desired), base), IsNil)
+	c.Assert(srv.User("john").Attributes, DeepEquals, map[string][]string{
+		"a": {"1"}, "b": {"20"}, "d": {"4"},
+	})
+	c.Assert(srv.Requests(), DeepEquals, []string{
+		"GET user/attribute", "POST user/attribute", "DELETE user/attribute",
+	})
+
+	// Conflict: "b" was modified by another writer after we read it
+	c.Assert(api.PatchUserAttributes("john", base.Diff(desired), base), Equals, ErrAttrsConflict)
+
+	// Without base there is no conflict check and nothing to change
+	srv.ResetRequests()
+	c.Assert(api.PatchUserAttributes("john", base.Diff(desired)), IsNil)
+	c.Assert(srv.Requests(), DeepEquals, []string{"GET user/attribute"})
+
+	c.Assert(api.PatchUserAttributes("bob", base.Diff(desired)), Equals, ErrUserNoFound)
+
+	groupAttrs, err := api.GetGroupAttributes("ops")
+	c.Assert(err, IsNil)
+
+	diff := groupAttrs.Diff(Attributes{&Attribute{"b", []string{"2"}}})
+
+	c.Assert(api.PatchGroupAttributes("ops", diff, groupAttrs), IsNil)
+	c.Assert(srv.Group("ops").Attributes, DeepEquals, map[string][]string{"b": {"2"}})
+	c.Assert(api.PatchGroupAttributes("unknown", diff), Equals, ErrGroupNoFound)
+}
+
+func (s *CrowdSuite) TestAttributeSchema(c *C) {
+	srv := crowdtest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser(&crowdtest.User{
+		Name:       "john",
+		Attributes: map[string][]string{"employeeId": {"1234"}},
+	})
+
+	srv.AddUser(&crowdtest.User{
+		Name: "bob",
+		Attributes: map[string][]string{
+			"employeeId": {"A1"}, "phone": {"1", "2"},
+			"lastAuthenticated": {"1700000000123"}, "invalidPasswordAttempts": {"0"},
+		},
+	})
+
+	api, _ := NewAPI(srv.URL(), "app", "test")
+	api.UserSchema = &AttributeSchema{
+		Rules: []*AttributeRule{
+			{Name: "employeeId", Required: true, Pattern: regexp.MustCompile(`^[0-9]+$`)},
+			{Name: "phone", MultiValued: true, MaxLength: 5},
+			{Name: "title"},
+		},
+		Strict: true,
+	}
+
+	err := api.SetUserAttributes("john", &UserAttributes{Attributes: Attributes{
+		&Attribute{"employeeId", []string{"12a", "13"}},
+		&Attribute{"phone", []string{"+1 555 0100"}},
+		&Attribute{"unknown", []string{"1"}},
+	}})
+
+	c.Assert(err, FitsTypeOf, &ValidationError{})
+	c.Assert(err.(*ValidationError).Violations, HasLen, 4)
+	c.Assert(err, ErrorMatches, `Attributes validation failed: `+
+		`employeeId: attribute must be single-valued, but has 2 values; `+
+		`employeeId: value doesn't match pattern "\^\[0-9\]\+\$" \(value "12a"\); `+
+		`phone: value is longer than 5 characters \(value "\+1 555 0100"\); `+
+		`unknown: attribute is not allowed by schema`)
+
+	err = api.DeleteUserAttributes("john", "employeeId")
+	c.Assert(err, ErrorMatches, `Attributes validation failed: employeeId: required attribute can't be deleted`)
+
+	err = api.PatchUserAttributes("john", AttributesDiff{Added: Attributes{&Attribute{"title", nil}}})
+	c.Assert(err, IsNil)
+
+	err = api.PatchUserAttributes("john", AttributesDiff{Changed: Attributes{&Attribute{"employeeId", nil}}})
+	c.Assert(err, ErrorMatches, `Attributes validation failed: employeeId: required attribute must have a value`)
+
+	c.Assert(srv.User("john").Attributes, DeepEquals, map[string][]string{
+		"employeeId": {"1234"}, "title": nil,
+	})
+
+	report, err := api.CheckUsersAttributes("john", "bob")
+
+	c.Assert(err, IsNil)
+	c.Assert(report.Checked, Equals, 2)
+	c.Assert(report.IsValid(), Equals, false)
+	c.Assert(report.String(), Equals, "bob:\n"+
+		"  - employeeId: value doesn't match pattern \"^[0-9]+$\" (value \"A1\")\n")
+
+	_, err = api.CheckUsersAttributes("unknown")
+	c.Assert(err, ErrorMatches, `Can't get attributes of "unknown": User could not be found`)
+
+	srv.AddGroup(&crowdtest.Group{
+		Name:       "ops",
+		Attributes: map[string][]string{TEMP_MEMBERS_ATTR: {"2030-01-01T00:00:00Z john"}},
+	})
+
+	report, err = api.CheckGroupsAttributes("ops")
+
+	c.Assert(err, IsNil)
+	c.Assert(report.IsValid(), Equals, true)
+	c.Assert(report.String(), Equals, "No violations found")
+
+	api.GroupSchema = &AttributeSchema{Strict: true}
+	report, _ = api.CheckGroupsAttributes("ops")
+	c.Assert(report.IsValid(), Equals, true)
+
+	api.GroupSchema = api.UserSchema
+	report, _ = api.CheckGroupsAttributes("ops")
+	c.Assert(report.String(), Equals, "ops:\n  - employeeId: required attribute is missing\n")
+}
+
+func (s *CrowdSuite) TestTemporaryMembership(c *C) {
+	srv := crowdtest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser(&crowdtest.User{Name: "john"})
+	srv.AddUser(&crowdtest.User{Name: "bob"})
+	srv.AddUser(&crowdtest.User{Name: "alice"})
+	srv.AddGroup(&crowdtest.Group{
+		Name:  "oncall",
+		Users: []string{"alice", "bob"},
+		Attributes: map[string][]string{
+			TEMP_MEMBERS_ATTR: {"2020-01-01T00:00:00Z bob", "invalid"},
+		},
+	})
+
+	api, _ := NewAPI(srv.URL(), "app", "test")
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	c.Assert(api.GrantTemporaryMembership("john", "oncall", time.Now().Add(-time.Hour)), Equals, ErrInvalidExpiry)
+	c.Assert(api.GrantTemporaryMembership("alice", "oncall", until), Equals, ErrMembershipExists)
+	c.Assert(api.GrantTemporaryMembership("john", "unknown", until), Equals, ErrGroupNoFound)
+	c.Assert(api.GrantTemporaryMembership("john", "oncall", until), IsNil)
+	c.Assert(api.GrantTemporaryMembership("john", "oncall", until.Add(time.Hour)), IsNil)
+
+	c.Assert(srv.Group("oncall").Users, DeepEquals, []string{"alice", "bob", "john"})
+
+	memberships, err := api.GetTemporaryMemberships("oncall")
+
+	c.Assert(err, IsNil)
+	c.Assert(memberships, DeepEquals, []*TemporaryMembership{
+		{"bob", "oncall", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"john", "oncall", until.Add(time.Hour)},
+	})
+
+	var reports []*SweepReport
+
+	sweeper := NewMembershipSweeper(api, "oncall", "unknown")
+	sweeper.OnSweep = func(r *SweepReport) { reports = append(reports, r) }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	sweeper.Run(ctx, 20*time.Millisecond)
+
+	c.Assert(len(reports) >= 2, Equals, true)
+	c.Assert(reports[0].Removed, DeepEquals, memberships[:1])
+	c.Assert(reports[0].Errors, HasLen, 1)
+	c.Assert(reports[0].Errors[0], ErrorMatches, `Can't get attributes of group "unknown": Group could not be found`)
+	c.Assert(reports[1].Removed, HasLen, 0)
+
+	// Invalid interval doesn't cause panic
+	reports = nil
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	sweeper.Run(ctx, 0)
+	c.Assert(reports, HasLen, 1)
+
+	c.Assert(srv.Group("oncall").Users, DeepEquals, []string{"alice", "john"})
+	c.Assert(srv.Group("oncall").Attributes[TEMP_MEMBERS_ATTR], DeepEquals, []string{
+		"invalid", until.Add(time.Hour).Format(time.RFC3339) + " john",
+	})
+
+	c.Assert(api.RemoveUserFromGroup("bob", "oncall"), Equals, ErrMembershipNoFound)
+	c.Assert(api.AddUserToGroup("unknown", "oncall"), Equals, ErrUserNoFound)
+	c.Assert(api.AddUserToGroup("bob", "unknown"), Equals, ErrGroupNoFound)
+}
+
+func (s *CrowdSuite) TestTemporaryMembershipRollback(c *C) {
+	srv := crowdtest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser(&crowdtest.User{Name: "john"})
+	srv.AddGroup(&crowdtest.Group{
+		Name:       "oncall",
+		Attributes: map[string][]string{TEMP_MEMBERS_ATTR: {"2030-01-01T00:00:00Z bob"}},
+	})
+
+	target, _ := url.Parse(srv.URL())
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	// Proxy rejects all new memberships
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/group/user/direct") {
+			w.WriteHeader(403)
+			return
+		}
+
+		proxy.ServeHTTP(w, r)
+	}))
+
+	defer front.Close()
+
+	api, _ := NewAPI(front.URL+"/", "app", "test")
+	until := time.Now().Add(time.Hour)
+
+	c.Assert(api.GrantTemporaryMembership("john", "oncall", until), Equals, ErrNoPerms)
+	c.Assert(srv.Group("oncall").Users, HasLen, 0)
+	c.Assert(srv.Group("oncall").Attributes[TEMP_MEMBERS_ATTR], DeepEquals, []string{
+		"2030-01-01T00:00:00Z bob",
+	})
+}
+
+func (s *CrowdSuite) TestChildGroups(c *C) {
+	srv := crowdtest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser(&crowdtest.User{Name: "john"})
+	srv.AddGroup(&crowdtest.Group{Name: "devs", Groups: []string{"qa"}})
+	srv.AddGroup(&crowdtest.Group{Name: "qa", Groups: []string{"testers"}})
+	srv.AddGroup(&crowdtest.Group{Name: "testers"})
+	srv.AddGroup(&crowdtest.Group{
+		Name:       "ops",
+		Attributes: map[string][]string{TEMP_MEMBERS_ATTR: {"2030-01-01T00:00:00Z john"}},
+	})
+
+	api, _ := NewAPI(srv.URL(), "app", "test")
+
+	groups, err := api.GetGroupDirectChildGroups("devs")
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 1)
+	c.Assert(groups[0].Name, Equals, "qa")
+
+	c.Assert(api.AddChildGroup("devs", "ops"), IsNil)
+	c.Assert(api.AddChildGroup("devs", "ops"), Equals, ErrMembershipExists)
+	c.Assert(api.AddChildGroup("devs", "unknown"), Equals, ErrGroupNoFound)
+	c.Assert(api.AddChildGroup("unknown", "ops"), Equals, ErrGroupNoFound)
+	c.Assert(srv.Group("devs").Groups, DeepEquals, []string{"qa", "ops"})
+
+	c.Assert(api.RemoveChildGroup("devs", "ops"), IsNil)
+	c.Assert(api.RemoveChildGroup("devs", "ops"), Equals, ErrMembershipNoFound)
+	c.Assert(srv.Group("devs").Groups, DeepEquals, []string{"qa"})
+}
+
+func (s *CrowdSuite) TestNotFoundErrors(c *C) {
+	srv := crowdtest.NewServer()
+	defer srv.Close()
+
+	api, _ := NewAPI(srv.URL(), "app", "test")
+
+	// Before 404 responses were reported as unknown errors
+	_, err := api.GetUser("unknown", false)
+	c.Assert(err, Equals, ErrUserNoFound)
+	_, err = api.GetGroup("unknown", false)
+	c.Assert(err, Equals, ErrGroupNoFound)
+	_, err = api.GetUserDirectGroups("unknown")
+	c.Assert(err, Equals, ErrUserNoFound)
+	_, err = api.GetUserNestedGroups("unknown")
+	c.Assert(err, Equals, ErrUserNoFound)
+}
+
+func (s *CrowdSuite) TestSessionValidation(c *C) {
+	srv := crowdtest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser(&crowdtest.User{Name: "john", IsActive: true})
+	srv.AddSession(&crowdtest.Session{Token: "SecretToken1", User: "john", RemoteAddress: "10.0.0.1"})
+
+	buf := &bytes.Buffer{}
+
+	var endpoints []string
+
+	api, _ := NewAPI(srv.URL(), "app", "test")
+	api.Dumper = &Dumper{
+		Logger: slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+	api.Interceptors = []Interceptor{
+		func(call *Call, next Invoker) error {
+			endpoints = append(endpoints, call.Endpoint())
+			return next(call)
+		},
+	}
+
+	session, err := api.ValidateSession(
+		"SecretToken1", &ValidationFactor{FACTOR_REMOTE_ADDRESS, "10.0.0.1"},
+	)
+
+	c.Assert(err, IsNil)
+	c.Assert(session.Token, Equals, "SecretToken1")
+	c.Assert(session.User, NotNil)
+	c.Assert(session.User.Name, Equals, "john")
+	c.Assert(session.Expiry().After(session.Created()), Equals, true)
+
+	_, err = api.ValidateSession("SecretToken1", &ValidationFactor{FACTOR_REMOTE_ADDRESS, "10.0.0.2"})
+	c.Assert(err, Equals, ErrInvalidToken)
+
+	_, err = api.ValidateSession("unknown")
+	c.Assert(err, Equals, ErrInvalidToken)
+
+	_, err = api.ValidateSession("")
+	c.Assert(err, Equals, ErrInvalidToken)
+
+	c.Assert(strings.Contains(buf.String(), "SecretToken1"), Equals, false)
+	c.Assert(endpoints[0], Equals, "rest/usermanagement/1/session/[REDACTED]")
+	c.Assert((&Dumper{}).Redact(`<token>abcd</token>`), Equals, `<token>[REDACTED]</token>`)
+}
+
+func (s *CrowdSuite) TestLoginErrors(c *C) {
+	srv := crowdtest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser(&crowdtest.User{Name: "john", Password: "test1234", IsActive: true})
+	srv.AddUser(&crowdtest.User{Name: "bob", Password: "test1234"})
+
+	api, _ := NewAPI(srv.URL(), "app", "test")
+
+	user, err := api.Login("john", "test1234")
+	c.Assert(err, IsNil)
+	c.Assert(user.Name, Equals, "john")
+
+	_, err = api.Login("john", "wrong")
+	c.Assert(err, Equals, ErrInvalidCredentials)
+	_, err = api.Login("bob", "test1234")
+	c.Assert(err, Equals, ErrInvalidCredentials)
+	_, err = api.Login("unknown", "test1234")
+	c.Assert(err, Equals, ErrInvalidCredentials)
+
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(403)
+	}))
+
+	defer forbidden.Close()
+
+	api, _ = NewAPI(forbidden.URL+"/", "app", "test")
+
+	_, err = api.Login("john", "test1234")
+	c.Assert(err, Equals, ErrNoPerms)
+}
+
+func (s *CrowdSuite) TestUsersAndGroupsManagement(c *C) {
+	srv := crowdtest.NewServer()
+	defer srv.Close()
+
+	srv.AddGroup(&crowdtest.Group{Name: "devs", IsActive: true})
+
+	api, _ := NewAPI(srv.URL(), "app", "test")
+
+	user := &User{
+		Name: "john", FirstName: "John", LastName: "Doe", DisplayName: "John Doe",
+		Email: "john@domain.com", Password: "test1234", IsActive: true,
+	}
+
+	c.Assert(api.CreateUser(user), IsNil)
+	c.Assert(api.CreateUser(user), Equals, ErrInvalidUser)
+	c.Assert(srv.User("john").Password, Equals, "test1234")
+	c.Assert(srv.User("john").Email, Equals, "john@domain.com")
+
+	user.Email, user.IsActive = "jdoe@domain.com", false
+
+	c.Assert(api.UpdateUser(user), IsNil)
+	c.Assert(api.UpdateUser(&User{Name: "unknown"}), Equals, ErrUserNoFound)
+	c.Assert(srv.User("john").Email, Equals, "jdoe@domain.com")
+	c.Assert(srv.User("john").IsActive, Equals, false)
+	c.Assert(srv.User("john").Password, Equals, "test1234")
+
+	c.Assert(api.SetUserPassword("john", "test5678"), IsNil)
+	c.Assert(api.SetUserPassword("john", ""), Equals, ErrInvalidPassword)
+	c.Assert(api.SetUserPassword("unknown", "test5678"), Equals, ErrUserNoFound)
+	c.Assert(srv.User("john").Password, Equals, "test5678")
+
+	c.Assert(api.CreateGroup(&Group{Name: "qa", Description: "QA", IsActive: true}), IsNil)
+	c.Assert(api.CreateGroup(&Group{Name: "qa"}), Equals, ErrInvalidGroup)
+
+	group, err := api.GetGroup("qa", false)
+	c.Assert(err, IsNil)
+	c.Assert(group.Type, Equals, GROUP_TYPE_GROUP)
+	c.Assert(group.Description, Equals, "QA")
+
+	c.Assert(api.UpdateGroup(&Group{Name: "qa", Description: "Testers"}), IsNil)
+	c.Assert(api.UpdateGroup(&Group{Name: "unknown"}), Equals, ErrGroupNoFound)
+	c.Assert(srv.Group("qa").Description, Equals, "Testers")
+	c.Assert(srv.Group("qa").IsActive, Equals, false)
+
+	c.Assert(api.AddUserToGroup("john", "devs"), IsNil)
+	c.Assert(api.AddChildGroup("devs", "qa"), IsNil)
+
+	c.Assert(api.DeleteUser("john"), IsNil)
+	c.Assert(api.DeleteUser("john"), Equals, ErrUserNoFound)
+	c.Assert(srv.User("john"), IsNil)
+	c.Assert(srv.Group("devs").Users, HasLen, 0)
+
+	c.Assert(api.DeleteGroup("qa"), IsNil)
+	c.Assert(api.DeleteGroup("qa"), Equals, ErrGroupNoFound)
+	c.Assert(srv.Group("qa"), IsNil)
+	c.Assert(srv.Group("devs").Groups, HasLen, 0)
+}
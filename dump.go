@@ -0,0 +1,155 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// REDACTED is placeholder for redacted secrets
+const REDACTED = "[REDACTED]"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Dumper logs full requests and responses with debug level. Passwords, basic
+// auth credentials, session tokens and values of sensitive attributes are
+// redacted.
+type Dumper struct {
+	// Logger is logger for dumps (slog.Default is used if nil)
+	Logger *slog.Logger
+
+	// SensitiveAttributes is list of names of attributes with sensitive values
+	SensitiveAttributes []string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var (
+	rxPassword   = regexp.MustCompile(`(?s)(<password>\s*<value>).*?(</value>)`)
+	rxAttribute  = regexp.MustCompile(`(?s)<attribute name="([^"]*)"[^>]*>.*?</attribute>`)
+	rxAttrValues = regexp.MustCompile(`(?s)(<value>).*?(</value>)`)
+	rxToken      = regexp.MustCompile(`(?s)(<token>).*?(</token>)`)
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// codebeat:disable[ARITY]
+
+// dump logs request and response
+func (d *Dumper) dump(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, latency time.Duration, err error) {
+	if d == nil {
+		return
+	}
+
+	logger := d.Logger
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	logger.DebugContext(
+		ctx, "Crowd request",
+		slog.String("method", string(req.Header.Method())),
+		slog.String("url", redactSessionPath(req.URI().String())),
+		slog.Any("headers", d.redactHeaders(req.Header.VisitAll)),
+		slog.String("body", d.Redact(string(req.Body()))),
+	)
+
+	if err != nil {
+		logger.DebugContext(
+			ctx, "Crowd request failed",
+			slog.Duration("latency", latency),
+			slog.String("error", err.Error()),
+		)
+
+		return
+	}
+
+	logger.DebugContext(
+		ctx, "Crowd response",
+		slog.Int("status", resp.StatusCode()),
+		slog.Duration("latency", latency),
+		slog.Any("headers", d.redactHeaders(resp.Header.VisitAll)),
+		slog.String("body", d.Redact(string(resp.Body()))),
+	)
+}
+
+// codebeat:enable[ARITY]
+
+// Redact redacts passwords, session tokens and values of sensitive attributes
+// in given xml-encoded data
+func (d *Dumper) Redact(data string) string {
+	if data == "" {
+		return ""
+	}
+
+	data = rxPassword.ReplaceAllString(data, "${1}"+REDACTED+"${2}")
+	data = rxToken.ReplaceAllString(data, "${1}"+REDACTED+"${2}")
+
+	if d == nil || len(d.SensitiveAttributes) == 0 {
+		return data
+	}
+
+	return rxAttribute.ReplaceAllStringFunc(data, func(attr string) string {
+		name := rxAttribute.FindStringSubmatch(attr)[1]
+
+		if !d.isSensitive(name) {
+			return attr
+		}
+
+		return rxAttrValues.ReplaceAllString(attr, "${1}"+REDACTED+"${2}")
+	})
+}
+
+// redactHeaders returns map with headers with redacted credentials
+func (d *Dumper) redactHeaders(visitor func(f func(key, value []byte))) map[string]string {
+	result := make(map[string]string)
+
+	visitor(func(key, value []byte) {
+		name, val := string(key), string(value)
+
+		switch strings.ToLower(name) {
+		case "authorization":
+			scheme, _, _ := strings.Cut(val, " ")
+			val = scheme + " " + REDACTED
+		case "cookie", "set-cookie":
+			val = REDACTED
+		}
+
+		if result[name] != "" {
+			result[name] += ", " + val
+		} else {
+			result[name] = val
+		}
+	})
+
+	return result
+}
+
+// isSensitive returns true if attribute with given name is sensitive
+func (d *Dumper) isSensitive(name string) bool {
+	for _, attr := range d.SensitiveAttributes {
+		if strings.EqualFold(attr, name) {
+			return true
+		}
+	}
+
+	return false
+}
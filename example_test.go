@@ -7,7 +7,14 @@ package crowd
 //                                                                                    //
 // ////////////////////////////////////////////////////////////////////////////////// //
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"time"
+)
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
@@ -389,3 +396,412 @@ func ExampleAPI_SearchGroups() {
 		}
 	}
 }
+
+func ExampleDefaultRetryPolicy() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	api.RetryPolicy = DefaultRetryPolicy()
+	// also retry 500 errors
+	api.RetryPolicy.StatusCodes = []int{500, 502, 503, 504}
+
+	user, err := api.GetUser("john", true)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("%#v\n", user)
+}
+
+func ExampleCircuitBreaker() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	api.CircuitBreaker = &CircuitBreaker{
+		FailureRate: 0.3,
+		Cooldown:    time.Minute,
+		OnStateChange: func(from, to BreakerState) {
+			fmt.Printf("Crowd circuit breaker state changed: %s → %s\n", from, to)
+		},
+	}
+
+	_, err = api.GetUser("john", true)
+
+	if err == ErrCircuitOpen {
+		fmt.Println("Crowd is unavailable")
+	}
+}
+
+func ExampleNewClusterAPI() {
+	api, err := NewClusterAPI(
+		[]string{
+			"https://crowd1.domain.com/crowd/",
+			"https://crowd2.domain.com/crowd/",
+			"https://crowd3.domain.com/crowd/",
+		},
+		"myapp", "MySuppaPAssWOrd",
+	)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	api.NodeSelection = SELECT_PRIORITY
+	api.StartHealthProbe(15 * time.Second)
+
+	defer api.StopHealthProbe()
+
+	user, err := api.GetUser("john", true)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("%#v\n", user)
+}
+
+func ExampleNewRateLimiter() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	api.ReadLimiter = NewRateLimiter(50, 10)
+	api.WriteLimiter = NewRateLimiter(5, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, userName := range []string{"john", "bob", "alice"} {
+		attrs, err := api.WithContext(ctx).GetUserAttributes(userName)
+
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		fmt.Printf("%s: %v\n", userName, attrs)
+	}
+}
+
+func ExampleAPI_WithContext() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := api.WithContext(ctx).GetUser("john", true)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("%#v\n", user)
+}
+
+func ExampleInterceptor() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	api.Interceptors = append(api.Interceptors,
+		// Log all requests
+		func(call *Call, next Invoker) error {
+			err := next(call)
+			fmt.Printf(
+				"%s %s → %d (%s, error: %v)\n",
+				call.Method, call.Endpoint(), call.StatusCode, call.Latency, err,
+			)
+			return err
+		},
+		// Add request ID
+		func(call *Call, next Invoker) error {
+			call.Header.Set("X-Request-Id", "b6ad28a2")
+			return next(call)
+		},
+	)
+
+	user, err := api.GetUser("john", true)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("%#v\n", user)
+}
+
+func ExampleDumper() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	api.Dumper = &Dumper{
+		Logger: slog.New(slog.NewTextHandler(
+			os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug},
+		)),
+		SensitiveAttributes: []string{"recoveryCode"},
+	}
+
+	_, err = api.Login("john", "Test1234!")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+func ExampleUnmarshalAttributes() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	attrs, err := api.GetUserAttributes("john")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	profile := struct {
+		EmployeeID int       `crowd:"employeeId"`
+		HireDate   time.Time `crowd:"hireDate,layout=2006-01-02"`
+		Phones     []string  `crowd:"phone"`
+	}{}
+
+	err = UnmarshalAttributes(attrs, &profile)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Employee ID: %d\n", profile.EmployeeID)
+}
+
+func ExampleMarshalAttributes() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	profile := struct {
+		EmployeeID int      `crowd:"employeeId"`
+		Phones     []string `crowd:"phone,omitempty"`
+	}{
+		EmployeeID: 1234,
+		Phones:     []string{"+1 555 0100"},
+	}
+
+	attrs, err := MarshalAttributes(profile)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	err = api.SetUserAttributes("john", &UserAttributes{Attributes: attrs})
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+func ExampleAPI_PatchUserAttributes() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	attrs, err := api.GetUserAttributes("john")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	diff := AttributesDiff{
+		Changed: Attributes{&Attribute{Name: "department", Values: []string{"ops"}}},
+		Removed: Attributes{&Attribute{Name: "tmpAccess"}},
+	}
+
+	// Pass previously read attributes to reject the patch if somebody
+	// modified them in the meantime
+	err = api.PatchUserAttributes("john", diff, attrs)
+
+	if err == ErrAttrsConflict {
+		fmt.Println("Attributes were modified by someone else")
+	}
+}
+
+func ExampleAttributes_Set() {
+	attrs := AttributesFromMap(map[string][]string{
+		"department": {"dev"},
+		"phone":      {"+1 555 0100"},
+	})
+
+	attrs.Set("department", "ops")
+	attrs.Add("phone", "+1 555 0101")
+	attrs.Delete("tmpAccess")
+
+	fmt.Println(attrs.ToMap()["phone"])
+	// Output: [+1 555 0100 +1 555 0101]
+}
+
+func ExampleAttributes_Merge() {
+	attrs := AttributesFromMap(map[string][]string{"roles": {"dev"}})
+	extra := AttributesFromMap(map[string][]string{"roles": {"ops"}})
+
+	fmt.Println(attrs.Merge(extra, MERGE_UNION).GetList("roles"))
+	// Output: [dev ops]
+}
+
+func ExampleAttributes_GetTime() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	user, err := api.GetUser("john", true)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	lastActive, err := user.Attributes.GetTime("lastActive", TIME_UNIX_MILLI)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Last active: %s\n", lastActive)
+}
+
+func ExampleAttributeSchema() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	api.UserSchema = &AttributeSchema{
+		Rules: []*AttributeRule{
+			{Name: "employeeId", Required: true, Pattern: regexp.MustCompile(`^[0-9]{4,8}$`)},
+			{Name: "phone", MultiValued: true, MaxLength: 32},
+		},
+	}
+
+	err = api.SetUserAttributes("john", &UserAttributes{
+		Attributes: Attributes{&Attribute{Name: "employeeId", Values: []string{"ABC"}}},
+	})
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+
+	report, err := api.CheckUsersAttributes("john", "bob", "alice")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if !report.IsValid() {
+		fmt.Println(report)
+	}
+}
+
+func ExampleAPI_GrantTemporaryMembership() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	err = api.GrantTemporaryMembership("john", "oncall", time.Now().Add(12*time.Hour))
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+func ExampleMembershipSweeper() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	sweeper := NewMembershipSweeper(api, "oncall", "contractors")
+	sweeper.OnSweep = func(report *SweepReport) {
+		for _, m := range report.Removed {
+			fmt.Printf("User %s removed from group %s (expired %s)\n", m.User, m.Group, m.Until)
+		}
+
+		for _, err := range report.Errors {
+			fmt.Printf("Error: %v\n", err)
+		}
+	}
+
+	go sweeper.Run(context.Background(), 5*time.Minute)
+}
+
+func ExampleAPI_ValidateSession() {
+	api, err := NewAPI("https://crowd.domain.com/crowd/", "myapp", "MySuppaPAssWOrd")
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	session, err := api.ValidateSession(
+		"1Ac7hdUZuDPXaO6DqqJ0jw00",
+		&ValidationFactor{Name: FACTOR_REMOTE_ADDRESS, Value: "192.168.1.10"},
+	)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("User %s (session expires %s)\n", session.User.Name, session.Expiry())
+}
@@ -0,0 +1,464 @@
+// Package forwardauth provides handler for auth subrequests of reverse proxies
+// (nginx auth_request, Traefik ForwardAuth)
+package forwardauth
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/authz"
+	"github.com/essentialkaos/go-crowd/v3/basicauth"
+	"github.com/essentialkaos/go-crowd/v3/sso"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Identity headers
+const (
+	HEADER_USER   = "X-Forwarded-User"
+	HEADER_GROUPS = "X-Forwarded-Groups"
+	HEADER_EMAIL  = "X-Forwarded-Email"
+)
+
+// DEFAULT_GROUPS_CACHE_TTL is default TTL for cached user groups
+const DEFAULT_GROUPS_CACHE_TTL = 30 * time.Second
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Handler answers auth subrequests. Original request info is read from
+// X-Forwarded-Host/X-Forwarded-Uri (Traefik) or X-Original-URL (nginx) headers.
+type Handler struct {
+	// SSO is SSO cookie authenticator (nil disables SSO)
+	SSO *sso.Middleware
+
+	// Basic is Basic auth authenticator (nil disables Basic auth)
+	Basic *basicauth.Authenticator
+
+	// Rules is list of access rules, the first matching rule is used
+	Rules []*Rule
+
+	// LoginURL is URL of login page. If set, unauthenticated GET requests are
+	// redirected to it (works with Traefik, nginx requires 401).
+	LoginURL string
+
+	// GroupsCacheTTL is TTL for cached user groups (0 disables caching)
+	GroupsCacheTTL time.Duration
+
+	// TrustedProxies is list of networks of trusted reverse proxies. Original
+	// URL (X-Forwarded-Host, X-Forwarded-Proto, X-Forwarded-Uri and X-Original-URL
+	// headers) and client address (X-Real-IP and X-Forwarded-For headers) are read
+	// only if request comes from trusted proxy.
+	TrustedProxies []netip.Prefix
+
+	authz  *authz.Authorizer
+	mu     sync.Mutex
+	groups map[string]*groupsItem
+}
+
+// Rule requires group membership for requests to matching host and path
+type Rule struct {
+	// Host is host name or glob pattern (empty matches any host)
+	Host string `json:"host,omitempty"`
+
+	// Path is path prefix matched on segment boundaries, i.e. "/admin" matches
+	// "/admin" and "/admin/users", but not "/administrator" (empty matches any
+	// path)
+	Path string `json:"path,omitempty"`
+
+	// Groups is list of groups, user must be a direct or nested member of
+	// any of them (empty allows any authenticated user)
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// groupsItem is cached user groups
+type groupsItem struct {
+	groups []string
+	expiry time.Time
+}
+
+// identity is authenticated user info
+type identity struct {
+	user   *crowd.User
+	groups []string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// errUnauthenticated is returned if request doesn't contain valid credentials
+var errUnauthenticated = errors.New("Request is not authenticated")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ReadRules reads rules from JSON file
+func ReadRules(file string) ([]*Rule, error) {
+	fd, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return DecodeRules(fd)
+}
+
+// DecodeRules decodes JSON-encoded list of rules
+func DecodeRules(r io.Reader) ([]*Rule, error) {
+	var rules []*Rule
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(&rules)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode rules: %w", err)
+	}
+
+	for i, rule := range rules {
+		_, err = path.Match(rule.Host, "")
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid rule %d: host pattern %q is malformed", i, rule.Host)
+		}
+	}
+
+	return rules, nil
+}
+
+// New creates new handler with SSO and Basic authentication enabled
+func New(api *crowd.API) *Handler {
+	return &Handler{
+		SSO:            sso.New(api),
+		Basic:          basicauth.New(api),
+		GroupsCacheTTL: DEFAULT_GROUPS_CACHE_TTL,
+
+		authz:  authz.New(api),
+		groups: make(map[string]*groupsItem),
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ServeHTTP handles auth subrequest
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	original := h.getOriginalURL(r)
+	id, err := h.authenticate(r)
+
+	switch {
+	case errors.Is(err, errUnauthenticated):
+		h.handleUnauthenticated(w, r, original)
+		return
+	case err != nil:
+		http.Error(w, "Authentication service is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	rule := h.findRule(original)
+
+	if rule != nil && len(rule.Groups) != 0 && !isMemberOfAny(id.groups, rule.Groups) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set(HEADER_USER, id.user.Name)
+	w.Header().Set(HEADER_GROUPS, strings.Join(id.groups, ","))
+
+	if id.user.Email != "" {
+		w.Header().Set(HEADER_EMAIL, id.user.Email)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// authenticate authenticates request using SSO cookie or Basic credentials
+func (h *Handler) authenticate(r *http.Request) (*identity, error) {
+	var user *crowd.User
+
+	if h.SSO != nil {
+		session, err := h.SSO.Authenticate(h.withClientAddr(r))
+
+		switch {
+		case err == nil:
+			user = session.User
+		case !errors.Is(err, crowd.ErrInvalidToken):
+			return nil, err
+		}
+	}
+
+	if user == nil && h.Basic != nil {
+		userName, password, ok := r.BasicAuth()
+
+		if ok {
+			var err error
+
+			user, err = h.Basic.Authenticate(r.Context(), userName, password)
+
+			if err != nil && !errors.Is(err, crowd.ErrInvalidCredentials) {
+				return nil, err
+			}
+		}
+	}
+
+	if user == nil {
+		return nil, errUnauthenticated
+	}
+
+	groups, err := h.getGroups(r, user.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity{user, groups}, nil
+}
+
+// handleUnauthenticated handles request without valid credentials
+func (h *Handler) handleUnauthenticated(w http.ResponseWriter, r *http.Request, original *url.URL) {
+	method := r.Header.Get("X-Forwarded-Method")
+
+	if h.LoginURL != "" && (method == "" || method == http.MethodGet) {
+		loginURL, err := url.Parse(h.LoginURL)
+
+		if err == nil {
+			param := sso.DEFAULT_RETURN_PARAM
+
+			if h.SSO != nil && h.SSO.ReturnParam != "" {
+				param = h.SSO.ReturnParam
+			}
+
+			query := loginURL.Query()
+			query.Set(param, original.String())
+			loginURL.RawQuery = query.Encode()
+
+			http.Redirect(w, r, loginURL.String(), http.StatusFound)
+
+			return
+		}
+	}
+
+	if h.Basic != nil {
+		realm := h.Basic.Realm
+
+		if realm == "" {
+			realm = basicauth.DEFAULT_REALM
+		}
+
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+	}
+
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// findRule returns the first rule matching given URL
+func (h *Handler) findRule(u *url.URL) *Rule {
+	host := strings.ToLower(u.Hostname())
+	urlPath := path.Clean("/" + u.Path)
+
+	for _, rule := range h.Rules {
+		if rule.Host != "" {
+			ok, _ := path.Match(strings.ToLower(rule.Host), host)
+
+			if !ok {
+				continue
+			}
+		}
+
+		if rule.Path != "" && !matchPath(urlPath, rule.Path) {
+			continue
+		}
+
+		return rule
+	}
+
+	return nil
+}
+
+// getGroups returns names of groups where user is a direct or nested member
+func (h *Handler) getGroups(r *http.Request, userName string) ([]string, error) {
+	if h.GroupsCacheTTL > 0 {
+		h.mu.Lock()
+		item := h.groups[userName]
+		h.mu.Unlock()
+
+		if item != nil && time.Now().Before(item.expiry) {
+			return item.groups, nil
+		}
+	}
+
+	groups, err := h.authz.Subject(r.Context(), userName).NestedGroups()
+
+	if err != nil || h.GroupsCacheTTL <= 0 {
+		return groups, err
+	}
+
+	h.mu.Lock()
+
+	if h.groups == nil || len(h.groups) >= 10000 {
+		h.groups = make(map[string]*groupsItem)
+	}
+
+	h.groups[userName] = &groupsItem{groups, time.Now().Add(h.GroupsCacheTTL)}
+	h.mu.Unlock()
+
+	return groups, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getOriginalURL returns URL of original request. Headers with original URL
+// are ignored if request doesn't come from trusted proxy.
+func (h *Handler) getOriginalURL(r *http.Request) *url.URL {
+	if !h.isFromTrustedProxy(r) {
+		return &url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	}
+
+	if r.Header.Get("X-Forwarded-Host") != "" {
+		proto := r.Header.Get("X-Forwarded-Proto")
+
+		if proto == "" {
+			proto = "http"
+		}
+
+		// Request URI is parsed as path, so "//admin" isn't treated as host
+		u, err := url.ParseRequestURI(r.Header.Get("X-Forwarded-Uri"))
+
+		if err != nil {
+			u = &url.URL{}
+		}
+
+		u.Scheme, u.Host = proto, r.Header.Get("X-Forwarded-Host")
+
+		return u
+	}
+
+	if r.Header.Get("X-Original-URL") != "" {
+		u, err := url.Parse(r.Header.Get("X-Original-URL"))
+
+		if err == nil {
+			return u
+		}
+	}
+
+	return &url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+}
+
+// withClientAddr returns copy of request with remote address of original
+// client, so SSO validation factors match the ones used by Crowd
+func (h *Handler) withClientAddr(r *http.Request) *http.Request {
+	if !h.isFromTrustedProxy(r) {
+		return r
+	}
+
+	clientAddr := h.getClientAddr(r)
+
+	if !clientAddr.IsValid() {
+		return r
+	}
+
+	rc := r.Clone(r.Context())
+	rc.RemoteAddr = net.JoinHostPort(clientAddr.String(), "0")
+
+	return rc
+}
+
+// getClientAddr returns address of original client from X-Real-IP header or
+// the rightmost untrusted X-Forwarded-For entry
+func (h *Handler) getClientAddr(r *http.Request) netip.Addr {
+	if r.Header.Get("X-Real-IP") != "" {
+		addr, _ := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+		return addr.Unmap()
+	}
+
+	var entries []string
+
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		entries = append(entries, strings.Split(header, ",")...)
+	}
+
+	var result netip.Addr
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(entries[i]))
+
+		if err != nil {
+			return netip.Addr{}
+		}
+
+		result = addr.Unmap()
+
+		if !h.isTrustedProxy(result) {
+			break
+		}
+	}
+
+	return result
+}
+
+// isFromTrustedProxy returns true if request comes from trusted proxy
+func (h *Handler) isFromTrustedProxy(r *http.Request) bool {
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(remoteAddr)
+
+	return err == nil && h.isTrustedProxy(addr)
+}
+
+// isTrustedProxy returns true if address belongs to trusted proxy
+func (h *Handler) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, network := range h.TrustedProxies {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPath returns true if path is equal to rule path or nested in it
+func matchPath(urlPath, rulePath string) bool {
+	rulePath = strings.TrimSuffix(rulePath, "/")
+	return urlPath == rulePath || strings.HasPrefix(urlPath, rulePath+"/")
+}
+
+// isMemberOfAny returns true if groups contain any of required groups
+func isMemberOfAny(groups, required []string) bool {
+	for _, group := range groups {
+		for _, req := range required {
+			if strings.EqualFold(group, req) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,320 @@
+package forwardauth
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ForwardAuthSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&ForwardAuthSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *ForwardAuthSuite) TestRules(c *C) {
+	rules, err := DecodeRules(strings.NewReader(`[
+		{"host": "*.domain.com", "path": "/admin", "groups": ["admins"]},
+		{"host": "wiki.domain.com"}
+	]`))
+
+	c.Assert(err, IsNil)
+	c.Assert(rules, DeepEquals, []*Rule{
+		{Host: "*.domain.com", Path: "/admin", Groups: []string{"admins"}},
+		{Host: "wiki.domain.com"},
+	})
+
+	_, err = DecodeRules(strings.NewReader(`[{"hosts": "*"}]`))
+	c.Assert(err, NotNil)
+	_, err = DecodeRules(strings.NewReader(`[{"host": "domain["}]`))
+	c.Assert(err, ErrorMatches, `Invalid rule 0: host pattern "domain\[" is malformed`)
+
+	file := c.MkDir() + "/rules.json"
+	os.WriteFile(file, []byte(`[{"host": "domain.com", "groups": ["devs"]}]`), 0644)
+
+	rules, err = ReadRules(file)
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 1)
+
+	_, err = ReadRules(c.MkDir() + "/unknown.json")
+	c.Assert(err, NotNil)
+}
+
+func (s *ForwardAuthSuite) TestHandler(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	h := New(api)
+	h.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	h.Rules, _ = DecodeRules(strings.NewReader(`[
+		{"host": "*.domain.com", "path": "/admin", "groups": ["admins"]},
+		{"host": "ci.domain.com", "groups": ["DEVS"]}
+	]`))
+
+	// Traefik-style request with SSO cookie
+	req := newRequest("https://wiki.domain.com/admin/settings")
+	req.AddCookie(&http.Cookie{Name: crowd.SSO_COOKIE_NAME, Value: "token1"})
+	w := serve(h, req)
+
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Header().Get(HEADER_USER), Equals, "john")
+	c.Assert(w.Header().Get(HEADER_GROUPS), Equals, "admins,devs,ops")
+	c.Assert(w.Header().Get(HEADER_EMAIL), Equals, "john@domain.com")
+
+	// Session is bound to original client address
+	req = newRequest("https://wiki.domain.com/")
+	req.Header.Set("X-Real-IP", "10.0.0.2")
+	req.AddCookie(&http.Cookie{Name: crowd.SSO_COOKIE_NAME, Value: "token1"})
+	c.Assert(serve(h, req).Code, Equals, 401)
+
+	// nginx-style request with Basic credentials
+	req = httptest.NewRequest("GET", "/auth", nil)
+	req.Header.Set("X-Original-URL", "https://ci.domain.com/job/1")
+	req.SetBasicAuth("mary", "test1234")
+	w = serve(h, req)
+
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Header().Get(HEADER_USER), Equals, "mary")
+	c.Assert(w.Header().Get(HEADER_EMAIL), Equals, "")
+
+	req = newRequest("https://wiki.domain.com/admin")
+	req.SetBasicAuth("mary", "test1234")
+	c.Assert(serve(h, req).Code, Equals, 403)
+
+	req = newRequest("https://ci.domain.com/")
+	req.SetBasicAuth("bob", "test1234")
+	c.Assert(serve(h, req).Code, Equals, 403)
+
+	req = newRequest("https://ci.domain.com/")
+	req.SetBasicAuth("bob", "wrong")
+	w = serve(h, req)
+
+	c.Assert(w.Code, Equals, 401)
+	c.Assert(w.Header().Get("WWW-Authenticate"), Equals, `Basic realm="Restricted"`)
+
+	h.Basic = nil
+	req = newRequest("https://ci.domain.com/")
+	req.SetBasicAuth("mary", "test1234")
+	w = serve(h, req)
+
+	c.Assert(w.Code, Equals, 401)
+	c.Assert(w.Header().Get("WWW-Authenticate"), Equals, "")
+
+	h.LoginURL = "https://sso.domain.com/login"
+
+	w = serve(h, newRequest("https://ci.domain.com/job?id=1"))
+	c.Assert(w.Code, Equals, 302)
+	c.Assert(w.Header().Get("Location"), Equals, "https://sso.domain.com/login?os_destination=https%3A%2F%2Fci.domain.com%2Fjob%3Fid%3D1")
+
+	req = newRequest("https://ci.domain.com/")
+	req.Header.Set("X-Forwarded-Method", "POST")
+	c.Assert(serve(h, req).Code, Equals, 401)
+
+	req = httptest.NewRequest("GET", "http://ci.domain.com/job", nil)
+	c.Assert(h.getOriginalURL(req).String(), Equals, "http://ci.domain.com/job")
+}
+
+func (s *ForwardAuthSuite) TestRulePaths(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	h := New(api)
+	h.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	h.Rules, _ = DecodeRules(strings.NewReader(`[
+		{"path": "/admin/", "groups": ["admins"]},
+		{"path": "/public"}
+	]`))
+
+	for _, uri := range []string{
+		"https://wiki.domain.com/admin",
+		"https://wiki.domain.com/admin/users",
+		"https://wiki.domain.com/public/../admin",
+		"https://wiki.domain.com//admin/./users",
+	} {
+		req := newRequest(uri)
+		req.SetBasicAuth("mary", "test1234")
+		c.Assert(serve(h, req).Code, Equals, 403, Commentf("URL: %s", uri))
+	}
+
+	for _, uri := range []string{
+		"https://wiki.domain.com/administrator",
+		"https://wiki.domain.com/public/admin",
+	} {
+		req := newRequest(uri)
+		req.SetBasicAuth("mary", "test1234")
+		c.Assert(serve(h, req).Code, Equals, 200, Commentf("URL: %s", uri))
+	}
+
+	c.Assert(h.findRule(&url.URL{Path: "/administrator"}), Equals, (*Rule)(nil))
+	c.Assert(h.findRule(&url.URL{Path: "/public"}), Equals, h.Rules[1])
+}
+
+func (s *ForwardAuthSuite) TestOriginalURL(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	h := New(api)
+	h.Rules, _ = DecodeRules(strings.NewReader(`[
+		{"host": "admin.domain.com", "groups": ["admins"]}
+	]`))
+
+	// Headers from untrusted clients are ignored
+	req := httptest.NewRequest("GET", "http://admin.domain.com/auth", nil)
+	req.Header.Set("X-Forwarded-Host", "wiki.domain.com")
+	req.Header.Set("X-Forwarded-Uri", "/")
+	req.SetBasicAuth("mary", "test1234")
+
+	c.Assert(h.getOriginalURL(req).String(), Equals, "http://admin.domain.com/auth")
+	c.Assert(serve(h, req).Code, Equals, 403)
+
+	req.Header.Del("X-Forwarded-Host")
+	req.Header.Set("X-Original-URL", "https://wiki.domain.com/")
+
+	c.Assert(h.getOriginalURL(req).String(), Equals, "http://admin.domain.com/auth")
+	c.Assert(serve(h, req).Code, Equals, 403)
+
+	h.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+
+	c.Assert(h.getOriginalURL(req).String(), Equals, "https://wiki.domain.com/")
+	c.Assert(serve(h, req).Code, Equals, 200)
+}
+
+func (s *ForwardAuthSuite) TestClientAddr(c *C) {
+	h := New(nil)
+
+	req := httptest.NewRequest("GET", "/auth", nil)
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+
+	// Headers from untrusted clients are ignored
+	c.Assert(h.withClientAddr(req).RemoteAddr, Equals, "192.0.2.1:1234")
+
+	h.TrustedProxies = []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+	}
+
+	c.Assert(h.withClientAddr(req).RemoteAddr, Equals, "10.0.0.1:0")
+
+	req.Header.Del("X-Real-IP")
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 10.0.0.1, 172.16.0.2")
+	c.Assert(h.withClientAddr(req).RemoteAddr, Equals, "10.0.0.1:0")
+
+	req.Header.Set("X-Forwarded-For", "172.16.0.3")
+	req.Header.Add("X-Forwarded-For", "172.16.0.2")
+	c.Assert(h.withClientAddr(req).RemoteAddr, Equals, "172.16.0.3:0")
+
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, unknown")
+	c.Assert(h.withClientAddr(req).RemoteAddr, Equals, "192.0.2.1:1234")
+
+	req.Header.Del("X-Forwarded-For")
+	c.Assert(h.withClientAddr(req).RemoteAddr, Equals, "192.0.2.1:1234")
+
+	req.RemoteAddr = "[::ffff:192.0.2.10]:1234"
+	req.Header.Set("X-Forwarded-For", "2001:db8::1")
+	c.Assert(h.withClientAddr(req).RemoteAddr, Equals, "[2001:db8::1]:0")
+}
+
+func (s *ForwardAuthSuite) TestGroupsCache(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	h := New(api)
+	h.SSO = nil
+
+	for range 2 {
+		req := newRequest("https://ci.domain.com/")
+		req.SetBasicAuth("mary", "test1234")
+		c.Assert(serve(h, req).Code, Equals, 200)
+	}
+
+	c.Assert(srv.Requests(), HasLen, 2)
+
+	h.GroupsCacheTTL = 0
+	srv.ResetRequests()
+
+	req := newRequest("https://ci.domain.com/")
+	req.SetBasicAuth("mary", "test1234")
+	c.Assert(serve(h, req).Code, Equals, 200)
+	c.Assert(srv.Requests(), HasLen, 1)
+}
+
+func (s *ForwardAuthSuite) TestErrors(c *C) {
+	api, _ := crowd.NewAPI("http://127.0.0.1:1/", "app", "test")
+
+	h := New(api)
+
+	req := newRequest("https://ci.domain.com/")
+	req.AddCookie(&http.Cookie{Name: crowd.SSO_COOKIE_NAME, Value: "token1"})
+	c.Assert(serve(h, req).Code, Equals, 503)
+
+	req = newRequest("https://ci.domain.com/")
+	req.SetBasicAuth("mary", "test1234")
+	c.Assert(serve(h, req).Code, Equals, 503)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func newTestServer() (*crowdtest.Server, *crowd.API) {
+	srv := crowdtest.NewServer()
+
+	srv.AddUser(&crowdtest.User{
+		Name: "john", Email: "john@domain.com", Password: "test1234", IsActive: true,
+	})
+	srv.AddUser(&crowdtest.User{Name: "bob", Password: "test1234", IsActive: true})
+	srv.AddUser(&crowdtest.User{Name: "mary", Password: "test1234", IsActive: true})
+
+	srv.AddGroup(&crowdtest.Group{Name: "admins", Users: []string{"john"}})
+	srv.AddGroup(&crowdtest.Group{Name: "devs", Groups: []string{"ops"}})
+	srv.AddGroup(&crowdtest.Group{Name: "ops", Users: []string{"john", "mary"}})
+
+	srv.AddSession(&crowdtest.Session{
+		Token: "token1", User: "john", RemoteAddress: "192.168.1.1",
+		Expiry: time.Now().Add(time.Hour),
+	})
+
+	api, _ := crowd.NewAPI(srv.URL(), "app", "test")
+
+	return srv, api
+}
+
+func newRequest(original string) *http.Request {
+	req := httptest.NewRequest("GET", "/auth", nil)
+	i := strings.Index(original, "://")
+	host, uri, _ := strings.Cut(original[i+3:], "/")
+
+	req.Header.Set("X-Forwarded-Proto", original[:i])
+	req.Header.Set("X-Forwarded-Host", host)
+	req.Header.Set("X-Forwarded-Uri", "/"+uri)
+	req.Header.Set("X-Forwarded-For", "192.168.1.1")
+
+	return req
+}
+
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,74 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Call contains info about request to Crowd
+type Call struct {
+	// Context is request context
+	Context context.Context
+
+	// Method is HTTP method
+	Method string
+
+	// Path is request path with query (relative to Crowd URL)
+	Path string
+
+	// Header contains additional headers for outgoing request
+	Header http.Header
+
+	// RequestBody is raw request body
+	RequestBody []byte
+
+	// StatusCode is response status code
+	StatusCode int
+
+	// ResponseBody is raw response body
+	ResponseBody []byte
+
+	// Latency is total duration of request (including retries)
+	Latency time.Duration
+
+	// Attempts is number of attempts made
+	Attempts int
+}
+
+// Invoker executes call
+type Invoker func(call *Call) error
+
+// Interceptor is function which can observe and modify calls. Interceptor must
+// call next to continue the chain (error returned by next is a transport error)
+// or can fill status code and response body and return without calling next to
+// short-circuit the call with synthetic response.
+type Interceptor func(call *Call, next Invoker) error
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Endpoint returns path without query (i.e. "rest/usermanagement/1/user").
+// Session tokens in path are redacted.
+func (c *Call) Endpoint() string {
+	endpoint, _, _ := strings.Cut(c.Path, "?")
+	return redactSessionPath(endpoint)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// wrapInvoker wraps invoker with interceptor
+func wrapInvoker(interceptor Interceptor, next Invoker) Invoker {
+	return func(call *Call) error {
+		return interceptor(call, next)
+	}
+}
@@ -0,0 +1,100 @@
+// Package cache provides TTL cache for authentication results
+package cache
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"sync"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// MAX_SIZE is maximum number of cached items
+const MAX_SIZE = 10000
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Cache is TTL cache with hashed keys. Zero value is ready to use.
+type Cache[T any] struct {
+	mu    sync.Mutex
+	items map[[32]byte]*item[T]
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// item is cached value
+type item[T any] struct {
+	value  T
+	expiry time.Time
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Get returns cached value
+func (c *Cache[T]) Get(key [32]byte) (T, bool) {
+	var zero T
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	it := c.items[key]
+
+	if it == nil {
+		return zero, false
+	}
+
+	if time.Now().After(it.expiry) {
+		delete(c.items, key)
+		return zero, false
+	}
+
+	return it.value, true
+}
+
+// Put adds value to cache
+func (c *Cache[T]) Put(key [32]byte, value T, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.items == nil {
+		c.items = make(map[[32]byte]*item[T])
+	}
+
+	if len(c.items) >= MAX_SIZE {
+		c.purge()
+	}
+
+	c.items[key] = &item[T]{value, expiry}
+}
+
+// Len returns number of cached items
+func (c *Cache[T]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.items)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// purge removes expired items from cache or clears it if there are no expired
+// items
+func (c *Cache[T]) purge() {
+	now := time.Now()
+
+	for key, it := range c.items {
+		if now.After(it.expiry) {
+			delete(c.items, key)
+		}
+	}
+
+	if len(c.items) >= MAX_SIZE {
+		clear(c.items)
+	}
+}
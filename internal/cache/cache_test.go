@@ -0,0 +1,73 @@
+package cache
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type CacheSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&CacheSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *CacheSuite) TestCache(c *C) {
+	var cache Cache[string]
+
+	_, ok := cache.Get(getKey(1))
+	c.Assert(ok, Equals, false)
+
+	cache.Put(getKey(1), "test", time.Now().Add(time.Minute))
+
+	value, ok := cache.Get(getKey(1))
+	c.Assert(ok, Equals, true)
+	c.Assert(value, Equals, "test")
+
+	cache.Put(getKey(2), "test", time.Now().Add(-time.Second))
+
+	_, ok = cache.Get(getKey(2))
+	c.Assert(ok, Equals, false)
+	c.Assert(cache.Len(), Equals, 1)
+}
+
+func (s *CacheSuite) TestPurge(c *C) {
+	var cache Cache[int]
+
+	for i := range MAX_SIZE {
+		cache.Put(getKey(i), i, time.Now().Add(-time.Second))
+	}
+
+	cache.Put(getKey(MAX_SIZE), 1, time.Now().Add(time.Minute))
+	c.Assert(cache.Len(), Equals, 1)
+
+	cache = Cache[int]{}
+
+	for i := range MAX_SIZE {
+		cache.Put(getKey(i), i, time.Now().Add(time.Minute))
+	}
+
+	// Cache is cleared if there are no expired items
+	cache.Put(getKey(MAX_SIZE), 1, time.Now().Add(time.Minute))
+	c.Assert(cache.Len(), Equals, 1)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func getKey(i int) [32]byte {
+	return [32]byte{byte(i), byte(i >> 8), byte(i >> 16)}
+}
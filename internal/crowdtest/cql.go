@@ -0,0 +1,212 @@
+package crowdtest
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"errors"
+	"strings"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// cqlMatcher checks if entity fields match restriction
+type cqlMatcher func(fields map[string]string) bool
+
+// cqlParser is parser for subset of Crowd Query Language: 'field = "value"'
+// terms (with * wildcards) combined with "and", "or" and parentheses
+type cqlParser struct {
+	tokens []string
+	pos    int
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// errInvalidCQL is returned if restriction can't be parsed
+var errInvalidCQL = errors.New("Invalid restriction")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseCQL parses restriction
+func parseCQL(restriction string) (cqlMatcher, error) {
+	tokens, err := tokenizeCQL(restriction)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return func(map[string]string) bool { return true }, nil
+	}
+
+	p := &cqlParser{tokens: tokens}
+	m, err := p.parseOr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, errInvalidCQL
+	}
+
+	return m, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseOr parses "or" expression
+func (p *cqlParser) parseOr() (cqlMatcher, error) {
+	return p.parseLogic("or", p.parseAnd)
+}
+
+// parseAnd parses "and" expression
+func (p *cqlParser) parseAnd() (cqlMatcher, error) {
+	return p.parseLogic("and", p.parseTerm)
+}
+
+// parseLogic parses expressions joined with given operator
+func (p *cqlParser) parseLogic(op string, next func() (cqlMatcher, error)) (cqlMatcher, error) {
+	var matchers []cqlMatcher
+
+	for {
+		m, err := next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		matchers = append(matchers, m)
+
+		if p.pos >= len(p.tokens) || !strings.EqualFold(p.tokens[p.pos], op) {
+			break
+		}
+
+		p.pos++
+	}
+
+	return func(fields map[string]string) bool {
+		for _, m := range matchers {
+			if m(fields) != (op == "and") {
+				return op != "and"
+			}
+		}
+
+		return op == "and"
+	}, nil
+}
+
+// parseTerm parses term or expression in parentheses
+func (p *cqlParser) parseTerm() (cqlMatcher, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errInvalidCQL
+	}
+
+	if p.tokens[p.pos] == "(" {
+		p.pos++
+
+		m, err := p.parseOr()
+
+		if err != nil || p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+			return nil, errInvalidCQL
+		}
+
+		p.pos++
+
+		return m, nil
+	}
+
+	if p.pos+3 > len(p.tokens) {
+		return nil, errInvalidCQL
+	}
+
+	field, op, value := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+
+	if op != "=" {
+		return nil, errInvalidCQL
+	}
+
+	p.pos += 3
+	value = strings.TrimPrefix(value, "\x00")
+
+	return func(fields map[string]string) bool {
+		return matchWildcard(strings.ToLower(value), strings.ToLower(fields[strings.ToLower(field)]))
+	}, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// tokenizeCQL splits restriction into tokens. Quoted strings are unescaped and
+// prefixed with zero byte.
+func tokenizeCQL(restriction string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(restriction); {
+		switch c := restriction[i]; {
+		case c == ' ':
+			i++
+		case c == '(' || c == ')' || c == '=':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			var value strings.Builder
+
+			for i++; i < len(restriction) && restriction[i] != '"'; i++ {
+				if restriction[i] == '\\' && i+1 < len(restriction) {
+					i++
+				}
+
+				value.WriteByte(restriction[i])
+			}
+
+			if i >= len(restriction) {
+				return nil, errInvalidCQL
+			}
+
+			tokens = append(tokens, "\x00"+value.String())
+			i++
+		default:
+			j := strings.IndexAny(restriction[i:], ` ()="`)
+
+			if j == -1 {
+				j = len(restriction) - i
+			}
+
+			tokens = append(tokens, restriction[i:i+j])
+			i += j
+		}
+	}
+
+	return tokens, nil
+}
+
+// matchWildcard matches value against pattern with * wildcards
+func matchWildcard(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+
+	if len(parts) == 1 {
+		return pattern == value
+	}
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+
+	value = value[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+
+		if i == -1 {
+			return false
+		}
+
+		value = value[i+len(part):]
+	}
+
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
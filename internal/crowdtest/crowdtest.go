@@ -0,0 +1,1046 @@
+// Package crowdtest provides in-memory fake Crowd server for tests
+package crowdtest
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Server is fake Crowd server
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	users    map[string]*User
+	groups   map[string]*Group
+	sessions map[string]*Session
+	requests []string
+}
+
+// User contains user info
+type User struct {
+	Name        string
+	FirstName   string
+	LastName    string
+	DisplayName string
+	Email       string
+	Password    string
+	IsActive    bool
+	Attributes  map[string][]string
+}
+
+// Group contains group info
+type Group struct {
+	Name        string
+	Description string
+	IsActive    bool
+	Attributes  map[string][]string
+	Users       []string // Direct members
+	Groups      []string // Direct child groups
+}
+
+// Session contains SSO session info
+type Session struct {
+	Token         string
+	User          string
+	RemoteAddress string // Required remote_address validation factor (optional)
+	Expiry        time.Time
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type xmlAttribute struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"values>value"`
+}
+
+type xmlAttributes struct {
+	XMLName    xml.Name        `xml:"attributes"`
+	Attributes []*xmlAttribute `xml:"attribute"`
+}
+
+type xmlUser struct {
+	XMLName     xml.Name        `xml:"user"`
+	Name        string          `xml:"name,attr"`
+	FirstName   string          `xml:"first-name"`
+	LastName    string          `xml:"last-name"`
+	DisplayName string          `xml:"display-name"`
+	Email       string          `xml:"email"`
+	IsActive    bool            `xml:"active"`
+	Password    *xmlPassword    `xml:"password,omitempty"`
+	Attributes  []*xmlAttribute `xml:"attributes>attribute,omitempty"`
+}
+
+type xmlGroup struct {
+	XMLName     xml.Name        `xml:"group"`
+	Name        string          `xml:"name,attr"`
+	Description string          `xml:"description"`
+	Type        string          `xml:"type"`
+	IsActive    bool            `xml:"active"`
+	Attributes  []*xmlAttribute `xml:"attributes>attribute,omitempty"`
+}
+
+type xmlName struct {
+	Name string `xml:"name,attr"`
+}
+
+type xmlPassword struct {
+	Value string `xml:"value"`
+}
+
+type xmlSession struct {
+	XMLName     xml.Name `xml:"session"`
+	Token       string   `xml:"token"`
+	User        *xmlUser `xml:"user"`
+	CreatedDate int64    `xml:"created-date"`
+	ExpiryDate  int64    `xml:"expiry-date"`
+}
+
+type xmlValidationFactors struct {
+	Factors []struct {
+		Name  string `xml:"name"`
+		Value string `xml:"value"`
+	} `xml:"validation-factor"`
+}
+
+type xmlError struct {
+	XMLName xml.Name `xml:"error"`
+	Reason  string   `xml:"reason"`
+	Message string   `xml:"message"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewServer creates and starts new fake Crowd server
+func NewServer() *Server {
+	s := &Server{
+		users:    make(map[string]*User),
+		groups:   make(map[string]*Group),
+		sessions: make(map[string]*Session),
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// URL returns base URL of server (with trailing slash)
+func (s *Server) URL() string {
+	return s.srv.URL + "/"
+}
+
+// Close stops server
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// AddUser adds user to server
+func (s *Server) AddUser(u *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u.Attributes == nil {
+		u.Attributes = make(map[string][]string)
+	}
+
+	s.users[u.Name] = u
+}
+
+// AddGroup adds group to server
+func (s *Server) AddGroup(g *Group) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g.Attributes == nil {
+		g.Attributes = make(map[string][]string)
+	}
+
+	s.groups[g.Name] = g
+}
+
+// AddSession adds SSO session to server
+func (s *Server) AddSession(session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.Token] = session
+}
+
+// User returns copy of user with given name
+func (s *Server) User(name string) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.users[name]
+
+	if u == nil {
+		return nil
+	}
+
+	uc := *u
+	uc.Attributes = cloneAttrs(u.Attributes)
+
+	return &uc
+}
+
+// Group returns copy of group with given name
+func (s *Server) Group(name string) *Group {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groups[name]
+
+	if g == nil {
+		return nil
+	}
+
+	gc := *g
+	gc.Attributes = cloneAttrs(g.Attributes)
+	gc.Users = slices.Clone(g.Users)
+	gc.Groups = slices.Clone(g.Groups)
+
+	return &gc
+}
+
+// Requests returns list of all received requests in "METHOD path" format
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.requests)
+}
+
+// ResetRequests clears list of received requests
+func (s *Server) ResetRequests() {
+	s.mu.Lock()
+	s.requests = nil
+	s.mu.Unlock()
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// handle handles requests
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/rest/usermanagement/1/")
+	query := r.URL.Query()
+
+	s.requests = append(s.requests, r.Method+" "+path)
+
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case path == "config/cookie":
+		writeXML(w, 200, &struct {
+			XMLName xml.Name `xml:"cookie-config"`
+			Name    string   `xml:"name"`
+		}{Name: "crowd.token_key"})
+
+	case path == "user" && r.Method == "GET":
+		s.handleGetUser(w, query.Get("username"), query.Get("expand") == "attributes")
+
+	case path == "user" && r.Method == "POST":
+		s.handleCreateUser(w, body)
+
+	case path == "user" && r.Method == "PUT":
+		s.handleUpdateUser(w, query.Get("username"), body)
+
+	case path == "user" && r.Method == "DELETE":
+		s.handleDeleteUser(w, query.Get("username"))
+
+	case path == "user/password" && r.Method == "PUT":
+		s.handleSetPassword(w, query.Get("username"), body)
+
+	case path == "authentication" && r.Method == "POST":
+		s.handleAuth(w, query.Get("username"), body)
+
+	case path == "user/attribute":
+		s.handleAttributes(w, r.Method, s.userAttrs(query.Get("username")), "USER_NOT_FOUND", query, body)
+
+	case path == "group" && r.Method == "GET":
+		s.handleGetGroup(w, query.Get("groupname"), query.Get("expand") == "attributes")
+
+	case path == "group" && r.Method == "POST":
+		s.handleCreateGroup(w, body)
+
+	case path == "group" && r.Method == "PUT":
+		s.handleUpdateGroup(w, query.Get("groupname"), body)
+
+	case path == "group" && r.Method == "DELETE":
+		s.handleDeleteGroup(w, query.Get("groupname"))
+
+	case path == "group/attribute":
+		s.handleAttributes(w, r.Method, s.groupAttrs(query.Get("groupname")), "GROUP_NOT_FOUND", query, body)
+
+	case strings.HasPrefix(path, "user/group/"):
+		s.handleUserGroups(w, r.Method, strings.TrimPrefix(path, "user/group/"), query)
+
+	case strings.HasPrefix(path, "group/user/"):
+		s.handleGroupUsers(w, r.Method, strings.TrimPrefix(path, "group/user/"), query, body)
+
+	case strings.HasPrefix(path, "group/child-group/"):
+		s.handleChildGroups(w, r.Method, strings.TrimPrefix(path, "group/child-group/"), query, body)
+
+	case path == "group/membership":
+		s.handleMemberships(w)
+
+	case strings.HasPrefix(path, "session/") && r.Method == "POST":
+		s.handleValidateSession(w, strings.TrimPrefix(path, "session/"), body)
+
+	case path == "search":
+		s.handleSearch(w, query.Get("entity-type"), query.Get("restriction"), query)
+
+	default:
+		writeError(w, 404, "NOT_FOUND", "Unknown resource")
+	}
+}
+
+// handleGetUser handles user info request
+func (s *Server) handleGetUser(w http.ResponseWriter, name string, withAttrs bool) {
+	u := s.users[name]
+
+	if u == nil {
+		writeError(w, 404, "USER_NOT_FOUND", "User <"+name+"> does not exist")
+		return
+	}
+
+	writeXML(w, 200, encodeUser(u, withAttrs))
+}
+
+// handleCreateUser handles user creation request
+func (s *Server) handleCreateUser(w http.ResponseWriter, body []byte) {
+	data := &xmlUser{}
+
+	if xml.Unmarshal(body, data) != nil || data.Name == "" {
+		writeError(w, 400, "INVALID_USER", "Can't parse user")
+		return
+	}
+
+	if s.users[data.Name] != nil {
+		writeError(w, 400, "INVALID_USER", "User <"+data.Name+"> already exists")
+		return
+	}
+
+	u := &User{
+		Name:        data.Name,
+		FirstName:   data.FirstName,
+		LastName:    data.LastName,
+		DisplayName: data.DisplayName,
+		Email:       data.Email,
+		IsActive:    data.IsActive,
+		Attributes:  make(map[string][]string),
+	}
+
+	if data.Password != nil {
+		u.Password = data.Password.Value
+	}
+
+	s.users[u.Name] = u
+
+	w.WriteHeader(201)
+}
+
+// handleUpdateUser handles user update request
+func (s *Server) handleUpdateUser(w http.ResponseWriter, name string, body []byte) {
+	u := s.users[name]
+
+	if u == nil {
+		writeError(w, 404, "USER_NOT_FOUND", "User <"+name+"> does not exist")
+		return
+	}
+
+	data := &xmlUser{}
+
+	if xml.Unmarshal(body, data) != nil || data.Name != name {
+		writeError(w, 400, "INVALID_USER", "User name in body does not match query")
+		return
+	}
+
+	u.FirstName, u.LastName = data.FirstName, data.LastName
+	u.DisplayName, u.Email = data.DisplayName, data.Email
+	u.IsActive = data.IsActive
+
+	w.WriteHeader(204)
+}
+
+// handleDeleteUser handles user deletion request
+func (s *Server) handleDeleteUser(w http.ResponseWriter, name string) {
+	if s.users[name] == nil {
+		writeError(w, 404, "USER_NOT_FOUND", "User <"+name+"> does not exist")
+		return
+	}
+
+	delete(s.users, name)
+
+	for _, g := range s.groups {
+		g.Users = slices.DeleteFunc(g.Users, func(n string) bool { return n == name })
+	}
+
+	w.WriteHeader(204)
+}
+
+// handleSetPassword handles password change request
+func (s *Server) handleSetPassword(w http.ResponseWriter, name string, body []byte) {
+	u := s.users[name]
+
+	if u == nil {
+		writeError(w, 404, "USER_NOT_FOUND", "User <"+name+"> does not exist")
+		return
+	}
+
+	pwd := &xmlPassword{}
+
+	if xml.Unmarshal(body, pwd) != nil || pwd.Value == "" {
+		writeError(w, 400, "INVALID_PASSWORD", "Password is empty")
+		return
+	}
+
+	u.Password = pwd.Value
+
+	w.WriteHeader(204)
+}
+
+// handleAuth handles authentication request
+func (s *Server) handleAuth(w http.ResponseWriter, name string, body []byte) {
+	pwd := &xmlPassword{}
+	xml.Unmarshal(body, pwd)
+
+	u := s.users[name]
+
+	switch {
+	case u == nil, u.Password != pwd.Value:
+		writeError(w, 400, "INVALID_USER_AUTHENTICATION", "Failed to authenticate principal, password was invalid")
+	case !u.IsActive:
+		writeError(w, 400, "INACTIVE_ACCOUNT", "Account is inactive")
+	default:
+		writeXML(w, 200, encodeUser(u, false))
+	}
+}
+
+// handleValidateSession handles session validation request
+func (s *Server) handleValidateSession(w http.ResponseWriter, token string, body []byte) {
+	factors := &xmlValidationFactors{}
+	xml.Unmarshal(body, factors)
+
+	session := s.sessions[token]
+
+	if session == nil || s.users[session.User] == nil ||
+		(!session.Expiry.IsZero() && session.Expiry.Before(time.Now())) {
+		writeError(w, 404, "INVALID_SSO_TOKEN", "Token does not validate")
+		return
+	}
+
+	if session.RemoteAddress != "" {
+		var remoteAddress string
+
+		for _, f := range factors.Factors {
+			if f.Name == "remote_address" {
+				remoteAddress = f.Value
+			}
+		}
+
+		if remoteAddress != session.RemoteAddress {
+			writeError(w, 400, "INVALID_SSO_TOKEN", "Validation factors do not match")
+			return
+		}
+	}
+
+	expiry := session.Expiry
+
+	if expiry.IsZero() {
+		expiry = time.Now().Add(time.Hour)
+	}
+
+	writeXML(w, 200, &xmlSession{
+		Token:       token,
+		User:        encodeUser(s.users[session.User], false),
+		CreatedDate: time.Now().UnixMilli(),
+		ExpiryDate:  expiry.UnixMilli(),
+	})
+}
+
+// handleGetGroup handles group info request
+func (s *Server) handleGetGroup(w http.ResponseWriter, name string, withAttrs bool) {
+	g := s.groups[name]
+
+	if g == nil {
+		writeError(w, 404, "GROUP_NOT_FOUND", "Group <"+name+"> does not exist")
+		return
+	}
+
+	writeXML(w, 200, encodeGroup(g, withAttrs))
+}
+
+// handleCreateGroup handles group creation request
+func (s *Server) handleCreateGroup(w http.ResponseWriter, body []byte) {
+	data := &xmlGroup{}
+
+	if xml.Unmarshal(body, data) != nil || data.Name == "" {
+		writeError(w, 400, "INVALID_GROUP", "Can't parse group")
+		return
+	}
+
+	if s.groups[data.Name] != nil {
+		writeError(w, 400, "INVALID_GROUP", "Group <"+data.Name+"> already exists")
+		return
+	}
+
+	s.groups[data.Name] = &Group{
+		Name:        data.Name,
+		Description: data.Description,
+		IsActive:    data.IsActive,
+		Attributes:  make(map[string][]string),
+	}
+
+	w.WriteHeader(201)
+}
+
+// handleUpdateGroup handles group update request
+func (s *Server) handleUpdateGroup(w http.ResponseWriter, name string, body []byte) {
+	g := s.groups[name]
+
+	if g == nil {
+		writeError(w, 404, "GROUP_NOT_FOUND", "Group <"+name+"> does not exist")
+		return
+	}
+
+	data := &xmlGroup{}
+
+	if xml.Unmarshal(body, data) != nil || data.Name != name {
+		writeError(w, 400, "INVALID_GROUP", "Group name in body does not match query")
+		return
+	}
+
+	g.Description, g.IsActive = data.Description, data.IsActive
+
+	w.WriteHeader(204)
+}
+
+// handleDeleteGroup handles group deletion request
+func (s *Server) handleDeleteGroup(w http.ResponseWriter, name string) {
+	if s.groups[name] == nil {
+		writeError(w, 404, "GROUP_NOT_FOUND", "Group <"+name+"> does not exist")
+		return
+	}
+
+	delete(s.groups, name)
+
+	for _, g := range s.groups {
+		g.Groups = slices.DeleteFunc(g.Groups, func(n string) bool { return n == name })
+	}
+
+	w.WriteHeader(204)
+}
+
+// codebeat:disable[ARITY]
+
+// handleAttributes handles user and group attributes requests
+func (s *Server) handleAttributes(w http.ResponseWriter, method string, attrs map[string][]string, reason string, query map[string][]string, body []byte) {
+	if attrs == nil {
+		writeError(w, 404, reason, "Entity does not exist")
+		return
+	}
+
+	switch method {
+	case "GET":
+		writeXML(w, 200, &xmlAttributes{Attributes: encodeAttrs(attrs)})
+
+	case "POST":
+		data := &xmlAttributes{}
+
+		if xml.Unmarshal(body, data) != nil {
+			writeError(w, 400, "INVALID_ATTRIBUTES", "Can't parse attributes")
+			return
+		}
+
+		for _, attr := range data.Attributes {
+			attrs[attr.Name] = attr.Values
+		}
+
+		w.WriteHeader(204)
+
+	case "DELETE":
+		delete(attrs, first(query["attributename"]))
+		w.WriteHeader(204)
+	}
+}
+
+// codebeat:enable[ARITY]
+
+// handleUserGroups handles user groups requests
+func (s *Server) handleUserGroups(w http.ResponseWriter, method, typ string, query map[string][]string) {
+	userName := first(query["username"])
+
+	if s.users[userName] == nil {
+		writeError(w, 404, "USER_NOT_FOUND", "User <"+userName+"> does not exist")
+		return
+	}
+
+	if method != "GET" {
+		writeError(w, 405, "METHOD_NOT_ALLOWED", "Method not allowed")
+		return
+	}
+
+	var names []string
+
+	if typ == "nested" {
+		names = s.nestedGroups(userName)
+	} else {
+		names = s.directGroups(userName)
+	}
+
+	result := &struct {
+		XMLName xml.Name    `xml:"groups"`
+		Groups  []*xmlGroup `xml:"group"`
+	}{}
+
+	for _, name := range paginate(names, query) {
+		result.Groups = append(result.Groups, encodeGroup(s.groups[name], false))
+	}
+
+	writeXML(w, 200, result)
+}
+
+// handleGroupUsers handles group users requests
+func (s *Server) handleGroupUsers(w http.ResponseWriter, method, typ string, query map[string][]string, body []byte) {
+	groupName := first(query["groupname"])
+	g := s.groups[groupName]
+
+	if g == nil {
+		writeError(w, 404, "GROUP_NOT_FOUND", "Group <"+groupName+"> does not exist")
+		return
+	}
+
+	switch method {
+	case "POST":
+		user := &xmlName{}
+		xml.Unmarshal(body, user)
+
+		switch {
+		case s.users[user.Name] == nil:
+			writeError(w, 400, "USER_NOT_FOUND", "User <"+user.Name+"> does not exist")
+		case slices.Contains(g.Users, user.Name):
+			writeError(w, 409, "MEMBERSHIP_ALREADY_EXISTS", "User is already a member")
+		default:
+			g.Users = append(g.Users, user.Name)
+			w.WriteHeader(201)
+		}
+
+		return
+
+	case "DELETE":
+		userName := first(query["username"])
+
+		if !slices.Contains(g.Users, userName) {
+			writeError(w, 404, "MEMBERSHIP_NOT_FOUND", "Membership does not exist")
+			return
+		}
+
+		g.Users = slices.DeleteFunc(g.Users, func(n string) bool { return n == userName })
+		w.WriteHeader(204)
+
+		return
+	}
+
+	var names []string
+
+	if typ == "nested" {
+		names = s.nestedUsers(groupName)
+	} else {
+		names = slices.Sorted(slices.Values(g.Users))
+	}
+
+	result := &struct {
+		XMLName xml.Name   `xml:"users"`
+		Users   []*xmlUser `xml:"user"`
+	}{}
+
+	for _, name := range paginate(names, query) {
+		result.Users = append(result.Users, encodeUser(s.users[name], false))
+	}
+
+	writeXML(w, 200, result)
+}
+
+// handleChildGroups handles child groups requests
+func (s *Server) handleChildGroups(w http.ResponseWriter, method, typ string, query map[string][]string, body []byte) {
+	groupName := first(query["groupname"])
+	g := s.groups[groupName]
+
+	if g == nil {
+		writeError(w, 404, "GROUP_NOT_FOUND", "Group <"+groupName+"> does not exist")
+		return
+	}
+
+	switch method {
+	case "POST":
+		child := &xmlName{}
+		xml.Unmarshal(body, child)
+
+		if s.groups[child.Name] == nil {
+			writeError(w, 400, "GROUP_NOT_FOUND", "Group <"+child.Name+"> does not exist")
+			return
+		}
+
+		if slices.Contains(g.Groups, child.Name) {
+			writeError(w, 409, "MEMBERSHIP_ALREADY_EXISTS", "Group is already a member")
+			return
+		}
+
+		g.Groups = append(g.Groups, child.Name)
+		w.WriteHeader(201)
+
+	case "DELETE":
+		childName := first(query["child-groupname"])
+
+		if !slices.Contains(g.Groups, childName) {
+			writeError(w, 404, "MEMBERSHIP_NOT_FOUND", "Membership does not exist")
+			return
+		}
+
+		g.Groups = slices.DeleteFunc(g.Groups, func(n string) bool { return n == childName })
+		w.WriteHeader(204)
+
+	default:
+		result := &struct {
+			XMLName xml.Name    `xml:"groups"`
+			Groups  []*xmlGroup `xml:"group"`
+		}{}
+
+		names := slices.Sorted(slices.Values(g.Groups))
+
+		if typ == "nested" {
+			names = s.nestedChildGroups(groupName)
+		}
+
+		for _, name := range paginate(names, query) {
+			result.Groups = append(result.Groups, encodeGroup(s.groups[name], false))
+		}
+
+		writeXML(w, 200, result)
+	}
+}
+
+// handleMemberships handles memberships request
+func (s *Server) handleMemberships(w http.ResponseWriter) {
+	type membership struct {
+		Group  string     `xml:"group,attr"`
+		Users  []*xmlName `xml:"users>user"`
+		Groups []*xmlName `xml:"groups>group"`
+	}
+
+	result := &struct {
+		XMLName     xml.Name      `xml:"memberships"`
+		Memberships []*membership `xml:"membership"`
+	}{}
+
+	for _, name := range sortedKeys(s.groups) {
+		m := &membership{Group: name}
+
+		for _, u := range s.groups[name].Users {
+			m.Users = append(m.Users, &xmlName{u})
+		}
+
+		for _, g := range s.groups[name].Groups {
+			m.Groups = append(m.Groups, &xmlName{g})
+		}
+
+		result.Memberships = append(result.Memberships, m)
+	}
+
+	writeXML(w, 200, result)
+}
+
+// handleSearch handles search requests. Restrictions in format
+// 'field = "value"' (with * wildcards) combined with "and", "or" and
+// parentheses are supported.
+func (s *Server) handleSearch(w http.ResponseWriter, entity, restriction string, query map[string][]string) {
+	match, err := parseCQL(restriction)
+
+	if err != nil {
+		writeError(w, 400, "INVALID_SEARCH_RESTRICTION", err.Error())
+		return
+	}
+
+	if entity == "group" {
+		result := &struct {
+			XMLName xml.Name    `xml:"groups"`
+			Groups  []*xmlGroup `xml:"group"`
+		}{}
+
+		var names []string
+
+		for _, name := range sortedKeys(s.groups) {
+			g := s.groups[name]
+
+			if match(map[string]string{
+				"name": g.Name, "description": g.Description,
+				"active": strconv.FormatBool(g.IsActive),
+			}) {
+				names = append(names, name)
+			}
+		}
+
+		for _, name := range paginate(names, query) {
+			result.Groups = append(result.Groups, encodeGroup(s.groups[name], false))
+		}
+
+		writeXML(w, 200, result)
+		return
+	}
+
+	result := &struct {
+		XMLName xml.Name   `xml:"users"`
+		Users   []*xmlUser `xml:"user"`
+	}{}
+
+	var names []string
+
+	for _, name := range sortedKeys(s.users) {
+		u := s.users[name]
+
+		if match(map[string]string{
+			"name": u.Name, "email": u.Email, "firstname": u.FirstName,
+			"lastname": u.LastName, "displayname": u.DisplayName,
+			"active": strconv.FormatBool(u.IsActive),
+		}) {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range paginate(names, query) {
+		result.Users = append(result.Users, encodeUser(s.users[name], false))
+	}
+
+	writeXML(w, 200, result)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// userAttrs returns attributes of user with given name
+func (s *Server) userAttrs(name string) map[string][]string {
+	if s.users[name] == nil {
+		return nil
+	}
+
+	return s.users[name].Attributes
+}
+
+// groupAttrs returns attributes of group with given name
+func (s *Server) groupAttrs(name string) map[string][]string {
+	if s.groups[name] == nil {
+		return nil
+	}
+
+	return s.groups[name].Attributes
+}
+
+// directGroups returns names of groups where user is a direct member
+func (s *Server) directGroups(userName string) []string {
+	var result []string
+
+	for _, name := range sortedKeys(s.groups) {
+		if slices.Contains(s.groups[name].Users, userName) {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+// nestedGroups returns names of groups where user is a direct or nested member
+func (s *Server) nestedGroups(userName string) []string {
+	result := s.directGroups(userName)
+
+	for i := 0; i < len(result); i++ {
+		for _, name := range sortedKeys(s.groups) {
+			if slices.Contains(s.groups[name].Groups, result[i]) && !slices.Contains(result, name) {
+				result = append(result, name)
+			}
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// nestedChildGroups returns names of direct and nested child groups of group
+func (s *Server) nestedChildGroups(groupName string) []string {
+	var result []string
+
+	groups := []string{groupName}
+
+	for i := 0; i < len(groups); i++ {
+		for _, g := range s.groups[groups[i]].Groups {
+			if !slices.Contains(groups, g) && s.groups[g] != nil {
+				groups = append(groups, g)
+				result = append(result, g)
+			}
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// nestedUsers returns names of direct and nested members of group
+func (s *Server) nestedUsers(groupName string) []string {
+	var result []string
+
+	groups := []string{groupName}
+
+	for i := 0; i < len(groups); i++ {
+		for _, u := range s.groups[groups[i]].Users {
+			if !slices.Contains(result, u) {
+				result = append(result, u)
+			}
+		}
+
+		for _, g := range s.groups[groups[i]].Groups {
+			if !slices.Contains(groups, g) && s.groups[g] != nil {
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// encodeUser converts user to xml struct
+func encodeUser(u *User, withAttrs bool) *xmlUser {
+	result := &xmlUser{
+		Name:        u.Name,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		DisplayName: u.DisplayName,
+		Email:       u.Email,
+		IsActive:    u.IsActive,
+	}
+
+	if withAttrs {
+		result.Attributes = encodeAttrs(u.Attributes)
+	}
+
+	return result
+}
+
+// encodeGroup converts group to xml struct
+func encodeGroup(g *Group, withAttrs bool) *xmlGroup {
+	result := &xmlGroup{
+		Name:        g.Name,
+		Description: g.Description,
+		Type:        "GROUP",
+		IsActive:    g.IsActive,
+	}
+
+	if withAttrs {
+		result.Attributes = encodeAttrs(g.Attributes)
+	}
+
+	return result
+}
+
+// encodeAttrs converts attributes map to slice
+func encodeAttrs(attrs map[string][]string) []*xmlAttribute {
+	var result []*xmlAttribute
+
+	for _, name := range sortedKeys(attrs) {
+		result = append(result, &xmlAttribute{name, attrs[name]})
+	}
+
+	return result
+}
+
+// cloneAttrs returns deep copy of attributes map
+func cloneAttrs(attrs map[string][]string) map[string][]string {
+	result := make(map[string][]string, len(attrs))
+
+	for name, values := range attrs {
+		result[name] = slices.Clone(values)
+	}
+
+	return result
+}
+
+// paginate applies start-index and max-results query options to slice
+func paginate(names []string, query map[string][]string) []string {
+	start, _ := strconv.Atoi(first(query["start-index"]))
+	limit, _ := strconv.Atoi(first(query["max-results"]))
+
+	if start < 0 || start >= len(names) {
+		return nil
+	}
+
+	names = names[start:]
+
+	if limit > 0 && limit < len(names) {
+		names = names[:limit]
+	}
+
+	return names
+}
+
+// writeXML writes xml-encoded response
+func writeXML(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+
+	body, _ := xml.Marshal(data)
+
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// writeError writes Crowd error response
+func writeError(w http.ResponseWriter, statusCode int, reason, message string) {
+	writeXML(w, statusCode, &xmlError{Reason: reason, Message: message})
+}
+
+// sortedKeys returns sorted map keys
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// first returns first value from slice
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
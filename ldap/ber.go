@@ -0,0 +1,295 @@
+package ldap
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// BER classes
+const (
+	classUniversal   byte = 0x00
+	classApplication byte = 0x40
+	classContext     byte = 0x80
+)
+
+// BER universal tags
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagEnumerated  = 0x0A
+	tagSequence    = 0x10
+	tagSet         = 0x11
+)
+
+// constructedBit is flag of constructed encoding
+const constructedBit byte = 0x20
+
+// maxPacketDepth is maximum nesting depth of BER elements
+const maxPacketDepth = 64
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// packet is BER-encoded element
+type packet struct {
+	class       byte
+	constructed bool
+	tag         byte
+	data        []byte    // Contents of primitive element
+	children    []*packet // Elements of constructed element
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// errMalformedPacket is returned if packet has invalid encoding
+var errMalformedPacket = errors.New("Malformed BER packet")
+
+// errPacketTooLarge is returned if packet exceeds size limit
+var errPacketTooLarge = errors.New("BER packet is too large")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// readPacket reads and decodes BER element from reader
+func readPacket(r *bufio.Reader, maxSize int) (*packet, error) {
+	header := make([]byte, 2, 6)
+	_, err := io.ReadFull(r, header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size := int(header[1])
+
+	if size&0x80 != 0 {
+		n := size & 0x7F
+
+		if n == 0 || n > 4 {
+			return nil, errMalformedPacket
+		}
+
+		header = header[:2+n]
+		_, err = io.ReadFull(r, header[2:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		size = 0
+
+		for _, b := range header[2:] {
+			size = size<<8 | int(b)
+		}
+	}
+
+	if size > maxSize {
+		return nil, errPacketTooLarge
+	}
+
+	data := make([]byte, len(header)+size)
+	copy(data, header)
+	_, err = io.ReadFull(r, data[len(header):])
+
+	if err != nil {
+		return nil, err
+	}
+
+	p, rest, err := decodePacket(data, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, errMalformedPacket
+	}
+
+	return p, nil
+}
+
+// decodePacket decodes BER element and returns remaining data
+func decodePacket(data []byte, depth int) (*packet, []byte, error) {
+	if len(data) < 2 || data[0]&0x1F == 0x1F || depth > maxPacketDepth {
+		return nil, nil, errMalformedPacket
+	}
+
+	p := &packet{
+		class:       data[0] & 0xC0,
+		constructed: data[0]&constructedBit != 0,
+		tag:         data[0] & 0x1F,
+	}
+
+	size, offset := int(data[1]), 2
+
+	if size&0x80 != 0 {
+		n := size & 0x7F
+
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return nil, nil, errMalformedPacket
+		}
+
+		size = 0
+
+		for _, b := range data[2 : 2+n] {
+			size = size<<8 | int(b)
+		}
+
+		offset += n
+	}
+
+	if size < 0 || len(data)-offset < size {
+		return nil, nil, errMalformedPacket
+	}
+
+	content, rest := data[offset:offset+size], data[offset+size:]
+
+	if !p.constructed {
+		p.data = content
+		return p, rest, nil
+	}
+
+	for len(content) != 0 {
+		child, tail, err := decodePacket(content, depth+1)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		p.children = append(p.children, child)
+		content = tail
+	}
+
+	return p, rest, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// newSequence creates constructed element
+func newSequence(class, tag byte, children ...*packet) *packet {
+	return &packet{class: class, constructed: true, tag: tag, children: children}
+}
+
+// newString creates octet string element
+func newString(class, tag byte, value string) *packet {
+	return &packet{class: class, tag: tag, data: []byte(value)}
+}
+
+// newInteger creates integer element
+func newInteger(class, tag byte, value int64) *packet {
+	data := []byte{byte(value)}
+
+	for v := value >> 8; ; v >>= 8 {
+		// Stop when remaining bytes are only sign extension
+		if (v == 0 && data[0]&0x80 == 0) || (v == -1 && data[0]&0x80 != 0) {
+			break
+		}
+
+		data = append([]byte{byte(v)}, data...)
+	}
+
+	return &packet{class: class, tag: tag, data: data}
+}
+
+// newBoolean creates boolean element
+func newBoolean(class, tag byte, value bool) *packet {
+	if value {
+		return &packet{class: class, tag: tag, data: []byte{0xFF}}
+	}
+
+	return &packet{class: class, tag: tag, data: []byte{0x00}}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// encode encodes element
+func (p *packet) encode() []byte {
+	content := p.data
+
+	if p.constructed {
+		content = nil
+
+		for _, child := range p.children {
+			content = append(content, child.encode()...)
+		}
+	}
+
+	identifier := p.class | p.tag
+
+	if p.constructed {
+		identifier |= constructedBit
+	}
+
+	result := []byte{identifier}
+
+	switch size := len(content); {
+	case size < 0x80:
+		result = append(result, byte(size))
+	case size <= 0xFF:
+		result = append(result, 0x81, byte(size))
+	case size <= 0xFFFF:
+		result = append(result, 0x82, byte(size>>8), byte(size))
+	case size <= 0xFFFFFF:
+		result = append(result, 0x83, byte(size>>16), byte(size>>8), byte(size))
+	default:
+		result = append(result, 0x84, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+	}
+
+	return append(result, content...)
+}
+
+// is returns true if element has given class and tag
+func (p *packet) is(class, tag byte) bool {
+	return p != nil && p.class == class && p.tag == tag
+}
+
+// child returns child element with given index or nil
+func (p *packet) child(index int) *packet {
+	if p == nil || index >= len(p.children) {
+		return nil
+	}
+
+	return p.children[index]
+}
+
+// str returns contents of primitive element as string
+func (p *packet) str() string {
+	if p == nil {
+		return ""
+	}
+
+	return string(p.data)
+}
+
+// int returns contents of primitive element as integer
+func (p *packet) int() (int64, error) {
+	if p == nil || p.constructed || len(p.data) == 0 || len(p.data) > 8 {
+		return 0, errMalformedPacket
+	}
+
+	value := int64(int8(p.data[0]))
+
+	for _, b := range p.data[1:] {
+		value = value<<8 | int64(b)
+	}
+
+	return value, nil
+}
+
+// bool returns contents of primitive element as boolean
+func (p *packet) bool() (bool, error) {
+	if p == nil || p.constructed || len(p.data) != 1 {
+		return false, errMalformedPacket
+	}
+
+	return p.data[0] != 0, nil
+}
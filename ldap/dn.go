@@ -0,0 +1,162 @@
+package ldap
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// rdn is relative distinguished name
+type rdn struct {
+	attr  string
+	value string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ErrInvalidDN is returned if DN has invalid syntax
+var ErrInvalidDN = errors.New("Invalid DN syntax")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseDN parses DN (RFC 4514). Multi-valued RDNs are not supported.
+func parseDN(dn string) ([]rdn, error) {
+	var result []rdn
+
+	dn = strings.TrimSpace(dn)
+
+	if dn == "" {
+		return nil, nil
+	}
+
+	for i := 0; i <= len(dn); {
+		eq := strings.IndexByte(dn[i:], '=')
+
+		if eq == -1 {
+			return nil, ErrInvalidDN
+		}
+
+		attr := strings.TrimSpace(dn[i : i+eq])
+
+		if attr == "" {
+			return nil, ErrInvalidDN
+		}
+
+		var value strings.Builder
+
+		i += eq + 1
+
+		// Skip leading spaces
+		for i < len(dn) && dn[i] == ' ' {
+			i++
+		}
+
+		lastEscaped := 0
+
+	VALUE:
+		for ; i < len(dn); i++ {
+			switch c := dn[i]; c {
+			case ',', ';':
+				break VALUE
+			case '+':
+				return nil, ErrInvalidDN
+			case '\\':
+				if i+1 >= len(dn) {
+					return nil, ErrInvalidDN
+				}
+
+				if isHex(dn[i+1]) {
+					if i+2 >= len(dn) || !isHex(dn[i+2]) {
+						return nil, ErrInvalidDN
+					}
+
+					b, _ := hex.DecodeString(dn[i+1 : i+3])
+					value.Write(b)
+					i += 2
+				} else {
+					value.WriteByte(dn[i+1])
+					i++
+				}
+
+				lastEscaped = value.Len()
+			default:
+				value.WriteByte(c)
+			}
+		}
+
+		// Trailing spaces are removed unless escaped
+		v := value.String()
+		v = v[:max(lastEscaped, len(strings.TrimRight(v, " ")))]
+
+		result = append(result, rdn{attr, v})
+
+		i++
+	}
+
+	return result, nil
+}
+
+// normalizeDN returns normalized DN for comparison
+func normalizeDN(dn string) (string, error) {
+	rdns, err := parseDN(dn)
+
+	if err != nil {
+		return "", err
+	}
+
+	return joinRDNs(rdns, true), nil
+}
+
+// joinRDNs builds DN from RDNs
+func joinRDNs(rdns []rdn, normalize bool) string {
+	var result strings.Builder
+
+	for i, r := range rdns {
+		if i > 0 {
+			result.WriteByte(',')
+		}
+
+		if normalize {
+			result.WriteString(strings.ToLower(r.attr) + "=" + escapeDNValue(strings.ToLower(r.value)))
+		} else {
+			result.WriteString(r.attr + "=" + escapeDNValue(r.value))
+		}
+	}
+
+	return result.String()
+}
+
+// escapeDNValue escapes special characters in attribute value
+func escapeDNValue(value string) string {
+	var result strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case strings.IndexByte(`,+"\<>;=`, c) != -1,
+			(c == ' ' || c == '#') && i == 0,
+			c == ' ' && i == len(value)-1:
+			result.WriteByte('\\')
+			result.WriteByte(c)
+		case c < 0x20:
+			result.WriteString(`\` + hex.EncodeToString([]byte{c}))
+		default:
+			result.WriteByte(c)
+		}
+	}
+
+	return result.String()
+}
+
+// isHex returns true if given char is a hex digit
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
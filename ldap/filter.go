@@ -0,0 +1,370 @@
+package ldap
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"strings"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Filter choices (RFC 4511, section 4.5.1)
+const (
+	filterAnd            byte = 0
+	filterOr             byte = 1
+	filterNot            byte = 2
+	filterEquality       byte = 3
+	filterSubstrings     byte = 4
+	filterGreaterOrEqual byte = 5
+	filterLessOrEqual    byte = 6
+	filterPresent        byte = 7
+	filterApprox         byte = 8
+	filterExtensible     byte = 9
+)
+
+// Substring choices
+const (
+	substringInitial byte = 0
+	substringAny     byte = 1
+	substringFinal   byte = 2
+)
+
+// maxFilterDepth is maximum nesting depth of filters
+const maxFilterDepth = 32
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// filter is decoded search filter
+type filter struct {
+	op       byte
+	children []*filter
+	attr     string
+	value    string
+	initial  string
+	any      []string
+	final    string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// cqlAll is restriction which matches all entities
+const cqlAll = ""
+
+// cqlNone is marker of restriction which doesn't match any entity
+const cqlNone = "\x00"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// userCQLFields maps user attributes to Crowd search fields. Attributes with
+// fallback values (cn, sn) can't be translated.
+var userCQLFields = map[string]string{
+	"uid":         "name",
+	"mail":        "email",
+	"givenname":   "firstName",
+	"displayname": "displayName",
+}
+
+// groupCQLFields maps group attributes to Crowd search fields
+var groupCQLFields = map[string]string{
+	"cn":          "name",
+	"description": "description",
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseFilter decodes search filter
+func parseFilter(p *packet, depth int) (*filter, error) {
+	if p == nil || p.class != classContext || depth > maxFilterDepth {
+		return nil, errMalformedPacket
+	}
+
+	f := &filter{op: p.tag}
+
+	switch p.tag {
+	case filterAnd, filterOr:
+		if !p.constructed {
+			return nil, errMalformedPacket
+		}
+
+		for _, c := range p.children {
+			child, err := parseFilter(c, depth+1)
+
+			if err != nil {
+				return nil, err
+			}
+
+			f.children = append(f.children, child)
+		}
+
+	case filterNot:
+		child, err := parseFilter(p.child(0), depth+1)
+
+		if err != nil || len(p.children) != 1 {
+			return nil, errMalformedPacket
+		}
+
+		f.children = []*filter{child}
+
+	case filterEquality, filterGreaterOrEqual, filterLessOrEqual, filterApprox:
+		if len(p.children) != 2 {
+			return nil, errMalformedPacket
+		}
+
+		f.attr, f.value = p.child(0).str(), p.child(1).str()
+
+	case filterSubstrings:
+		if len(p.children) != 2 {
+			return nil, errMalformedPacket
+		}
+
+		f.attr = p.child(0).str()
+
+		for _, s := range p.child(1).children {
+			switch s.tag {
+			case substringInitial:
+				f.initial = s.str()
+			case substringAny:
+				f.any = append(f.any, s.str())
+			case substringFinal:
+				f.final = s.str()
+			}
+		}
+
+	case filterPresent:
+		f.attr = p.str()
+
+	case filterExtensible:
+		// Extensible matching isn't supported, such filters never match
+
+	default:
+		return nil, errMalformedPacket
+	}
+
+	return f, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// match returns true if entry matches filter
+func (f *filter) match(e *entry) bool {
+	switch f.op {
+	case filterAnd:
+		for _, c := range f.children {
+			if !c.match(e) {
+				return false
+			}
+		}
+
+		return true
+
+	case filterOr:
+		for _, c := range f.children {
+			if c.match(e) {
+				return true
+			}
+		}
+
+		return false
+
+	case filterNot:
+		return !f.children[0].match(e)
+
+	case filterPresent:
+		return strings.EqualFold(f.attr, "objectClass") || len(e.get(f.attr)) != 0
+	}
+
+	for _, value := range e.get(f.attr) {
+		if f.matchValue(normalizeValue(f.attr, value)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchValue returns true if normalized attribute value matches filter
+func (f *filter) matchValue(value string) bool {
+	switch f.op {
+	case filterEquality, filterApprox:
+		return value == normalizeValue(f.attr, f.value)
+
+	case filterGreaterOrEqual:
+		return value >= normalizeValue(f.attr, f.value)
+
+	case filterLessOrEqual:
+		return value <= normalizeValue(f.attr, f.value)
+
+	case filterSubstrings:
+		initial := strings.ToLower(f.initial)
+
+		if !strings.HasPrefix(value, initial) {
+			return false
+		}
+
+		value = value[len(initial):]
+
+		for _, part := range f.any {
+			part = strings.ToLower(part)
+			i := strings.Index(value, part)
+
+			if i == -1 {
+				return false
+			}
+
+			value = value[i+len(part):]
+		}
+
+		return strings.HasSuffix(value, strings.ToLower(f.final))
+	}
+
+	return false
+}
+
+// references returns true if filter references given attribute
+func (f *filter) references(attr string) bool {
+	if strings.EqualFold(f.attr, attr) {
+		return true
+	}
+
+	for _, c := range f.children {
+		if c.references(attr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// assertion returns value of equality assertion for given attribute if filter
+// is such assertion or AND filter which contains it
+func (f *filter) assertion(attr string) string {
+	if f.op == filterEquality && strings.EqualFold(f.attr, attr) {
+		return f.value
+	}
+
+	if f.op == filterAnd {
+		for _, c := range f.children {
+			if c.op == filterEquality && strings.EqualFold(c.attr, attr) {
+				return c.value
+			}
+		}
+	}
+
+	return ""
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// cql translates filter to Crowd search restriction. Restriction may match
+// more entities than filter (they are filtered later), but never less.
+func (f *filter) cql(s *schema) string {
+	switch f.op {
+	case filterAnd:
+		var terms []string
+
+		for _, c := range f.children {
+			switch term := c.cql(s); term {
+			case cqlNone:
+				return cqlNone
+			case cqlAll:
+				continue
+			default:
+				terms = append(terms, term)
+			}
+		}
+
+		return joinCQL(terms, "and")
+
+	case filterOr:
+		var terms []string
+
+		for _, c := range f.children {
+			switch term := c.cql(s); term {
+			case cqlAll:
+				return cqlAll
+			case cqlNone:
+				continue
+			default:
+				terms = append(terms, term)
+			}
+		}
+
+		if len(terms) == 0 {
+			return cqlNone
+		}
+
+		return joinCQL(terms, "or")
+
+	case filterNot:
+		return cqlAll
+
+	case filterExtensible:
+		return cqlNone
+	}
+
+	attr := strings.ToLower(f.attr)
+
+	if attr == "objectclass" {
+		if f.op == filterEquality && !s.hasClass(f.value) {
+			return cqlNone
+		}
+
+		return cqlAll
+	}
+
+	if !s.hasAttr(attr) {
+		return cqlNone
+	}
+
+	field := s.fields[attr]
+
+	switch {
+	case field == "":
+		return cqlAll
+	case f.op == filterEquality:
+		return field + " = " + quoteCQL(f.value)
+	case f.op == filterSubstrings && f.initial != "":
+		return field + " = " + quoteCQL(f.initial+"*")
+	}
+
+	return cqlAll
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// joinCQL joins restriction terms with given operator
+func joinCQL(terms []string, op string) string {
+	switch len(terms) {
+	case 0:
+		return cqlAll
+	case 1:
+		return terms[0]
+	}
+
+	return "(" + strings.Join(terms, " "+op+" ") + ")"
+}
+
+// quoteCQL quotes value for using in restriction
+func quoteCQL(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// normalizeValue normalizes attribute value for comparison. All supported
+// attributes use case-insensitive matching rules.
+func normalizeValue(attr, value string) string {
+	switch strings.ToLower(attr) {
+	case "member", "memberof":
+		dn, err := normalizeDN(value)
+
+		if err == nil {
+			return dn
+		}
+	}
+
+	return strings.ToLower(value)
+}
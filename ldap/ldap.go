@@ -0,0 +1,1109 @@
+// Package ldap provides read-only LDAPv3 front end which proxies binds and
+// searches to Crowd
+package ldap
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DEFAULT_SIZE_LIMIT is default maximum number of entries returned by search
+const DEFAULT_SIZE_LIMIT = 1000
+
+// DEFAULT_IDLE_TIMEOUT is default timeout for idle connections
+const DEFAULT_IDLE_TIMEOUT = 5 * time.Minute
+
+// OID_WHO_AM_I is OID of "Who am I?" extended operation (RFC 4532)
+const OID_WHO_AM_I = "1.3.6.1.4.1.4203.1.11.3"
+
+// Organizational units
+const (
+	USERS_OU  = "users"
+	GROUPS_OU = "groups"
+)
+
+// Protocol operations (RFC 4511, section 4.2)
+const (
+	appBindRequest     byte = 0
+	appBindResponse    byte = 1
+	appUnbindRequest   byte = 2
+	appSearchRequest   byte = 3
+	appSearchEntry     byte = 4
+	appSearchDone      byte = 5
+	appModifyRequest   byte = 6
+	appModifyResponse  byte = 7
+	appAddRequest      byte = 8
+	appAddResponse     byte = 9
+	appDelRequest      byte = 10
+	appDelResponse     byte = 11
+	appModDNRequest    byte = 12
+	appModDNResponse   byte = 13
+	appCompareRequest  byte = 14
+	appCompareResponse byte = 15
+	appAbandonRequest  byte = 16
+	appExtendedRequest byte = 23
+	appExtendedResp    byte = 24
+)
+
+// Result codes
+const (
+	resultSuccess                 = 0
+	resultProtocolError           = 2
+	resultTimeLimitExceeded       = 3
+	resultSizeLimitExceeded       = 4
+	resultAuthMethodNotSupported  = 7
+	resultNoSuchObject            = 32
+	resultInvalidDNSyntax         = 34
+	resultInvalidCredentials      = 49
+	resultInsufficientAccessRight = 50
+	resultUnavailable             = 52
+	resultUnwillingToPerform      = 53
+)
+
+// Search scopes
+const (
+	scopeBase        = 0
+	scopeOne         = 1
+	scopeSubtree     = 2
+	scopeSubordinate = 3
+)
+
+// maxMessageSize is maximum size of LDAP message
+const maxMessageSize = 1024 * 1024
+
+// msgUnavailable is diagnostic message sent to clients if Crowd request failed.
+// Error details are logged and never sent to clients.
+const msgUnavailable = "Crowd is unavailable"
+
+// pageSize is number of users or groups requested per page
+const pageSize = 1000
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Server is read-only LDAP server. Users are exposed as inetOrgPerson entries
+// under "ou=users,<base DN>" and groups as groupOfNames entries under
+// "ou=groups,<base DN>".
+type Server struct {
+	// AllowAnonymous allows searches without bind
+	AllowAnonymous bool
+
+	// IncludeInactive enables exposing inactive users and groups
+	IncludeInactive bool
+
+	// SizeLimit is maximum number of entries returned by search
+	SizeLimit int
+
+	// IdleTimeout is timeout for idle connections (0 disables timeout)
+	IdleTimeout time.Duration
+
+	// Logger is logger for Crowd errors (slog.Default is used if nil)
+	Logger *slog.Logger
+
+	api *crowd.API
+
+	baseDN   string
+	usersDN  string
+	groupsDN string
+	baseRDN  rdn
+	norm     map[string]string // Normalized DNs of static entries
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// conn is client connection
+type conn struct {
+	srv     *Server
+	nc      net.Conn
+	boundDN string
+}
+
+// search is search operation
+type search struct {
+	c         *conn
+	api       *crowd.API
+	id        int64
+	filter    *filter
+	attrs     []string
+	typesOnly bool
+	limit     int
+	sent      int
+}
+
+// entry is directory entry
+type entry struct {
+	dn    string
+	attrs []*attribute
+}
+
+// attribute is entry attribute
+type attribute struct {
+	name   string
+	values []string
+}
+
+// schema contains object classes and attributes of entries
+type schema struct {
+	classes []string
+	attrs   []string
+	fields  map[string]string // Crowd search fields
+}
+
+// ldapError is error with LDAP result code
+type ldapError struct {
+	code int
+	msg  string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ErrServerClosed is returned by Serve after Close call
+var ErrServerClosed = errors.New("LDAP server closed")
+
+// errSizeLimit is returned when search size limit is reached
+var errSizeLimit = &ldapError{resultSizeLimitExceeded, "Size limit exceeded"}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// userSchema is schema of user entries
+var userSchema = &schema{
+	classes: []string{"top", "person", "organizationalPerson", "inetOrgPerson"},
+	attrs:   []string{"objectClass", "uid", "cn", "sn", "givenName", "displayName", "mail", "memberOf"},
+	fields:  userCQLFields,
+}
+
+// groupSchema is schema of group entries
+var groupSchema = &schema{
+	classes: []string{"top", "groupOfNames"},
+	attrs:   []string{"objectClass", "cn", "description", "member"},
+	fields:  groupCQLFields,
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// New creates new LDAP server with given base DN (i.e. "dc=example,dc=com")
+func New(api *crowd.API, baseDN string) (*Server, error) {
+	rdns, err := parseDN(baseDN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rdns) == 0 {
+		return nil, ErrInvalidDN
+	}
+
+	for i := range rdns {
+		rdns[i].attr = strings.ToLower(rdns[i].attr)
+	}
+
+	baseDN = joinRDNs(rdns, false)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Server{
+		SizeLimit:   DEFAULT_SIZE_LIMIT,
+		IdleTimeout: DEFAULT_IDLE_TIMEOUT,
+
+		api:      api,
+		baseDN:   baseDN,
+		usersDN:  "ou=" + USERS_OU + "," + baseDN,
+		groupsDN: "ou=" + GROUPS_OU + "," + baseDN,
+		baseRDN:  rdns[0],
+
+		ctx:       ctx,
+		cancel:    cancel,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+
+	s.norm = map[string]string{}
+
+	for _, dn := range []string{s.baseDN, s.usersDN, s.groupsDN} {
+		s.norm[dn], _ = normalizeDN(dn)
+	}
+
+	return s, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListenAndServe listens on the TCP address and serves LDAP connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts and serves LDAP connections on given listener. It always
+// returns non-nil error, after Close it returns ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		nc, err := l.Accept()
+
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			return err
+		}
+
+		if !s.trackConn(nc) {
+			nc.Close()
+			return ErrServerClosed
+		}
+
+		go (&conn{srv: s, nc: nc}).serve()
+	}
+}
+
+// Close closes all listeners and connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.cancel()
+
+	for l := range s.listeners {
+		l.Close()
+	}
+
+	for nc := range s.conns {
+		nc.Close()
+	}
+
+	return nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Error returns error message
+func (e *ldapError) Error() string {
+	return e.msg
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// isClosed returns true if server is closed
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// trackConn adds connection to the list of active connections
+func (s *Server) trackConn(nc net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[nc] = struct{}{}
+
+	return true
+}
+
+// untrackConn removes connection from the list of active connections
+func (s *Server) untrackConn(nc net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, nc)
+	s.mu.Unlock()
+}
+
+// userDN returns DN of user entry
+func (s *Server) userDN(name string) string {
+	return "uid=" + escapeDNValue(name) + "," + s.usersDN
+}
+
+// groupDN returns DN of group entry
+func (s *Server) groupDN(name string) string {
+	return "cn=" + escapeDNValue(name) + "," + s.groupsDN
+}
+
+// parseChildDN returns value of the first RDN if DN is DN of entry with given
+// naming attribute right under given parent
+func (s *Server) parseChildDN(dn, attr, parent string) (string, bool) {
+	rdns, err := parseDN(dn)
+
+	if err != nil || len(rdns) < 2 || !strings.EqualFold(rdns[0].attr, attr) {
+		return "", false
+	}
+
+	return rdns[0].value, joinRDNs(rdns[1:], true) == s.norm[parent]
+}
+
+// parseBindDN returns user name from bind DN. User name without attribute is
+// accepted as well.
+func (s *Server) parseBindDN(dn string) (string, bool) {
+	if !strings.Contains(dn, "=") {
+		return strings.TrimSpace(dn), strings.TrimSpace(dn) != ""
+	}
+
+	return s.parseChildDN(dn, "uid", s.usersDN)
+}
+
+// logError logs error of Crowd request
+func (s *Server) logError(msg string, err error, remoteAddr net.Addr) {
+	logger := s.Logger
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	logger.Error(msg, slog.String("client", remoteAddr.String()), slog.Any("error", err))
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// serve serves client connection
+func (c *conn) serve() {
+	defer c.srv.untrackConn(c.nc)
+	defer c.nc.Close()
+
+	r := bufio.NewReader(c.nc)
+
+	for {
+		if c.srv.IdleTimeout > 0 {
+			c.nc.SetReadDeadline(time.Now().Add(c.srv.IdleTimeout))
+		}
+
+		msg, err := readPacket(r, maxMessageSize)
+
+		if err != nil || !c.handle(msg) {
+			return
+		}
+	}
+}
+
+// handle handles LDAP message. It returns false if connection must be closed.
+func (c *conn) handle(msg *packet) bool {
+	if !msg.is(classUniversal, tagSequence) || len(msg.children) < 2 {
+		return false
+	}
+
+	id, err := msg.child(0).int()
+	op := msg.child(1)
+
+	if err != nil || op.class != classApplication {
+		return false
+	}
+
+	switch op.tag {
+	case appBindRequest:
+		c.handleBind(id, op)
+	case appUnbindRequest:
+		return false
+	case appSearchRequest:
+		c.handleSearch(id, op)
+	case appExtendedRequest:
+		c.handleExtended(id, op)
+	case appAbandonRequest:
+		// Operations are processed sequentially, so there is nothing to abandon
+	case appModifyRequest:
+		c.writeResult(id, appModifyResponse, resultUnwillingToPerform, "Directory is read-only")
+	case appAddRequest:
+		c.writeResult(id, appAddResponse, resultUnwillingToPerform, "Directory is read-only")
+	case appDelRequest:
+		c.writeResult(id, appDelResponse, resultUnwillingToPerform, "Directory is read-only")
+	case appModDNRequest:
+		c.writeResult(id, appModDNResponse, resultUnwillingToPerform, "Directory is read-only")
+	case appCompareRequest:
+		c.writeResult(id, appCompareResponse, resultUnwillingToPerform, "Compare operation is not supported")
+	default:
+		return false
+	}
+
+	return true
+}
+
+// handleBind handles bind request
+func (c *conn) handleBind(id int64, op *packet) {
+	version, err := op.child(0).int()
+	dn, auth := op.child(1).str(), op.child(2)
+
+	// Failed bind resets connection to anonymous state
+	c.boundDN = ""
+
+	switch {
+	case err != nil || version != 3:
+		c.writeResult(id, appBindResponse, resultProtocolError, "Only LDAPv3 is supported")
+		return
+	case auth.is(classContext, 3):
+		c.writeResult(id, appBindResponse, resultAuthMethodNotSupported, "SASL authentication is not supported")
+		return
+	case !auth.is(classContext, 0):
+		c.writeResult(id, appBindResponse, resultProtocolError, "Unknown authentication method")
+		return
+	}
+
+	password := auth.str()
+
+	switch {
+	case dn == "" && password == "":
+		c.writeResult(id, appBindResponse, resultSuccess, "")
+		return
+	case password == "":
+		c.writeResult(id, appBindResponse, resultUnwillingToPerform, "Unauthenticated binds are not allowed")
+		return
+	}
+
+	userName, ok := c.srv.parseBindDN(dn)
+
+	if !ok {
+		c.writeResult(id, appBindResponse, resultInvalidCredentials, "")
+		return
+	}
+
+	user, err := c.srv.api.WithContext(c.srv.ctx).Login(userName, password)
+
+	switch {
+	case errors.Is(err, crowd.ErrInvalidCredentials):
+		c.writeResult(id, appBindResponse, resultInvalidCredentials, "")
+	case err != nil:
+		c.srv.logError("Bind request to Crowd failed", err, c.nc.RemoteAddr())
+		c.writeResult(id, appBindResponse, resultUnavailable, msgUnavailable)
+	default:
+		c.boundDN = c.srv.userDN(user.Name)
+		c.writeResult(id, appBindResponse, resultSuccess, "")
+	}
+}
+
+// handleExtended handles extended request
+func (c *conn) handleExtended(id int64, op *packet) {
+	if !op.child(0).is(classContext, 0) || op.child(0).str() != OID_WHO_AM_I {
+		c.writeResult(id, appExtendedResp, resultProtocolError, "Unsupported extended operation")
+		return
+	}
+
+	authzID := ""
+
+	if c.boundDN != "" {
+		authzID = "dn:" + c.boundDN
+	}
+
+	c.write(id, newSequence(classApplication, appExtendedResp,
+		newInteger(classUniversal, tagEnumerated, resultSuccess),
+		newString(classUniversal, tagOctetString, ""),
+		newString(classUniversal, tagOctetString, ""),
+		newString(classContext, 11, authzID),
+	))
+}
+
+// handleSearch handles search request
+func (c *conn) handleSearch(id int64, op *packet) {
+	if len(op.children) != 8 {
+		c.writeResult(id, appSearchDone, resultProtocolError, "Malformed search request")
+		return
+	}
+
+	baseDN := op.child(0).str()
+	scope, err1 := op.child(1).int()
+	sizeLimit, err2 := op.child(3).int()
+	timeLimit, err3 := op.child(4).int()
+	typesOnly, err4 := op.child(5).bool()
+	f, err5 := parseFilter(op.child(6), 0)
+
+	if errors.Join(err1, err2, err3, err4, err5) != nil {
+		c.writeResult(id, appSearchDone, resultProtocolError, "Malformed search request")
+		return
+	}
+
+	isRootDSE := baseDN == "" && scope == scopeBase
+
+	if c.boundDN == "" && !c.srv.AllowAnonymous && !isRootDSE {
+		c.writeResult(id, appSearchDone, resultInsufficientAccessRight, "Anonymous search is not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.srv.ctx)
+	defer cancel()
+
+	if timeLimit > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeLimit)*time.Second)
+		defer cancel()
+	}
+
+	s := &search{
+		c:         c,
+		api:       c.srv.api.WithContext(ctx),
+		id:        id,
+		filter:    f,
+		typesOnly: typesOnly,
+		limit:     c.srv.SizeLimit,
+	}
+
+	if sizeLimit > 0 && (s.limit <= 0 || int(sizeLimit) < s.limit) {
+		s.limit = int(sizeLimit)
+	}
+
+	for _, attr := range op.child(7).children {
+		s.attrs = append(s.attrs, attr.str())
+	}
+
+	err := s.run(baseDN, int(scope))
+
+	var ldapErr *ldapError
+
+	switch {
+	case err == nil:
+		c.writeResult(id, appSearchDone, resultSuccess, "")
+	case errors.As(err, &ldapErr):
+		c.writeResult(id, appSearchDone, ldapErr.code, ldapErr.msg)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		c.writeResult(id, appSearchDone, resultTimeLimitExceeded, "Time limit exceeded")
+	case errors.Is(err, crowd.ErrNoPerms):
+		c.writeResult(id, appSearchDone, resultInsufficientAccessRight, err.Error())
+	default:
+		c.srv.logError("Search request to Crowd failed", err, c.nc.RemoteAddr())
+		c.writeResult(id, appSearchDone, resultUnavailable, msgUnavailable)
+	}
+}
+
+// writeResult writes response with LDAP result
+func (c *conn) writeResult(id int64, tag byte, code int, msg string) {
+	c.write(id, newSequence(classApplication, tag,
+		newInteger(classUniversal, tagEnumerated, int64(code)),
+		newString(classUniversal, tagOctetString, ""),
+		newString(classUniversal, tagOctetString, msg),
+	))
+}
+
+// write writes LDAP message with given operation
+func (c *conn) write(id int64, op *packet) error {
+	msg := newSequence(classUniversal, tagSequence,
+		newInteger(classUniversal, tagInteger, id), op,
+	)
+
+	_, err := c.nc.Write(msg.encode())
+
+	return err
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// run searches entries and sends them to client
+func (s *search) run(baseDN string, scope int) error {
+	srv := s.c.srv
+
+	if scope < scopeBase || scope > scopeSubordinate {
+		return &ldapError{resultProtocolError, "Invalid search scope"}
+	}
+
+	if baseDN == "" {
+		if scope != scopeBase {
+			return &ldapError{resultNoSuchObject, "Entry does not exist"}
+		}
+
+		return s.send(srv.rootDSE())
+	}
+
+	norm, err := normalizeDN(baseDN)
+
+	if err != nil {
+		return &ldapError{resultInvalidDNSyntax, err.Error()}
+	}
+
+	withBase := scope == scopeBase || scope == scopeSubtree
+	withChildren := scope != scopeBase
+
+	switch norm {
+	case srv.norm[srv.baseDN]:
+		if withBase {
+			err = s.send(srv.baseEntry())
+		}
+
+		if err == nil && withChildren {
+			err = s.send(srv.ouEntry(USERS_OU))
+		}
+
+		if err == nil && withChildren {
+			err = s.send(srv.ouEntry(GROUPS_OU))
+		}
+
+		if err == nil && (scope == scopeSubtree || scope == scopeSubordinate) {
+			err = s.searchUsers()
+
+			if err == nil {
+				err = s.searchGroups()
+			}
+		}
+
+		return err
+
+	case srv.norm[srv.usersDN], srv.norm[srv.groupsDN]:
+		ou, searchFunc := USERS_OU, s.searchUsers
+
+		if norm == srv.norm[srv.groupsDN] {
+			ou, searchFunc = GROUPS_OU, s.searchGroups
+		}
+
+		if withBase {
+			err = s.send(srv.ouEntry(ou))
+		}
+
+		if err == nil && withChildren {
+			err = searchFunc()
+		}
+
+		return err
+	}
+
+	if name, ok := srv.parseChildDN(baseDN, "uid", srv.usersDN); ok {
+		return s.searchUser(name, withBase)
+	}
+
+	if name, ok := srv.parseChildDN(baseDN, "cn", srv.groupsDN); ok {
+		return s.searchGroup(name, withBase)
+	}
+
+	return &ldapError{resultNoSuchObject, "Entry does not exist"}
+}
+
+// searchUser sends entry of user with given name
+func (s *search) searchUser(name string, withBase bool) error {
+	user, err := s.api.GetUser(name, false)
+
+	if err == crowd.ErrUserNoFound || (err == nil && !user.IsActive && !s.c.srv.IncludeInactive) {
+		return &ldapError{resultNoSuchObject, "Entry does not exist"}
+	}
+
+	if err != nil || !withBase {
+		return err
+	}
+
+	return s.sendUser(user)
+}
+
+// searchGroup sends entry of group with given name
+func (s *search) searchGroup(name string, withBase bool) error {
+	group, err := s.api.GetGroup(name, false)
+
+	if err == crowd.ErrGroupNoFound || (err == nil && !group.IsActive && !s.c.srv.IncludeInactive) {
+		return &ldapError{resultNoSuchObject, "Entry does not exist"}
+	}
+
+	if err != nil || !withBase {
+		return err
+	}
+
+	return s.sendGroup(group)
+}
+
+// searchUsers sends user entries matching filter. Filters with memberOf
+// assertion are resolved using group members listing.
+func (s *search) searchUsers() error {
+	if dn := s.filter.assertion("memberOf"); dn != "" {
+		name, ok := s.c.srv.parseChildDN(dn, "cn", s.c.srv.groupsDN)
+
+		if !ok {
+			return nil
+		}
+
+		return paginate(func(opts crowd.ListingOptions) (int, error) {
+			users, err := s.api.GetGroupNestedUsers(name, opts)
+
+			switch {
+			case err == crowd.ErrGroupNoFound:
+				return 0, nil
+			case err != nil:
+				return 0, err
+			}
+
+			return len(users), s.sendUsers(users)
+		})
+	}
+
+	cql := s.filter.cql(userSchema)
+
+	if cql == cqlNone {
+		return nil
+	}
+
+	return paginate(func(opts crowd.ListingOptions) (int, error) {
+		users, err := s.api.SearchUsers(cql, opts)
+
+		if err != nil {
+			return 0, err
+		}
+
+		return len(users), s.sendUsers(users)
+	})
+}
+
+// searchGroups sends group entries matching filter. Filters with member
+// assertion are resolved using user groups listing.
+func (s *search) searchGroups() error {
+	if dn := s.filter.assertion("member"); dn != "" {
+		name, ok := s.c.srv.parseChildDN(dn, "uid", s.c.srv.usersDN)
+
+		if ok {
+			return paginate(func(opts crowd.ListingOptions) (int, error) {
+				groups, err := s.api.GetUserDirectGroups(name, opts)
+
+				switch {
+				case err == crowd.ErrUserNoFound:
+					return 0, nil
+				case err != nil:
+					return 0, err
+				}
+
+				return len(groups), s.sendGroups(groups)
+			})
+		}
+	}
+
+	cql := s.filter.cql(groupSchema)
+
+	if cql == cqlNone {
+		return nil
+	}
+
+	return paginate(func(opts crowd.ListingOptions) (int, error) {
+		groups, err := s.api.SearchGroups(cql, opts)
+
+		if err != nil {
+			return 0, err
+		}
+
+		return len(groups), s.sendGroups(groups)
+	})
+}
+
+// sendUsers sends entries of given users
+func (s *search) sendUsers(users []*crowd.User) error {
+	for _, user := range users {
+		if !user.IsActive && !s.c.srv.IncludeInactive {
+			continue
+		}
+
+		err := s.sendUser(user)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendGroups sends entries of given groups
+func (s *search) sendGroups(groups []*crowd.Group) error {
+	for _, group := range groups {
+		if !group.IsActive && !s.c.srv.IncludeInactive {
+			continue
+		}
+
+		err := s.sendGroup(group)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendUser sends user entry
+func (s *search) sendUser(user *crowd.User) error {
+	srv := s.c.srv
+	e := &entry{dn: srv.userDN(user.Name)}
+
+	e.add("objectClass", userSchema.classes...)
+	e.add("uid", user.Name)
+	e.add("cn", firstNonEmpty(user.DisplayName, strings.TrimSpace(user.FirstName+" "+user.LastName), user.Name))
+	e.add("sn", firstNonEmpty(user.LastName, user.Name))
+	e.add("givenName", user.FirstName)
+	e.add("displayName", user.DisplayName)
+	e.add("mail", user.Email)
+
+	if s.filter.references("memberOf") || s.isRequested("memberOf") {
+		err := paginate(func(opts crowd.ListingOptions) (int, error) {
+			groups, err := s.api.GetUserNestedGroups(user.Name, opts)
+
+			for _, group := range groups {
+				e.add("memberOf", srv.groupDN(group.Name))
+			}
+
+			return len(groups), err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.send(e)
+}
+
+// sendGroup sends group entry
+func (s *search) sendGroup(group *crowd.Group) error {
+	srv := s.c.srv
+	e := &entry{dn: srv.groupDN(group.Name)}
+
+	e.add("objectClass", groupSchema.classes...)
+	e.add("cn", group.Name)
+	e.add("description", group.Description)
+
+	if s.filter.references("member") || s.isRequested("member") {
+		err := paginate(func(opts crowd.ListingOptions) (int, error) {
+			users, err := s.api.GetGroupDirectUsers(group.Name, opts)
+
+			for _, user := range users {
+				e.add("member", srv.userDN(user.Name))
+			}
+
+			return len(users), err
+		})
+
+		if err == nil {
+			err = paginate(func(opts crowd.ListingOptions) (int, error) {
+				groups, err := s.api.GetGroupDirectChildGroups(group.Name, opts)
+
+				for _, child := range groups {
+					e.add("member", srv.groupDN(child.Name))
+				}
+
+				return len(groups), err
+			})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.send(e)
+}
+
+// send sends entry to client if it matches filter
+func (s *search) send(e *entry) error {
+	if !s.filter.match(e) {
+		return nil
+	}
+
+	if s.limit > 0 && s.sent >= s.limit {
+		return errSizeLimit
+	}
+
+	var attrs []*packet
+
+	for _, attr := range e.attrs {
+		if !s.isRequested(attr.name) {
+			continue
+		}
+
+		values := newSequence(classUniversal, tagSet)
+
+		if !s.typesOnly {
+			for _, value := range attr.values {
+				values.children = append(values.children, newString(classUniversal, tagOctetString, value))
+			}
+		}
+
+		attrs = append(attrs, newSequence(classUniversal, tagSequence,
+			newString(classUniversal, tagOctetString, attr.name), values,
+		))
+	}
+
+	s.sent++
+
+	return s.c.write(s.id, newSequence(classApplication, appSearchEntry,
+		newString(classUniversal, tagOctetString, e.dn),
+		newSequence(classUniversal, tagSequence, attrs...),
+	))
+}
+
+// isRequested returns true if attribute is requested by client. Operational
+// attribute memberOf is returned only if requested explicitly or with "+".
+func (s *search) isRequested(name string) bool {
+	isOperational := strings.EqualFold(name, "memberOf")
+
+	if len(s.attrs) == 0 {
+		return !isOperational
+	}
+
+	for _, attr := range s.attrs {
+		switch {
+		case attr == "*" && !isOperational,
+			attr == "+" && isOperational,
+			strings.EqualFold(attr, name):
+			return true
+		}
+	}
+
+	return false
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// rootDSE returns root DSE entry
+func (s *Server) rootDSE() *entry {
+	e := &entry{}
+
+	e.add("objectClass", "top")
+	e.add("namingContexts", s.baseDN)
+	e.add("supportedLDAPVersion", "3")
+	e.add("supportedExtension", OID_WHO_AM_I)
+
+	return e
+}
+
+// baseEntry returns entry of base DN
+func (s *Server) baseEntry() *entry {
+	e := &entry{dn: s.baseDN}
+
+	switch strings.ToLower(s.baseRDN.attr) {
+	case "dc":
+		e.add("objectClass", "top", "domain")
+	case "o":
+		e.add("objectClass", "top", "organization")
+	case "ou":
+		e.add("objectClass", "top", "organizationalUnit")
+	default:
+		e.add("objectClass", "top")
+	}
+
+	e.add(s.baseRDN.attr, s.baseRDN.value)
+
+	return e
+}
+
+// ouEntry returns entry of organizational unit
+func (s *Server) ouEntry(ou string) *entry {
+	e := &entry{dn: "ou=" + ou + "," + s.baseDN}
+
+	e.add("objectClass", "top", "organizationalUnit")
+	e.add("ou", ou)
+
+	return e
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// add adds non-empty values to entry attribute
+func (e *entry) add(name string, values ...string) {
+	var attr *attribute
+
+	for _, a := range e.attrs {
+		if a.name == name {
+			attr = a
+			break
+		}
+	}
+
+	if attr == nil {
+		attr = &attribute{name: name}
+		e.attrs = append(e.attrs, attr)
+	}
+
+	for _, value := range values {
+		if value != "" {
+			attr.values = append(attr.values, value)
+		}
+	}
+}
+
+// get returns values of attribute with given name
+func (e *entry) get(name string) []string {
+	for _, a := range e.attrs {
+		if strings.EqualFold(a.name, name) {
+			return a.values
+		}
+	}
+
+	return nil
+}
+
+// hasClass returns true if schema contains given object class
+func (s *schema) hasClass(class string) bool {
+	for _, c := range s.classes {
+		if strings.EqualFold(c, class) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasAttr returns true if schema contains given attribute
+func (s *schema) hasAttr(attr string) bool {
+	for _, a := range s.attrs {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// paginate calls function for all pages until it returns incomplete page
+func paginate(fn func(opts crowd.ListingOptions) (int, error)) error {
+	for start := 0; ; start += pageSize {
+		n, err := fn(crowd.ListingOptions{StartIndex: start, MaxResults: pageSize})
+
+		if err != nil || n < pageSize {
+			return err
+		}
+	}
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,736 @@
+package ldap
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type LDAPSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// testClient is minimal LDAP client
+type testClient struct {
+	nc net.Conn
+	r  *bufio.Reader
+	id int64
+}
+
+// testEntry is search result entry
+type testEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&LDAPSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *LDAPSuite) TestBER(c *C) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40, -(1 << 40)} {
+		p, rest, err := decodePacket(newInteger(classUniversal, tagInteger, v).encode(), 0)
+		c.Assert(err, IsNil)
+		c.Assert(rest, HasLen, 0)
+
+		i, err := p.int()
+		c.Assert(err, IsNil)
+		c.Assert(i, Equals, v)
+	}
+
+	c.Assert(newInteger(classUniversal, tagInteger, 128).data, DeepEquals, []byte{0x00, 0x80})
+	c.Assert(newInteger(classUniversal, tagInteger, -129).data, DeepEquals, []byte{0xFF, 0x7F})
+
+	for _, size := range []int{0, 127, 128, 300, 70000} {
+		value := string(bytes.Repeat([]byte{'a'}, size))
+		data := newSequence(classApplication, 3,
+			newString(classContext, 0, value), newBoolean(classUniversal, tagBoolean, true),
+		).encode()
+
+		p, err := readPacket(bufio.NewReader(bytes.NewReader(data)), maxMessageSize)
+
+		c.Assert(err, IsNil)
+		c.Assert(p.is(classApplication, 3), Equals, true)
+		c.Assert(p.constructed, Equals, true)
+		c.Assert(p.child(0).str(), Equals, value)
+		c.Assert(p.child(2), IsNil)
+
+		ok, err := p.child(1).bool()
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, true)
+	}
+
+	for _, data := range [][]byte{
+		{0x30}, {0x1F, 0x00}, {0x30, 0x80}, {0x30, 0x85, 1, 1, 1, 1, 1},
+		{0x30, 0x05, 0x04}, {0x30, 0x02, 0x04, 0x05}, {0x30, 0x03, 0x04, 0x01},
+	} {
+		_, err := readPacket(bufio.NewReader(bytes.NewReader(data)), maxMessageSize)
+		c.Assert(err, NotNil, Commentf("Data: %v", data))
+	}
+
+	_, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{0x04, 0x82, 0x10, 0x00})), 100)
+	c.Assert(err, Equals, errPacketTooLarge)
+
+	deep := newString(classUniversal, tagOctetString, "a")
+
+	for range maxPacketDepth + 1 {
+		deep = newSequence(classUniversal, tagSequence, deep)
+	}
+
+	_, err = readPacket(bufio.NewReader(bytes.NewReader(deep.encode())), maxMessageSize)
+	c.Assert(err, Equals, errMalformedPacket)
+
+	var p *packet
+
+	c.Assert(p.str(), Equals, "")
+	_, err = p.int()
+	c.Assert(err, NotNil)
+	_, err = p.bool()
+	c.Assert(err, NotNil)
+}
+
+func (s *LDAPSuite) TestDN(c *C) {
+	rdns, err := parseDN(` UID = John\2C Jr. , ou=users,dc=example ;dc=com`)
+
+	c.Assert(err, IsNil)
+	c.Assert(rdns, DeepEquals, []rdn{
+		{"UID", "John, Jr."}, {"ou", "users"}, {"dc", "example"}, {"dc", "com"},
+	})
+
+	dn, err := normalizeDN(`cn=\ Admins\ ,OU=Groups`)
+
+	c.Assert(err, IsNil)
+	c.Assert(dn, Equals, `cn=\ admins\ ,ou=groups`)
+
+	rdns, err = parseDN(`cn=` + escapeDNValue(`#a+b="c";<d>\`) + `,dc=com`)
+
+	c.Assert(err, IsNil)
+	c.Assert(rdns[0].value, Equals, `#a+b="c";<d>\`)
+	c.Assert(escapeDNValue("a\x01"), Equals, `a\01`)
+
+	rdns, err = parseDN("")
+	c.Assert(err, IsNil)
+	c.Assert(rdns, HasLen, 0)
+
+	for _, dn := range []string{"john", "=john", "cn=a+sn=b", `cn=a\`, `cn=a\4`, "cn=a,", `cn=\4x`} {
+		_, err = parseDN(dn)
+		c.Assert(err, Equals, ErrInvalidDN, Commentf("DN: %s", dn))
+	}
+
+	_, err = New(nil, "")
+	c.Assert(err, Equals, ErrInvalidDN)
+	_, err = New(nil, "dc")
+	c.Assert(err, Equals, ErrInvalidDN)
+}
+
+func (s *LDAPSuite) TestFilterCQL(c *C) {
+	cql := func(f *packet, sc *schema) string {
+		ff, err := parseFilter(f, 0)
+		c.Assert(err, IsNil)
+		return ff.cql(sc)
+	}
+
+	c.Assert(cql(fEq("uid", "john"), userSchema), Equals, `name = "john"`)
+	c.Assert(cql(fEq("objectClass", "inetOrgPerson"), userSchema), Equals, cqlAll)
+	c.Assert(cql(fEq("objectClass", "groupOfNames"), userSchema), Equals, cqlNone)
+	c.Assert(cql(fEq("cn", "John Doe"), userSchema), Equals, cqlAll)
+	c.Assert(cql(fEq("gidNumber", "1"), userSchema), Equals, cqlNone)
+	c.Assert(cql(fPresent("mail"), userSchema), Equals, cqlAll)
+	c.Assert(cql(fSub("mail", "jo", "", `"x`), userSchema), Equals, `email = "jo*"`)
+	c.Assert(cql(fSub("mail", "", "x", ""), userSchema), Equals, cqlAll)
+	c.Assert(cql(fNot(fEq("uid", "john")), userSchema), Equals, cqlAll)
+	c.Assert(cql(fExt(), userSchema), Equals, cqlNone)
+	c.Assert(cql(fGe("uid", "a"), userSchema), Equals, cqlAll)
+
+	c.Assert(
+		cql(fAnd(fEq("objectClass", "person"), fOr(fEq("uid", `a"b`), fEq("givenName", "Bob"))), userSchema),
+		Equals, `(name = "a\"b" or firstName = "Bob")`,
+	)
+	c.Assert(
+		cql(fAnd(fEq("uid", "john"), fEq("mail", "j@domain.com")), userSchema),
+		Equals, `(name = "john" and email = "j@domain.com")`,
+	)
+	c.Assert(cql(fAnd(fEq("uid", "john"), fEq("member", "x")), userSchema), Equals, cqlNone)
+	c.Assert(cql(fAnd(), userSchema), Equals, cqlAll)
+	c.Assert(cql(fOr(fEq("uid", "john"), fPresent("cn")), userSchema), Equals, cqlAll)
+	c.Assert(cql(fOr(fEq("member", "x"), fEq("objectClass", "group")), userSchema), Equals, cqlNone)
+	c.Assert(cql(fOr(fEq("uid", "john"), fEq("member", "x")), userSchema), Equals, `name = "john"`)
+	c.Assert(cql(fEq("cn", "admins"), groupSchema), Equals, `name = "admins"`)
+
+	for _, p := range []*packet{
+		nil, newString(classUniversal, 0, ""), newString(classContext, 10, ""),
+		newString(classContext, filterAnd, ""), newSequence(classContext, filterNot),
+		newSequence(classContext, filterEquality, newString(classUniversal, tagOctetString, "a")),
+		newSequence(classContext, filterSubstrings),
+		newSequence(classContext, filterAnd, newString(classContext, 10, "")),
+	} {
+		_, err := parseFilter(p, 0)
+		c.Assert(err, NotNil)
+	}
+
+	deep := fPresent("cn")
+
+	for range maxFilterDepth + 1 {
+		deep = fNot(deep)
+	}
+
+	_, err := parseFilter(deep, 0)
+	c.Assert(err, NotNil)
+}
+
+func (s *LDAPSuite) TestBind(c *C) {
+	srv, l := newTestServer(c)
+	defer srv.Close()
+	defer l.Close()
+
+	client := dial(c, l)
+	defer client.nc.Close()
+
+	c.Assert(client.bind("", ""), Equals, resultSuccess)
+	c.Assert(client.whoAmI(), Equals, "")
+	c.Assert(client.bind("uid=john,ou=users,dc=example,dc=com", "test1234"), Equals, resultSuccess)
+	c.Assert(client.whoAmI(), Equals, "dn:uid=john,ou=users,dc=example,dc=com")
+	c.Assert(client.bind("john", "wrong"), Equals, resultInvalidCredentials)
+	c.Assert(client.whoAmI(), Equals, "")
+	c.Assert(client.bind("UID=bob, OU=Users, DC=Example, DC=Com", "test1234"), Equals, resultSuccess)
+	c.Assert(client.bind("bob", "test1234"), Equals, resultSuccess)
+	c.Assert(client.bind("mary", "test1234"), Equals, resultInvalidCredentials)
+	c.Assert(client.bind("cn=bob,dc=example,dc=com", "test1234"), Equals, resultInvalidCredentials)
+	c.Assert(client.bind("uid=bob", "test1234"), Equals, resultInvalidCredentials)
+	c.Assert(client.bind("bob", ""), Equals, resultUnwillingToPerform)
+
+	code, _ := client.request(newSequence(classApplication, appBindRequest,
+		newInteger(classUniversal, tagInteger, 2),
+		newString(classUniversal, tagOctetString, "bob"),
+		newString(classContext, 0, "test1234"),
+	))
+	c.Assert(code, Equals, resultProtocolError)
+
+	code, _ = client.request(newSequence(classApplication, appBindRequest,
+		newInteger(classUniversal, tagInteger, 3),
+		newString(classUniversal, tagOctetString, ""),
+		newSequence(classContext, 3, newString(classUniversal, tagOctetString, "EXTERNAL")),
+	))
+	c.Assert(code, Equals, resultAuthMethodNotSupported)
+
+	code, _ = client.request(newSequence(classApplication, appBindRequest,
+		newInteger(classUniversal, tagInteger, 3),
+		newString(classUniversal, tagOctetString, "bob"),
+		newString(classContext, 1, "test1234"),
+	))
+	c.Assert(code, Equals, resultProtocolError)
+
+	code, _ = client.request(newSequence(classApplication, appExtendedRequest,
+		newString(classContext, 0, "1.3.6.1.4.1.1466.20037"),
+	))
+	c.Assert(code, Equals, resultProtocolError)
+
+	for _, tag := range []byte{appModifyRequest, appAddRequest, appDelRequest, appModDNRequest, appCompareRequest} {
+		code, _ = client.request(newString(classApplication, tag, "uid=bob,ou=users,dc=example,dc=com"))
+		c.Assert(code, Equals, resultUnwillingToPerform)
+	}
+
+	// Abandon request has no response
+	client.send(newInteger(classApplication, appAbandonRequest, 1))
+	c.Assert(client.bind("", ""), Equals, resultSuccess)
+
+	client.send(newSequence(classApplication, appUnbindRequest))
+	_, err := client.r.ReadByte()
+	c.Assert(err, NotNil)
+}
+
+func (s *LDAPSuite) TestSearch(c *C) {
+	srv, l := newTestServer(c)
+	defer srv.Close()
+	defer l.Close()
+
+	client := dial(c, l)
+	defer client.nc.Close()
+
+	entries, code := client.search("", scopeBase, fPresent("objectClass"))
+
+	c.Assert(code, Equals, resultSuccess)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].dn, Equals, "")
+	c.Assert(entries[0].attrs["namingContexts"], DeepEquals, []string{"dc=example,dc=com"})
+
+	_, code = client.search("dc=example,dc=com", scopeSubtree, fPresent("objectClass"))
+	c.Assert(code, Equals, resultInsufficientAccessRight)
+
+	c.Assert(client.bind("john", "test1234"), Equals, resultSuccess)
+
+	entries, code = client.search("dc=example,dc=com", scopeSubtree, fPresent("objectClass"))
+
+	c.Assert(code, Equals, resultSuccess)
+	c.Assert(dns(entries), DeepEquals, []string{
+		"cn=admins,ou=groups,dc=example,dc=com",
+		"cn=ops,ou=groups,dc=example,dc=com",
+		"dc=example,dc=com",
+		"ou=groups,dc=example,dc=com",
+		"ou=users,dc=example,dc=com",
+		"uid=bob,ou=users,dc=example,dc=com",
+		"uid=john,ou=users,dc=example,dc=com",
+	})
+
+	entries, _ = client.search("dc=example,dc=com", scopeBase, fPresent("objectClass"))
+
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].attrs, DeepEquals, map[string][]string{
+		"objectClass": {"top", "domain"}, "dc": {"example"},
+	})
+
+	entries, _ = client.search("dc=example,dc=com", scopeOne, fPresent("objectClass"))
+	c.Assert(dns(entries), DeepEquals, []string{"ou=groups,dc=example,dc=com", "ou=users,dc=example,dc=com"})
+
+	entries, _ = client.search("DC=Example,DC=Com", scopeSubordinate, fEq("objectClass", "organizationalUnit"))
+	c.Assert(entries, HasLen, 2)
+
+	// User entries
+	entries, code = client.search(
+		"ou=users,dc=example,dc=com", scopeOne,
+		fAnd(fEq("objectClass", "inetOrgPerson"), fEq("uid", "JOHN")),
+		"*", "memberOf",
+	)
+
+	c.Assert(code, Equals, resultSuccess)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].dn, Equals, "uid=john,ou=users,dc=example,dc=com")
+	c.Assert(entries[0].attrs, DeepEquals, map[string][]string{
+		"objectClass": {"top", "person", "organizationalPerson", "inetOrgPerson"},
+		"uid":         {"john"},
+		"cn":          {"John Doe"},
+		"sn":          {"Doe"},
+		"givenName":   {"John"},
+		"displayName": {"John Doe"},
+		"mail":        {"john@domain.com"},
+		"memberOf":    {"cn=admins,ou=groups,dc=example,dc=com"},
+	})
+
+	entries, _ = client.search("ou=users,dc=example,dc=com", scopeSubtree, fEq("uid", "bob"))
+
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].attrs["cn"], DeepEquals, []string{"bob"})
+	c.Assert(entries[0].attrs["sn"], DeepEquals, []string{"bob"})
+	c.Assert(entries[0].attrs["memberOf"], IsNil)
+
+	entries, _ = client.search("ou=users,dc=example,dc=com", scopeSubtree, fSub("cn", "john", "", "doe"), "cn", "+")
+
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].attrs, DeepEquals, map[string][]string{
+		"cn": {"John Doe"}, "memberOf": {"cn=admins,ou=groups,dc=example,dc=com"},
+	})
+
+	// Nested membership is resolved using group members listing
+	srv.ResetRequests()
+	entries, _ = client.search(
+		"dc=example,dc=com", scopeSubtree,
+		fAnd(fEq("objectClass", "person"), fEq("memberOf", "CN=admins, OU=Groups, DC=Example, DC=Com")),
+		"1.1",
+	)
+
+	c.Assert(dns(entries), DeepEquals, []string{
+		"uid=bob,ou=users,dc=example,dc=com", "uid=john,ou=users,dc=example,dc=com",
+	})
+	c.Assert(entries[0].attrs, HasLen, 0)
+	c.Assert(srv.Requests()[0], Equals, "GET group/user/nested")
+
+	entries, _ = client.search("ou=users,dc=example,dc=com", scopeOne, fEq("memberOf", "cn=ops,ou=users,dc=example,dc=com"))
+	c.Assert(entries, HasLen, 0)
+	entries, _ = client.search("ou=users,dc=example,dc=com", scopeOne, fEq("memberOf", "cn=unknown,ou=groups,dc=example,dc=com"))
+	c.Assert(entries, HasLen, 0)
+
+	entries, _ = client.search("ou=users,dc=example,dc=com", scopeOne, fNot(fEq("memberOf", "cn=ops,ou=groups,dc=example,dc=com")))
+	c.Assert(dns(entries), DeepEquals, []string{"uid=john,ou=users,dc=example,dc=com"})
+
+	// Group entries
+	entries, _ = client.search("ou=groups,dc=example,dc=com", scopeOne, fEq("cn", "admins"))
+
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].attrs, DeepEquals, map[string][]string{
+		"objectClass": {"top", "groupOfNames"},
+		"cn":          {"admins"},
+		"description": {"Administrators"},
+		"member": {
+			"uid=john,ou=users,dc=example,dc=com",
+			"cn=ops,ou=groups,dc=example,dc=com",
+		},
+	})
+
+	entries, _ = client.search("ou=groups,dc=example,dc=com", scopeOne, fEq("member", "uid=bob,ou=users,dc=example,dc=com"), "cn")
+
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].attrs, DeepEquals, map[string][]string{"cn": {"ops"}})
+
+	entries, _ = client.search("ou=groups,dc=example,dc=com", scopeOne, fEq("member", "cn=ops,ou=groups,dc=example,dc=com"), "cn")
+	c.Assert(dns(entries), DeepEquals, []string{"cn=admins,ou=groups,dc=example,dc=com"})
+	entries, _ = client.search("ou=groups,dc=example,dc=com", scopeOne, fEq("member", "uid=unknown,ou=users,dc=example,dc=com"))
+	c.Assert(entries, HasLen, 0)
+
+	// Base searches
+	entries, code = client.search("uid=john,ou=users,dc=example,dc=com", scopeBase, fPresent("objectClass"), "mail")
+
+	c.Assert(code, Equals, resultSuccess)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].attrs, DeepEquals, map[string][]string{"mail": {"john@domain.com"}})
+
+	entries, code = client.search("uid=john,ou=users,dc=example,dc=com", scopeOne, fPresent("objectClass"))
+	c.Assert(code, Equals, resultSuccess)
+	c.Assert(entries, HasLen, 0)
+
+	entries, code = client.search("cn=ops,ou=groups,dc=example,dc=com", scopeSubtree, fPresent("objectClass"), "cn")
+	c.Assert(code, Equals, resultSuccess)
+	c.Assert(entries, HasLen, 1)
+
+	entries, code = client.search("ou=groups,dc=example,dc=com", scopeBase, fPresent("objectClass"))
+	c.Assert(code, Equals, resultSuccess)
+	c.Assert(entries[0].attrs["ou"], DeepEquals, []string{"groups"})
+
+	for _, dn := range []string{
+		"uid=mary,ou=users,dc=example,dc=com", "uid=unknown,ou=users,dc=example,dc=com",
+		"cn=unknown,ou=groups,dc=example,dc=com", "cn=old,ou=groups,dc=example,dc=com",
+		"ou=people,dc=example,dc=com", "dc=domain,dc=com",
+	} {
+		_, code = client.search(dn, scopeBase, fPresent("objectClass"))
+		c.Assert(code, Equals, resultNoSuchObject, Commentf("DN: %s", dn))
+	}
+
+	_, code = client.search("", scopeSubtree, fPresent("objectClass"))
+	c.Assert(code, Equals, resultNoSuchObject)
+	_, code = client.search("dc", scopeSubtree, fPresent("objectClass"))
+	c.Assert(code, Equals, resultInvalidDNSyntax)
+	_, code = client.search("dc=example,dc=com", 5, fPresent("objectClass"))
+	c.Assert(code, Equals, resultProtocolError)
+
+	// Types only
+	entries, _ = client.searchRaw("ou=users,dc=example,dc=com", scopeOne, 0, true, fEq("uid", "john"), "uid")
+
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].attrs, DeepEquals, map[string][]string{"uid": nil})
+
+	// Size limits
+	entries, code = client.searchRaw("dc=example,dc=com", scopeSubtree, 2, false, fPresent("objectClass"))
+	c.Assert(code, Equals, resultSizeLimitExceeded)
+	c.Assert(entries, HasLen, 2)
+
+	code, _ = client.request(newSequence(classApplication, appSearchRequest))
+	c.Assert(code, Equals, resultProtocolError)
+}
+
+func (s *LDAPSuite) TestOptions(c *C) {
+	srv, l := newTestServer(c)
+	defer srv.Close()
+	defer l.Close()
+
+	server := l.srv
+	server.AllowAnonymous = true
+	server.IncludeInactive = true
+	server.SizeLimit = 3
+
+	client := dial(c, l)
+	defer client.nc.Close()
+
+	entries, code := client.search("ou=users,dc=example,dc=com", scopeOne, fPresent("objectClass"), "uid")
+	c.Assert(code, Equals, resultSuccess)
+	c.Assert(entries, HasLen, 3)
+
+	entries, code = client.search("dc=example,dc=com", scopeSubtree, fPresent("objectClass"))
+	c.Assert(code, Equals, resultSizeLimitExceeded)
+	c.Assert(entries, HasLen, 3)
+
+	entries, _ = client.search("ou=groups,dc=example,dc=com", scopeOne, fEq("cn", "old"))
+	c.Assert(entries, HasLen, 1)
+	entries, _ = client.search("uid=mary,ou=users,dc=example,dc=com", scopeBase, fPresent("objectClass"))
+	c.Assert(entries, HasLen, 1)
+
+	server.Close()
+
+	c.Assert(<-l.done, Equals, ErrServerClosed)
+	c.Assert(server.Serve(l.Listener), Equals, ErrServerClosed)
+
+	_, err := client.r.ReadByte()
+	c.Assert(err, NotNil)
+}
+
+func (s *LDAPSuite) TestErrors(c *C) {
+	api, _ := crowd.NewAPI("http://127.0.0.1:1/", "app", "test")
+	server, _ := New(api, "dc=example,dc=com")
+	server.AllowAnonymous = true
+
+	var logBuf bytes.Buffer
+
+	server.Logger = slog.New(slog.NewTextHandler(&logBuf, nil))
+
+	l := serve(c, server)
+	defer server.Close()
+
+	client := dial(c, l)
+	defer client.nc.Close()
+
+	// Error details are logged, but not sent to clients
+	code, resp := client.request(newSequence(classApplication, appBindRequest,
+		newInteger(classUniversal, tagInteger, 3),
+		newString(classUniversal, tagOctetString, "john"),
+		newString(classContext, 0, "test1234"),
+	))
+
+	c.Assert(code, Equals, resultUnavailable)
+	c.Assert(resp.child(2).str(), Equals, msgUnavailable)
+	c.Assert(logBuf.String(), Matches, `(?s).*Bind request to Crowd failed.*127\.0\.0\.1:1.*`)
+
+	for _, dn := range []string{"ou=users,dc=example,dc=com", "ou=groups,dc=example,dc=com"} {
+		_, code := client.search(dn, scopeOne, fPresent("objectClass"))
+		c.Assert(code, Equals, resultUnavailable)
+	}
+
+	_, code = client.search("uid=john,ou=users,dc=example,dc=com", scopeBase, fPresent("objectClass"))
+	c.Assert(code, Equals, resultUnavailable)
+
+	// Malformed message closes connection
+	client.nc.Write([]byte{0x04, 0x00})
+	_, err := client.r.ReadByte()
+	c.Assert(err, NotNil)
+
+	c.Assert(server.ListenAndServe("256.0.0.1:1"), NotNil)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// testListener is listener served by LDAP server
+type testListener struct {
+	net.Listener
+	srv  *Server
+	done chan error
+}
+
+func newTestServer(c *C) (*crowdtest.Server, *testListener) {
+	srv := crowdtest.NewServer()
+
+	srv.AddUser(&crowdtest.User{
+		Name: "john", FirstName: "John", LastName: "Doe", DisplayName: "John Doe",
+		Email: "john@domain.com", Password: "test1234", IsActive: true,
+	})
+	srv.AddUser(&crowdtest.User{Name: "bob", Password: "test1234", IsActive: true})
+	srv.AddUser(&crowdtest.User{Name: "mary", Password: "test1234"})
+
+	srv.AddGroup(&crowdtest.Group{
+		Name: "admins", Description: "Administrators", IsActive: true,
+		Users: []string{"john"}, Groups: []string{"ops"},
+	})
+	srv.AddGroup(&crowdtest.Group{Name: "ops", IsActive: true, Users: []string{"bob"}})
+	srv.AddGroup(&crowdtest.Group{Name: "old", Users: []string{"bob"}})
+
+	api, _ := crowd.NewAPI(srv.URL(), "app", "test")
+	server, err := New(api, "DC=example, DC=com")
+
+	c.Assert(err, IsNil)
+
+	return srv, serve(c, server)
+}
+
+func serve(c *C, server *Server) *testListener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	tl := &testListener{Listener: l, srv: server, done: make(chan error, 1)}
+
+	go func() { tl.done <- server.Serve(l) }()
+
+	return tl
+}
+
+func dial(c *C, l *testListener) *testClient {
+	nc, err := net.DialTimeout("tcp", l.Addr().String(), time.Second)
+	c.Assert(err, IsNil)
+
+	nc.SetDeadline(time.Now().Add(10 * time.Second))
+
+	return &testClient{nc: nc, r: bufio.NewReader(nc)}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (c *testClient) send(op *packet) {
+	c.id++
+	c.nc.Write(newSequence(classUniversal, tagSequence,
+		newInteger(classUniversal, tagInteger, c.id), op,
+	).encode())
+}
+
+func (c *testClient) read() *packet {
+	msg, err := readPacket(c.r, maxMessageSize)
+
+	if err != nil {
+		return nil
+	}
+
+	return msg.child(1)
+}
+
+func (c *testClient) request(op *packet) (int, *packet) {
+	c.send(op)
+
+	resp := c.read()
+
+	if resp == nil {
+		return -1, nil
+	}
+
+	code, _ := resp.child(0).int()
+
+	return int(code), resp
+}
+
+func (c *testClient) bind(dn, password string) int {
+	code, _ := c.request(newSequence(classApplication, appBindRequest,
+		newInteger(classUniversal, tagInteger, 3),
+		newString(classUniversal, tagOctetString, dn),
+		newString(classContext, 0, password),
+	))
+
+	return code
+}
+
+func (c *testClient) whoAmI() string {
+	_, resp := c.request(newSequence(classApplication, appExtendedRequest,
+		newString(classContext, 0, OID_WHO_AM_I),
+	))
+
+	return resp.child(3).str()
+}
+
+func (c *testClient) search(base string, scope int, f *packet, attrs ...string) ([]*testEntry, int) {
+	return c.searchRaw(base, scope, 0, false, f, attrs...)
+}
+
+func (c *testClient) searchRaw(base string, scope, sizeLimit int, typesOnly bool, f *packet, attrs ...string) ([]*testEntry, int) {
+	attrList := newSequence(classUniversal, tagSequence)
+
+	for _, attr := range attrs {
+		attrList.children = append(attrList.children, newString(classUniversal, tagOctetString, attr))
+	}
+
+	c.send(newSequence(classApplication, appSearchRequest,
+		newString(classUniversal, tagOctetString, base),
+		newInteger(classUniversal, tagEnumerated, int64(scope)),
+		newInteger(classUniversal, tagEnumerated, 0),
+		newInteger(classUniversal, tagInteger, int64(sizeLimit)),
+		newInteger(classUniversal, tagInteger, 10),
+		newBoolean(classUniversal, tagBoolean, typesOnly),
+		f, attrList,
+	))
+
+	var entries []*testEntry
+
+	for {
+		resp := c.read()
+
+		if resp == nil {
+			return entries, -1
+		}
+
+		if resp.is(classApplication, appSearchDone) {
+			code, _ := resp.child(0).int()
+			return entries, int(code)
+		}
+
+		e := &testEntry{dn: resp.child(0).str(), attrs: map[string][]string{}}
+
+		for _, attr := range resp.child(1).children {
+			var values []string
+
+			for _, v := range attr.child(1).children {
+				values = append(values, v.str())
+			}
+
+			e.attrs[attr.child(0).str()] = values
+		}
+
+		entries = append(entries, e)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func dns(entries []*testEntry) []string {
+	var result []string
+
+	for _, e := range entries {
+		result = append(result, e.dn)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func fAnd(filters ...*packet) *packet {
+	return newSequence(classContext, filterAnd, filters...)
+}
+
+func fOr(filters ...*packet) *packet {
+	return newSequence(classContext, filterOr, filters...)
+}
+
+func fNot(f *packet) *packet {
+	return newSequence(classContext, filterNot, f)
+}
+
+func fEq(attr, value string) *packet {
+	return newSequence(classContext, filterEquality,
+		newString(classUniversal, tagOctetString, attr),
+		newString(classUniversal, tagOctetString, value),
+	)
+}
+
+func fGe(attr, value string) *packet {
+	return newSequence(classContext, filterGreaterOrEqual,
+		newString(classUniversal, tagOctetString, attr),
+		newString(classUniversal, tagOctetString, value),
+	)
+}
+
+func fPresent(attr string) *packet {
+	return newString(classContext, filterPresent, attr)
+}
+
+func fSub(attr, initial, any, final string) *packet {
+	subs := newSequence(classUniversal, tagSequence)
+
+	for i, s := range []string{initial, any, final} {
+		if s != "" {
+			subs.children = append(subs.children, newString(classContext, byte(i), s))
+		}
+	}
+
+	return newSequence(classContext, filterSubstrings,
+		newString(classUniversal, tagOctetString, attr), subs,
+	)
+}
+
+func fExt() *packet {
+	return newSequence(classContext, filterExtensible,
+		newString(classContext, 2, "cn"), newString(classContext, 3, "x"),
+	)
+}
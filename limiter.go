@@ -0,0 +1,104 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// RateLimiter is token bucket rate limiter
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64 // bucket size
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewRateLimiter creates new rate limiter with given number of requests per second
+// and burst size
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+
+	burst = max(burst, 1)
+
+	return &RateLimiter{
+		rate:   rps,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Wait blocks until request is allowed or context is canceled
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+
+	now := time.Now()
+
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	l.last = now
+	l.tokens--
+
+	var delay time.Duration
+
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	err := sleep(ctx, delay)
+
+	if err != nil {
+		// Return reserved token back to bucket
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+	}
+
+	return err
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// sleep pauses current goroutine for given duration or until context is canceled
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,278 @@
+// Package metrics provides Prometheus-compatible metrics for Crowd API client
+package metrics
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/essentialkaos/go-crowd/v3"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// authEndpoint is authentication endpoint used by Login method
+const authEndpoint = "rest/usermanagement/1/authentication"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Metrics contains metrics for Crowd API client
+type Metrics struct {
+	buckets []float64
+
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[durationKey]*histogram
+	retries   map[string]uint64
+	cache     map[cacheKey]uint64
+	auth      map[string]uint64
+	inFlight  atomic.Int64
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type requestKey struct {
+	endpoint string
+	method   string
+	code     string
+}
+
+type durationKey struct {
+	endpoint string
+	method   string
+}
+
+type cacheKey struct {
+	cache  string
+	result string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DefaultBuckets is default histogram buckets for request latency (in seconds)
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// New creates new metrics collector. If buckets are not set, DefaultBuckets
+// is used.
+func New(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	return &Metrics{
+		buckets:   buckets,
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[durationKey]*histogram),
+		retries:   make(map[string]uint64),
+		cache:     make(map[cacheKey]uint64),
+		auth:      make(map[string]uint64),
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Instrument adds metrics interceptor to given API instance
+func (m *Metrics) Instrument(api *crowd.API) {
+	api.Interceptors = append([]crowd.Interceptor{m.Interceptor}, api.Interceptors...)
+}
+
+// Interceptor is API interceptor which collects metrics
+func (m *Metrics) Interceptor(call *crowd.Call, next crowd.Invoker) error {
+	m.inFlight.Add(1)
+	err := next(call)
+	m.inFlight.Add(-1)
+
+	endpoint := call.Endpoint()
+	code := strconv.Itoa(call.StatusCode)
+
+	if err != nil {
+		code = "error"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{endpoint, call.Method, code}]++
+
+	h := m.durations[durationKey{endpoint, call.Method}]
+
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[durationKey{endpoint, call.Method}] = h
+	}
+
+	h.observe(m.buckets, call.Latency.Seconds())
+
+	if call.Attempts > 1 {
+		m.retries[endpoint] += uint64(call.Attempts - 1)
+	}
+
+	if endpoint == authEndpoint && err == nil {
+		switch {
+		case call.StatusCode == 200:
+			m.auth["success"]++
+		case call.StatusCode < 500:
+			m.auth["failure"]++
+		}
+	}
+
+	return err
+}
+
+// ObserveCache registers hit or miss of cache with given name
+func (m *Metrics) ObserveCache(cache string, hit bool) {
+	result := "miss"
+
+	if hit {
+		result = "hit"
+	}
+
+	m.mu.Lock()
+	m.cache[cacheKey{cache, result}]++
+	m.mu.Unlock()
+}
+
+// CacheObserver returns function which registers hits and misses of cache with
+// given name. It can be used as OnCacheLookup hook of sso, basicauth and
+// tokenreview packages.
+func (m *Metrics) CacheObserver(cache string) func(hit bool) {
+	return func(hit bool) {
+		m.ObserveCache(cache, hit)
+	}
+}
+
+// ServeHTTP writes metrics in Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes metrics in Prometheus text exposition format to given writer
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	m.mu.Lock()
+
+	writeHeader(&buf, "crowd_client_requests_total", "counter", "Total number of requests to Crowd")
+
+	for _, k := range sortedKeys(m.requests) {
+		fmt.Fprintf(&buf, "crowd_client_requests_total{endpoint=%s,method=%s,code=%s} %d\n",
+			quote(k.endpoint), quote(k.method), quote(k.code), m.requests[k],
+		)
+	}
+
+	writeHeader(&buf, "crowd_client_request_duration_seconds", "histogram", "Latency of requests to Crowd")
+
+	for _, k := range sortedKeys(m.durations) {
+		m.durations[k].write(&buf, m.buckets,
+			"crowd_client_request_duration_seconds",
+			"endpoint="+quote(k.endpoint)+",method="+quote(k.method),
+		)
+	}
+
+	writeHeader(&buf, "crowd_client_requests_in_flight", "gauge", "Number of requests to Crowd in progress")
+	fmt.Fprintf(&buf, "crowd_client_requests_in_flight %d\n", m.inFlight.Load())
+
+	writeHeader(&buf, "crowd_client_retries_total", "counter", "Total number of retried requests to Crowd")
+
+	for _, k := range sortedKeys(m.retries) {
+		fmt.Fprintf(&buf, "crowd_client_retries_total{endpoint=%s} %d\n", quote(k), m.retries[k])
+	}
+
+	writeHeader(&buf, "crowd_client_cache_requests_total", "counter", "Total number of cache lookups")
+
+	for _, k := range sortedKeys(m.cache) {
+		fmt.Fprintf(&buf, "crowd_client_cache_requests_total{cache=%s,result=%s} %d\n",
+			quote(k.cache), quote(k.result), m.cache[k],
+		)
+	}
+
+	writeHeader(&buf, "crowd_client_authentications_total", "counter", "Total number of authentication attempts")
+
+	for _, k := range sortedKeys(m.auth) {
+		fmt.Fprintf(&buf, "crowd_client_authentications_total{result=%s} %d\n", quote(k), m.auth[k])
+	}
+
+	m.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// observe adds new value to histogram
+func (h *histogram) observe(buckets []float64, value float64) {
+	for i, bound := range buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += value
+	h.count++
+}
+
+// write writes histogram data to buffer
+func (h *histogram) write(buf *bytes.Buffer, buckets []float64, name, labels string) {
+	for i, bound := range buckets {
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n",
+			name, labels, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i],
+		)
+	}
+
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// writeHeader writes metric help and type info
+func writeHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// quote quotes and escapes label value
+func quote(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
+
+// sortedKeys returns map keys sorted by string representation
+func sortedKeys[K comparable, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.SortFunc(keys, func(a, b K) int {
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	})
+
+	return keys
+}
@@ -0,0 +1,107 @@
+package metrics
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MetricsSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&MetricsSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *MetricsSuite) TestMetrics(c *C) {
+	var hits int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+
+		switch {
+		case r.URL.Query().Get("username") == "bad":
+			w.WriteHeader(400)
+		case hits == 1:
+			w.WriteHeader(503)
+		default:
+			w.Write([]byte(`<user name="john"><active>true</active></user>`))
+		}
+	}))
+
+	defer srv.Close()
+
+	api, _ := crowd.NewAPI(srv.URL+"/", "app", "test")
+	api.RetryPolicy = &crowd.RetryPolicy{MaxAttempts: 2, RetryNonIdempotent: true}
+
+	m := New(0.5, 0.1)
+	m.Instrument(api)
+
+	c.Assert(api.Interceptors, HasLen, 1)
+
+	_, err := api.GetUser("john", false)
+	c.Assert(err, IsNil)
+	_, err = api.Login("john", "test")
+	c.Assert(err, IsNil)
+	_, err = api.Login("bad", "test")
+	c.Assert(err, NotNil)
+
+	observer := m.CacheObserver("sso")
+	observer(true)
+	observer(false)
+	m.ObserveCache("sso", false)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	c.Assert(rec.Header().Get("Content-Type"), Matches, "text/plain; version=0.0.4.*")
+
+	data := rec.Body.String()
+
+	for _, line := range []string{
+		`crowd_client_requests_total{endpoint="rest/usermanagement/1/user",method="GET",code="200"} 1`,
+		`crowd_client_requests_total{endpoint="rest/usermanagement/1/authentication",method="POST",code="200"} 1`,
+		`crowd_client_requests_total{endpoint="rest/usermanagement/1/authentication",method="POST",code="400"} 1`,
+		`crowd_client_request_duration_seconds_bucket{endpoint="rest/usermanagement/1/user",method="GET",le="0.1"} 1`,
+		`crowd_client_request_duration_seconds_bucket{endpoint="rest/usermanagement/1/user",method="GET",le="+Inf"} 1`,
+		`crowd_client_request_duration_seconds_count{endpoint="rest/usermanagement/1/authentication",method="POST"} 2`,
+		`crowd_client_requests_in_flight 0`,
+		`crowd_client_retries_total{endpoint="rest/usermanagement/1/user"} 1`,
+		`crowd_client_cache_requests_total{cache="sso",result="hit"} 1`,
+		`crowd_client_cache_requests_total{cache="sso",result="miss"} 2`,
+		`crowd_client_authentications_total{result="failure"} 1`,
+		`crowd_client_authentications_total{result="success"} 1`,
+		`# TYPE crowd_client_request_duration_seconds histogram`,
+	} {
+		c.Assert(data, Matches, "(?s).*"+regexp.QuoteMeta(line)+"\n.*")
+	}
+}
+
+func (s *MetricsSuite) TestHelpers(c *C) {
+	c.Assert(quote("a\"b\\c\nd"), Equals, `"a\"b\\c\nd"`)
+
+	h := &histogram{counts: make([]uint64, 2)}
+	h.observe([]float64{0.1, 1}, (50 * time.Millisecond).Seconds())
+	h.observe([]float64{0.1, 1}, 5)
+
+	c.Assert(h.counts, DeepEquals, []uint64{1, 1})
+	c.Assert(h.count, Equals, uint64(2))
+}
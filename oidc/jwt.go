@@ -0,0 +1,179 @@
+package oidc
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Token types
+const (
+	typeIDToken     = "JWT"
+	typeAccessToken = "at+jwt"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// jwtHeader is JWS header
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// ecdsaSignature is ASN.1 structure of ECDSA signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// errInvalidJWT is returned if token is malformed or has invalid signature
+var errInvalidJWT = errors.New("Invalid token")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// signJWT creates signed token with given claims
+func signJWT(key *Key, typ string, claims map[string]any) (string, error) {
+	header, _ := json.Marshal(&jwtHeader{Alg: key.Algorithm(), Kid: key.ID, Typ: typ})
+	payload, err := json.Marshal(claims)
+
+	if err != nil {
+		return "", err
+	}
+
+	input := encodeSegment(header) + "." + encodeSegment(payload)
+	signature, err := key.sign([]byte(input))
+
+	if err != nil {
+		return "", err
+	}
+
+	return input + "." + encodeSegment(signature), nil
+}
+
+// verifyJWT verifies token signature and type and returns token claims.
+// Claims (expiration, issuer, etc.) must be validated by caller.
+func verifyJWT(token, typ string, keys []*Key) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, errInvalidJWT
+	}
+
+	header := &jwtHeader{}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil || json.Unmarshal(data, header) != nil || !strings.EqualFold(header.Typ, typ) {
+		return nil, errInvalidJWT
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, errInvalidJWT
+	}
+
+	var key *Key
+
+	for _, k := range keys {
+		if k.ID == header.Kid && k.Algorithm() == header.Alg {
+			key = k
+			break
+		}
+	}
+
+	if key == nil || !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, errInvalidJWT
+	}
+
+	claims := map[string]any{}
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil || json.Unmarshal(data, &claims) != nil {
+		return nil, errInvalidJWT
+	}
+
+	return claims, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// sign signs data
+func (k *Key) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	switch k.Algorithm() {
+	case ALG_RS256:
+		return k.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+
+	case ALG_ES256:
+		der, err := k.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sig := &ecdsaSignature{}
+		_, err = asn1.Unmarshal(der, sig)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// JWS uses fixed-size R || S encoding
+		result := make([]byte, 64)
+		sig.R.FillBytes(result[:32])
+		sig.S.FillBytes(result[32:])
+
+		return result, nil
+
+	case ALG_EDDSA:
+		return k.Signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+// verify verifies signature of data
+func (k *Key) verify(data, signature []byte) bool {
+	digest := sha256.Sum256(data)
+
+	switch pub := k.Signer.Public().(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+
+		return ecdsa.Verify(pub, digest[:], r, s)
+
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, data, signature)
+	}
+
+	return false
+}
@@ -0,0 +1,257 @@
+package oidc
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Signing algorithms
+const (
+	ALG_RS256 = "RS256"
+	ALG_ES256 = "ES256"
+	ALG_EDDSA = "EdDSA"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// KeyStore provides keys for signing and verifying tokens
+type KeyStore interface {
+	// SigningKey returns key used for signing new tokens
+	SigningKey() (*Key, error)
+
+	// VerificationKeys returns keys published in JWKS. It must contain signing
+	// key and may contain previous keys which were used for issued tokens.
+	VerificationKeys() ([]*Key, error)
+}
+
+// Key is signing key. Signer can be any crypto.Signer (i.e. backed by HSM)
+// with RSA, ECDSA P-256 or Ed25519 public key.
+type Key struct {
+	ID     string
+	Signer crypto.Signer
+}
+
+// StaticKeyStore is key store with fixed set of keys. First key is used for
+// signing.
+type StaticKeyStore struct {
+	keys []*Key
+}
+
+// JWK is JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Key errors
+var (
+	ErrUnsupportedKey = errors.New("Unsupported key type")
+	ErrNoKeys         = errors.New("Key store doesn't contain any keys")
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewKey creates new key. Key ID is JWK thumbprint (RFC 7638) of public key.
+func NewKey(signer crypto.Signer) (*Key, error) {
+	key := &Key{Signer: signer}
+
+	if key.Algorithm() == "" {
+		return nil, ErrUnsupportedKey
+	}
+
+	key.ID = key.thumbprint()
+
+	return key, nil
+}
+
+// ReadKey reads PEM-encoded private key (PKCS #8, PKCS #1 or SEC 1) from file
+func ReadKey(file string) (*Key, error) {
+	fd, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return DecodeKey(fd)
+}
+
+// DecodeKey decodes PEM-encoded private key (PKCS #8, PKCS #1 or SEC 1)
+func DecodeKey(r io.Reader) (*Key, error) {
+	data, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, errors.New("Can't decode key: no PEM data found")
+	}
+
+	var privateKey any
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, errors.New("Can't decode key: " + err.Error())
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	return NewKey(signer)
+}
+
+// NewStaticKeyStore creates new key store with given keys. First key is used
+// for signing.
+func NewStaticKeyStore(keys ...*Key) *StaticKeyStore {
+	return &StaticKeyStore{keys: keys}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// SigningKey returns first key
+func (s *StaticKeyStore) SigningKey() (*Key, error) {
+	if len(s.keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	return s.keys[0], nil
+}
+
+// VerificationKeys returns all keys
+func (s *StaticKeyStore) VerificationKeys() ([]*Key, error) {
+	if len(s.keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	return s.keys, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Algorithm returns JWS algorithm of key or empty string if key isn't supported
+func (k *Key) Algorithm() string {
+	if k == nil || k.Signer == nil {
+		return ""
+	}
+
+	switch pub := k.Signer.Public().(type) {
+	case *rsa.PublicKey:
+		return ALG_RS256
+	case *ecdsa.PublicKey:
+		if pub.Curve == elliptic.P256() {
+			return ALG_ES256
+		}
+	case ed25519.PublicKey:
+		return ALG_EDDSA
+	}
+
+	return ""
+}
+
+// JWK returns public key in JWK format
+func (k *Key) JWK() *JWK {
+	jwk := &JWK{Kid: k.ID, Use: "sig", Alg: k.Algorithm()}
+
+	switch pub := k.Signer.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(pub.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.Kty, jwk.Crv = "EC", "P-256"
+		jwk.X = encodeSegment(pub.X.FillBytes(make([]byte, 32)))
+		jwk.Y = encodeSegment(pub.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+		jwk.X = encodeSegment(pub)
+	}
+
+	return jwk
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// thumbprint returns JWK thumbprint of public key
+func (k *Key) thumbprint() string {
+	jwk := k.JWK()
+
+	// Only required members in lexicographic order are used
+	var members any
+
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	data, _ := json.Marshal(members)
+	hash := sha256.Sum256(data)
+
+	return encodeSegment(hash[:])
+}
+
+// encodeSegment encodes data using unpadded base64url encoding
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,935 @@
+// Package oidc provides OpenID Connect provider (authorization code flow with
+// PKCE) which uses Crowd as identity backend
+package oidc
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/sso"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Endpoints paths relative to issuer URL
+const (
+	PATH_DISCOVERY = "/.well-known/openid-configuration"
+	PATH_AUTHORIZE = "/authorize"
+	PATH_TOKEN     = "/token"
+	PATH_USERINFO  = "/userinfo"
+	PATH_JWKS      = "/jwks"
+)
+
+// Supported scopes
+const (
+	SCOPE_OPENID  = "openid"
+	SCOPE_PROFILE = "profile"
+	SCOPE_EMAIL   = "email"
+	SCOPE_GROUPS  = "groups"
+)
+
+const (
+	// DEFAULT_CODE_TTL is default TTL for authorization codes
+	DEFAULT_CODE_TTL = time.Minute
+
+	// DEFAULT_TOKEN_TTL is default TTL for ID and access tokens
+	DEFAULT_TOKEN_TTL = time.Hour
+)
+
+// maxCodes is maximum number of pending authorization codes
+const maxCodes = 10000
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Provider is OpenID Connect provider
+type Provider struct {
+	// Clients is list of registered clients
+	Clients []*Client
+
+	// SSO is middleware used for authenticating users with Crowd SSO session
+	// (if nil, users always authenticate with login form)
+	SSO *sso.Middleware
+
+	// AttributeClaims maps user attributes to claims returned with "profile"
+	// scope (attribute → claim)
+	AttributeClaims map[string]string
+
+	// EmailVerified enables marking emails as verified
+	EmailVerified bool
+
+	// CodeTTL is TTL for authorization codes
+	CodeTTL time.Duration
+
+	// TokenTTL is TTL for ID and access tokens
+	TokenTTL time.Duration
+
+	// LoginTemplate is template of login page (default login page is used if
+	// nil). Template gets LoginPage as data.
+	LoginTemplate *template.Template
+
+	api    *crowd.API
+	issuer string
+	keys   KeyStore
+	mu     sync.Mutex
+	codes  map[[32]byte]*authCode
+}
+
+// Client is registered client
+type Client struct {
+	// ID is client ID
+	ID string `json:"id"`
+
+	// Secret is client secret. Clients without secret are public clients and
+	// must use PKCE.
+	Secret string `json:"secret,omitempty"`
+
+	// RedirectURIs is list of allowed redirect URIs
+	RedirectURIs []string `json:"redirect_uris"`
+
+	// Groups is list of groups, user must be a member of any of them (if empty,
+	// membership isn't checked)
+	Groups []string `json:"groups,omitempty"`
+}
+
+// LoginPage contains data for login page template
+type LoginPage struct {
+	ClientID string
+	UserName string
+	Error    string
+	Params   map[string]string // Authorization request parameters
+}
+
+// Discovery is provider metadata
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	AuthorizationResponseIssParameter bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// TokenResponse is response of token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// authRequest is validated authorization request
+type authRequest struct {
+	client        *Client
+	redirectURI   string // Effective redirect URI
+	redirectParam string // Redirect URI from request
+	scopes        []string
+	state         string
+	nonce         string
+	challenge     string
+}
+
+// authCode is issued authorization code
+type authCode struct {
+	clientID      string
+	redirectParam string
+	userName      string
+	scopes        []string
+	nonce         string
+	challenge     string
+	authTime      time.Time
+	expiry        time.Time
+}
+
+// oauthError is OAuth 2.0 error
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// supportedScopes is list of supported scopes
+var supportedScopes = []string{SCOPE_OPENID, SCOPE_PROFILE, SCOPE_EMAIL, SCOPE_GROUPS}
+
+// defaultLoginTemplate is default login page template
+var defaultLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<form method="post">
+<h1>Sign in to {{.ClientID}}</h1>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<p><input name="username" placeholder="Username" value="{{.UserName}}" autofocus required></p>
+<p><input name="password" type="password" placeholder="Password" required></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body>
+</html>
+`))
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// New creates new provider with given issuer URL (i.e. "https://sso.domain.com")
+func New(api *crowd.API, issuer string, keys KeyStore) *Provider {
+	return &Provider{
+		CodeTTL:  DEFAULT_CODE_TTL,
+		TokenTTL: DEFAULT_TOKEN_TTL,
+
+		api:    api,
+		issuer: strings.TrimRight(issuer, "/"),
+		keys:   keys,
+		codes:  make(map[[32]byte]*authCode),
+	}
+}
+
+// ReadClients reads clients from JSON file
+func ReadClients(file string) ([]*Client, error) {
+	fd, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return DecodeClients(fd)
+}
+
+// DecodeClients decodes clients from JSON
+func DecodeClients(r io.Reader) ([]*Client, error) {
+	var clients []*Client
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(&clients)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode clients: %w", err)
+	}
+
+	for i, client := range clients {
+		if client.ID == "" || len(client.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("Invalid client %d: ID and redirect URIs are required", i)
+		}
+	}
+
+	return clients, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ServeHTTP serves provider endpoints. Handler expects paths relative to issuer
+// URL, so if issuer URL has path, it must be mounted using http.StripPrefix.
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case PATH_DISCOVERY:
+		p.handleDiscovery(w)
+	case PATH_JWKS:
+		p.handleJWKS(w)
+	case PATH_AUTHORIZE:
+		p.handleAuthorize(w, r)
+	case PATH_TOKEN:
+		p.handleToken(w, r)
+	case PATH_USERINFO:
+		p.handleUserinfo(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// handleDiscovery serves discovery document
+func (p *Provider) handleDiscovery(w http.ResponseWriter) {
+	key, err := p.keys.SigningKey()
+
+	if err != nil {
+		http.Error(w, "Signing key is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	claims := []string{
+		"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+		"name", "given_name", "family_name", "preferred_username",
+		"email", "email_verified", "groups",
+	}
+
+	for _, claim := range p.AttributeClaims {
+		claims = append(claims, claim)
+	}
+
+	writeJSON(w, http.StatusOK, &Discovery{
+		Issuer:                            p.issuer,
+		AuthorizationEndpoint:             p.issuer + PATH_AUTHORIZE,
+		TokenEndpoint:                     p.issuer + PATH_TOKEN,
+		UserinfoEndpoint:                  p.issuer + PATH_USERINFO,
+		JWKSURI:                           p.issuer + PATH_JWKS,
+		ScopesSupported:                   supportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		ResponseModesSupported:            []string{"query"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{key.Algorithm()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   slices.Compact(slices.Sorted(slices.Values(claims))),
+		AuthorizationResponseIssParameter: true,
+	})
+}
+
+// handleJWKS serves public keys
+func (p *Provider) handleJWKS(w http.ResponseWriter) {
+	keys, err := p.keys.VerificationKeys()
+
+	if err != nil {
+		http.Error(w, "Keys are unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	result := &struct {
+		Keys []*JWK `json:"keys"`
+	}{}
+
+	for _, key := range keys {
+		result.Keys = append(result.Keys, key.JWK())
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAuthorize handles authorization request
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+
+	req, err := p.parseAuthRequest(r.Form)
+
+	switch {
+	case req == nil:
+		// Client or redirect URI is invalid, so user can't be redirected
+		http.Error(w, err.Description, http.StatusBadRequest)
+		return
+	case err != nil:
+		p.redirectError(w, r, req, err)
+		return
+	}
+
+	api := p.api.WithContext(r.Context())
+	prompt := r.Form.Get("prompt")
+
+	var user *crowd.User
+	var authTime time.Time
+
+	switch {
+	case r.Method == http.MethodPost && r.PostForm.Has("username"):
+		var loginErr error
+
+		userName := r.PostForm.Get("username")
+		user, loginErr = api.Login(userName, r.PostForm.Get("password"))
+		authTime = time.Now()
+
+		switch {
+		case errors.Is(loginErr, crowd.ErrInvalidCredentials):
+			p.renderLogin(w, req, r.Form, userName, "Invalid username or password", http.StatusUnauthorized)
+			return
+		case loginErr != nil:
+			p.renderLogin(w, req, r.Form, userName, "Authentication service is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+	case prompt != "login" && p.SSO != nil:
+		session, ssoErr := p.SSO.Authenticate(r)
+
+		switch {
+		case ssoErr == nil:
+			user, authTime = session.User, session.Created()
+		case !errors.Is(ssoErr, crowd.ErrInvalidToken):
+			p.redirectError(w, r, req, &oauthError{"temporarily_unavailable", "Authentication service is unavailable"})
+			return
+		}
+	}
+
+	switch {
+	case user != nil:
+		p.completeAuthorization(w, r, api, req, user, authTime)
+	case prompt == "none":
+		p.redirectError(w, r, req, &oauthError{"login_required", "User is not authenticated"})
+	default:
+		p.renderLogin(w, req, r.Form, "", "", http.StatusOK)
+	}
+}
+
+// handleToken handles token request
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, &oauthError{"invalid_request", "Only POST is supported"})
+		return
+	}
+
+	r.ParseForm()
+
+	client := p.authenticateClient(r)
+
+	switch {
+	case client == nil:
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		writeJSON(w, http.StatusUnauthorized, &oauthError{"invalid_client", "Client authentication failed"})
+		return
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		writeJSON(w, http.StatusBadRequest, &oauthError{"unsupported_grant_type", "Only authorization_code grant is supported"})
+		return
+	}
+
+	code := p.takeCode(r.PostForm.Get("code"))
+
+	switch {
+	case code == nil || code.clientID != client.ID:
+		writeJSON(w, http.StatusBadRequest, &oauthError{"invalid_grant", "Invalid or expired code"})
+		return
+	case code.redirectParam != r.PostForm.Get("redirect_uri"):
+		writeJSON(w, http.StatusBadRequest, &oauthError{"invalid_grant", "Redirect URI doesn't match"})
+		return
+	case code.challenge != "" && code.challenge != challengeS256(r.PostForm.Get("code_verifier")):
+		writeJSON(w, http.StatusBadRequest, &oauthError{"invalid_grant", "Invalid code verifier"})
+		return
+	}
+
+	api := p.api.WithContext(r.Context())
+	user, err := api.GetUser(code.userName, true)
+
+	switch {
+	case errors.Is(err, crowd.ErrUserNoFound), err == nil && !user.IsActive:
+		writeJSON(w, http.StatusBadRequest, &oauthError{"invalid_grant", "User is not active"})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusServiceUnavailable, &oauthError{"temporarily_unavailable", "Crowd is unavailable"})
+		return
+	}
+
+	resp, err := p.issueTokens(api, client, user, code)
+
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, &oauthError{"temporarily_unavailable", err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleUserinfo handles userinfo request
+func (p *Provider) handleUserinfo(w http.ResponseWriter, r *http.Request) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := p.verifyAccessToken(token)
+
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	userName, _ := claims["sub"].(string)
+	scope, _ := claims["scope"].(string)
+
+	api := p.api.WithContext(r.Context())
+	user, err := api.GetUser(userName, true)
+
+	switch {
+	case errors.Is(err, crowd.ErrUserNoFound), err == nil && !user.IsActive:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "Crowd is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	result, err := p.userClaims(api, user, strings.Fields(scope))
+
+	if err != nil {
+		http.Error(w, "Crowd is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseAuthRequest validates authorization request. If client or redirect URI
+// is invalid, request is nil and user must not be redirected.
+func (p *Provider) parseAuthRequest(form url.Values) (*authRequest, *oauthError) {
+	req := &authRequest{
+		redirectParam: form.Get("redirect_uri"),
+		state:         form.Get("state"),
+		nonce:         form.Get("nonce"),
+		challenge:     form.Get("code_challenge"),
+	}
+
+	req.client = p.findClient(form.Get("client_id"))
+
+	if req.client == nil {
+		return nil, &oauthError{Description: "Unknown client"}
+	}
+
+	switch {
+	case slices.Contains(req.client.RedirectURIs, req.redirectParam):
+		req.redirectURI = req.redirectParam
+	case req.redirectParam == "" && len(req.client.RedirectURIs) == 1:
+		req.redirectURI = req.client.RedirectURIs[0]
+	default:
+		return nil, &oauthError{Description: "Invalid redirect URI"}
+	}
+
+	for _, scope := range strings.Fields(form.Get("scope")) {
+		if slices.Contains(supportedScopes, scope) && !slices.Contains(req.scopes, scope) {
+			req.scopes = append(req.scopes, scope)
+		}
+	}
+
+	switch {
+	case form.Get("response_type") != "code":
+		return req, &oauthError{"unsupported_response_type", "Only code response type is supported"}
+	case !slices.Contains(req.scopes, SCOPE_OPENID):
+		return req, &oauthError{"invalid_scope", "Scope openid is required"}
+	case req.challenge != "" && form.Get("code_challenge_method") != "S256":
+		return req, &oauthError{"invalid_request", "Only S256 code challenge method is supported"}
+	case req.challenge == "" && req.client.Secret == "":
+		return req, &oauthError{"invalid_request", "PKCE is required for public clients"}
+	}
+
+	return req, nil
+}
+
+// completeAuthorization checks access to client and redirects user with code
+func (p *Provider) completeAuthorization(w http.ResponseWriter, r *http.Request, api *crowd.API, req *authRequest, user *crowd.User, authTime time.Time) {
+	if len(req.client.Groups) != 0 {
+		groups, err := fetchGroups(api, user.Name)
+
+		if err != nil {
+			p.redirectError(w, r, req, &oauthError{"temporarily_unavailable", "Authentication service is unavailable"})
+			return
+		}
+
+		if !hasAnyGroup(groups, req.client.Groups) {
+			p.redirectError(w, r, req, &oauthError{"access_denied", "User is not allowed to access client"})
+			return
+		}
+	}
+
+	code := &authCode{
+		clientID:      req.client.ID,
+		redirectParam: req.redirectParam,
+		userName:      user.Name,
+		scopes:        req.scopes,
+		nonce:         req.nonce,
+		challenge:     req.challenge,
+		authTime:      authTime,
+	}
+
+	value, ok := p.putCode(code)
+
+	if !ok {
+		p.redirectError(w, r, req, &oauthError{"temporarily_unavailable", "Too many pending authorizations"})
+		return
+	}
+
+	p.redirect(w, r, req, url.Values{"code": {value}})
+}
+
+// renderLogin renders login page
+func (p *Provider) renderLogin(w http.ResponseWriter, req *authRequest, form url.Values, userName, message string, statusCode int) {
+	page := &LoginPage{
+		ClientID: req.client.ID,
+		UserName: userName,
+		Error:    message,
+		Params:   make(map[string]string),
+	}
+
+	for _, name := range []string{
+		"response_type", "client_id", "redirect_uri", "scope", "state",
+		"nonce", "code_challenge", "code_challenge_method",
+	} {
+		if form.Has(name) {
+			page.Params[name] = form.Get(name)
+		}
+	}
+
+	tmpl := p.LoginTemplate
+
+	if tmpl == nil {
+		tmpl = defaultLoginTemplate
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(statusCode)
+
+	tmpl.Execute(w, page)
+}
+
+// redirectError redirects user with error
+func (p *Provider) redirectError(w http.ResponseWriter, r *http.Request, req *authRequest, err *oauthError) {
+	p.redirect(w, r, req, url.Values{
+		"error":             {err.Code},
+		"error_description": {err.Description},
+	})
+}
+
+// redirect redirects user to client with given parameters
+func (p *Provider) redirect(w http.ResponseWriter, r *http.Request, req *authRequest, params url.Values) {
+	redirectURL, _ := url.Parse(req.redirectURI)
+	query := redirectURL.Query()
+
+	for name, values := range params {
+		query[name] = values
+	}
+
+	if req.state != "" {
+		query.Set("state", req.state)
+	}
+
+	query.Set("iss", p.issuer)
+	redirectURL.RawQuery = query.Encode()
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// authenticateClient authenticates client using basic auth or form parameters
+func (p *Provider) authenticateClient(r *http.Request) *Client {
+	clientID, secret, hasBasic := r.BasicAuth()
+
+	if hasBasic {
+		// Credentials are form-encoded before using in basic auth (RFC 6749)
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	client := p.findClient(clientID)
+
+	switch {
+	case client == nil:
+		return nil
+	case client.Secret == "" && secret == "":
+		return client
+	case client.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) == 1:
+		return client
+	}
+
+	return nil
+}
+
+// issueTokens creates ID and access tokens
+func (p *Provider) issueTokens(api *crowd.API, client *Client, user *crowd.User, code *authCode) (*TokenResponse, error) {
+	key, err := p.keys.SigningKey()
+
+	if err != nil {
+		return nil, errors.New("Signing key is unavailable")
+	}
+
+	claims, err := p.userClaims(api, user, code.scopes)
+
+	if err != nil {
+		return nil, errors.New("Crowd is unavailable")
+	}
+
+	now := time.Now()
+	exp := now.Add(p.TokenTTL)
+
+	claims["iss"] = p.issuer
+	claims["aud"] = client.ID
+	claims["iat"] = now.Unix()
+	claims["exp"] = exp.Unix()
+
+	if !code.authTime.IsZero() {
+		claims["auth_time"] = code.authTime.Unix()
+	}
+
+	if code.nonce != "" {
+		claims["nonce"] = code.nonce
+	}
+
+	idToken, err := signJWT(key, typeIDToken, claims)
+
+	if err != nil {
+		return nil, errors.New("Can't sign token")
+	}
+
+	scope := strings.Join(code.scopes, " ")
+	accessToken, err := signJWT(key, typeAccessToken, map[string]any{
+		"iss":       p.issuer,
+		"sub":       user.Name,
+		"aud":       client.ID,
+		"client_id": client.ID,
+		"scope":     scope,
+		"iat":       now.Unix(),
+		"exp":       exp.Unix(),
+		"jti":       randomString(),
+	})
+
+	if err != nil {
+		return nil, errors.New("Can't sign token")
+	}
+
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(p.TokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       scope,
+	}, nil
+}
+
+// verifyAccessToken verifies access token and returns its claims
+func (p *Provider) verifyAccessToken(token string) (map[string]any, error) {
+	keys, err := p.keys.VerificationKeys()
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := verifyJWT(token, typeAccessToken, keys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exp, _ := claims["exp"].(float64)
+
+	if claims["iss"] != p.issuer || time.Now().Unix() >= int64(exp) {
+		return nil, errInvalidJWT
+	}
+
+	return claims, nil
+}
+
+// userClaims returns user claims for given scopes
+func (p *Provider) userClaims(api *crowd.API, user *crowd.User, scopes []string) (map[string]any, error) {
+	claims := map[string]any{"sub": user.Name}
+
+	if slices.Contains(scopes, SCOPE_PROFILE) {
+		claims["preferred_username"] = user.Name
+		setClaim(claims, "name", user.DisplayName)
+		setClaim(claims, "given_name", user.FirstName)
+		setClaim(claims, "family_name", user.LastName)
+
+		for _, attr := range user.Attributes {
+			claim := p.AttributeClaims[attr.Name]
+
+			switch {
+			case claim == "" || len(attr.Values) == 0:
+				continue
+			case len(attr.Values) == 1:
+				claims[claim] = attr.Values[0]
+			default:
+				claims[claim] = attr.Values
+			}
+		}
+	}
+
+	if slices.Contains(scopes, SCOPE_EMAIL) && user.Email != "" {
+		claims["email"] = user.Email
+		claims["email_verified"] = p.EmailVerified
+	}
+
+	if slices.Contains(scopes, SCOPE_GROUPS) {
+		groups, err := fetchGroups(api, user.Name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		claims["groups"] = groups
+	}
+
+	return claims, nil
+}
+
+// findClient returns client with given ID
+func (p *Provider) findClient(id string) *Client {
+	if id == "" {
+		return nil
+	}
+
+	for _, client := range p.Clients {
+		if client.ID == id {
+			return client
+		}
+	}
+
+	return nil
+}
+
+// putCode stores authorization code and returns its value
+func (p *Provider) putCode(code *authCode) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.codes) >= maxCodes {
+		p.purgeCodes()
+
+		if len(p.codes) >= maxCodes {
+			return "", false
+		}
+	}
+
+	value := randomString()
+	code.expiry = time.Now().Add(p.CodeTTL)
+	p.codes[sha256.Sum256([]byte(value))] = code
+
+	return value, true
+}
+
+// takeCode returns authorization code and removes it, so it can be used only once
+func (p *Provider) takeCode(value string) *authCode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := sha256.Sum256([]byte(value))
+	code := p.codes[key]
+
+	if code == nil {
+		return nil
+	}
+
+	delete(p.codes, key)
+
+	if time.Now().After(code.expiry) {
+		return nil
+	}
+
+	return code
+}
+
+// purgeCodes removes expired codes
+func (p *Provider) purgeCodes() {
+	now := time.Now()
+
+	for key, code := range p.codes {
+		if now.After(code.expiry) {
+			delete(p.codes, key)
+		}
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// fetchGroups returns names of groups user is a member of (including nested)
+func fetchGroups(api *crowd.API, userName string) ([]string, error) {
+	groups, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+		return api.GetUserNestedGroups(userName, opts)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+
+	for _, g := range groups {
+		result = append(result, g.Name)
+	}
+
+	return result, nil
+}
+
+// hasAnyGroup returns true if any of required groups is in the list. Crowd
+// group names are case-insensitive.
+func hasAnyGroup(groups, required []string) bool {
+	for _, g := range groups {
+		for _, r := range required {
+			if strings.EqualFold(g, r) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// challengeS256 returns S256 code challenge for verifier
+func challengeS256(verifier string) string {
+	if verifier == "" {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(verifier))
+
+	return encodeSegment(hash[:])
+}
+
+// setClaim sets claim if value isn't empty
+func setClaim(claims map[string]any, name, value string) {
+	if value != "" {
+		claims[name] = value
+	}
+}
+
+// randomString returns random string for codes and token IDs
+func randomString() string {
+	data := make([]byte, 32)
+	rand.Read(data)
+
+	return encodeSegment(data)
+}
+
+// writeJSON writes JSON response
+func writeJSON(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	json.NewEncoder(w).Encode(data)
+}
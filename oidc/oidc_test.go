@@ -0,0 +1,679 @@
+package oidc
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"html/template"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+	"github.com/essentialkaos/go-crowd/v3/sso"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+const (
+	testIssuer   = "https://sso.domain.com"
+	testRedirect = "https://app.domain.com/callback"
+	testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type OIDCSuite struct{}
+
+// publicSigner is signer which only has public key
+type publicSigner struct {
+	pub crypto.PublicKey
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&OIDCSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s publicSigner) Public() crypto.PublicKey { return s.pub }
+
+func (s publicSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, ErrUnsupportedKey
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *OIDCSuite) TestKeys(c *C) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for alg, signer := range map[string]crypto.Signer{
+		ALG_RS256: rsaKey, ALG_ES256: ecKey, ALG_EDDSA: edKey,
+	} {
+		key, err := NewKey(signer)
+		c.Assert(err, IsNil)
+		c.Assert(key.Algorithm(), Equals, alg)
+		c.Assert(key.ID, HasLen, 43)
+		c.Assert(key.JWK().Alg, Equals, alg)
+		c.Assert(key.JWK().Kid, Equals, key.ID)
+
+		token, err := signJWT(key, typeIDToken, map[string]any{"sub": "john"})
+		c.Assert(err, IsNil)
+
+		claims, err := verifyJWT(token, typeIDToken, []*Key{key})
+		c.Assert(err, IsNil, Commentf("Algorithm: %s", alg))
+		c.Assert(claims["sub"], Equals, "john")
+
+		parts := strings.Split(token, ".")
+		tampered := parts[0] + "." + encodeSegment([]byte(`{"sub":"bob"}`)) + "." + parts[2]
+
+		for _, t := range []string{tampered, parts[0] + "." + parts[1], parts[0] + ".!." + parts[2], token + "A"} {
+			_, err = verifyJWT(t, typeIDToken, []*Key{key})
+			c.Assert(err, Equals, errInvalidJWT)
+		}
+
+		_, err = verifyJWT(token, typeAccessToken, []*Key{key})
+		c.Assert(err, Equals, errInvalidJWT)
+		_, err = verifyJWT(token, typeIDToken, nil)
+		c.Assert(err, Equals, errInvalidJWT)
+	}
+
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, err := NewKey(p384Key)
+	c.Assert(err, Equals, ErrUnsupportedKey)
+	c.Assert((*Key)(nil).Algorithm(), Equals, "")
+
+	_, err = signJWT(&Key{Signer: p384Key}, typeIDToken, nil)
+	c.Assert(err, Equals, ErrUnsupportedKey)
+	c.Assert((&Key{Signer: p384Key}).verify(nil, nil), Equals, false)
+	c.Assert((&Key{Signer: ecKey}).verify(nil, []byte("AB")), Equals, false)
+
+	_, err = signJWT(&Key{Signer: publicSigner{ecKey.Public()}}, typeIDToken, nil)
+	c.Assert(err, Equals, ErrUnsupportedKey)
+
+	// RFC 7638, section 3.1
+	n, _ := base64.RawURLEncoding.DecodeString(
+		"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	)
+
+	key := &Key{Signer: publicSigner{&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}}}
+	c.Assert(key.thumbprint(), Equals, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs")
+	c.Assert(key.JWK().E, Equals, "AQAB")
+
+	store := NewStaticKeyStore()
+	_, err = store.SigningKey()
+	c.Assert(err, Equals, ErrNoKeys)
+	_, err = store.VerificationKeys()
+	c.Assert(err, Equals, ErrNoKeys)
+}
+
+func (s *OIDCSuite) TestDecodeKey(c *C) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	xKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	ecData, _ := x509.MarshalECPrivateKey(ecKey)
+	edData, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	xData, _ := x509.MarshalPKCS8PrivateKey(xKey)
+
+	for block, alg := range map[*pem.Block]string{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}: ALG_RS256,
+		{Type: "EC PRIVATE KEY", Bytes: ecData}:                               ALG_ES256,
+		{Type: "PRIVATE KEY", Bytes: edData}:                                  ALG_EDDSA,
+	} {
+		key, err := DecodeKey(bytes.NewReader(pem.EncodeToMemory(block)))
+		c.Assert(err, IsNil)
+		c.Assert(key.Algorithm(), Equals, alg)
+	}
+
+	_, err := DecodeKey(bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: xData})))
+	c.Assert(err, Equals, ErrUnsupportedKey)
+	_, err = DecodeKey(bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("test")})))
+	c.Assert(err, ErrorMatches, "Can't decode key: .*")
+	_, err = DecodeKey(strings.NewReader("test"))
+	c.Assert(err, ErrorMatches, "Can't decode key: no PEM data found")
+
+	file := c.MkDir() + "/key.pem"
+	os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edData}), 0600)
+
+	key, err := ReadKey(file)
+	c.Assert(err, IsNil)
+	c.Assert(key.Algorithm(), Equals, ALG_EDDSA)
+
+	_, err = ReadKey(c.MkDir() + "/unknown.pem")
+	c.Assert(err, NotNil)
+}
+
+func (s *OIDCSuite) TestDecodeClients(c *C) {
+	clients, err := DecodeClients(strings.NewReader(`[
+		{"id": "app", "secret": "secret", "redirect_uris": ["https://app.domain.com/callback"], "groups": ["devs"]},
+		{"id": "spa", "redirect_uris": ["https://spa.domain.com/"]}
+	]`))
+
+	c.Assert(err, IsNil)
+	c.Assert(clients, HasLen, 2)
+	c.Assert(clients[0].Groups, DeepEquals, []string{"devs"})
+	c.Assert(clients[1].Secret, Equals, "")
+
+	_, err = DecodeClients(strings.NewReader(`[{"id": "app", "uris": []}]`))
+	c.Assert(err, ErrorMatches, "Can't decode clients: .*")
+	_, err = DecodeClients(strings.NewReader(`[{"id": "app"}]`))
+	c.Assert(err, ErrorMatches, "Invalid client 0: ID and redirect URIs are required")
+
+	file := c.MkDir() + "/clients.json"
+	os.WriteFile(file, []byte(`[{"id": "app", "redirect_uris": ["https://app.domain.com/"]}]`), 0600)
+
+	clients, err = ReadClients(file)
+	c.Assert(err, IsNil)
+	c.Assert(clients, HasLen, 1)
+
+	_, err = ReadClients(c.MkDir() + "/unknown.json")
+	c.Assert(err, NotNil)
+}
+
+func (s *OIDCSuite) TestMetadata(c *C) {
+	srv, p := newTestProvider()
+	defer srv.Close()
+
+	w := serve(p, "GET", PATH_DISCOVERY, "")
+	c.Assert(w.Code, Equals, 200)
+
+	discovery := &Discovery{}
+	json.Unmarshal(w.Body.Bytes(), discovery)
+
+	c.Assert(discovery.Issuer, Equals, testIssuer)
+	c.Assert(discovery.AuthorizationEndpoint, Equals, testIssuer+"/authorize")
+	c.Assert(discovery.TokenEndpoint, Equals, testIssuer+"/token")
+	c.Assert(discovery.UserinfoEndpoint, Equals, testIssuer+"/userinfo")
+	c.Assert(discovery.JWKSURI, Equals, testIssuer+"/jwks")
+	c.Assert(discovery.IDTokenSigningAlgValuesSupported, DeepEquals, []string{ALG_ES256})
+	c.Assert(discovery.CodeChallengeMethodsSupported, DeepEquals, []string{"S256"})
+	c.Assert(strings.Contains(w.Body.String(), `"department"`), Equals, true)
+
+	w = serve(p, "GET", PATH_JWKS, "")
+	c.Assert(w.Code, Equals, 200)
+
+	jwks := &struct {
+		Keys []*JWK `json:"keys"`
+	}{}
+
+	json.Unmarshal(w.Body.Bytes(), jwks)
+
+	c.Assert(jwks.Keys, HasLen, 2)
+	c.Assert(jwks.Keys[0].Kty, Equals, "EC")
+	c.Assert(jwks.Keys[1].Kty, Equals, "OKP")
+
+	w = serve(p, "GET", "/unknown", "")
+	c.Assert(w.Code, Equals, 404)
+
+	p.keys = NewStaticKeyStore()
+
+	c.Assert(serve(p, "GET", PATH_DISCOVERY, "").Code, Equals, 503)
+	c.Assert(serve(p, "GET", PATH_JWKS, "").Code, Equals, 503)
+}
+
+func (s *OIDCSuite) TestLoginFlow(c *C) {
+	srv, p := newTestProvider()
+	defer srv.Close()
+
+	params := authParams("app", "openid profile email groups offline_access")
+	params.Set("nonce", "n-0S6_WzA2Mj")
+	params.Set("code_challenge", challengeS256(testVerifier))
+	params.Set("code_challenge_method", "S256")
+
+	w := serve(p, "GET", PATH_AUTHORIZE+"?"+params.Encode(), "")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Header().Get("X-Frame-Options"), Equals, "DENY")
+	c.Assert(w.Body.String(), Matches, `(?s).*Sign in to app.*name="state" value="af0ifjsldkj".*`)
+
+	params.Set("username", "john")
+	params.Set("password", "wrong")
+
+	w = serve(p, "POST", PATH_AUTHORIZE, params.Encode())
+	c.Assert(w.Code, Equals, 401)
+	c.Assert(w.Body.String(), Matches, `(?s).*Invalid username or password.*value="john".*`)
+
+	params.Set("password", "test1234")
+
+	code := getCode(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode()))
+
+	form := tokenForm(code, "app", "secret")
+	form.Set("code_verifier", "wrong")
+
+	w = serve(p, "POST", PATH_TOKEN, form.Encode())
+	c.Assert(w.Code, Equals, 400)
+	c.Assert(w.Body.String(), Matches, `(?s).*"invalid_grant".*`)
+
+	// Code can be used only once
+	code = getCode(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode()))
+	form = tokenForm(code, "app", "secret")
+	form.Set("code_verifier", testVerifier)
+
+	w = serve(p, "POST", PATH_TOKEN, form.Encode())
+	c.Assert(w.Code, Equals, 200, Commentf("Body: %s", w.Body.String()))
+	c.Assert(w.Header().Get("Cache-Control"), Equals, "no-store")
+	c.Assert(serve(p, "POST", PATH_TOKEN, form.Encode()).Code, Equals, 400)
+
+	resp := &TokenResponse{}
+	json.Unmarshal(w.Body.Bytes(), resp)
+
+	c.Assert(resp.TokenType, Equals, "Bearer")
+	c.Assert(resp.ExpiresIn, Equals, 3600)
+	c.Assert(resp.Scope, Equals, "openid profile email groups")
+
+	keys, _ := p.keys.VerificationKeys()
+	claims, err := verifyJWT(resp.IDToken, typeIDToken, keys)
+
+	c.Assert(err, IsNil)
+	c.Assert(claims["iss"], Equals, testIssuer)
+	c.Assert(claims["aud"], Equals, "app")
+	c.Assert(claims["sub"], Equals, "john")
+	c.Assert(claims["nonce"], Equals, "n-0S6_WzA2Mj")
+	c.Assert(claims["name"], Equals, "John Doe")
+	c.Assert(claims["given_name"], Equals, "John")
+	c.Assert(claims["family_name"], Equals, "Doe")
+	c.Assert(claims["preferred_username"], Equals, "john")
+	c.Assert(claims["email"], Equals, "john@domain.com")
+	c.Assert(claims["email_verified"], Equals, true)
+	c.Assert(claims["department"], Equals, "R&D")
+	c.Assert(claims["phones"], DeepEquals, []any{"100", "200"})
+	c.Assert(claims["groups"], DeepEquals, []any{"admins", "devs"})
+	c.Assert(claims["auth_time"], NotNil)
+	c.Assert(claims["exp"].(float64) > claims["iat"].(float64), Equals, true)
+
+	w = serveUserinfo(p, resp.AccessToken)
+	c.Assert(w.Code, Equals, 200)
+
+	claims = map[string]any{}
+	json.Unmarshal(w.Body.Bytes(), &claims)
+
+	c.Assert(claims["sub"], Equals, "john")
+	c.Assert(claims["email"], Equals, "john@domain.com")
+	c.Assert(claims["groups"], DeepEquals, []any{"admins", "devs"})
+	c.Assert(claims["nonce"], IsNil)
+
+	c.Assert(serveUserinfo(p, resp.IDToken).Code, Equals, 401)
+	c.Assert(serveUserinfo(p, "").Code, Equals, 401)
+	c.Assert(serveUserinfo(p, "test").Header().Get("WWW-Authenticate"), Equals, `Bearer error="invalid_token"`)
+
+	srv.AddUser(&crowdtest.User{Name: "john", Password: "test1234"})
+
+	c.Assert(serveUserinfo(p, resp.AccessToken).Code, Equals, 401)
+
+	srv.Close()
+
+	c.Assert(serveUserinfo(p, resp.AccessToken).Code, Equals, 503)
+
+	w = serve(p, "POST", PATH_AUTHORIZE, params.Encode())
+	c.Assert(w.Code, Equals, 503)
+	c.Assert(w.Body.String(), Matches, `(?s).*Authentication service is unavailable.*`)
+}
+
+func (s *OIDCSuite) TestSSOFlow(c *C) {
+	srv, p := newTestProvider()
+	defer srv.Close()
+
+	srv.AddSession(&crowdtest.Session{Token: "token1", User: "bob"})
+
+	p.SSO = sso.New(srv.API())
+
+	params := authParams("spa", "openid email")
+	params.Set("code_challenge", challengeS256(testVerifier))
+	params.Set("code_challenge_method", "S256")
+
+	w := serveWithCookie(p, PATH_AUTHORIZE+"?"+params.Encode(), "token1")
+	code := getCode(c, w)
+
+	form := tokenForm(code, "spa", "")
+	form.Set("code_verifier", testVerifier)
+
+	w = serve(p, "POST", PATH_TOKEN, form.Encode())
+	c.Assert(w.Code, Equals, 200, Commentf("Body: %s", w.Body.String()))
+
+	resp := &TokenResponse{}
+	json.Unmarshal(w.Body.Bytes(), resp)
+
+	keys, _ := p.keys.VerificationKeys()
+	claims, _ := verifyJWT(resp.IDToken, typeIDToken, keys)
+
+	c.Assert(claims["sub"], Equals, "bob")
+	c.Assert(claims["aud"], Equals, "spa")
+	c.Assert(claims["email"], Equals, "bob@domain.com")
+	c.Assert(claims["name"], IsNil)
+	c.Assert(claims["nonce"], IsNil)
+
+	params.Set("prompt", "login")
+
+	w = serveWithCookie(p, PATH_AUTHORIZE+"?"+params.Encode(), "token1")
+	c.Assert(w.Code, Equals, 200)
+
+	params.Set("prompt", "none")
+
+	w = serveWithCookie(p, PATH_AUTHORIZE+"?"+params.Encode(), "unknown")
+	c.Assert(redirectParams(c, w).Get("error"), Equals, "login_required")
+
+	// Inactive user can't get tokens even with valid session
+	srv.AddUser(&crowdtest.User{Name: "bob"})
+	code = getCode(c, serveWithCookie(p, PATH_AUTHORIZE+"?"+params.Encode(), "token1"))
+	form = tokenForm(code, "spa", "")
+	form.Set("code_verifier", testVerifier)
+
+	w = serve(p, "POST", PATH_TOKEN, form.Encode())
+	c.Assert(w.Code, Equals, 400)
+	c.Assert(w.Body.String(), Matches, `(?s).*User is not active.*`)
+
+	code = getCode(c, serveWithCookie(p, PATH_AUTHORIZE+"?"+params.Encode(), "token1"))
+	form = tokenForm(code, "spa", "")
+	form.Set("code_verifier", testVerifier)
+
+	srv.Close()
+
+	w = serve(p, "POST", PATH_TOKEN, form.Encode())
+	c.Assert(w.Code, Equals, 503)
+
+	w = serveWithCookie(p, PATH_AUTHORIZE+"?"+params.Encode(), "token2")
+	c.Assert(redirectParams(c, w).Get("error"), Equals, "temporarily_unavailable")
+}
+
+func (s *OIDCSuite) TestAuthorizeErrors(c *C) {
+	srv, p := newTestProvider()
+	defer srv.Close()
+
+	p.LoginTemplate = template.Must(template.New("").Parse(`Custom {{.ClientID}}`))
+
+	params := authParams("unknown", "openid")
+	c.Assert(serve(p, "GET", PATH_AUTHORIZE+"?"+params.Encode(), "").Code, Equals, 400)
+
+	params = authParams("app", "openid")
+	params.Set("redirect_uri", "https://evil.com/callback")
+	c.Assert(serve(p, "GET", PATH_AUTHORIZE+"?"+params.Encode(), "").Code, Equals, 400)
+
+	params.Del("redirect_uri")
+	w := serve(p, "GET", PATH_AUTHORIZE+"?"+params.Encode(), "")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Body.String(), Equals, "Custom app")
+
+	c.Assert(serve(p, "PUT", PATH_AUTHORIZE, "").Code, Equals, 405)
+
+	for param, value := range map[string]string{
+		"response_type":         "token",
+		"scope":                 "profile",
+		"code_challenge_method": "plain",
+	} {
+		params = authParams("app", "openid")
+		params.Set("code_challenge", "test")
+		params.Set(param, value)
+
+		w = serve(p, "GET", PATH_AUTHORIZE+"?"+params.Encode(), "")
+		query := redirectParams(c, w)
+
+		c.Assert(query.Get("error"), Not(Equals), "", Commentf("Param: %s", param))
+		c.Assert(query.Get("state"), Equals, "af0ifjsldkj")
+		c.Assert(query.Get("iss"), Equals, testIssuer)
+	}
+
+	params = authParams("spa", "openid")
+	c.Assert(redirectParams(c, serve(p, "GET", PATH_AUTHORIZE+"?"+params.Encode(), "")).Get("error"), Equals, "invalid_request")
+
+	params = authParams("app", "openid")
+	params.Set("username", "bob")
+	params.Set("password", "test1234")
+
+	c.Assert(redirectParams(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode())).Get("error"), Equals, "access_denied")
+
+	p.mu.Lock()
+	for i := range maxCodes {
+		p.codes[sha256.Sum256([]byte{byte(i), byte(i >> 8)})] = &authCode{expiry: time.Now().Add(time.Minute)}
+	}
+	p.mu.Unlock()
+
+	params.Set("username", "john")
+	c.Assert(redirectParams(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode())).Get("error"), Equals, "temporarily_unavailable")
+
+	p.mu.Lock()
+	for _, code := range p.codes {
+		code.expiry = time.Now().Add(-time.Second)
+	}
+	p.mu.Unlock()
+
+	c.Assert(redirectParams(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode())).Get("code"), Not(Equals), "")
+	c.Assert(p.codes, HasLen, 1)
+
+	srv.Close()
+
+	c.Assert(serve(p, "POST", PATH_AUTHORIZE, params.Encode()).Code, Equals, 503)
+}
+
+func (s *OIDCSuite) TestTokenErrors(c *C) {
+	srv, p := newTestProvider()
+	defer srv.Close()
+
+	params := authParams("app", "openid")
+	params.Set("username", "john")
+	params.Set("password", "test1234")
+
+	code := getCode(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode()))
+
+	c.Assert(serve(p, "GET", PATH_TOKEN, "").Code, Equals, 405)
+
+	w := serve(p, "POST", PATH_TOKEN, tokenForm(code, "app", "wrong").Encode())
+	c.Assert(w.Code, Equals, 401)
+	c.Assert(w.Body.String(), Matches, `(?s).*"invalid_client".*`)
+
+	c.Assert(serve(p, "POST", PATH_TOKEN, tokenForm(code, "spa", "secret").Encode()).Code, Equals, 401)
+	c.Assert(serve(p, "POST", PATH_TOKEN, tokenForm(code, "unknown", "").Encode()).Code, Equals, 401)
+
+	form := tokenForm(code, "app", "secret")
+	form.Set("grant_type", "password")
+
+	w = serve(p, "POST", PATH_TOKEN, form.Encode())
+	c.Assert(w.Code, Equals, 400)
+	c.Assert(w.Body.String(), Matches, `(?s).*"unsupported_grant_type".*`)
+
+	form = tokenForm(code, "app", "secret")
+	form.Set("redirect_uri", "https://app.domain.com/other")
+
+	w = serve(p, "POST", PATH_TOKEN, form.Encode())
+	c.Assert(w.Body.String(), Matches, `(?s).*Redirect URI doesn't match.*`)
+
+	// Client credentials in basic auth
+	code = getCode(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode()))
+	form = tokenForm(code, "", "")
+
+	r := httptest.NewRequest("POST", PATH_TOKEN, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("app", url.QueryEscape("secret"))
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+
+	c.Assert(w.Code, Equals, 200, Commentf("Body: %s", w.Body.String()))
+
+	// Code issued for other client
+	code = getCode(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode()))
+	p.Clients = append(p.Clients, &Client{ID: "other", Secret: "secret", RedirectURIs: []string{testRedirect}})
+	c.Assert(serve(p, "POST", PATH_TOKEN, tokenForm(code, "other", "secret").Encode()).Code, Equals, 400)
+
+	// Expired code
+	p.CodeTTL = -time.Second
+	code = getCode(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode()))
+	c.Assert(serve(p, "POST", PATH_TOKEN, tokenForm(code, "app", "secret").Encode()).Code, Equals, 400)
+
+	// Unavailable signing key
+	p.CodeTTL = time.Minute
+	code = getCode(c, serve(p, "POST", PATH_AUTHORIZE, params.Encode()))
+	p.keys = NewStaticKeyStore()
+
+	w = serve(p, "POST", PATH_TOKEN, tokenForm(code, "app", "secret").Encode())
+	c.Assert(w.Code, Equals, 503)
+	c.Assert(w.Body.String(), Matches, `(?s).*Signing key is unavailable.*`)
+	c.Assert(serveUserinfo(p, "test").Code, Equals, 401)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func newTestProvider() (*testServer, *Provider) {
+	srv := &testServer{crowdtest.NewServer()}
+
+	srv.AddUser(&crowdtest.User{
+		Name: "john", FirstName: "John", LastName: "Doe", DisplayName: "John Doe",
+		Email: "john@domain.com", Password: "test1234", IsActive: true,
+		Attributes: map[string][]string{"department": {"R&D"}, "phone": {"100", "200"}, "secret": {"1"}},
+	})
+	srv.AddUser(&crowdtest.User{Name: "bob", Email: "bob@domain.com", Password: "test1234", IsActive: true})
+
+	srv.AddGroup(&crowdtest.Group{Name: "admins", Groups: []string{"devs"}})
+	srv.AddGroup(&crowdtest.Group{Name: "devs", Users: []string{"john"}})
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	key1, _ := NewKey(ecKey)
+	key2, _ := NewKey(edKey)
+
+	p := New(srv.API(), testIssuer+"/", NewStaticKeyStore(key1, key2))
+	p.EmailVerified = true
+	p.AttributeClaims = map[string]string{"department": "department", "phone": "phones"}
+	p.Clients = []*Client{
+		{ID: "app", Secret: "secret", RedirectURIs: []string{testRedirect}, Groups: []string{"ADMINS"}},
+		{ID: "spa", RedirectURIs: []string{"https://spa.domain.com/", "https://spa.domain.com/callback"}},
+	}
+
+	return srv, p
+}
+
+// testServer is fake Crowd server with API helper
+type testServer struct {
+	*crowdtest.Server
+}
+
+func (s *testServer) API() *crowd.API {
+	api, _ := crowd.NewAPI(s.URL(), "app", "test")
+	return api
+}
+
+func authParams(clientID, scope string) url.Values {
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"scope":         {scope},
+		"state":         {"af0ifjsldkj"},
+	}
+
+	if clientID == "spa" {
+		params.Set("redirect_uri", "https://spa.domain.com/callback")
+	} else {
+		params.Set("redirect_uri", testRedirect)
+	}
+
+	return params
+}
+
+func tokenForm(code, clientID, secret string) url.Values {
+	form := url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {code},
+	}
+
+	if clientID == "spa" {
+		form.Set("redirect_uri", "https://spa.domain.com/callback")
+	} else {
+		form.Set("redirect_uri", testRedirect)
+	}
+
+	if clientID != "" {
+		form.Set("client_id", clientID)
+	}
+
+	if secret != "" {
+		form.Set("client_secret", secret)
+	}
+
+	return form
+}
+
+func serve(h http.Handler, method, uri, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, uri, strings.NewReader(body))
+
+	if method == "POST" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func serveWithCookie(h http.Handler, uri, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", uri, nil)
+	r.AddCookie(&http.Cookie{Name: crowd.SSO_COOKIE_NAME, Value: token})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func serveUserinfo(h http.Handler, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", PATH_USERINFO, nil)
+
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func redirectParams(c *C, w *httptest.ResponseRecorder) url.Values {
+	c.Assert(w.Code, Equals, 302, Commentf("Body: %s", w.Body.String()))
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	c.Assert(err, IsNil)
+
+	return location.Query()
+}
+
+func getCode(c *C, w *httptest.ResponseRecorder) string {
+	query := redirectParams(c, w)
+
+	c.Assert(query.Get("error"), Equals, "", Commentf("Description: %s", query.Get("error_description")))
+	c.Assert(query.Get("code"), Not(Equals), "")
+	c.Assert(query.Get("state"), Equals, "af0ifjsldkj")
+	c.Assert(query.Get("iss"), Equals, testIssuer)
+
+	return query.Get("code")
+}
@@ -0,0 +1,462 @@
+// Package reconcile provides declarative reconciliation of Crowd group memberships
+package reconcile
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/essentialkaos/go-crowd/v3"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Action types
+const (
+	ADD_CHILD_GROUP ActionType = iota
+	ADD_MEMBER
+	SET_ATTRIBUTE
+	REMOVE_CHILD_GROUP
+	REMOVE_MEMBER
+	DELETE_ATTRIBUTE
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// State is desired state of groups
+type State struct {
+	Groups map[string]*GroupState `json:"groups"`
+}
+
+// GroupState is desired state of single group. Nil fields are not managed.
+type GroupState struct {
+	// Members is list of direct members of the group
+	Members []string `json:"members,omitempty"`
+
+	// ChildGroups is list of direct child groups
+	ChildGroups []string `json:"child_groups,omitempty"`
+
+	// Attributes is map with group attributes
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+// ActionType is type of reconciliation action
+type ActionType uint8
+
+// Action is single change required to reach desired state
+type Action struct {
+	Type   ActionType
+	Group  string
+	Target string   // User name, child group name or attribute name
+	Values []string // Attribute values
+}
+
+// Plan is list of changes required to reach desired state
+type Plan struct {
+	Actions []*Action
+}
+
+// Reconciler compares desired state with Crowd and applies changes
+type Reconciler struct {
+	// Concurrency is maximum number of concurrent requests (default: 1)
+	Concurrency int
+
+	// DryRun disables applying changes
+	DryRun bool
+
+	// MaxChanges is maximum number of changes which can be applied (0 means
+	// no limit)
+	MaxChanges int
+
+	api *crowd.API
+}
+
+// ApplyReport contains info about applied changes
+type ApplyReport struct {
+	Applied []*Action
+	Errors  []error
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ErrTooManyChanges is returned if number of changes in plan exceeds the limit
+var ErrTooManyChanges = errors.New("Plan contains too many changes")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ReadState reads desired state from JSON file
+func ReadState(file string) (*State, error) {
+	fd, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return DecodeState(fd)
+}
+
+// DecodeState decodes JSON-encoded desired state
+func DecodeState(r io.Reader) (*State, error) {
+	state := &State{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(state)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode state: %w", err)
+	}
+
+	return state, nil
+}
+
+// NewReconciler creates new reconciler
+func NewReconciler(api *crowd.API) *Reconciler {
+	return &Reconciler{api: api, Concurrency: 1}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Plan compares desired state with current state in Crowd and returns plan
+// with required changes. Temporary members (see crowd.GrantTemporaryMembership)
+// and temporary memberships info are never removed.
+func (r *Reconciler) Plan(state *State) (*Plan, error) {
+	plan := &Plan{}
+
+	if state == nil {
+		return plan, nil
+	}
+
+	for _, groupName := range slices.Sorted(maps.Keys(state.Groups)) {
+		actions, err := r.planGroup(groupName, state.Groups[groupName])
+
+		if err != nil {
+			return nil, fmt.Errorf("Can't plan changes for group %q: %w", groupName, err)
+		}
+
+		plan.Actions = append(plan.Actions, actions...)
+	}
+
+	return plan, nil
+}
+
+// Apply applies changes from plan. Additions are applied before removals, so
+// members never lose access while moving between groups. In dry run mode report
+// contains actions which would be applied.
+func (r *Reconciler) Apply(plan *Plan) (*ApplyReport, error) {
+	if plan == nil || plan.IsEmpty() {
+		return &ApplyReport{}, nil
+	}
+
+	if r.MaxChanges > 0 && len(plan.Actions) > r.MaxChanges {
+		return nil, fmt.Errorf(
+			"%w (%d > %d)", ErrTooManyChanges, len(plan.Actions), r.MaxChanges,
+		)
+	}
+
+	result := &ApplyReport{}
+
+	if r.DryRun {
+		result.Applied = slices.Clone(plan.Actions)
+		return result, nil
+	}
+
+	var additions, removals []*Action
+
+	for _, action := range plan.Actions {
+		if action.isRemoval() {
+			removals = append(removals, action)
+		} else {
+			additions = append(additions, action)
+		}
+	}
+
+	// Removals start only after all additions are finished
+	for _, actions := range [][]*Action{additions, removals} {
+		errs := r.applyActions(actions)
+
+		for index, action := range actions {
+			if errs[index] != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("Can't %s: %w", action, errs[index]))
+			} else {
+				result.Applied = append(result.Applied, action)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// IsEmpty returns true if plan has no changes
+func (p *Plan) IsEmpty() bool {
+	return p == nil || len(p.Actions) == 0
+}
+
+// String returns human-readable plan
+func (p *Plan) String() string {
+	if p.IsEmpty() {
+		return "No changes required\n"
+	}
+
+	var buf strings.Builder
+
+	for _, action := range p.Actions {
+		switch {
+		case action.isRemoval():
+			buf.WriteString("- ")
+		case action.Type == SET_ATTRIBUTE:
+			buf.WriteString("~ ")
+		default:
+			buf.WriteString("+ ")
+		}
+
+		buf.WriteString(action.String() + "\n")
+	}
+
+	fmt.Fprintf(&buf, "\nTotal changes: %d\n", len(p.Actions))
+
+	return buf.String()
+}
+
+// String returns action description
+func (a *Action) String() string {
+	switch a.Type {
+	case ADD_CHILD_GROUP:
+		return fmt.Sprintf("add child group %q to group %q", a.Target, a.Group)
+	case ADD_MEMBER:
+		return fmt.Sprintf("add user %q to group %q", a.Target, a.Group)
+	case SET_ATTRIBUTE:
+		return fmt.Sprintf("set attribute %q of group %q to %q", a.Target, a.Group, a.Values)
+	case REMOVE_CHILD_GROUP:
+		return fmt.Sprintf("remove child group %q from group %q", a.Target, a.Group)
+	case REMOVE_MEMBER:
+		return fmt.Sprintf("remove user %q from group %q", a.Target, a.Group)
+	case DELETE_ATTRIBUTE:
+		return fmt.Sprintf("delete attribute %q of group %q", a.Target, a.Group)
+	}
+
+	return "unknown action"
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// isRemoval returns true if action removes membership or attribute
+func (a *Action) isRemoval() bool {
+	switch a.Type {
+	case REMOVE_CHILD_GROUP, REMOVE_MEMBER, DELETE_ATTRIBUTE:
+		return true
+	}
+
+	return false
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// planGroup returns actions required for reconciling single group
+func (r *Reconciler) planGroup(groupName string, desired *GroupState) ([]*Action, error) {
+	if desired == nil {
+		return nil, nil
+	}
+
+	_, err := r.api.GetGroup(groupName, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := r.api.GetGroupAttributes(groupName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var additions, removals []*Action
+
+	if desired.ChildGroups != nil {
+		current, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+			return r.api.GetGroupDirectChildGroups(groupName, opts)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		added, removed := compareNames(groupNames(current), desired.ChildGroups)
+		additions = append(additions, makeActions(ADD_CHILD_GROUP, groupName, added)...)
+		removals = append(removals, makeActions(REMOVE_CHILD_GROUP, groupName, removed)...)
+	}
+
+	if desired.Members != nil {
+		current, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.User, error) {
+			return r.api.GetGroupDirectUsers(groupName, opts)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		temporary := crowd.ParseTemporaryMemberships(groupName, attrs)
+		added, removed := compareNames(userNames(current), desired.Members)
+		removed = slices.DeleteFunc(removed, func(name string) bool {
+			return slices.ContainsFunc(temporary, func(m *crowd.TemporaryMembership) bool {
+				return strings.EqualFold(m.User, name)
+			})
+		})
+
+		additions = append(additions, makeActions(ADD_MEMBER, groupName, added)...)
+		removals = append(removals, makeActions(REMOVE_MEMBER, groupName, removed)...)
+	}
+
+	if desired.Attributes != nil {
+		wanted := crowd.AttributesFromMap(desired.Attributes)
+		wanted.Delete(crowd.TEMP_MEMBERS_ATTR)
+
+		current := attrs.Clone()
+		current.Delete(crowd.TEMP_MEMBERS_ATTR)
+
+		diff := current.Diff(wanted)
+
+		for _, attr := range append(diff.Added, diff.Changed...) {
+			additions = append(additions, &Action{SET_ATTRIBUTE, groupName, attr.Name, attr.Values})
+		}
+
+		for _, attr := range diff.Removed {
+			removals = append(removals, &Action{Type: DELETE_ATTRIBUTE, Group: groupName, Target: attr.Name})
+		}
+	}
+
+	return append(additions, removals...), nil
+}
+
+// applyActions applies actions concurrently and returns errors for every action
+func (r *Reconciler) applyActions(actions []*Action) []error {
+	var wg sync.WaitGroup
+
+	errs := make([]error, len(actions))
+	queue := make(chan int)
+
+	for range max(r.Concurrency, 1) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for index := range queue {
+				errs[index] = r.applyAction(actions[index])
+			}
+		}()
+	}
+
+	for index := range actions {
+		queue <- index
+	}
+
+	close(queue)
+	wg.Wait()
+
+	return errs
+}
+
+// applyAction applies single action
+func (r *Reconciler) applyAction(action *Action) error {
+	switch action.Type {
+	case ADD_CHILD_GROUP:
+		return r.api.AddChildGroup(action.Group, action.Target)
+	case ADD_MEMBER:
+		return r.api.AddUserToGroup(action.Target, action.Group)
+	case SET_ATTRIBUTE:
+		return r.api.SetGroupAttributes(action.Group, &crowd.GroupAttributes{
+			Attributes: crowd.Attributes{{Name: action.Target, Values: action.Values}},
+		})
+	case REMOVE_CHILD_GROUP:
+		return r.api.RemoveChildGroup(action.Group, action.Target)
+	case REMOVE_MEMBER:
+		return r.api.RemoveUserFromGroup(action.Target, action.Group)
+	case DELETE_ATTRIBUTE:
+		return r.api.DeleteGroupAttributes(action.Group, action.Target)
+	}
+
+	return fmt.Errorf("Unknown action type %d", action.Type)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// compareNames returns names which must be added and removed. Names in Crowd
+// are case-insensitive.
+func compareNames(current, desired []string) ([]string, []string) {
+	var added, removed []string
+
+	for _, name := range desired {
+		if !containsName(current, name) && !containsName(added, name) {
+			added = append(added, name)
+		}
+	}
+
+	for _, name := range current {
+		if !containsName(desired, name) {
+			removed = append(removed, name)
+		}
+	}
+
+	slices.Sort(added)
+	slices.Sort(removed)
+
+	return added, removed
+}
+
+// makeActions creates actions of given type for all targets
+func makeActions(typ ActionType, groupName string, targets []string) []*Action {
+	var result []*Action
+
+	for _, target := range targets {
+		result = append(result, &Action{Type: typ, Group: groupName, Target: target})
+	}
+
+	return result
+}
+
+// containsName returns true if list contains given name
+func containsName(names []string, name string) bool {
+	return slices.ContainsFunc(names, func(n string) bool {
+		return strings.EqualFold(n, name)
+	})
+}
+
+// groupNames returns names of groups
+func groupNames(groups []*crowd.Group) []string {
+	var result []string
+
+	for _, g := range groups {
+		result = append(result, g.Name)
+	}
+
+	return result
+}
+
+// userNames returns names of users
+func userNames(users []*crowd.User) []string {
+	var result []string
+
+	for _, u := range users {
+		result = append(result, u.Name)
+	}
+
+	return result
+}
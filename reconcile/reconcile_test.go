@@ -0,0 +1,282 @@
+package reconcile
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ReconcileSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&ReconcileSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *ReconcileSuite) TestState(c *C) {
+	state, err := DecodeState(strings.NewReader(`{
+		"groups": {
+			"devs": {"members": ["john"], "attributes": {"team": ["core"]}},
+			"ops": {"members": []}
+		}
+	}`))
+
+	c.Assert(err, IsNil)
+	c.Assert(state.Groups, HasLen, 2)
+	c.Assert(state.Groups["devs"].Members, DeepEquals, []string{"john"})
+	c.Assert(state.Groups["devs"].ChildGroups, IsNil)
+	c.Assert(state.Groups["devs"].Attributes["team"], DeepEquals, []string{"core"})
+	c.Assert(state.Groups["ops"].Members, NotNil)
+	c.Assert(state.Groups["ops"].Members, HasLen, 0)
+
+	_, err = DecodeState(strings.NewReader(`{"groups": {"devs": {"users": []}}}`))
+	c.Assert(err, NotNil)
+
+	file := c.MkDir() + "/state.json"
+	os.WriteFile(file, []byte(`{"groups": {"devs": {"child_groups": ["qa"]}}}`), 0644)
+
+	state, err = ReadState(file)
+
+	c.Assert(err, IsNil)
+	c.Assert(state.Groups["devs"].ChildGroups, DeepEquals, []string{"qa"})
+
+	_, err = ReadState(c.MkDir() + "/unknown.json")
+	c.Assert(err, NotNil)
+}
+
+func (s *ReconcileSuite) TestPlanApply(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	r := NewReconciler(api)
+
+	state := &State{Groups: map[string]*GroupState{
+		"devs": {
+			Members:     []string{"john", "bob"},
+			ChildGroups: []string{"qa"},
+			Attributes:  map[string][]string{"team": {"core"}, "channel": {"#devs"}},
+		},
+		"qa": {},
+	}}
+
+	plan, err := r.Plan(state)
+
+	c.Assert(err, IsNil)
+	c.Assert(plan.Actions, DeepEquals, []*Action{
+		{Type: ADD_CHILD_GROUP, Group: "devs", Target: "qa"},
+		{Type: ADD_MEMBER, Group: "devs", Target: "bob"},
+		{Type: SET_ATTRIBUTE, Group: "devs", Target: "channel", Values: []string{"#devs"}},
+		{Type: SET_ATTRIBUTE, Group: "devs", Target: "team", Values: []string{"core"}},
+		{Type: REMOVE_CHILD_GROUP, Group: "devs", Target: "ops"},
+		{Type: REMOVE_MEMBER, Group: "devs", Target: "mary"},
+		{Type: DELETE_ATTRIBUTE, Group: "devs", Target: "legacy"},
+	})
+
+	c.Assert(plan.String(), Equals, `+ add child group "qa" to group "devs"
++ add user "bob" to group "devs"
+~ set attribute "channel" of group "devs" to ["#devs"]
+~ set attribute "team" of group "devs" to ["core"]
+- remove child group "ops" from group "devs"
+- remove user "mary" from group "devs"
+- delete attribute "legacy" of group "devs"
+
+Total changes: 7
+`)
+
+	r.MaxChanges = 5
+	_, err = r.Apply(plan)
+	c.Assert(errors.Is(err, ErrTooManyChanges), Equals, true)
+
+	r.MaxChanges = 0
+	r.DryRun = true
+	srv.ResetRequests()
+	result, err := r.Apply(plan)
+
+	c.Assert(err, IsNil)
+	c.Assert(result.Applied, DeepEquals, plan.Actions)
+	c.Assert(srv.Requests(), HasLen, 0)
+
+	r.DryRun = false
+	r.Concurrency = 4
+	result, err = r.Apply(plan)
+
+	c.Assert(err, IsNil)
+	c.Assert(result.Errors, HasLen, 0)
+	c.Assert(result.Applied, DeepEquals, plan.Actions)
+
+	g := srv.Group("devs")
+	c.Assert(g.Users, DeepEquals, []string{"john", "bob"})
+	c.Assert(g.Groups, DeepEquals, []string{"qa"})
+	c.Assert(g.Attributes["team"], DeepEquals, []string{"core"})
+	c.Assert(g.Attributes["channel"], DeepEquals, []string{"#devs"})
+	c.Assert(g.Attributes["legacy"], IsNil)
+
+	plan, err = r.Plan(state)
+
+	c.Assert(err, IsNil)
+	c.Assert(plan.IsEmpty(), Equals, true)
+	c.Assert(plan.String(), Equals, "No changes required\n")
+
+	result, err = r.Apply(plan)
+
+	c.Assert(err, IsNil)
+	c.Assert(result.Applied, HasLen, 0)
+}
+
+func (s *ReconcileSuite) TestApplyOrder(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	r := NewReconciler(api)
+	r.Concurrency = 4
+
+	// Removals from one group go before additions to another group in plan
+	plan := &Plan{Actions: []*Action{
+		{Type: REMOVE_MEMBER, Group: "devs", Target: "mary"},
+		{Type: REMOVE_MEMBER, Group: "devs", Target: "john"},
+		{Type: DELETE_ATTRIBUTE, Group: "devs", Target: "legacy"},
+		{Type: ADD_MEMBER, Group: "ops", Target: "mary"},
+		{Type: ADD_MEMBER, Group: "ops", Target: "john"},
+		{Type: SET_ATTRIBUTE, Group: "ops", Target: "legacy", Values: []string{"yes"}},
+	}}
+
+	for range 10 {
+		srv.ResetRequests()
+		result, err := r.Apply(plan)
+
+		c.Assert(err, IsNil)
+		c.Assert(result.Errors, HasLen, 0)
+		c.Assert(result.Applied, DeepEquals, slices.Concat(plan.Actions[3:], plan.Actions[:3]))
+
+		requests := srv.Requests()
+
+		c.Assert(requests, HasLen, 6)
+
+		for _, req := range requests[:3] {
+			c.Assert(req, Matches, "POST .*")
+		}
+
+		for _, req := range requests[3:] {
+			c.Assert(req, Matches, "DELETE .*")
+		}
+
+		srv.AddGroup(&crowdtest.Group{Name: "devs", Users: []string{"john", "mary"}, Attributes: map[string][]string{"legacy": {"yes"}}})
+		srv.AddGroup(&crowdtest.Group{Name: "ops"})
+	}
+}
+
+func (s *ReconcileSuite) TestTemporaryMembers(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	err := api.GrantTemporaryMembership("bob", "devs", time.Now().Add(time.Hour))
+	c.Assert(err, IsNil)
+
+	r := NewReconciler(api)
+
+	plan, err := r.Plan(&State{Groups: map[string]*GroupState{
+		"devs": {
+			Members:    []string{"john", "mary"},
+			Attributes: map[string][]string{"legacy": {"yes"}},
+		},
+	}})
+
+	c.Assert(err, IsNil)
+	c.Assert(plan.IsEmpty(), Equals, true)
+}
+
+func (s *ReconcileSuite) TestNamesCase(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	srv.AddGroup(&crowdtest.Group{
+		Name:       "devs",
+		IsActive:   true,
+		Users:      []string{"john", "mary", "bob"},
+		Groups:     []string{"ops"},
+		Attributes: map[string][]string{crowd.TEMP_MEMBERS_ATTR: {"2030-01-01T00:00:00Z BOB"}},
+	})
+
+	r := NewReconciler(api)
+
+	plan, err := r.Plan(&State{Groups: map[string]*GroupState{
+		"devs": {
+			Members:     []string{"John", "MARY", "mary"},
+			ChildGroups: []string{"OPS"},
+		},
+	}})
+
+	c.Assert(err, IsNil)
+	c.Assert(plan.IsEmpty(), Equals, true)
+}
+
+func (s *ReconcileSuite) TestErrors(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	r := NewReconciler(api)
+
+	plan, err := r.Plan(nil)
+	c.Assert(err, IsNil)
+	c.Assert(plan.IsEmpty(), Equals, true)
+
+	_, err = r.Plan(&State{Groups: map[string]*GroupState{"unknown": {}}})
+	c.Assert(errors.Is(err, crowd.ErrGroupNoFound), Equals, true)
+
+	result, err := r.Apply(&Plan{Actions: []*Action{
+		{Type: ADD_MEMBER, Group: "devs", Target: "unknown"},
+		{Type: ADD_MEMBER, Group: "devs", Target: "bob"},
+		{Type: ActionType(100), Group: "devs", Target: "bob"},
+	}})
+
+	c.Assert(err, IsNil)
+	c.Assert(result.Applied, HasLen, 1)
+	c.Assert(result.Errors, HasLen, 2)
+	c.Assert(errors.Is(result.Errors[0], crowd.ErrUserNoFound), Equals, true)
+	c.Assert(result.Errors[1], ErrorMatches, `Can't unknown action: Unknown action type 100`)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func newTestServer() (*crowdtest.Server, *crowd.API) {
+	srv := crowdtest.NewServer()
+
+	for _, name := range []string{"john", "bob", "mary"} {
+		srv.AddUser(&crowdtest.User{Name: name, IsActive: true})
+	}
+
+	srv.AddGroup(&crowdtest.Group{
+		Name:       "devs",
+		IsActive:   true,
+		Users:      []string{"john", "mary"},
+		Groups:     []string{"ops"},
+		Attributes: map[string][]string{"legacy": {"yes"}},
+	})
+
+	srv.AddGroup(&crowdtest.Group{Name: "ops", IsActive: true})
+	srv.AddGroup(&crowdtest.Group{Name: "qa", IsActive: true})
+
+	api, _ := crowd.NewAPI(srv.URL()+"/", "app", "test")
+
+	return srv, api
+}
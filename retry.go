@@ -0,0 +1,132 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// RetryPolicy contains configuration for retrying failed requests
+type RetryPolicy struct {
+	// MaxAttempts is maximum number of attempts (including the first one)
+	MaxAttempts int
+
+	// BaseDelay is delay before the first retry, every next delay is doubled
+	BaseDelay time.Duration
+
+	// MaxDelay is maximum delay between attempts (0 means no limit). If server
+	// asks to wait longer using Retry-After header, request isn't retried.
+	MaxDelay time.Duration
+
+	// Jitter is randomization factor for delays (0.0 - 1.0)
+	Jitter float64
+
+	// StatusCodes is list of status codes which can be retried. If list is empty,
+	// DefaultRetryStatusCodes is used.
+	StatusCodes []int
+
+	// RetryNonIdempotent allows retrying non-idempotent requests (POST)
+	RetryNonIdempotent bool
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DefaultRetryStatusCodes is list of status codes retried by default
+var DefaultRetryStatusCodes = []int{429, 502, 503, 504}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DefaultRetryPolicy returns retry policy with default settings
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   250 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// canRetry returns true if request can be retried
+func (p *RetryPolicy) canRetry(method string, attempt int, resp *response, err error) bool {
+	switch {
+	case p == nil,
+		attempt >= p.MaxAttempts,
+		!p.RetryNonIdempotent && !isIdempotentMethod(method):
+		return false
+	case err != nil:
+		return true
+	case p.MaxDelay > 0 && resp.retryAfter > p.MaxDelay:
+		// Retrying earlier than server asks is pointless
+		return false
+	}
+
+	if len(p.StatusCodes) == 0 {
+		return slices.Contains(DefaultRetryStatusCodes, resp.statusCode)
+	}
+
+	return slices.Contains(p.StatusCodes, resp.statusCode)
+}
+
+// getDelay returns delay before the next attempt
+func (p *RetryPolicy) getDelay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+
+	if delay < 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		delay -= time.Duration(float64(delay) * min(p.Jitter, 1.0) * rand.Float64())
+	}
+
+	return max(delay, retryAfter)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// isIdempotentMethod returns true if HTTP method is idempotent
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+
+	return false
+}
+
+// parseRetryAfter parses value of Retry-After header
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+
+	if err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+
+	date, err := http.ParseTime(value)
+
+	if err != nil {
+		return 0
+	}
+
+	return max(time.Until(date), 0)
+}
@@ -0,0 +1,320 @@
+// Package roles provides mapping of Crowd groups to application roles
+package roles
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/essentialkaos/go-crowd/v3"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Mapping maps Crowd group to application role
+type Mapping struct {
+	// Group is group name or glob pattern (i.e. "team-*-admins")
+	Group string `json:"group"`
+
+	// Role is application role
+	Role string `json:"role"`
+
+	// Priority is role priority, role with the highest priority is the primary
+	// role of user
+	Priority int `json:"priority,omitempty"`
+
+	// DirectOnly disables granting role to nested members of the group
+	DirectOnly bool `json:"direct_only,omitempty"`
+}
+
+// RoleMapper resolves application roles of Crowd users
+type RoleMapper struct {
+	api      *crowd.API
+	mappings []*Mapping
+}
+
+// RoleSet is set of resolved user roles
+type RoleSet struct {
+	// Roles is list of roles ordered by priority
+	Roles []string
+
+	// Sources contains names of groups which grant every role
+	Sources map[string][]string
+}
+
+// Grant contains info about group which grants role
+type Grant struct {
+	Group   string   // Group which grants role
+	Via     string   // Mapped group if group is its nested child group
+	Mapping *Mapping // Mapping which grants role
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Errors
+var (
+	ErrEmptyGroup = errors.New("Mapping group can't be empty")
+	ErrEmptyRole  = errors.New("Mapping role can't be empty")
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ReadMappings reads mappings from JSON file
+func ReadMappings(file string) ([]*Mapping, error) {
+	fd, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return DecodeMappings(fd)
+}
+
+// DecodeMappings decodes JSON-encoded list of mappings
+func DecodeMappings(r io.Reader) ([]*Mapping, error) {
+	var mappings []*Mapping
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(&mappings)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode mappings: %w", err)
+	}
+
+	return mappings, nil
+}
+
+// NewRoleMapper creates new role mapper with given mappings
+func NewRoleMapper(api *crowd.API, mappings ...*Mapping) (*RoleMapper, error) {
+	for i, m := range mappings {
+		switch {
+		case m.Group == "":
+			return nil, fmt.Errorf("Invalid mapping %d: %w", i, ErrEmptyGroup)
+		case m.Role == "":
+			return nil, fmt.Errorf("Invalid mapping %d: %w", i, ErrEmptyRole)
+		}
+
+		_, err := path.Match(m.Group, "")
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid mapping %d: pattern %q is malformed", i, m.Group)
+		}
+	}
+
+	return &RoleMapper{api: api, mappings: mappings}, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Resolve resolves roles of user with given name
+func (m *RoleMapper) Resolve(ctx context.Context, userName string) (*RoleSet, error) {
+	api := m.api.WithContext(ctx)
+	nested, err := fetchGroups(userName, api.GetUserNestedGroups)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var direct []string
+
+	if slices.ContainsFunc(m.mappings, func(m *Mapping) bool { return m.DirectOnly }) {
+		direct, err = fetchGroups(userName, api.GetUserDirectGroups)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m.ResolveGroups(direct, nested), nil
+}
+
+// ResolveGroups resolves roles for given direct and nested (including direct)
+// groups
+func (m *RoleMapper) ResolveGroups(direct, nested []string) *RoleSet {
+	result := &RoleSet{Sources: make(map[string][]string)}
+	priorities := make(map[string]int)
+
+	for _, mapping := range m.mappings {
+		groups := nested
+
+		if mapping.DirectOnly {
+			groups = direct
+		}
+
+		for _, group := range groups {
+			if !mapping.Match(group) {
+				continue
+			}
+
+			priority, ok := priorities[mapping.Role]
+
+			if !ok || mapping.Priority > priority {
+				priorities[mapping.Role] = mapping.Priority
+			}
+
+			if !slices.Contains(result.Sources[mapping.Role], group) {
+				result.Sources[mapping.Role] = append(result.Sources[mapping.Role], group)
+			}
+		}
+	}
+
+	result.Roles = slices.SortedFunc(maps.Keys(priorities), func(a, b string) int {
+		return cmp.Or(cmp.Compare(priorities[b], priorities[a]), cmp.Compare(a, b))
+	})
+
+	for _, groups := range result.Sources {
+		slices.Sort(groups)
+	}
+
+	return result
+}
+
+// GroupsForRole returns all Crowd groups which grant given role, including
+// nested child groups of mapped groups
+func (m *RoleMapper) GroupsForRole(ctx context.Context, role string) ([]*Grant, error) {
+	var result []*Grant
+
+	api := m.api.WithContext(ctx)
+
+	for _, mapping := range m.mappings {
+		if mapping.Role != role {
+			continue
+		}
+
+		groups, err := m.findGroups(api, mapping)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range groups {
+			result = append(result, &Grant{Group: group, Mapping: mapping})
+
+			if mapping.DirectOnly {
+				continue
+			}
+
+			children, err := fetchGroups(group, api.GetGroupNestedChildGroups)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, child := range children {
+				result = append(result, &Grant{Group: child, Via: group, Mapping: mapping})
+			}
+		}
+	}
+
+	slices.SortStableFunc(result, func(a, b *Grant) int {
+		return cmp.Or(cmp.Compare(a.Group, b.Group), cmp.Compare(a.Via, b.Via))
+	})
+
+	return result, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Match returns true if mapping matches group with given name. Crowd group
+// names are case-insensitive.
+func (m *Mapping) Match(group string) bool {
+	ok, _ := path.Match(strings.ToLower(m.Group), strings.ToLower(group))
+	return ok
+}
+
+// IsPattern returns true if mapping group is a glob pattern
+func (m *Mapping) IsPattern() bool {
+	return strings.ContainsAny(m.Group, `*?[\`)
+}
+
+// Has returns true if set contains given role
+func (r *RoleSet) Has(role string) bool {
+	return r != nil && slices.Contains(r.Roles, role)
+}
+
+// Primary returns role with the highest priority
+func (r *RoleSet) Primary() string {
+	if r == nil || len(r.Roles) == 0 {
+		return ""
+	}
+
+	return r.Roles[0]
+}
+
+// String returns grant description
+func (g *Grant) String() string {
+	if g.Via != "" {
+		return fmt.Sprintf("%s (nested in %s, mapped by %q)", g.Group, g.Via, g.Mapping.Group)
+	}
+
+	return fmt.Sprintf("%s (mapped by %q)", g.Group, g.Mapping.Group)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// findGroups returns names of existing groups matching mapping
+func (m *RoleMapper) findGroups(api *crowd.API, mapping *Mapping) ([]string, error) {
+	if !mapping.IsPattern() {
+		group, err := api.GetGroup(mapping.Group, false)
+
+		switch {
+		case err == crowd.ErrGroupNoFound:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+
+		return []string{group.Name}, nil
+	}
+
+	prefix := mapping.Group[:strings.IndexAny(mapping.Group, `*?[\`)]
+	cql := fmt.Sprintf(`name = "%s*"`, strings.ReplaceAll(prefix, `"`, `\"`))
+
+	groups, err := fetchGroups(cql, api.SearchGroups)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(groups, func(g string) bool { return !mapping.Match(g) }), nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// fetchGroups fetches names of all groups using given function
+func fetchGroups(arg string, fetchFunc func(string, ...crowd.ListingOptions) ([]*crowd.Group, error)) ([]string, error) {
+	groups, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+		return fetchFunc(arg, opts)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	for _, g := range groups {
+		result = append(result, g.Name)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,185 @@
+package roles
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type RolesSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&RolesSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *RolesSuite) TestMappings(c *C) {
+	mappings, err := DecodeMappings(strings.NewReader(`[
+		{"group": "admins", "role": "admin", "priority": 100},
+		{"group": "team-*", "role": "user", "direct_only": true}
+	]`))
+
+	c.Assert(err, IsNil)
+	c.Assert(mappings, DeepEquals, []*Mapping{
+		{Group: "admins", Role: "admin", Priority: 100},
+		{Group: "team-*", Role: "user", DirectOnly: true},
+	})
+
+	_, err = DecodeMappings(strings.NewReader(`[{"name": "admins"}]`))
+	c.Assert(err, NotNil)
+
+	file := c.MkDir() + "/roles.json"
+	os.WriteFile(file, []byte(`[{"group": "admins", "role": "admin"}]`), 0644)
+
+	mappings, err = ReadMappings(file)
+	c.Assert(err, IsNil)
+	c.Assert(mappings, HasLen, 1)
+
+	_, err = ReadMappings(c.MkDir() + "/unknown.json")
+	c.Assert(err, NotNil)
+
+	_, err = NewRoleMapper(nil, &Mapping{Role: "admin"})
+	c.Assert(errors.Is(err, ErrEmptyGroup), Equals, true)
+	_, err = NewRoleMapper(nil, &Mapping{Group: "admins"})
+	c.Assert(errors.Is(err, ErrEmptyRole), Equals, true)
+	_, err = NewRoleMapper(nil, &Mapping{Group: "admins[", Role: "admin"})
+	c.Assert(err, ErrorMatches, `Invalid mapping 0: pattern "admins\[" is malformed`)
+
+	m := &Mapping{Group: "Team-*-Admins"}
+
+	c.Assert(m.IsPattern(), Equals, true)
+	c.Assert(m.Match("team-ops-admins"), Equals, true)
+	c.Assert(m.Match("team-ops-users"), Equals, false)
+	c.Assert((&Mapping{Group: "admins"}).IsPattern(), Equals, false)
+}
+
+func (s *RolesSuite) TestResolve(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	m, err := NewRoleMapper(api, testMappings()...)
+	c.Assert(err, IsNil)
+
+	roles, err := m.Resolve(context.Background(), "john")
+
+	c.Assert(err, IsNil)
+	c.Assert(roles.Roles, DeepEquals, []string{"admin", "developer", "viewer"})
+	c.Assert(roles.Primary(), Equals, "admin")
+	c.Assert(roles.Has("developer"), Equals, true)
+	c.Assert(roles.Has("owner"), Equals, false)
+	c.Assert(roles.Sources, DeepEquals, map[string][]string{
+		"admin":     {"admins"},
+		"developer": {"team-core-devs"},
+		"viewer":    {"admins", "all", "team-core-devs"},
+	})
+
+	// Bob is a nested member of "team-ops-devs" and "owners"
+	roles, err = m.Resolve(context.Background(), "bob")
+
+	c.Assert(err, IsNil)
+	c.Assert(roles.Roles, DeepEquals, []string{"developer", "viewer"})
+
+	roles, err = m.Resolve(context.Background(), "mary")
+
+	c.Assert(err, IsNil)
+	c.Assert(roles.Roles, HasLen, 0)
+	c.Assert(roles.Primary(), Equals, "")
+	c.Assert((*RoleSet)(nil).Has("admin"), Equals, false)
+	c.Assert((*RoleSet)(nil).Primary(), Equals, "")
+
+	_, err = m.Resolve(context.Background(), "unknown")
+	c.Assert(err, Equals, crowd.ErrUserNoFound)
+
+	m, _ = NewRoleMapper(api, &Mapping{Group: "owners", Role: "owner", DirectOnly: true})
+	_, err = m.Resolve(context.Background(), "unknown")
+	c.Assert(err, Equals, crowd.ErrUserNoFound)
+}
+
+func (s *RolesSuite) TestGroupsForRole(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	m, _ := NewRoleMapper(api, testMappings()...)
+
+	grants, err := m.GroupsForRole(context.Background(), "developer")
+
+	c.Assert(err, IsNil)
+	c.Assert(grants, HasLen, 3)
+	c.Assert(grants[0].String(), Equals, `qa (nested in team-ops-devs, mapped by "team-*-devs")`)
+	c.Assert(grants[1].String(), Equals, `team-core-devs (mapped by "team-*-devs")`)
+	c.Assert(grants[2].String(), Equals, `team-ops-devs (mapped by "team-*-devs")`)
+
+	grants, err = m.GroupsForRole(context.Background(), "owner")
+
+	c.Assert(err, IsNil)
+	c.Assert(grants, HasLen, 1)
+	c.Assert(grants[0].Group, Equals, "owners")
+
+	grants, err = m.GroupsForRole(context.Background(), "unknown")
+
+	c.Assert(err, IsNil)
+	c.Assert(grants, HasLen, 0)
+
+	m, _ = NewRoleMapper(api, &Mapping{Group: "unknown", Role: "admin"})
+	grants, err = m.GroupsForRole(context.Background(), "admin")
+
+	c.Assert(err, IsNil)
+	c.Assert(grants, HasLen, 0)
+
+	api, _ = crowd.NewAPI("http://127.0.0.1:1/", "app", "test")
+
+	for _, group := range []string{"admins", "admins-*"} {
+		m, _ = NewRoleMapper(api, &Mapping{Group: group, Role: "admin"})
+		_, err = m.GroupsForRole(context.Background(), "admin")
+		c.Assert(err, NotNil)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func testMappings() []*Mapping {
+	return []*Mapping{
+		{Group: "admins", Role: "admin", Priority: 100},
+		{Group: "team-*-devs", Role: "developer", Priority: 50},
+		{Group: "owners", Role: "owner", Priority: 200, DirectOnly: true},
+		{Group: "*", Role: "viewer"},
+	}
+}
+
+func newTestServer() (*crowdtest.Server, *crowd.API) {
+	srv := crowdtest.NewServer()
+
+	for _, name := range []string{"john", "bob", "mary"} {
+		srv.AddUser(&crowdtest.User{Name: name, IsActive: true})
+	}
+
+	srv.AddGroup(&crowdtest.Group{Name: "admins", Users: []string{"john"}})
+	srv.AddGroup(&crowdtest.Group{Name: "all", Users: []string{"john"}})
+	srv.AddGroup(&crowdtest.Group{Name: "team-core-devs", Users: []string{"john"}})
+	srv.AddGroup(&crowdtest.Group{Name: "team-ops-devs", Groups: []string{"qa"}})
+	srv.AddGroup(&crowdtest.Group{Name: "qa", Users: []string{"bob"}})
+	srv.AddGroup(&crowdtest.Group{Name: "owners", Groups: []string{"qa"}})
+
+	api, _ := crowd.NewAPI(srv.URL(), "app", "test")
+
+	return srv, api
+}
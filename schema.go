@@ -0,0 +1,296 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// AttributeSchema describes allowed attributes and their values
+type AttributeSchema struct {
+	// Rules is list of rules for attributes
+	Rules []*AttributeRule
+
+	// Strict disallows attributes without rules. Attributes maintained by
+	// Crowd (i.e. lastAuthenticated) and by this package (i.e. TEMP_MEMBERS_ATTR)
+	// are always allowed.
+	Strict bool
+}
+
+// AttributeRule contains rule for single attribute
+type AttributeRule struct {
+	// Name is attribute name
+	Name string
+
+	// Required marks attribute as required (it can't be deleted)
+	Required bool
+
+	// MultiValued allows attribute to have more than one value
+	MultiValued bool
+
+	// Pattern is regular expression every value must match
+	Pattern *regexp.Regexp
+
+	// MaxLength is maximum length of every value in characters (0 means no limit)
+	MaxLength int
+}
+
+// ValidationError contains all schema violations
+type ValidationError struct {
+	Violations []*Violation
+}
+
+// Violation contains info about single schema violation
+type Violation struct {
+	Attribute string // Attribute name
+	Value     string // Invalid value
+	Message   string // Violation description
+}
+
+// SchemaReport contains result of checking existing data against the schema
+type SchemaReport struct {
+	Checked    int                         // Number of checked entities
+	Violations map[string]*ValidationError // Entity name → violations
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// internalAttributes is set of attributes maintained by Crowd and by this package
+var internalAttributes = map[string]bool{
+	"autoGroupsAdded":         true,
+	"invalidPasswordAttempts": true,
+	"lastActive":              true,
+	"lastAuthenticated":       true,
+	"passwordLastChanged":     true,
+	"requiresPasswordChange":  true,
+	TEMP_MEMBERS_ATTR:         true,
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Validate checks full set of attributes against the schema
+func (s *AttributeSchema) Validate(attrs Attributes) error {
+	if s == nil {
+		return nil
+	}
+
+	violations := s.check(attrs)
+
+	for _, rule := range s.Rules {
+		if rule.Required && len(attrs.GetList(rule.Name)) == 0 {
+			violations = append(violations, &Violation{
+				Attribute: rule.Name,
+				Message:   "required attribute is missing",
+			})
+		}
+	}
+
+	return makeValidationError(violations)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// CheckUsersAttributes checks attributes of users with given names against
+// user attributes schema
+func (api *API) CheckUsersAttributes(userNames ...string) (*SchemaReport, error) {
+	return checkEntitiesAttributes(api.UserSchema, userNames, api.GetUserAttributes)
+}
+
+// CheckGroupsAttributes checks attributes of groups with given names against
+// group attributes schema
+func (api *API) CheckGroupsAttributes(groupNames ...string) (*SchemaReport, error) {
+	return checkEntitiesAttributes(api.GroupSchema, groupNames, api.GetGroupAttributes)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// IsValid returns true if there are no violations
+func (r *SchemaReport) IsValid() bool {
+	return r == nil || len(r.Violations) == 0
+}
+
+// String returns human-readable report
+func (r *SchemaReport) String() string {
+	if r.IsValid() {
+		return "No violations found"
+	}
+
+	var result strings.Builder
+
+	for _, name := range slices.Sorted(maps.Keys(r.Violations)) {
+		fmt.Fprintf(&result, "%s:\n", name)
+
+		for _, v := range r.Violations[name].Violations {
+			fmt.Fprintf(&result, "  - %s\n", v)
+		}
+	}
+
+	return result.String()
+}
+
+// Error returns error message
+func (e *ValidationError) Error() string {
+	var messages []string
+
+	for _, v := range e.Violations {
+		messages = append(messages, v.String())
+	}
+
+	return "Attributes validation failed: " + strings.Join(messages, "; ")
+}
+
+// String returns violation description
+func (v *Violation) String() string {
+	if v.Value == "" {
+		return v.Attribute + ": " + v.Message
+	}
+
+	return fmt.Sprintf("%s: %s (value %q)", v.Attribute, v.Message, v.Value)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateChanges validates updated and removed attributes
+func (s *AttributeSchema) validateChanges(update Attributes, removed ...string) error {
+	if s == nil {
+		return nil
+	}
+
+	violations := s.check(update)
+
+	for _, name := range removed {
+		rule := s.getRule(name)
+
+		if rule != nil && rule.Required {
+			violations = append(violations, &Violation{
+				Attribute: name,
+				Message:   "required attribute can't be deleted",
+			})
+		}
+	}
+
+	return makeValidationError(violations)
+}
+
+// check checks values of given attributes
+func (s *AttributeSchema) check(attrs Attributes) []*Violation {
+	var violations []*Violation
+
+	for _, attr := range attrs {
+		rule := s.getRule(attr.Name)
+
+		if rule == nil {
+			if s.Strict && !internalAttributes[attr.Name] {
+				violations = append(violations, &Violation{
+					Attribute: attr.Name,
+					Message:   "attribute is not allowed by schema",
+				})
+			}
+
+			continue
+		}
+
+		violations = append(violations, rule.check(attr)...)
+	}
+
+	return violations
+}
+
+// getRule returns rule for attribute with given name
+func (s *AttributeSchema) getRule(name string) *AttributeRule {
+	for _, rule := range s.Rules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// check checks attribute values
+func (r *AttributeRule) check(attr *Attribute) []*Violation {
+	var violations []*Violation
+
+	if !r.MultiValued && len(attr.Values) > 1 {
+		violations = append(violations, &Violation{
+			Attribute: attr.Name,
+			Message:   fmt.Sprintf("attribute must be single-valued, but has %d values", len(attr.Values)),
+		})
+	}
+
+	if r.Required && len(attr.Values) == 0 {
+		violations = append(violations, &Violation{
+			Attribute: attr.Name,
+			Message:   "required attribute must have a value",
+		})
+	}
+
+	for _, value := range attr.Values {
+		if r.MaxLength > 0 && utf8.RuneCountInString(value) > r.MaxLength {
+			violations = append(violations, &Violation{
+				Attribute: attr.Name,
+				Value:     value,
+				Message:   fmt.Sprintf("value is longer than %d characters", r.MaxLength),
+			})
+		}
+
+		if r.Pattern != nil && !r.Pattern.MatchString(value) {
+			violations = append(violations, &Violation{
+				Attribute: attr.Name,
+				Value:     value,
+				Message:   fmt.Sprintf("value doesn't match pattern %q", r.Pattern),
+			})
+		}
+	}
+
+	return violations
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// checkEntitiesAttributes checks attributes of given entities
+func checkEntitiesAttributes(
+	schema *AttributeSchema, names []string,
+	getFunc func(name string) (Attributes, error),
+) (*SchemaReport, error) {
+	report := &SchemaReport{Violations: make(map[string]*ValidationError)}
+
+	for _, name := range names {
+		attrs, err := getFunc(name)
+
+		if err != nil {
+			return nil, fmt.Errorf("Can't get attributes of %q: %w", name, err)
+		}
+
+		report.Checked++
+
+		err = schema.Validate(attrs)
+
+		if err != nil {
+			report.Violations[name] = err.(*ValidationError)
+		}
+	}
+
+	return report, nil
+}
+
+// makeValidationError creates validation error if there are any violations
+func makeValidationError(violations []*Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return &ValidationError{violations}
+}
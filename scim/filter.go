@@ -0,0 +1,321 @@
+package scim
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// maxFilterDepth is maximum nesting depth of filters
+const maxFilterDepth = 32
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// filterParser translates SCIM filter (RFC 7644, section 3.4.2.2) to Crowd
+// search restriction
+type filterParser struct {
+	tokens []string
+	pos    int
+	depth  int
+	prefix string            // attribute prefix inside value path
+	fields map[string]string // SCIM attribute → Crowd search field
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// userFilterFields maps user attributes to Crowd search fields
+var userFilterFields = map[string]string{
+	"id":              "name",
+	"username":        "name",
+	"name.givenname":  "firstName",
+	"name.familyname": "lastName",
+	"displayname":     "displayName",
+	"emails":          "email",
+	"emails.value":    "email",
+	"active":          "active",
+}
+
+// groupFilterFields maps group attributes to Crowd search fields
+var groupFilterFields = map[string]string{
+	"id":          "name",
+	"displayname": "name",
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// translateFilter translates filter to Crowd search restriction. Only filters
+// which can be translated exactly are supported, so pagination of search
+// results matches pagination of SCIM listing.
+func translateFilter(filter string, fields map[string]string) (string, error) {
+	tokens, err := tokenizeFilter(filter)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(tokens) == 0 {
+		return "", nil
+	}
+
+	p := &filterParser{tokens: tokens, fields: fields}
+	cql, err := p.parseOr()
+
+	if err != nil {
+		return "", err
+	}
+
+	if p.pos != len(p.tokens) {
+		return "", errInvalidFilter("Unexpected token " + p.tokens[p.pos])
+	}
+
+	return cql, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseOr parses "or" expression
+func (p *filterParser) parseOr() (string, error) {
+	return p.parseLogic("or", p.parseAnd)
+}
+
+// parseAnd parses "and" expression
+func (p *filterParser) parseAnd() (string, error) {
+	return p.parseLogic("and", p.parseFactor)
+}
+
+// parseLogic parses expressions joined with given operator
+func (p *filterParser) parseLogic(op string, next func() (string, error)) (string, error) {
+	var terms []string
+
+	for {
+		term, err := next()
+
+		if err != nil {
+			return "", err
+		}
+
+		terms = append(terms, term)
+
+		if !p.accept(op) {
+			break
+		}
+	}
+
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+
+	return "(" + strings.Join(terms, " "+op+" ") + ")", nil
+}
+
+// parseFactor parses expression in parentheses, value path or comparison
+func (p *filterParser) parseFactor() (string, error) {
+	if p.depth++; p.depth > maxFilterDepth {
+		return "", errInvalidFilter("Filter is too deep")
+	}
+
+	defer func() { p.depth-- }()
+
+	switch {
+	case p.accept("("):
+		cql, err := p.parseOr()
+
+		if err != nil {
+			return "", err
+		}
+
+		return cql, p.expect(")")
+
+	case p.accept("not"):
+		return "", errInvalidFilter("Operator \"not\" is not supported")
+	}
+
+	attr, err := p.next()
+
+	if err != nil {
+		return "", err
+	}
+
+	attr = p.prefix + normalizePath(attr)
+
+	if p.accept("[") {
+		if p.prefix != "" {
+			return "", errInvalidFilter("Nested value paths are not supported")
+		}
+
+		p.prefix = attr + "."
+		cql, err := p.parseOr()
+		p.prefix = ""
+
+		if err != nil {
+			return "", err
+		}
+
+		return cql, p.expect("]")
+	}
+
+	op, err := p.next()
+
+	if err != nil {
+		return "", err
+	}
+
+	op = strings.ToLower(op)
+
+	if op == "pr" {
+		return "", errInvalidFilter("Operator \"pr\" is not supported")
+	}
+
+	token, err := p.next()
+
+	if err != nil {
+		return "", err
+	}
+
+	return p.translateComparison(attr, op, token)
+}
+
+// translateComparison translates attribute comparison to restriction term
+func (p *filterParser) translateComparison(attr, op, token string) (string, error) {
+	field := p.fields[attr]
+
+	if field == "" {
+		return "", errInvalidFilter("Filtering by attribute " + attr + " is not supported")
+	}
+
+	if field == "active" {
+		if op != "eq" || (token != "true" && token != "false") {
+			return "", errInvalidFilter("Attribute active supports only equality with boolean value")
+		}
+
+		return field + " = " + token, nil
+	}
+
+	var value string
+
+	if !strings.HasPrefix(token, `"`) || json.Unmarshal([]byte(token), &value) != nil {
+		return "", errInvalidFilter("Attribute " + attr + " must be compared with string")
+	}
+
+	// Crowd treats * as wildcard and there is no way to escape it
+	if strings.Contains(value, "*") {
+		return "", errInvalidFilter("Values with * are not supported")
+	}
+
+	switch op {
+	case "eq":
+	case "sw":
+		value = value + "*"
+	case "ew":
+		value = "*" + value
+	case "co":
+		value = "*" + value + "*"
+	default:
+		return "", errInvalidFilter("Operator \"" + op + "\" is not supported")
+	}
+
+	return field + " = " + quoteCQL(value), nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// accept consumes next token if it is equal to given one
+func (p *filterParser) accept(token string) bool {
+	if p.pos < len(p.tokens) && strings.EqualFold(p.tokens[p.pos], token) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+// expect consumes next token or returns error if it isn't equal to given one
+func (p *filterParser) expect(token string) error {
+	if !p.accept(token) {
+		return errInvalidFilter("Expected " + token)
+	}
+
+	return nil
+}
+
+// next consumes next token
+func (p *filterParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", errInvalidFilter("Unexpected end of filter")
+	}
+
+	p.pos++
+
+	return p.tokens[p.pos-1], nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// tokenizeFilter splits filter into tokens. Strings are kept quoted.
+func tokenizeFilter(filter string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; {
+		case c == ' ':
+			i++
+
+		case strings.IndexByte("()[]", c) != -1:
+			tokens = append(tokens, string(c))
+			i++
+
+		case c == '"':
+			j := i + 1
+
+			for ; j < len(filter) && filter[j] != '"'; j++ {
+				if filter[j] == '\\' {
+					j++
+				}
+			}
+
+			if j >= len(filter) {
+				return nil, errInvalidFilter("Unterminated string")
+			}
+
+			tokens = append(tokens, filter[i:j+1])
+			i = j + 1
+
+		default:
+			j := strings.IndexAny(filter[i:], ` ()[]"`)
+
+			if j == -1 {
+				j = len(filter) - i
+			}
+
+			tokens = append(tokens, filter[i:i+j])
+			i += j
+		}
+	}
+
+	return tokens, nil
+}
+
+// normalizePath converts attribute path to lower case and removes schema URN
+func normalizePath(path string) string {
+	path = strings.ToLower(path)
+
+	if strings.HasPrefix(path, "urn:") {
+		i := strings.LastIndexByte(path, ':')
+		path = path[i+1:]
+	}
+
+	return path
+}
+
+// quoteCQL quotes value for using in restriction
+func quoteCQL(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
@@ -0,0 +1,1221 @@
+// Package scim provides SCIM 2.0 service provider (RFC 7643, RFC 7644) backed
+// by Crowd
+package scim
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/essentialkaos/go-crowd/v3"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Schemas URNs
+const (
+	SCHEMA_USER          = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCHEMA_GROUP         = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCHEMA_LIST_RESPONSE = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCHEMA_PATCH_OP      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCHEMA_ERROR         = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// CONTENT_TYPE is SCIM media type
+const CONTENT_TYPE = "application/scim+json"
+
+// DEFAULT_MAX_COUNT is default maximum number of resources returned per page
+const DEFAULT_MAX_COUNT = 100
+
+// maxBodySize is maximum size of request body
+const maxBodySize = 1024 * 1024
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Handler is SCIM service provider which serves /Users and /Groups endpoints.
+// Resource ids are Crowd user and group names, so resources can't be renamed.
+// Attributes which have no Crowd counterpart are ignored.
+type Handler struct {
+	// Token is bearer token required from SCIM clients. If empty, all requests
+	// are rejected unless AllowAnonymous is set.
+	Token string
+
+	// AllowAnonymous disables authentication. Handler must be protected by
+	// other means then.
+	AllowAnonymous bool
+
+	// BaseURL is public URL of handler used for resource locations (i.e.
+	// "https://scim.domain.com/scim/v2"). If empty, locations are omitted.
+	BaseURL string
+
+	// MaxCount is maximum number of resources returned per page
+	MaxCount int
+
+	api *crowd.API
+}
+
+// User is SCIM user resource
+type User struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        *Name        `json:"name,omitempty"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []*Email     `json:"emails,omitempty"`
+	Active      *bool        `json:"active,omitempty"`
+	Password    string       `json:"password,omitempty"`
+	Groups      []*Reference `json:"groups,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// Name contains user name components
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Email contains user email
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Group is SCIM group resource
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []*Reference `json:"members,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// Reference is reference to user or group used in group members and user groups
+type Reference struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Meta contains resource metadata
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// ListResponse is response for listing requests
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// PatchRequest is PATCH request body
+type PatchRequest struct {
+	Schemas    []string          `json:"schemas"`
+	Operations []*PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is single PATCH operation
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Error is SCIM error response
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// New creates new SCIM handler
+func New(api *crowd.API) *Handler {
+	return &Handler{MaxCount: DEFAULT_MAX_COUNT, api: api}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Error returns error detail
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// ServeHTTP serves SCIM requests. Handler expects paths relative to SCIM base
+// URL, so it must be mounted using http.StripPrefix.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.AllowAnonymous && !h.isAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="SCIM"`)
+		writeError(w, newError(http.StatusUnauthorized, "", "Authorization required"))
+		return
+	}
+
+	resource, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	api := h.api.WithContext(r.Context())
+
+	var err error
+
+	switch {
+	case resource == "Users" && id == "" && r.Method == http.MethodGet:
+		err = h.listUsers(w, api, r.URL.Query())
+	case resource == "Users" && id == "" && r.Method == http.MethodPost:
+		err = h.createUser(w, api, r)
+	case resource == "Users" && id != "" && r.Method == http.MethodGet:
+		err = h.getUser(w, api, id)
+	case resource == "Users" && id != "" && r.Method == http.MethodPatch:
+		err = h.patchUser(w, api, id, r)
+	case resource == "Users" && id != "" && r.Method == http.MethodDelete:
+		err = api.DeleteUser(id)
+
+	case resource == "Groups" && id == "" && r.Method == http.MethodGet:
+		err = h.listGroups(w, api, r.URL.Query())
+	case resource == "Groups" && id == "" && r.Method == http.MethodPost:
+		err = h.createGroup(w, api, r)
+	case resource == "Groups" && id != "" && r.Method == http.MethodGet:
+		err = h.getGroup(w, api, id)
+	case resource == "Groups" && id != "" && r.Method == http.MethodPatch:
+		err = h.patchGroup(w, api, id, r)
+	case resource == "Groups" && id != "" && r.Method == http.MethodDelete:
+		err = api.DeleteGroup(id)
+
+	case resource == "Users", resource == "Groups":
+		err = newError(http.StatusMethodNotAllowed, "", "Method "+r.Method+" is not supported")
+	default:
+		err = newError(http.StatusNotFound, "", "Unknown resource")
+	}
+
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// isAuthorized returns true if request contains valid bearer token
+func (h *Handler) isAuthorized(r *http.Request) bool {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+	return h.Token != "" && strings.EqualFold(scheme, "Bearer") &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
+}
+
+// listUsers lists users matching filter
+func (h *Handler) listUsers(w http.ResponseWriter, api *crowd.API, query url.Values) error {
+	cql, start, count, err := h.parseListQuery(query, userFilterFields)
+
+	if err != nil {
+		return err
+	}
+
+	// One extra user is requested to find out if there are more results
+	users, err := api.SearchUsers(cql, crowd.ListingOptions{StartIndex: start - 1, MaxResults: count + 1})
+
+	if err != nil {
+		return err
+	}
+
+	resp := newListResponse(start, len(users))
+	withGroups := isRequested(query, "groups", false)
+
+	for _, u := range users[:min(count, len(users))] {
+		user, err := h.convertUser(api, u, withGroups)
+
+		if err != nil {
+			return err
+		}
+
+		resp.Resources = append(resp.Resources, user)
+	}
+
+	resp.ItemsPerPage = len(resp.Resources)
+
+	writeJSON(w, http.StatusOK, resp)
+
+	return nil
+}
+
+// getUser returns user
+func (h *Handler) getUser(w http.ResponseWriter, api *crowd.API, id string) error {
+	u, err := api.GetUser(id, false)
+
+	if err != nil {
+		return err
+	}
+
+	user, err := h.convertUser(api, u, true)
+
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, user)
+
+	return nil
+}
+
+// createUser creates new user
+func (h *Handler) createUser(w http.ResponseWriter, api *crowd.API, r *http.Request) error {
+	user := &User{}
+	err := decodeBody(w, r, user)
+
+	if err != nil {
+		return err
+	}
+
+	if user.UserName == "" {
+		return newError(http.StatusBadRequest, "invalidValue", "Attribute userName is required")
+	}
+
+	err = checkUnique(api.GetUser(user.UserName, false))
+
+	if err != nil {
+		return err
+	}
+
+	u := &crowd.User{
+		Name:        user.UserName,
+		DisplayName: user.DisplayName,
+		Email:       primaryEmail(user.Emails),
+		Password:    user.Password,
+		IsActive:    user.Active == nil || *user.Active,
+	}
+
+	if user.Name != nil {
+		u.FirstName, u.LastName = user.Name.GivenName, user.Name.FamilyName
+
+		if u.DisplayName == "" {
+			u.DisplayName = user.Name.Formatted
+		}
+	}
+
+	err = api.CreateUser(u)
+
+	if err != nil {
+		return err
+	}
+
+	u, err = api.GetUser(u.Name, false)
+
+	if err != nil {
+		return err
+	}
+
+	user, err = h.convertUser(api, u, true)
+
+	if err != nil {
+		return err
+	}
+
+	if user.Meta.Location != "" {
+		w.Header().Set("Location", user.Meta.Location)
+	}
+
+	writeJSON(w, http.StatusCreated, user)
+
+	return nil
+}
+
+// patchUser modifies user
+func (h *Handler) patchUser(w http.ResponseWriter, api *crowd.API, id string, r *http.Request) error {
+	ops, err := decodePatch(w, r)
+
+	if err != nil {
+		return err
+	}
+
+	u, err := api.GetUser(id, false)
+
+	if err != nil {
+		return err
+	}
+
+	orig := *u
+
+	var password string
+
+	for _, op := range ops {
+		err = applyUserOperation(u, op, &password)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if isUserModified(&orig, u) {
+		err = api.UpdateUser(u)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if password != "" {
+		err = api.SetUserPassword(u.Name, password)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	user, err := h.convertUser(api, u, true)
+
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, user)
+
+	return nil
+}
+
+// convertUser converts Crowd user to SCIM resource
+func (h *Handler) convertUser(api *crowd.API, u *crowd.User, withGroups bool) (*User, error) {
+	user := &User{
+		Schemas:     []string{SCHEMA_USER},
+		ID:          u.Name,
+		UserName:    u.Name,
+		DisplayName: u.DisplayName,
+		Active:      &u.IsActive,
+		Meta:        &Meta{ResourceType: "User", Location: h.location("Users", u.Name)},
+	}
+
+	if u.FirstName != "" || u.LastName != "" {
+		user.Name = &Name{
+			Formatted:  strings.TrimSpace(u.FirstName + " " + u.LastName),
+			GivenName:  u.FirstName,
+			FamilyName: u.LastName,
+		}
+	}
+
+	if u.Email != "" {
+		user.Emails = []*Email{{Value: u.Email, Type: "work", Primary: true}}
+	}
+
+	if !withGroups {
+		return user, nil
+	}
+
+	direct, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+		return api.GetUserDirectGroups(u.Name, opts)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	nested, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+		return api.GetUserNestedGroups(u.Name, opts)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	isDirect := make(map[string]bool, len(direct))
+
+	for _, g := range direct {
+		isDirect[strings.ToLower(g.Name)] = true
+	}
+
+	for _, g := range nested {
+		ref := h.reference("Groups", g.Name, "direct")
+
+		if !isDirect[strings.ToLower(g.Name)] {
+			ref.Type = "indirect"
+		}
+
+		user.Groups = append(user.Groups, ref)
+	}
+
+	return user, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// listGroups lists groups matching filter
+func (h *Handler) listGroups(w http.ResponseWriter, api *crowd.API, query url.Values) error {
+	cql, start, count, err := h.parseListQuery(query, groupFilterFields)
+
+	if err != nil {
+		return err
+	}
+
+	// One extra group is requested to find out if there are more results
+	groups, err := api.SearchGroups(cql, crowd.ListingOptions{StartIndex: start - 1, MaxResults: count + 1})
+
+	if err != nil {
+		return err
+	}
+
+	resp := newListResponse(start, len(groups))
+	withMembers := isRequested(query, "members", false)
+
+	for _, g := range groups[:min(count, len(groups))] {
+		group, err := h.convertGroup(api, g, withMembers)
+
+		if err != nil {
+			return err
+		}
+
+		resp.Resources = append(resp.Resources, group)
+	}
+
+	resp.ItemsPerPage = len(resp.Resources)
+
+	writeJSON(w, http.StatusOK, resp)
+
+	return nil
+}
+
+// getGroup returns group
+func (h *Handler) getGroup(w http.ResponseWriter, api *crowd.API, id string) error {
+	g, err := api.GetGroup(id, false)
+
+	if err != nil {
+		return err
+	}
+
+	group, err := h.convertGroup(api, g, true)
+
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, group)
+
+	return nil
+}
+
+// createGroup creates new group
+func (h *Handler) createGroup(w http.ResponseWriter, api *crowd.API, r *http.Request) error {
+	group := &Group{}
+	err := decodeBody(w, r, group)
+
+	if err != nil {
+		return err
+	}
+
+	if group.DisplayName == "" {
+		return newError(http.StatusBadRequest, "invalidValue", "Attribute displayName is required")
+	}
+
+	err = checkUnique(api.GetGroup(group.DisplayName, false))
+
+	if err != nil {
+		return err
+	}
+
+	err = api.CreateGroup(&crowd.Group{Name: group.DisplayName, IsActive: true})
+
+	if err != nil {
+		return err
+	}
+
+	for _, m := range group.Members {
+		err = addMember(api, group.DisplayName, m)
+
+		if err != nil {
+			// Group is removed, so client can fix members and retry
+			api.DeleteGroup(group.DisplayName)
+			return err
+		}
+	}
+
+	g, err := api.GetGroup(group.DisplayName, false)
+
+	if err != nil {
+		return err
+	}
+
+	group, err = h.convertGroup(api, g, true)
+
+	if err != nil {
+		return err
+	}
+
+	if group.Meta.Location != "" {
+		w.Header().Set("Location", group.Meta.Location)
+	}
+
+	writeJSON(w, http.StatusCreated, group)
+
+	return nil
+}
+
+// patchGroup modifies group. Response has no body, because group members list
+// may be huge.
+func (h *Handler) patchGroup(w http.ResponseWriter, api *crowd.API, id string, r *http.Request) error {
+	ops, err := decodePatch(w, r)
+
+	if err != nil {
+		return err
+	}
+
+	g, err := api.GetGroup(id, false)
+
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		err = applyGroupOperation(api, g.Name, op)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// convertGroup converts Crowd group to SCIM resource
+func (h *Handler) convertGroup(api *crowd.API, g *crowd.Group, withMembers bool) (*Group, error) {
+	group := &Group{
+		Schemas:     []string{SCHEMA_GROUP},
+		ID:          g.Name,
+		DisplayName: g.Name,
+		Meta:        &Meta{ResourceType: "Group", Location: h.location("Groups", g.Name)},
+	}
+
+	if !withMembers {
+		return group, nil
+	}
+
+	users, groups, err := fetchMembers(api, g.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range users {
+		group.Members = append(group.Members, h.reference("Users", name, "User"))
+	}
+
+	for _, name := range groups {
+		group.Members = append(group.Members, h.reference("Groups", name, "Group"))
+	}
+
+	return group, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseListQuery parses filter and pagination parameters
+func (h *Handler) parseListQuery(query url.Values, fields map[string]string) (string, int, int, error) {
+	cql, err := translateFilter(query.Get("filter"), fields)
+
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	start, count := 1, h.MaxCount
+
+	if query.Has("startIndex") {
+		start, err = strconv.Atoi(query.Get("startIndex"))
+
+		if err != nil {
+			return "", 0, 0, newError(http.StatusBadRequest, "invalidValue", "Invalid startIndex")
+		}
+	}
+
+	if query.Has("count") {
+		count, err = strconv.Atoi(query.Get("count"))
+
+		if err != nil {
+			return "", 0, 0, newError(http.StatusBadRequest, "invalidValue", "Invalid count")
+		}
+	}
+
+	// Values less than 1 are interpreted as 1 (startIndex) and 0 (count)
+	return cql, max(start, 1), min(max(count, 0), h.MaxCount), nil
+}
+
+// location returns location of resource
+func (h *Handler) location(resource, id string) string {
+	if h.BaseURL == "" {
+		return ""
+	}
+
+	return strings.TrimRight(h.BaseURL, "/") + "/" + resource + "/" + url.PathEscape(id)
+}
+
+// reference creates reference to resource
+func (h *Handler) reference(resource, id, typ string) *Reference {
+	return &Reference{Value: id, Display: id, Type: typ, Ref: h.location(resource, id)}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// applyUserOperation applies PATCH operation to user
+func applyUserOperation(u *crowd.User, op *PatchOperation, password *string) error {
+	if op.Path != "" {
+		if op.Op == "remove" {
+			return setUserValue(u, normalizePath(op.Path), nil, password)
+		}
+
+		return setUserValue(u, normalizePath(op.Path), op.Value, password)
+	}
+
+	if op.Op == "remove" {
+		return newError(http.StatusBadRequest, "noTarget", "Operation remove requires path")
+	}
+
+	values := map[string]json.RawMessage{}
+
+	if json.Unmarshal(op.Value, &values) != nil {
+		return newError(http.StatusBadRequest, "invalidValue", "Operation without path requires object value")
+	}
+
+	for path, value := range values {
+		err := setUserValue(u, normalizePath(path), value, password)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setUserValue sets user attribute. Nil value removes attribute.
+func setUserValue(u *crowd.User, path string, value json.RawMessage, password *string) error {
+	var err error
+
+	switch {
+	case path == "username":
+		var name string
+
+		if value != nil {
+			err = decodeValue(path, value, &name)
+		}
+
+		if err == nil && !strings.EqualFold(name, u.Name) {
+			err = newError(http.StatusBadRequest, "mutability", "Attribute userName can't be changed")
+		}
+
+	case path == "name":
+		name := &Name{}
+
+		if value != nil {
+			err = decodeValue(path, value, name)
+		}
+
+		if err == nil && (value == nil || name.GivenName != "") {
+			u.FirstName = name.GivenName
+		}
+
+		if err == nil && (value == nil || name.FamilyName != "") {
+			u.LastName = name.FamilyName
+		}
+
+	case path == "name.givenname":
+		u.FirstName, err = decodeString(path, value)
+
+	case path == "name.familyname":
+		u.LastName, err = decodeString(path, value)
+
+	case path == "displayname":
+		u.DisplayName, err = decodeString(path, value)
+
+	case strings.HasPrefix(path, "emails"):
+		u.Email, err = decodeEmail(path, value)
+
+	case path == "active":
+		u.IsActive, err = decodeBool(path, value)
+
+	case path == "password":
+		*password, err = decodeString(path, value)
+
+		if err == nil && *password == "" {
+			err = newError(http.StatusBadRequest, "invalidValue", "Password can't be empty")
+		}
+	}
+
+	return err
+}
+
+// isUserModified returns true if user info was modified
+func isUserModified(orig, u *crowd.User) bool {
+	return orig.FirstName != u.FirstName || orig.LastName != u.LastName ||
+		orig.DisplayName != u.DisplayName || orig.Email != u.Email ||
+		orig.IsActive != u.IsActive
+}
+
+// applyGroupOperation applies PATCH operation to group
+func applyGroupOperation(api *crowd.API, groupName string, op *PatchOperation) error {
+	path := normalizePath(op.Path)
+
+	switch {
+	case path == "" && op.Op != "remove":
+		values := map[string]json.RawMessage{}
+
+		if json.Unmarshal(op.Value, &values) != nil {
+			return newError(http.StatusBadRequest, "invalidValue", "Operation without path requires object value")
+		}
+
+		for path, value := range values {
+			err := applyGroupOperation(api, groupName, &PatchOperation{Op: op.Op, Path: path, Value: value})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case path == "":
+		return newError(http.StatusBadRequest, "noTarget", "Operation remove requires path")
+
+	case path == "displayname":
+		var name string
+
+		if op.Op != "remove" {
+			err := decodeValue(path, op.Value, &name)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if !strings.EqualFold(name, groupName) {
+			return newError(http.StatusBadRequest, "mutability", "Attribute displayName can't be changed")
+		}
+
+		return nil
+
+	case path == "members":
+		var members []*Reference
+
+		if op.Op != "remove" || len(op.Value) != 0 {
+			err := decodeValue(path, op.Value, &members)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return updateMembers(api, groupName, op.Op, members)
+
+	case strings.HasPrefix(path, "members["):
+		value, err := parseMemberPath(op.Path)
+
+		if err != nil {
+			return err
+		}
+
+		if op.Op != "remove" {
+			return newError(http.StatusBadRequest, "invalidPath", "Only remove is supported for member path")
+		}
+
+		return removeMember(api, groupName, &Reference{Value: value})
+	}
+
+	return nil
+}
+
+// updateMembers adds, removes or replaces group members. Removing without
+// members list removes all members.
+func updateMembers(api *crowd.API, groupName, op string, members []*Reference) error {
+	if op == "add" {
+		for _, m := range members {
+			err := addMember(api, groupName, m)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	users, groups, err := fetchMembers(api, groupName)
+
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{}
+
+	if op == "replace" {
+		for _, m := range members {
+			keep[strings.ToLower(m.Value)] = true
+		}
+	}
+
+	var current []*Reference
+
+	for _, name := range users {
+		current = append(current, &Reference{Value: name, Type: "User"})
+	}
+
+	for _, name := range groups {
+		current = append(current, &Reference{Value: name, Type: "Group"})
+	}
+
+	if op == "remove" && len(members) != 0 {
+		current = members
+	}
+
+	for _, m := range current {
+		if keep[strings.ToLower(m.Value)] {
+			continue
+		}
+
+		err = removeMember(api, groupName, m)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if op == "replace" {
+		return updateMembers(api, groupName, "add", members)
+	}
+
+	return nil
+}
+
+// addMember adds user or child group to group. Member without type is added
+// as user if such user exists, otherwise as group.
+func addMember(api *crowd.API, groupName string, m *Reference) error {
+	var err error
+
+	switch strings.ToLower(m.Type) {
+	case "user":
+		err = api.AddUserToGroup(m.Value, groupName)
+	case "group":
+		err = api.AddChildGroup(groupName, m.Value)
+	default:
+		err = api.AddUserToGroup(m.Value, groupName)
+
+		if errors.Is(err, crowd.ErrUserNoFound) {
+			err = api.AddChildGroup(groupName, m.Value)
+		}
+	}
+
+	switch {
+	case errors.Is(err, crowd.ErrMembershipExists):
+		return nil
+	case errors.Is(err, crowd.ErrUserNoFound), errors.Is(err, crowd.ErrGroupNoFound):
+		return newError(http.StatusBadRequest, "invalidValue", "Member "+m.Value+" doesn't exist")
+	}
+
+	return err
+}
+
+// removeMember removes user or child group from group
+func removeMember(api *crowd.API, groupName string, m *Reference) error {
+	var err error
+
+	switch strings.ToLower(m.Type) {
+	case "user":
+		err = api.RemoveUserFromGroup(m.Value, groupName)
+	case "group":
+		err = api.RemoveChildGroup(groupName, m.Value)
+	default:
+		err = api.RemoveUserFromGroup(m.Value, groupName)
+
+		if errors.Is(err, crowd.ErrMembershipNoFound) {
+			err = api.RemoveChildGroup(groupName, m.Value)
+		}
+	}
+
+	if errors.Is(err, crowd.ErrMembershipNoFound) {
+		return nil
+	}
+
+	return err
+}
+
+// fetchMembers returns names of direct members of group
+func fetchMembers(api *crowd.API, groupName string) ([]string, []string, error) {
+	users, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.User, error) {
+		return api.GetGroupDirectUsers(groupName, opts)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+		return api.GetGroupDirectChildGroups(groupName, opts)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var userNames, groupNames []string
+
+	for _, u := range users {
+		userNames = append(userNames, u.Name)
+	}
+
+	for _, g := range groups {
+		groupNames = append(groupNames, g.Name)
+	}
+
+	return userNames, groupNames, nil
+}
+
+// parseMemberPath extracts member id from 'members[value eq "id"]' path
+func parseMemberPath(path string) (string, error) {
+	tokens, err := tokenizeFilter(path)
+
+	if err != nil || len(tokens) != 6 || tokens[1] != "[" || tokens[5] != "]" ||
+		!strings.EqualFold(tokens[2], "value") || !strings.EqualFold(tokens[3], "eq") {
+		return "", newError(http.StatusBadRequest, "invalidPath", "Unsupported path "+path)
+	}
+
+	var value string
+
+	if json.Unmarshal([]byte(tokens[4]), &value) != nil {
+		return "", newError(http.StatusBadRequest, "invalidPath", "Unsupported path "+path)
+	}
+
+	return value, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// decodeBody decodes JSON request body
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v)
+
+	if err != nil {
+		return newError(http.StatusBadRequest, "invalidSyntax", "Can't decode request: "+err.Error())
+	}
+
+	return nil
+}
+
+// decodePatch decodes PATCH request and validates operations
+func decodePatch(w http.ResponseWriter, r *http.Request) ([]*PatchOperation, error) {
+	req := &PatchRequest{}
+	err := decodeBody(w, r, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Operations) == 0 {
+		return nil, newError(http.StatusBadRequest, "invalidSyntax", "Operations are required")
+	}
+
+	for _, op := range req.Operations {
+		if op == nil {
+			return nil, newError(http.StatusBadRequest, "invalidSyntax", "Operation can't be null")
+		}
+
+		op.Op = strings.ToLower(op.Op)
+
+		switch {
+		case op.Op != "add" && op.Op != "replace" && op.Op != "remove":
+			return nil, newError(http.StatusBadRequest, "invalidSyntax", "Unsupported operation "+op.Op)
+		case op.Op != "remove" && len(op.Value) == 0:
+			return nil, newError(http.StatusBadRequest, "invalidValue", "Operation "+op.Op+" requires value")
+		}
+	}
+
+	return req.Operations, nil
+}
+
+// decodeValue decodes attribute value
+func decodeValue(path string, value json.RawMessage, v any) error {
+	if json.Unmarshal(value, v) != nil {
+		return newError(http.StatusBadRequest, "invalidValue", "Invalid value of "+path)
+	}
+
+	return nil
+}
+
+// decodeString decodes string value. Nil value is decoded as empty string.
+func decodeString(path string, value json.RawMessage) (string, error) {
+	var result string
+
+	if value == nil {
+		return "", nil
+	}
+
+	return result, decodeValue(path, value, &result)
+}
+
+// decodeBool decodes boolean value. Some clients send booleans as strings.
+func decodeBool(path string, value json.RawMessage) (bool, error) {
+	var result bool
+
+	if value == nil {
+		return false, newError(http.StatusBadRequest, "mutability", "Attribute "+path+" can't be removed")
+	}
+
+	if json.Unmarshal(value, &result) == nil {
+		return result, nil
+	}
+
+	str, err := decodeString(path, value)
+
+	if err != nil {
+		return false, err
+	}
+
+	result, err = strconv.ParseBool(str)
+
+	if err != nil {
+		return false, newError(http.StatusBadRequest, "invalidValue", "Invalid value of "+path)
+	}
+
+	return result, nil
+}
+
+// decodeEmail decodes email from string, email object or list of emails
+func decodeEmail(path string, value json.RawMessage) (string, error) {
+	var email string
+	var emails []*Email
+
+	switch {
+	case value == nil:
+		return "", nil
+	case json.Unmarshal(value, &email) == nil:
+		return email, nil
+	case json.Unmarshal(value, &emails) == nil:
+		return primaryEmail(emails), nil
+	}
+
+	obj := &Email{}
+	err := decodeValue(path, value, obj)
+
+	return obj.Value, err
+}
+
+// primaryEmail returns primary email or first email if there is no primary one
+func primaryEmail(emails []*Email) string {
+	for _, e := range emails {
+		if e != nil && e.Primary {
+			return e.Value
+		}
+	}
+
+	for _, e := range emails {
+		if e != nil {
+			return e.Value
+		}
+	}
+
+	return ""
+}
+
+// isRequested returns true if attribute is requested using "attributes" or
+// "excludedAttributes" parameters
+func isRequested(query url.Values, attr string, byDefault bool) bool {
+	contains := func(param string) bool {
+		for _, a := range strings.Split(query.Get(param), ",") {
+			if normalizePath(strings.TrimSpace(a)) == attr {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	switch {
+	case contains("excludedAttributes"):
+		return false
+	case contains("attributes"):
+		return true
+	}
+
+	return byDefault
+}
+
+// checkUnique checks result of looking for existing resource
+func checkUnique[T any](_ T, err error) error {
+	switch {
+	case err == nil:
+		return newError(http.StatusConflict, "uniqueness", "Resource already exists")
+	case errors.Is(err, crowd.ErrUserNoFound), errors.Is(err, crowd.ErrGroupNoFound):
+		return nil
+	}
+
+	return err
+}
+
+// newListResponse creates list response. Crowd doesn't report total number of
+// results, so totalResults is a lower bound which exceeds the last index of the
+// page if more results are available.
+func newListResponse(start, fetched int) *ListResponse {
+	return &ListResponse{
+		Schemas:      []string{SCHEMA_LIST_RESPONSE},
+		TotalResults: start - 1 + fetched,
+		StartIndex:   start,
+		Resources:    []any{},
+	}
+}
+
+// newError creates new SCIM error
+func newError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{SCHEMA_ERROR},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// errInvalidFilter creates invalid filter error
+func errInvalidFilter(detail string) *Error {
+	return newError(http.StatusBadRequest, "invalidFilter", detail)
+}
+
+// writeJSON writes SCIM response
+func writeJSON(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	w.WriteHeader(statusCode)
+
+	json.NewEncoder(w).Encode(data)
+}
+
+// writeError writes error response
+func writeError(w http.ResponseWriter, err error) {
+	var scimErr *Error
+
+	switch {
+	case errors.As(err, &scimErr):
+	case errors.Is(err, crowd.ErrUserNoFound), errors.Is(err, crowd.ErrGroupNoFound):
+		scimErr = newError(http.StatusNotFound, "", err.Error())
+	case errors.Is(err, crowd.ErrInvalidUser), errors.Is(err, crowd.ErrInvalidGroup),
+		errors.Is(err, crowd.ErrInvalidPassword):
+		scimErr = newError(http.StatusBadRequest, "invalidValue", err.Error())
+	case errors.Is(err, crowd.ErrNoPerms):
+		scimErr = newError(http.StatusForbidden, "", err.Error())
+	default:
+		scimErr = newError(http.StatusServiceUnavailable, "", "Crowd is unavailable")
+	}
+
+	status, _ := strconv.Atoi(scimErr.Status)
+
+	writeJSON(w, status, scimErr)
+}
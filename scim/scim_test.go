@@ -0,0 +1,478 @@
+package scim
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type SCIMSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&SCIMSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *SCIMSuite) TestFilter(c *C) {
+	for filter, cql := range map[string]string{
+		``:                           ``,
+		`userName eq "john"`:         `name = "john"`,
+		`UserName Eq "jo\"hn"`:       `name = "jo\"hn"`,
+		`id eq "john"`:               `name = "john"`,
+		`name.givenName sw "Jo"`:     `firstName = "Jo*"`,
+		`name.familyName ew "oe"`:    `lastName = "*oe"`,
+		`displayName co "Doe"`:       `displayName = "*Doe*"`,
+		`emails[value eq "j@d.com"]`: `email = "j@d.com"`,
+		`emails.value eq "j@d.com"`:  `email = "j@d.com"`,
+		`active eq false`:            `active = false`,
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "john"`: `name = "john"`,
+		`userName eq "a" or userName eq "b" and active eq true`:         `(name = "a" or (name = "b" and active = true))`,
+		`(userName eq "a" or userName eq "b") and active eq true`:       `((name = "a" or name = "b") and active = true)`,
+	} {
+		result, err := translateFilter(filter, userFilterFields)
+		c.Assert(err, IsNil, Commentf("Filter: %s", filter))
+		c.Assert(result, Equals, cql, Commentf("Filter: %s", filter))
+	}
+
+	for _, filter := range []string{
+		`userName`, `userName eq`, `userName eq "john`, `userName ne "john"`,
+		`userName pr`, `not (userName eq "john")`, `title eq "CEO"`,
+		`userName eq "jo*"`, `userName eq true`, `active eq "true"`, `active sw true`,
+		`(userName eq "john"`, `userName eq "john")`, `emails[value eq "a" ]]`,
+		`emails[value[value eq "a"]]`, strings.Repeat("(", 40) + `userName eq "a"` + strings.Repeat(")", 40),
+	} {
+		_, err := translateFilter(filter, userFilterFields)
+		c.Assert(err, NotNil, Commentf("Filter: %s", filter))
+		c.Assert(err.(*Error).ScimType, Equals, "invalidFilter")
+	}
+
+	result, err := translateFilter(`displayName eq "devs"`, groupFilterFields)
+	c.Assert(err, IsNil)
+	c.Assert(result, Equals, `name = "devs"`)
+}
+
+func (s *SCIMSuite) TestUsers(c *C) {
+	srv, h := newTestServer()
+	defer srv.Close()
+
+	w := serve(h, "GET", "/Users/john", "")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Header().Get("Content-Type"), Equals, CONTENT_TYPE)
+
+	user := &User{}
+	json.Unmarshal(w.Body.Bytes(), user)
+
+	c.Assert(user.Schemas, DeepEquals, []string{SCHEMA_USER})
+	c.Assert(user.ID, Equals, "john")
+	c.Assert(user.UserName, Equals, "john")
+	c.Assert(user.Name, DeepEquals, &Name{Formatted: "John Doe", GivenName: "John", FamilyName: "Doe"})
+	c.Assert(user.DisplayName, Equals, "John Doe")
+	c.Assert(user.Emails, DeepEquals, []*Email{{Value: "john@domain.com", Type: "work", Primary: true}})
+	c.Assert(*user.Active, Equals, true)
+	c.Assert(user.Meta, DeepEquals, &Meta{ResourceType: "User", Location: "https://scim.domain.com/v2/Users/john"})
+	c.Assert(user.Groups, DeepEquals, []*Reference{
+		{"admins", "admins", "indirect", "https://scim.domain.com/v2/Groups/admins"},
+		{"devs", "devs", "direct", "https://scim.domain.com/v2/Groups/devs"},
+	})
+
+	w = serve(h, "GET", "/Users/unknown", "")
+	c.Assert(w.Code, Equals, 404)
+	c.Assert(decodeError(w).Status, Equals, "404")
+
+	w = serve(h, "POST", "/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "mary",
+		"name": {"givenName": "Mary", "familyName": "Smith", "formatted": "Mary Smith"},
+		"emails": [{"value": "mary@home.com"}, {"value": "mary@domain.com", "primary": true}],
+		"password": "test1234",
+		"externalId": "00u1"
+	}`)
+
+	c.Assert(w.Code, Equals, 201)
+	c.Assert(w.Header().Get("Location"), Equals, "https://scim.domain.com/v2/Users/mary")
+	c.Assert(srv.User("mary"), DeepEquals, &crowdtest.User{
+		Name: "mary", FirstName: "Mary", LastName: "Smith", DisplayName: "Mary Smith",
+		Email: "mary@domain.com", Password: "test1234", IsActive: true,
+		Attributes: map[string][]string{},
+	})
+
+	w = serve(h, "POST", "/Users", `{"userName": "mary"}`)
+	c.Assert(w.Code, Equals, 409)
+	c.Assert(decodeError(w).ScimType, Equals, "uniqueness")
+
+	w = serve(h, "POST", "/Users", `{"displayName": "Unknown"}`)
+	c.Assert(w.Code, Equals, 400)
+	c.Assert(decodeError(w).ScimType, Equals, "invalidValue")
+
+	w = serve(h, "POST", "/Users", `{`)
+	c.Assert(w.Code, Equals, 400)
+	c.Assert(decodeError(w).ScimType, Equals, "invalidSyntax")
+
+	w = serve(h, "PATCH", "/Users/mary", `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Replace", "path": "name.familyName", "value": "Jones"},
+			{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "mary.jones@domain.com"},
+			{"op": "replace", "value": {"active": "False", "displayName": "Mary Jones", "title": "CEO"}},
+			{"op": "replace", "path": "password", "value": "test5678"},
+			{"op": "add", "path": "userName", "value": "MARY"}
+		]
+	}`)
+
+	c.Assert(w.Code, Equals, 200)
+
+	user = &User{}
+	json.Unmarshal(w.Body.Bytes(), user)
+
+	c.Assert(user.Name.FamilyName, Equals, "Jones")
+	c.Assert(*user.Active, Equals, false)
+	c.Assert(srv.User("mary").LastName, Equals, "Jones")
+	c.Assert(srv.User("mary").Email, Equals, "mary.jones@domain.com")
+	c.Assert(srv.User("mary").DisplayName, Equals, "Mary Jones")
+	c.Assert(srv.User("mary").IsActive, Equals, false)
+	c.Assert(srv.User("mary").Password, Equals, "test5678")
+
+	w = serve(h, "PATCH", "/Users/mary", `{"Operations": [
+		{"op": "remove", "path": "emails"},
+		{"op": "replace", "path": "name", "value": {"givenName": "Maria"}},
+		{"op": "replace", "path": "active", "value": true}
+	]}`)
+
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(srv.User("mary").Email, Equals, "")
+	c.Assert(srv.User("mary").FirstName, Equals, "Maria")
+	c.Assert(srv.User("mary").LastName, Equals, "Jones")
+	c.Assert(srv.User("mary").IsActive, Equals, true)
+
+	srv.ResetRequests()
+
+	w = serve(h, "PATCH", "/Users/mary", `{"Operations": [{"op": "replace", "path": "displayName", "value": "Mary Jones"}]}`)
+	c.Assert(w.Code, Equals, 200)
+
+	for _, req := range srv.Requests() {
+		c.Assert(strings.HasPrefix(req, "PUT"), Equals, false)
+	}
+
+	for body, scimType := range map[string]string{
+		`{"Operations": []}`:     "invalidSyntax",
+		`{"Operations": [null]}`: "invalidSyntax",
+		`{"Operations": [{"op": "move", "path": "displayName"}]}`:                 "invalidSyntax",
+		`{"Operations": [{"op": "replace", "path": "displayName"}]}`:              "invalidValue",
+		`{"Operations": [{"op": "replace", "path": "displayName", "value": 1}]}`:  "invalidValue",
+		`{"Operations": [{"op": "replace", "path": "userName", "value": "bob"}]}`: "mutability",
+		`{"Operations": [{"op": "remove", "path": "userName"}]}`:                  "mutability",
+		`{"Operations": [{"op": "remove", "path": "active"}]}`:                    "mutability",
+		`{"Operations": [{"op": "replace", "path": "active", "value": "yes"}]}`:   "invalidValue",
+		`{"Operations": [{"op": "replace", "path": "password", "value": ""}]}`:    "invalidValue",
+		`{"Operations": [{"op": "replace", "path": "name", "value": "Mary"}]}`:    "invalidValue",
+		`{"Operations": [{"op": "replace", "path": "emails", "value": 1}]}`:       "invalidValue",
+		`{"Operations": [{"op": "replace", "value": "Mary"}]}`:                    "invalidValue",
+		`{"Operations": [{"op": "remove"}]}`:                                      "noTarget",
+	} {
+		w = serve(h, "PATCH", "/Users/mary", body)
+		c.Assert(w.Code, Equals, 400, Commentf("Body: %s", body))
+		c.Assert(decodeError(w).ScimType, Equals, scimType, Commentf("Body: %s", body))
+	}
+
+	w = serve(h, "PATCH", "/Users/unknown", `{"Operations": [{"op": "replace", "path": "displayName", "value": "Unknown"}]}`)
+	c.Assert(w.Code, Equals, 404)
+
+	w = serve(h, "DELETE", "/Users/mary", "")
+	c.Assert(w.Code, Equals, 204)
+	c.Assert(srv.User("mary"), IsNil)
+
+	w = serve(h, "DELETE", "/Users/mary", "")
+	c.Assert(w.Code, Equals, 404)
+
+	w = serve(h, "PUT", "/Users/john", "")
+	c.Assert(w.Code, Equals, 405)
+}
+
+func (s *SCIMSuite) TestListing(c *C) {
+	srv, h := newTestServer()
+	defer srv.Close()
+
+	resp := list(h, "/Users")
+	c.Assert(resp.Schemas, DeepEquals, []string{SCHEMA_LIST_RESPONSE})
+	c.Assert(resp.TotalResults, Equals, 3)
+	c.Assert(resp.StartIndex, Equals, 1)
+	c.Assert(resp.ItemsPerPage, Equals, 2)
+	c.Assert(resourceIDs(resp), DeepEquals, []string{"bob", "jane"})
+	c.Assert(resp.Resources[1].(map[string]any)["groups"], IsNil)
+
+	resp = list(h, "/Users?startIndex=2&count=1")
+	c.Assert(resp.TotalResults, Equals, 3)
+	c.Assert(resp.StartIndex, Equals, 2)
+	c.Assert(resp.ItemsPerPage, Equals, 1)
+	c.Assert(resourceIDs(resp), DeepEquals, []string{"jane"})
+
+	resp = list(h, "/Users?startIndex=3&count=1")
+	c.Assert(resp.TotalResults, Equals, 3)
+	c.Assert(resourceIDs(resp), DeepEquals, []string{"john"})
+
+	resp = list(h, "/Users?startIndex=0&count=0")
+	c.Assert(resp.StartIndex, Equals, 1)
+	c.Assert(resp.Resources, HasLen, 0)
+
+	resp = list(h, "/Users?count=1000")
+	c.Assert(resp.ItemsPerPage, Equals, 2)
+
+	resp = list(h, "/Users?filter="+url.QueryEscape(`name.familyName eq "doe" and active eq true`)+"&attributes=userName,groups")
+	c.Assert(resourceIDs(resp), DeepEquals, []string{"john"})
+	c.Assert(resp.Resources[0].(map[string]any)["groups"], HasLen, 2)
+
+	resp = list(h, "/Users?filter="+url.QueryEscape(`userName eq "unknown"`))
+	c.Assert(resp.TotalResults, Equals, 0)
+	c.Assert(resp.Resources, HasLen, 0)
+
+	resp = list(h, "/Groups?filter="+url.QueryEscape(`displayName sw "D"`))
+	c.Assert(resourceIDs(resp), DeepEquals, []string{"devs"})
+	c.Assert(resp.Resources[0].(map[string]any)["members"], IsNil)
+
+	resp = list(h, "/Groups?attributes=members&excludedAttributes=members")
+	c.Assert(resourceIDs(resp), DeepEquals, []string{"admins", "devs"})
+	c.Assert(resp.Resources[1].(map[string]any)["members"], IsNil)
+
+	resp = list(h, "/Groups?attributes=members")
+	c.Assert(resp.Resources[1].(map[string]any)["members"], HasLen, 3)
+
+	for _, uri := range []string{
+		"/Users?filter=" + url.QueryEscape(`title eq "CEO"`),
+		"/Users?startIndex=A", "/Users?count=A",
+		"/Groups?filter=" + url.QueryEscape(`members eq "john"`),
+	} {
+		w := serve(h, "GET", uri, "")
+		c.Assert(w.Code, Equals, 400, Commentf("URI: %s", uri))
+	}
+}
+
+func (s *SCIMSuite) TestGroups(c *C) {
+	srv, h := newTestServer()
+	defer srv.Close()
+
+	w := serve(h, "GET", "/Groups/devs", "")
+	c.Assert(w.Code, Equals, 200)
+
+	group := &Group{}
+	json.Unmarshal(w.Body.Bytes(), group)
+
+	c.Assert(group.Schemas, DeepEquals, []string{SCHEMA_GROUP})
+	c.Assert(group.ID, Equals, "devs")
+	c.Assert(group.DisplayName, Equals, "devs")
+	c.Assert(group.Meta, DeepEquals, &Meta{ResourceType: "Group", Location: "https://scim.domain.com/v2/Groups/devs"})
+	c.Assert(group.Members, DeepEquals, []*Reference{
+		{"jane", "jane", "User", "https://scim.domain.com/v2/Users/jane"},
+		{"john", "john", "User", "https://scim.domain.com/v2/Users/john"},
+		{"qa", "qa", "Group", "https://scim.domain.com/v2/Groups/qa"},
+	})
+
+	w = serve(h, "GET", "/Groups/unknown", "")
+	c.Assert(w.Code, Equals, 404)
+
+	w = serve(h, "POST", "/Groups", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"displayName": "ops",
+		"members": [{"value": "john"}, {"value": "bob", "type": "User"}, {"value": "qa"}]
+	}`)
+
+	c.Assert(w.Code, Equals, 201)
+	c.Assert(w.Header().Get("Location"), Equals, "https://scim.domain.com/v2/Groups/ops")
+	c.Assert(srv.Group("ops").Users, DeepEquals, []string{"john", "bob"})
+	c.Assert(srv.Group("ops").Groups, DeepEquals, []string{"qa"})
+	c.Assert(srv.Group("ops").IsActive, Equals, true)
+
+	w = serve(h, "POST", "/Groups", `{"displayName": "ops"}`)
+	c.Assert(w.Code, Equals, 409)
+
+	w = serve(h, "POST", "/Groups", `{"members": []}`)
+	c.Assert(w.Code, Equals, 400)
+
+	w = serve(h, "POST", "/Groups", `{"displayName": "sre", "members": [{"value": "unknown"}]}`)
+	c.Assert(w.Code, Equals, 400)
+	c.Assert(decodeError(w).ScimType, Equals, "invalidValue")
+	c.Assert(srv.Group("sre"), IsNil)
+
+	w = serve(h, "PATCH", "/Groups/ops", `{"Operations": [
+		{"op": "add", "path": "members", "value": [{"value": "jane"}, {"value": "john"}]},
+		{"op": "remove", "path": "members[value eq \"bob\"]"},
+		{"op": "remove", "path": "members", "value": [{"value": "qa", "type": "Group"}, {"value": "unknown"}]},
+		{"op": "replace", "value": {"displayName": "OPS"}}
+	]}`)
+
+	c.Assert(w.Code, Equals, 204)
+	c.Assert(srv.Group("ops").Users, DeepEquals, []string{"john", "jane"})
+	c.Assert(srv.Group("ops").Groups, HasLen, 0)
+
+	w = serve(h, "PATCH", "/Groups/ops", `{"Operations": [
+		{"op": "replace", "path": "members", "value": [{"value": "jane"}, {"value": "admins", "type": "Group"}]}
+	]}`)
+
+	c.Assert(w.Code, Equals, 204)
+	c.Assert(srv.Group("ops").Users, DeepEquals, []string{"jane"})
+	c.Assert(srv.Group("ops").Groups, DeepEquals, []string{"admins"})
+
+	w = serve(h, "PATCH", "/Groups/ops", `{"Operations": [{"op": "remove", "path": "members"}]}`)
+	c.Assert(w.Code, Equals, 204)
+	c.Assert(srv.Group("ops").Users, HasLen, 0)
+	c.Assert(srv.Group("ops").Groups, HasLen, 0)
+
+	for body, scimType := range map[string]string{
+		`{"Operations": [{"op": "replace", "path": "displayName", "value": "sre"}]}`:          "mutability",
+		`{"Operations": [{"op": "remove", "path": "displayName"}]}`:                           "mutability",
+		`{"Operations": [{"op": "replace", "path": "displayName", "value": 1}]}`:              "invalidValue",
+		`{"Operations": [{"op": "add", "path": "members", "value": {"value": "john"}}]}`:      "invalidValue",
+		`{"Operations": [{"op": "add", "path": "members", "value": [{"value": "unknown"}]}]}`: "invalidValue",
+		`{"Operations": [{"op": "add", "path": "members[value eq \"john\"]", "value": {}}]}`:  "invalidPath",
+		`{"Operations": [{"op": "remove", "path": "members[display eq \"john\"]"}]}`:          "invalidPath",
+		`{"Operations": [{"op": "remove", "path": "members[value eq john]"}]}`:                "invalidPath",
+		`{"Operations": [{"op": "replace", "value": []}]}`:                                    "invalidValue",
+		`{"Operations": [{"op": "remove"}]}`:                                                  "noTarget",
+	} {
+		w = serve(h, "PATCH", "/Groups/ops", body)
+		c.Assert(w.Code, Equals, 400, Commentf("Body: %s", body))
+		c.Assert(decodeError(w).ScimType, Equals, scimType, Commentf("Body: %s", body))
+	}
+
+	w = serve(h, "PATCH", "/Groups/unknown", `{"Operations": [{"op": "remove", "path": "members"}]}`)
+	c.Assert(w.Code, Equals, 404)
+
+	w = serve(h, "DELETE", "/Groups/ops", "")
+	c.Assert(w.Code, Equals, 204)
+	c.Assert(srv.Group("ops"), IsNil)
+
+	w = serve(h, "DELETE", "/Groups/ops", "")
+	c.Assert(w.Code, Equals, 404)
+}
+
+func (s *SCIMSuite) TestErrors(c *C) {
+	srv, h := newTestServer()
+
+	h.AllowAnonymous = false
+
+	// Requests are rejected if token isn't configured
+	r := httptest.NewRequest("GET", "/Users/john", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, 401)
+
+	h.Token = "secret"
+
+	w = serve(h, "GET", "/Users/john", "")
+	c.Assert(w.Code, Equals, 401)
+	c.Assert(w.Header().Get("WWW-Authenticate"), Not(Equals), "")
+	c.Assert(decodeError(w).Status, Equals, "401")
+
+	r = httptest.NewRequest("GET", "/Users/john", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, 401)
+
+	r = httptest.NewRequest("GET", "/Users/john", nil)
+	r.Header.Set("Authorization", "bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, 200)
+
+	h.Token = ""
+	h.AllowAnonymous = true
+
+	w = serve(h, "GET", "/Schemas", "")
+	c.Assert(w.Code, Equals, 404)
+
+	h.BaseURL = ""
+
+	w = serve(h, "GET", "/Groups/devs", "")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(strings.Contains(w.Body.String(), "location"), Equals, false)
+
+	srv.Close()
+
+	w = serve(h, "GET", "/Users/john", "")
+	c.Assert(w.Code, Equals, 503)
+	c.Assert(decodeError(w).Detail, Equals, "Crowd is unavailable")
+
+	for _, err := range []error{crowd.ErrNoPerms, crowd.ErrInvalidPassword} {
+		w = httptest.NewRecorder()
+		writeError(w, err)
+		c.Assert(decodeError(w).Detail, Equals, err.Error())
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func newTestServer() (*crowdtest.Server, *Handler) {
+	srv := crowdtest.NewServer()
+
+	srv.AddUser(&crowdtest.User{
+		Name: "john", FirstName: "John", LastName: "Doe", DisplayName: "John Doe",
+		Email: "john@domain.com", IsActive: true,
+	})
+	srv.AddUser(&crowdtest.User{Name: "jane", FirstName: "Jane", LastName: "Doe", IsActive: false})
+	srv.AddUser(&crowdtest.User{Name: "bob", IsActive: true})
+
+	srv.AddGroup(&crowdtest.Group{Name: "admins", Groups: []string{"devs"}})
+	srv.AddGroup(&crowdtest.Group{Name: "devs", Users: []string{"john", "jane"}, Groups: []string{"qa"}})
+	srv.AddGroup(&crowdtest.Group{Name: "qa"})
+
+	api, _ := crowd.NewAPI(srv.URL(), "app", "test")
+
+	h := New(api)
+	h.AllowAnonymous = true
+	h.BaseURL = "https://scim.domain.com/v2/"
+	h.MaxCount = 2
+
+	return srv, h
+}
+
+func serve(h http.Handler, method, uri, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, uri, strings.NewReader(body)))
+
+	return w
+}
+
+func list(h http.Handler, uri string) *ListResponse {
+	resp := &ListResponse{}
+	json.Unmarshal(serve(h, "GET", uri, "").Body.Bytes(), resp)
+
+	return resp
+}
+
+func resourceIDs(resp *ListResponse) []string {
+	var ids []string
+
+	for _, r := range resp.Resources {
+		ids = append(ids, r.(map[string]any)["id"].(string))
+	}
+
+	return ids
+}
+
+func decodeError(w *httptest.ResponseRecorder) *Error {
+	e := &Error{}
+	json.Unmarshal(w.Body.Bytes(), e)
+
+	return e
+}
@@ -0,0 +1,108 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/xml"
+	"errors"
+	"regexp"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// SSO_COOKIE_NAME is default name of Crowd SSO cookie
+const SSO_COOKIE_NAME = "crowd.token_key"
+
+// Validation factors names
+const (
+	FACTOR_REMOTE_ADDRESS  = "remote_address"
+	FACTOR_X_FORWARDED_FOR = "X-Forwarded-For"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Session contains info about SSO session
+type Session struct {
+	Token       string `xml:"token"`
+	User        *User  `xml:"user"`
+	CreatedDate int64  `xml:"created-date"` // Milliseconds since epoch
+	ExpiryDate  int64  `xml:"expiry-date"`  // Milliseconds since epoch
+}
+
+// ValidationFactor contains validation factor for SSO session
+type ValidationFactor struct {
+	Name  string `xml:"name"`
+	Value string `xml:"value"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type validationFactors struct {
+	XMLName xml.Name            `xml:"validation-factors"`
+	Factors []*ValidationFactor `xml:"validation-factor"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ErrInvalidToken is returned if SSO token is invalid, expired or doesn't match
+// validation factors
+var ErrInvalidToken = errors.New("Token is invalid or expired")
+
+// rxSessionPath is regexp for session token in request path
+var rxSessionPath = regexp.MustCompile(`(/session/)[^/?]+`)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ValidateSession validates SSO token with given validation factors and returns
+// session with user info
+func (api *API) ValidateSession(token string, factors ...*ValidationFactor) (*Session, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	result := &Session{}
+	statusCode, err := api.doRequest(
+		"POST", "rest/usermanagement/1/session/"+esc(token)+"?expand=user",
+		result, &validationFactors{Factors: factors},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case 200:
+		return result, nil
+	case 400, 404:
+		return nil, ErrInvalidToken
+	case 403:
+		return nil, ErrNoPerms
+	default:
+		return nil, makeUnknownError(statusCode)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Created returns session creation time
+func (s *Session) Created() time.Time {
+	return time.UnixMilli(s.CreatedDate)
+}
+
+// Expiry returns session expiration time
+func (s *Session) Expiry() time.Time {
+	return time.UnixMilli(s.ExpiryDate)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// redactSessionPath redacts session token in request path
+func redactSessionPath(path string) string {
+	return rxSessionPath.ReplaceAllString(path, "${1}"+REDACTED)
+}
@@ -0,0 +1,319 @@
+// Package sshkeys provides lookup of SSH public keys stored in Crowd user
+// attribute (i.e. for sshd AuthorizedKeysCommand)
+package sshkeys
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DEFAULT_ATTRIBUTE is default name of attribute with SSH public keys
+const DEFAULT_ATTRIBUTE = "sshPublicKey"
+
+// DEFAULT_CACHE_MAX_AGE is default maximum age of cached keys
+const DEFAULT_CACHE_MAX_AGE = 24 * time.Hour
+
+// cacheFileSuffix is suffix of cache files
+const cacheFileSuffix = ".keys"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Lookup fetches user SSH public keys from Crowd
+type Lookup struct {
+	// Attribute is name of multi-valued attribute with keys (one key per value)
+	Attribute string
+
+	// Groups is list of groups allowed to use SSH (if empty, all active users
+	// are allowed). Nested membership is taken into account.
+	Groups []string
+
+	// CacheDir is path to directory for cached keys (if empty, cache is disabled).
+	// Cached keys are used only if Crowd is unreachable.
+	CacheDir string
+
+	// CacheMaxAge is maximum age of cached keys (0 means no limit)
+	CacheMaxAge time.Duration
+
+	// OnInvalidKey is called for every skipped invalid key
+	OnInvalidKey func(userName, key string, err error)
+
+	api *crowd.API
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// supportedKeyTypes is set of supported key types
+var supportedKeyTypes = map[string]bool{
+	"ssh-ed25519":                        true,
+	"ssh-rsa":                            true,
+	"ecdsa-sha2-nistp256":                true,
+	"ecdsa-sha2-nistp384":                true,
+	"ecdsa-sha2-nistp521":                true,
+	"sk-ssh-ed25519@openssh.com":         true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// Errors
+var (
+	// ErrAccessDenied is returned if user doesn't exist, inactive or isn't
+	// a member of allowed groups
+	ErrAccessDenied = errors.New("User doesn't exist or isn't allowed to use SSH")
+
+	// ErrInvalidKey is returned if key is malformed or has unsupported type
+	ErrInvalidKey = errors.New("Key is malformed or has unsupported type")
+
+	// ErrNoCache is returned if Crowd is unreachable and there are no cached keys
+	ErrNoCache = errors.New("Crowd is unreachable and there are no cached keys")
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// New creates new keys lookup
+func New(api *crowd.API) *Lookup {
+	return &Lookup{
+		Attribute:   DEFAULT_ATTRIBUTE,
+		CacheMaxAge: DEFAULT_CACHE_MAX_AGE,
+
+		api: api,
+	}
+}
+
+// ParseKey validates key in authorized_keys format ("type base64 [comment]")
+// and returns it in normalized form. Keys with options aren't supported.
+func ParseKey(key string) (string, error) {
+	fields := strings.Fields(key)
+
+	if len(fields) < 2 || !supportedKeyTypes[fields[0]] {
+		return "", ErrInvalidKey
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+
+	// Key blob starts with length-prefixed key type
+	if err != nil || len(blob) < 4 {
+		return "", ErrInvalidKey
+	}
+
+	typeLen := int(binary.BigEndian.Uint32(blob))
+
+	if typeLen != len(fields[0]) || len(blob) <= 4+typeLen || string(blob[4:4+typeLen]) != fields[0] {
+		return "", ErrInvalidKey
+	}
+
+	return strings.Join(fields, " "), nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Keys returns valid SSH public keys of user. It returns ErrAccessDenied if user
+// doesn't exist, inactive or isn't a member of allowed groups. If Crowd is
+// unreachable, cached keys are returned.
+func (l *Lookup) Keys(ctx context.Context, userName string) ([]string, error) {
+	if userName == "" {
+		return nil, ErrAccessDenied
+	}
+
+	keys, err := l.fetchKeys(l.api.WithContext(ctx), userName)
+
+	switch {
+	case err == nil:
+		l.writeCache(userName, keys)
+		return keys, nil
+
+	case errors.Is(err, ErrAccessDenied):
+		// Revoked access must not be restored from cache
+		l.removeCache(userName)
+		return nil, err
+	}
+
+	cached, cacheErr := l.readCache(userName)
+
+	if cacheErr != nil {
+		return nil, errors.Join(err, cacheErr)
+	}
+
+	return cached, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// fetchKeys fetches user info and keys from Crowd
+func (l *Lookup) fetchKeys(api *crowd.API, userName string) ([]string, error) {
+	user, err := api.GetUser(userName, true)
+
+	switch {
+	case errors.Is(err, crowd.ErrUserNoFound):
+		return nil, ErrAccessDenied
+	case err != nil:
+		return nil, err
+	case !user.IsActive:
+		return nil, ErrAccessDenied
+	}
+
+	if len(l.Groups) != 0 {
+		isMember, err := l.isMember(api, user.Name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !isMember {
+			return nil, ErrAccessDenied
+		}
+	}
+
+	return l.parseKeys(userName, user.Attributes.GetList(l.Attribute)), nil
+}
+
+// isMember checks if user is a member of any allowed group
+func (l *Lookup) isMember(api *crowd.API, userName string) (bool, error) {
+	groups, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+		return api.GetUserNestedGroups(userName, opts)
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, g := range groups {
+		for _, allowed := range l.Groups {
+			// Crowd group names are case-insensitive
+			if strings.EqualFold(g.Name, allowed) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// parseKeys validates keys and removes duplicates
+func (l *Lookup) parseKeys(userName string, values []string) []string {
+	var result []string
+
+	seen := map[string]bool{}
+
+	for _, value := range values {
+		// Value may contain several keys separated by new lines
+		for _, line := range strings.Split(value, "\n") {
+			line = strings.TrimSpace(line)
+
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			key, err := ParseKey(line)
+
+			if err != nil {
+				if l.OnInvalidKey != nil {
+					l.OnInvalidKey(userName, line, err)
+				}
+
+				continue
+			}
+
+			if !seen[key] {
+				seen[key] = true
+				result = append(result, key)
+			}
+		}
+	}
+
+	return result
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getCacheFile returns path to cache file for given user
+func (l *Lookup) getCacheFile(userName string) string {
+	// Crowd user names are case-insensitive
+	return filepath.Join(l.CacheDir, url.PathEscape(strings.ToLower(userName))+cacheFileSuffix)
+}
+
+// writeCache atomically writes keys to cache file
+func (l *Lookup) writeCache(userName string, keys []string) {
+	if l.CacheDir == "" {
+		return
+	}
+
+	fd, err := os.CreateTemp(l.CacheDir, ".tmp-*")
+
+	if err != nil {
+		return
+	}
+
+	for _, key := range keys {
+		_, err = fd.WriteString(key + "\n")
+
+		if err != nil {
+			break
+		}
+	}
+
+	if fd.Close() != nil || err != nil {
+		os.Remove(fd.Name())
+		return
+	}
+
+	if os.Rename(fd.Name(), l.getCacheFile(userName)) != nil {
+		os.Remove(fd.Name())
+	}
+}
+
+// removeCache removes cache file
+func (l *Lookup) removeCache(userName string) {
+	if l.CacheDir != "" {
+		os.Remove(l.getCacheFile(userName))
+	}
+}
+
+// readCache reads keys from cache file
+func (l *Lookup) readCache(userName string) ([]string, error) {
+	if l.CacheDir == "" {
+		return nil, ErrNoCache
+	}
+
+	file := l.getCacheFile(userName)
+	info, err := os.Stat(file)
+
+	if err != nil || (l.CacheMaxAge > 0 && time.Since(info.ModTime()) > l.CacheMaxAge) {
+		return nil, ErrNoCache
+	}
+
+	data, err := os.ReadFile(file)
+
+	if err != nil {
+		return nil, ErrNoCache
+	}
+
+	var keys []string
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// Cache file could be modified, so keys are validated again
+		key, err := ParseKey(line)
+
+		if err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
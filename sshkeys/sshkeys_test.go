@@ -0,0 +1,191 @@
+package sshkeys
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+const (
+	testKey1 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAICBPXfm/yv9oqbzk9qzvClsPtqd44/MnBuAxqoGZTuPE john@laptop"
+	testKey2 = "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBOZlyPxsw6GL3RkIi9rvJhsmmp79Btrdn+yN1/nc4jVTy2uXnuMtsZhfBhYaYfXCnqwv0KWFUCjGfEHQnBQlpoI="
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type SSHKeysSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&SSHKeysSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *SSHKeysSuite) TestParseKey(c *C) {
+	key, err := ParseKey("  ssh-ed25519\tAAAAC3NzaC1lZDI1NTE5AAAAICBPXfm/yv9oqbzk9qzvClsPtqd44/MnBuAxqoGZTuPE   john@laptop ")
+	c.Assert(err, IsNil)
+	c.Assert(key, Equals, testKey1)
+
+	key, err = ParseKey(testKey2)
+	c.Assert(err, IsNil)
+	c.Assert(key, Equals, testKey2)
+
+	for _, k := range []string{
+		"",
+		"ssh-ed25519",
+		`from="10.0.0.1" ` + testKey1,
+		"ssh-dss AAAAB3NzaC1kc3MAAACBAP",
+		"ssh-ed25519 !!!",
+		"ssh-ed25519 AAAA",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5",
+		"ssh-rsa AAAAC3NzaC1lZDI1NTE5AAAAICBPXfm/yv9oqbzk9qzvClsPtqd44/MnBuAxqoGZTuPE",
+	} {
+		_, err = ParseKey(k)
+		c.Assert(err, Equals, ErrInvalidKey, Commentf("Key: %q", k))
+	}
+}
+
+func (s *SSHKeysSuite) TestKeys(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	var invalid []string
+
+	l := New(api)
+	l.OnInvalidKey = func(userName, key string, err error) {
+		invalid = append(invalid, userName+": "+key)
+	}
+
+	keys, err := l.Keys(context.Background(), "john")
+	c.Assert(err, IsNil)
+	c.Assert(keys, DeepEquals, []string{testKey1, testKey2})
+	c.Assert(invalid, DeepEquals, []string{"john: ssh-rsa test"})
+
+	keys, err = l.Keys(context.Background(), "bob")
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 0)
+
+	for _, userName := range []string{"", "unknown", "mary"} {
+		_, err = l.Keys(context.Background(), userName)
+		c.Assert(err, Equals, ErrAccessDenied, Commentf("User: %q", userName))
+	}
+
+	l.Groups = []string{"SSH-USERS"}
+
+	_, err = l.Keys(context.Background(), "john")
+	c.Assert(err, IsNil)
+
+	_, err = l.Keys(context.Background(), "bob")
+	c.Assert(err, Equals, ErrAccessDenied)
+
+	l.Attribute = "publicKeys"
+
+	keys, err = l.Keys(context.Background(), "john")
+	c.Assert(err, IsNil)
+	c.Assert(keys, DeepEquals, []string{testKey2})
+
+	srv.Close()
+
+	_, err = l.Keys(context.Background(), "john")
+	c.Assert(err, ErrorMatches, `(?s).*Crowd is unreachable and there are no cached keys`)
+}
+
+func (s *SSHKeysSuite) TestCache(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	l := New(api)
+	l.CacheDir = c.MkDir()
+	l.Groups = []string{"ssh-users"}
+
+	_, err := l.Keys(context.Background(), "john")
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(l.CacheDir + "/john.keys")
+	c.Assert(err, IsNil)
+
+	srv.Close()
+
+	keys, err := l.Keys(context.Background(), "john")
+	c.Assert(err, IsNil)
+	c.Assert(keys, DeepEquals, []string{testKey1, testKey2})
+
+	_, err = l.Keys(context.Background(), "bob")
+	c.Assert(err, NotNil)
+
+	// Modified cache file is validated
+	os.WriteFile(l.CacheDir+"/john.keys", []byte("test\n"+testKey2+"\n"), 0600)
+
+	keys, err = l.Keys(context.Background(), "john")
+	c.Assert(err, IsNil)
+	c.Assert(keys, DeepEquals, []string{testKey2})
+
+	// Expired cache
+	old := time.Now().Add(-2 * DEFAULT_CACHE_MAX_AGE)
+	os.Chtimes(l.CacheDir+"/john.keys", old, old)
+
+	_, err = l.Keys(context.Background(), "john")
+	c.Assert(err, ErrorMatches, `(?s).*Crowd is unreachable and there are no cached keys`)
+
+	l.CacheMaxAge = 0
+
+	_, err = l.Keys(context.Background(), "john")
+	c.Assert(err, IsNil)
+
+	// Revoked access removes cached keys
+	srv, api = newTestServer()
+	defer srv.Close()
+
+	l.api = api
+	user := srv.User("john")
+	user.IsActive = false
+	srv.AddUser(user)
+
+	_, err = l.Keys(context.Background(), "john")
+	c.Assert(err, Equals, ErrAccessDenied)
+
+	_, err = os.Stat(l.CacheDir + "/john.keys")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(l.getCacheFile("../John"), Equals, l.CacheDir+"/..%2Fjohn.keys")
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func newTestServer() (*crowdtest.Server, *crowd.API) {
+	srv := crowdtest.NewServer()
+
+	srv.AddUser(&crowdtest.User{
+		Name: "john", IsActive: true,
+		Attributes: map[string][]string{
+			DEFAULT_ATTRIBUTE: {testKey1, "ssh-rsa test", testKey2 + "\n\n# Comment\n" + testKey1},
+			"publicKeys":      {testKey2},
+		},
+	})
+	srv.AddUser(&crowdtest.User{Name: "bob", IsActive: true})
+	srv.AddUser(&crowdtest.User{Name: "mary", Attributes: map[string][]string{DEFAULT_ATTRIBUTE: {testKey1}}})
+
+	srv.AddGroup(&crowdtest.Group{Name: "ssh-users", Groups: []string{"devs"}})
+	srv.AddGroup(&crowdtest.Group{Name: "devs", Users: []string{"john"}})
+
+	api, _ := crowd.NewAPI(srv.URL(), "app", "test")
+
+	return srv, api
+}
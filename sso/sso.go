@@ -0,0 +1,299 @@
+// Package sso provides net/http middleware for Crowd SSO cookie authentication
+package sso
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/cache"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Modes of handling unauthenticated requests
+const (
+	// MODE_REDIRECT redirects GET and HEAD requests to login page and rejects
+	// other requests with 401
+	MODE_REDIRECT Mode = iota
+
+	// MODE_UNAUTHORIZED rejects requests with 401
+	MODE_UNAUTHORIZED
+
+	// MODE_PASS_THROUGH passes requests to the next handler without user info
+	MODE_PASS_THROUGH
+)
+
+// DEFAULT_CACHE_TTL is default TTL for validated sessions
+const DEFAULT_CACHE_TTL = 30 * time.Second
+
+// DEFAULT_RETURN_PARAM is default name of login page query parameter with
+// original URL
+const DEFAULT_RETURN_PARAM = "os_destination"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Mode is mode of handling unauthenticated requests
+type Mode uint8
+
+// Middleware validates Crowd SSO cookie and puts user info into request context
+type Middleware struct {
+	// CookieName is name of SSO cookie (default: crowd.SSO_COOKIE_NAME)
+	CookieName string
+
+	// Mode is mode of handling unauthenticated requests
+	Mode Mode
+
+	// LoginURL is URL of login page used with MODE_REDIRECT
+	LoginURL string
+
+	// ReturnParam is name of login page query parameter with original URL
+	// (default: DEFAULT_RETURN_PARAM)
+	ReturnParam string
+
+	// CacheTTL is TTL for validated sessions (0 disables caching)
+	CacheTTL time.Duration
+
+	// OnCacheLookup is called on every cache lookup with its result (i.e. to
+	// collect metrics with metrics.CacheObserver)
+	OnCacheLookup func(hit bool)
+
+	// TrustProxy enables using X-Forwarded-For header as validation factor
+	TrustProxy bool
+
+	// ErrorHandler handles Crowd errors (503 is returned by default)
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+	api   *crowd.API
+	cache cache.Cache[*crowd.Session]
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// contextKey is type for context keys
+type contextKey uint8
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// sessionKey is context key for validated session
+const sessionKey contextKey = 0
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// New creates new middleware
+func New(api *crowd.API) *Middleware {
+	return &Middleware{
+		CookieName:  crowd.SSO_COOKIE_NAME,
+		ReturnParam: DEFAULT_RETURN_PARAM,
+		CacheTTL:    DEFAULT_CACHE_TTL,
+
+		api: api,
+	}
+}
+
+// UserFromContext returns authenticated user from request context
+func UserFromContext(ctx context.Context) *crowd.User {
+	session := SessionFromContext(ctx)
+
+	if session == nil {
+		return nil
+	}
+
+	return session.User
+}
+
+// SessionFromContext returns validated session from request context
+func SessionFromContext(ctx context.Context) *crowd.Session {
+	session, _ := ctx.Value(sessionKey).(*crowd.Session)
+	return session
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Handler wraps given handler with SSO authentication
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := m.Authenticate(r)
+
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r.WithContext(
+				context.WithValue(r.Context(), sessionKey, session),
+			))
+		case errors.Is(err, crowd.ErrInvalidToken):
+			m.handleUnauthenticated(w, r, next)
+		case m.ErrorHandler != nil:
+			m.ErrorHandler(w, r, err)
+		default:
+			http.Error(w, "Authentication service is unavailable", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// Authenticate validates SSO cookie from request. It returns crowd.ErrInvalidToken
+// if cookie is missing or invalid.
+func (m *Middleware) Authenticate(r *http.Request) (*crowd.Session, error) {
+	cookie, err := r.Cookie(m.getCookieName())
+
+	if err != nil || cookie.Value == "" {
+		return nil, crowd.ErrInvalidToken
+	}
+
+	factors := m.getValidationFactors(r)
+	key := getCacheKey(cookie.Value, factors)
+	session := m.getCached(key, cookie.Value)
+
+	if session != nil {
+		return session, nil
+	}
+
+	session, err = m.api.WithContext(r.Context()).ValidateSession(cookie.Value, factors...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.putCached(key, session)
+
+	return session, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// handleUnauthenticated handles request without valid session
+func (m *Middleware) handleUnauthenticated(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	switch {
+	case m.Mode == MODE_PASS_THROUGH:
+		next.ServeHTTP(w, r)
+	case m.Mode == MODE_REDIRECT && m.LoginURL != "" &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead):
+		http.Redirect(w, r, m.getLoginURL(r), http.StatusFound)
+	default:
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+}
+
+// getLoginURL returns login page URL with original URL
+func (m *Middleware) getLoginURL(r *http.Request) string {
+	loginURL, err := url.Parse(m.LoginURL)
+
+	if err != nil {
+		return m.LoginURL
+	}
+
+	param := m.ReturnParam
+
+	if param == "" {
+		param = DEFAULT_RETURN_PARAM
+	}
+
+	query := loginURL.Query()
+	query.Set(param, r.URL.RequestURI())
+	loginURL.RawQuery = query.Encode()
+
+	return loginURL.String()
+}
+
+// getCookieName returns name of SSO cookie
+func (m *Middleware) getCookieName() string {
+	if m.CookieName == "" {
+		return crowd.SSO_COOKIE_NAME
+	}
+
+	return m.CookieName
+}
+
+// getValidationFactors returns validation factors for request
+func (m *Middleware) getValidationFactors(r *http.Request) []*crowd.ValidationFactor {
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	factors := []*crowd.ValidationFactor{
+		{Name: crowd.FACTOR_REMOTE_ADDRESS, Value: remoteAddr},
+	}
+
+	if m.TrustProxy && r.Header.Get("X-Forwarded-For") != "" {
+		factors = append(factors, &crowd.ValidationFactor{
+			Name:  crowd.FACTOR_X_FORWARDED_FOR,
+			Value: r.Header.Get("X-Forwarded-For"),
+		})
+	}
+
+	return factors
+}
+
+// getCached returns copy of cached session with given token
+func (m *Middleware) getCached(key [32]byte, token string) *crowd.Session {
+	if m.CacheTTL <= 0 {
+		return nil
+	}
+
+	session, ok := m.cache.Get(key)
+
+	if m.OnCacheLookup != nil {
+		m.OnCacheLookup(ok)
+	}
+
+	if !ok {
+		return nil
+	}
+
+	result := *session
+	result.Token = token
+
+	return &result
+}
+
+// putCached adds copy of session without token to cache
+func (m *Middleware) putCached(key [32]byte, session *crowd.Session) {
+	if m.CacheTTL <= 0 {
+		return
+	}
+
+	expiry := time.Now().Add(m.CacheTTL)
+
+	if session.ExpiryDate > 0 && session.Expiry().Before(expiry) {
+		expiry = session.Expiry()
+	}
+
+	cached := *session
+	cached.Token = ""
+
+	m.cache.Put(key, &cached, expiry)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getCacheKey returns cache key for token and validation factors. Tokens are
+// hashed and removed from cached sessions, so cache never contains them in
+// plain text.
+func getCacheKey(token string, factors []*crowd.ValidationFactor) [32]byte {
+	hasher := sha256.New()
+	hasher.Write([]byte(token))
+
+	for _, f := range factors {
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(f.Name + "=" + f.Value))
+	}
+
+	var key [32]byte
+
+	hasher.Sum(key[:0])
+
+	return key
+}
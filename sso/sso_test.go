@@ -0,0 +1,212 @@
+package sso
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type SSOSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&SSOSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *SSOSuite) TestMiddleware(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	m := New(api)
+	h := m.Handler(http.HandlerFunc(userHandler))
+
+	w := serve(h, "GET", "/page?id=1", "token1", "")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Body.String(), Equals, "john")
+
+	srv.ResetRequests()
+
+	w = serve(h, "GET", "/page", "token1", "")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(srv.Requests(), HasLen, 0)
+
+	// Different validation factors must not use cached session
+	w = serve(h, "GET", "/page", "token1", "10.0.0.2:1234")
+	c.Assert(w.Code, Equals, 401)
+	c.Assert(srv.Requests(), HasLen, 1)
+
+	m.LoginURL = "https://sso.domain.com/login?app=test"
+
+	w = serve(h, "GET", "/page?id=1", "unknown", "")
+	c.Assert(w.Code, Equals, 302)
+	c.Assert(w.Header().Get("Location"), Equals, "https://sso.domain.com/login?app=test&os_destination=%2Fpage%3Fid%3D1")
+
+	w = serve(h, "GET", "/page", "", "")
+	c.Assert(w.Code, Equals, 302)
+
+	w = serve(h, "POST", "/page", "", "")
+	c.Assert(w.Code, Equals, 401)
+
+	m.Mode = MODE_UNAUTHORIZED
+	w = serve(h, "GET", "/page", "unknown", "")
+	c.Assert(w.Code, Equals, 401)
+
+	m.Mode = MODE_PASS_THROUGH
+	w = serve(h, "GET", "/page", "unknown", "")
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Body.String(), Equals, "anonymous")
+
+	m.CookieName = "custom"
+	w = serve(h, "GET", "/page", "token1", "")
+	c.Assert(w.Body.String(), Equals, "anonymous")
+}
+
+func (s *SSOSuite) TestCache(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	m := New(api)
+	m.CacheTTL = 0
+	h := m.Handler(http.HandlerFunc(userHandler))
+
+	serve(h, "GET", "/", "token1", "")
+	serve(h, "GET", "/", "token1", "")
+	c.Assert(srv.Requests(), HasLen, 2)
+
+	var lookups []bool
+
+	m.CacheTTL = time.Minute
+	m.OnCacheLookup = func(hit bool) { lookups = append(lookups, hit) }
+	srv.ResetRequests()
+
+	serve(h, "GET", "/", "token2", "")
+	serve(h, "GET", "/", "token2", "")
+	c.Assert(srv.Requests(), HasLen, 1)
+	c.Assert(lookups, DeepEquals, []bool{false, true})
+
+	// Cached sessions don't contain tokens
+	key := getCacheKey("test", nil)
+	m.putCached(key, &crowd.Session{Token: "test", User: &crowd.User{Name: "john"}})
+	cached, _ := m.cache.Get(key)
+	c.Assert(cached.Token, Equals, "")
+	c.Assert(m.getCached(key, "test").Token, Equals, "test")
+
+	// Cache TTL is limited by session expiry
+	m.putCached(key, &crowd.Session{ExpiryDate: time.Now().Add(-time.Second).UnixMilli()})
+	c.Assert(m.getCached(key, "test"), IsNil)
+}
+
+func (s *SSOSuite) TestProxy(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	m := New(api)
+	m.TrustProxy = true
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "192.168.1.1")
+
+	factors := m.getValidationFactors(req)
+
+	c.Assert(factors, HasLen, 2)
+	c.Assert(factors[0].Value, Equals, "192.0.2.1")
+	c.Assert(factors[1].Name, Equals, crowd.FACTOR_X_FORWARDED_FOR)
+	c.Assert(factors[1].Value, Equals, "192.168.1.1")
+
+	req.RemoteAddr = "unix"
+	c.Assert(m.getValidationFactors(req)[0].Value, Equals, "unix")
+}
+
+func (s *SSOSuite) TestErrors(c *C) {
+	api, _ := crowd.NewAPI("http://127.0.0.1:1/", "app", "test")
+
+	m := New(api)
+	h := m.Handler(http.HandlerFunc(userHandler))
+
+	w := serve(h, "GET", "/", "token1", "")
+	c.Assert(w.Code, Equals, 503)
+
+	var handlerErr error
+
+	m.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		handlerErr = err
+		w.WriteHeader(500)
+	}
+
+	w = serve(h, "GET", "/", "token1", "")
+	c.Assert(w.Code, Equals, 500)
+	c.Assert(handlerErr, NotNil)
+	c.Assert(errors.Is(handlerErr, crowd.ErrInvalidToken), Equals, false)
+
+	c.Assert(UserFromContext(httptest.NewRequest("GET", "/", nil).Context()), IsNil)
+
+	m.LoginURL = "%"
+	c.Assert(m.getLoginURL(httptest.NewRequest("GET", "/", nil)), Equals, "%")
+
+	m.ReturnParam = ""
+	m.LoginURL = "/login"
+	c.Assert(m.getLoginURL(httptest.NewRequest("GET", "/a", nil)), Equals, "/login?os_destination=%2Fa")
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func newTestServer() (*crowdtest.Server, *crowd.API) {
+	srv := crowdtest.NewServer()
+
+	srv.AddUser(&crowdtest.User{Name: "john", IsActive: true})
+	srv.AddUser(&crowdtest.User{Name: "bob", IsActive: true})
+
+	srv.AddSession(&crowdtest.Session{Token: "token1", User: "john", RemoteAddress: "192.0.2.1"})
+	srv.AddSession(&crowdtest.Session{Token: "token2", User: "bob", Expiry: time.Now().Add(5 * time.Second)})
+
+	api, _ := crowd.NewAPI(srv.URL(), "app", "test")
+
+	return srv, api
+}
+
+func serve(h http.Handler, method, uri, token, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, uri, nil)
+	w := httptest.NewRecorder()
+
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: crowd.SSO_COOKIE_NAME, Value: token})
+	}
+
+	if remoteAddr != "" {
+		req.RemoteAddr = remoteAddr
+	}
+
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func userHandler(w http.ResponseWriter, r *http.Request) {
+	user := UserFromContext(r.Context())
+
+	if user == nil {
+		w.Write([]byte("anonymous"))
+		return
+	}
+
+	w.Write([]byte(user.Name))
+}
@@ -0,0 +1,267 @@
+package crowd
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// TEMP_MEMBERS_ATTR is name of group attribute with expiration info of temporary
+// memberships. Every value has "<RFC3339 time> <username>" format.
+const TEMP_MEMBERS_ATTR = "goCrowdTemporaryMembers"
+
+// DEFAULT_SWEEP_INTERVAL is interval used by sweeper if given interval is invalid
+const DEFAULT_SWEEP_INTERVAL = 5 * time.Minute
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// TemporaryMembership contains info about temporary group membership
+type TemporaryMembership struct {
+	User  string
+	Group string
+	Until time.Time
+}
+
+// MembershipSweeper periodically removes expired temporary memberships
+type MembershipSweeper struct {
+	// OnSweep is callback executed after every sweep
+	OnSweep func(report *SweepReport)
+
+	api    *API
+	groups []string
+}
+
+// SweepReport contains info about sweep results
+type SweepReport struct {
+	Removed []*TemporaryMembership // Removed memberships
+	Errors  []error                // Errors occurred while sweeping
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ErrInvalidExpiry is returned if expiration time of temporary membership
+// isn't in the future
+var ErrInvalidExpiry = errors.New("Expiration time must be in the future")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// GrantTemporaryMembership adds user to the group until given time. Expiration
+// info is stored in group attribute (see TEMP_MEMBERS_ATTR), so membership will
+// be removed by MembershipSweeper after expiration. Granting membership again
+// updates expiration time. Permanent memberships can't be converted into
+// temporary ones.
+func (api *API) GrantTemporaryMembership(userName, groupName string, until time.Time) error {
+	if !until.After(time.Now()) {
+		return ErrInvalidExpiry
+	}
+
+	attrs, err := api.GetGroupAttributes(groupName)
+
+	if err != nil {
+		return err
+	}
+
+	isTemporary := slices.ContainsFunc(
+		ParseTemporaryMemberships(groupName, attrs),
+		func(m *TemporaryMembership) bool { return strings.EqualFold(m.User, userName) },
+	)
+
+	if !isTemporary {
+		groups, err := FetchAll(func(opts ListingOptions) ([]*Group, error) {
+			return api.GetUserDirectGroups(userName, opts)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		// Crowd group names are case-insensitive
+		if slices.ContainsFunc(groups, func(g *Group) bool { return strings.EqualFold(g.Name, groupName) }) {
+			return ErrMembershipExists
+		}
+	}
+
+	// Expiration info must be stored before adding user to the group, so sweeper
+	// never misses temporary membership
+	updated := attrs.Clone()
+	removeTemporaryRecord(&updated, userName)
+	updated.Add(TEMP_MEMBERS_ATTR, until.UTC().Format(time.RFC3339)+" "+userName)
+
+	err = api.PatchGroupAttributes(groupName, attrs.Diff(updated), attrs)
+
+	if err != nil {
+		return err
+	}
+
+	err = api.AddUserToGroup(userName, groupName)
+
+	switch {
+	case err == nil:
+		return nil
+	case isTemporary && err == ErrMembershipExists:
+		return nil
+	case !isTemporary:
+		// Expiration info must be removed, otherwise sweeper will remove membership
+		// added later by someone else
+		rollbackErr := api.PatchGroupAttributes(groupName, updated.Diff(attrs), updated)
+
+		if rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("Can't remove expiration info: %w", rollbackErr))
+		}
+	}
+
+	return err
+}
+
+// GetTemporaryMemberships returns temporary memberships of the group
+func (api *API) GetTemporaryMemberships(groupName string) ([]*TemporaryMembership, error) {
+	attrs, err := api.GetGroupAttributes(groupName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTemporaryMemberships(groupName, attrs), nil
+}
+
+// ParseTemporaryMemberships parses temporary memberships info stored in group
+// attributes (see TEMP_MEMBERS_ATTR). Records with invalid format are ignored.
+func ParseTemporaryMemberships(groupName string, attrs Attributes) []*TemporaryMembership {
+	var result []*TemporaryMembership
+
+	for _, value := range attrs.GetList(TEMP_MEMBERS_ATTR) {
+		until, userName, ok := strings.Cut(value, " ")
+
+		if !ok {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, until)
+
+		if err != nil {
+			continue
+		}
+
+		result = append(result, &TemporaryMembership{userName, groupName, t})
+	}
+
+	return result
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewMembershipSweeper creates new sweeper for temporary memberships in given
+// groups
+func NewMembershipSweeper(api *API, groups ...string) *MembershipSweeper {
+	return &MembershipSweeper{api: api, groups: groups}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Sweep removes all expired memberships
+func (s *MembershipSweeper) Sweep() *SweepReport {
+	report := &SweepReport{}
+
+	for _, groupName := range s.groups {
+		s.sweepGroup(groupName, report)
+	}
+
+	return report
+}
+
+// Run sweeps expired memberships with given interval until context is canceled.
+// If interval isn't positive, DEFAULT_SWEEP_INTERVAL is used.
+func (s *MembershipSweeper) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DEFAULT_SWEEP_INTERVAL
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report := s.Sweep()
+
+		if s.OnSweep != nil {
+			s.OnSweep(report)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// sweepGroup removes expired memberships from the group
+func (s *MembershipSweeper) sweepGroup(groupName string, report *SweepReport) {
+	attrs, err := s.api.GetGroupAttributes(groupName)
+
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("Can't get attributes of group %q: %w", groupName, err))
+		return
+	}
+
+	updated := attrs.Clone()
+	now := time.Now()
+
+	for _, m := range ParseTemporaryMemberships(groupName, attrs) {
+		if m.Until.After(now) {
+			continue
+		}
+
+		err = s.api.RemoveUserFromGroup(m.User, groupName)
+
+		if err != nil && err != ErrMembershipNoFound {
+			report.Errors = append(report.Errors, fmt.Errorf(
+				"Can't remove user %q from group %q: %w", m.User, groupName, err,
+			))
+			continue
+		}
+
+		removeTemporaryRecord(&updated, m.User)
+		report.Removed = append(report.Removed, m)
+	}
+
+	diff := attrs.Diff(updated)
+
+	if diff.IsEmpty() {
+		return
+	}
+
+	err = s.api.PatchGroupAttributes(groupName, diff, attrs)
+
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf(
+			"Can't update temporary memberships of group %q: %w", groupName, err,
+		))
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// removeTemporaryRecord removes expiration info for given user
+func removeTemporaryRecord(attrs *Attributes, userName string) {
+	for _, value := range attrs.GetList(TEMP_MEMBERS_ATTR) {
+		_, user, _ := strings.Cut(value, " ")
+
+		if strings.EqualFold(user, userName) {
+			attrs.RemoveValue(TEMP_MEMBERS_ATTR, value)
+		}
+	}
+}
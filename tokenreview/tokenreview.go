@@ -0,0 +1,364 @@
+// Package tokenreview provides Kubernetes webhook token authenticator
+// (authentication.k8s.io/v1 TokenReview API) backed by Crowd
+package tokenreview
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/cache"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+const (
+	// API_VERSION is supported TokenReview API version
+	API_VERSION = "authentication.k8s.io/v1"
+
+	// KIND is TokenReview object kind
+	KIND = "TokenReview"
+)
+
+// DEFAULT_CACHE_TTL is default TTL for successful reviews
+const DEFAULT_CACHE_TTL = 30 * time.Second
+
+// maxBodySize is maximum size of TokenReview request body
+const maxBodySize = 1024 * 1024
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// TokenReview is TokenReview object
+type TokenReview struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   map[string]any    `json:"metadata,omitempty"`
+	Spec       TokenReviewSpec   `json:"spec"`
+	Status     TokenReviewStatus `json:"status"`
+}
+
+// TokenReviewSpec is TokenReview request
+type TokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// TokenReviewStatus is TokenReview result
+type TokenReviewStatus struct {
+	Authenticated bool      `json:"authenticated"`
+	User          *UserInfo `json:"user,omitempty"`
+	Audiences     []string  `json:"audiences,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// UserInfo contains info about authenticated user
+type UserInfo struct {
+	Username string              `json:"username"`
+	UID      string              `json:"uid,omitempty"`
+	Groups   []string            `json:"groups,omitempty"`
+	Extra    map[string][]string `json:"extra,omitempty"`
+}
+
+// Authenticator reviews tokens. Token is either Crowd SSO token or
+// "username:password" pair.
+type Authenticator struct {
+	// GroupPrefix is prefix of groups exposed to Kubernetes (if empty, all
+	// groups are exposed)
+	GroupPrefix string
+
+	// TrimGroupPrefix enables removing prefix from exposed group names
+	TrimGroupPrefix bool
+
+	// Audiences is list of audiences tokens are valid for. If TokenReview
+	// contains audiences, token is authenticated only if any of them is in this
+	// list. If empty, no audiences are returned.
+	Audiences []string
+
+	// UsernamePrefix is prefix added to user names (i.e. "crowd:")
+	UsernamePrefix string
+
+	// PasswordTokens enables "username:password" tokens. Anyone who can reach
+	// the endpoint can use it for checking passwords, so access to it must be
+	// limited to API server (i.e. with client certificates).
+	PasswordTokens bool
+
+	// ValidationFactors is list of validation factors used for SSO token
+	// validation
+	ValidationFactors []*crowd.ValidationFactor
+
+	// CacheTTL is TTL for successful reviews (0 disables caching)
+	CacheTTL time.Duration
+
+	// OnCacheLookup is called on every cache lookup with its result (i.e. to
+	// collect metrics with metrics.CacheObserver)
+	OnCacheLookup func(hit bool)
+
+	api   *crowd.API
+	salt  []byte
+	cache cache.Cache[*UserInfo]
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Errors
+var (
+	// ErrInvalidToken is returned if token is invalid or expired
+	ErrInvalidToken = errors.New("Token is invalid or expired")
+
+	// ErrInactiveUser is returned if token belongs to inactive user
+	ErrInactiveUser = errors.New("User account is inactive")
+
+	// ErrInvalidAudience is returned if token isn't valid for any of requested
+	// audiences
+	ErrInvalidAudience = errors.New("Token isn't valid for requested audiences")
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// New creates new authenticator
+func New(api *crowd.API) *Authenticator {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+
+	return &Authenticator{
+		CacheTTL: DEFAULT_CACHE_TTL,
+
+		api:  api,
+		salt: salt,
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Review authenticates token and returns info about user. It returns
+// ErrInvalidToken if token is invalid.
+func (a *Authenticator) Review(ctx context.Context, token string) (*UserInfo, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	key := a.getCacheKey(token)
+	info := a.getCached(key)
+
+	if info != nil {
+		return info, nil
+	}
+
+	api := a.api.WithContext(ctx)
+	user, err := a.authenticate(api, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsActive {
+		return nil, ErrInactiveUser
+	}
+
+	groups, err := a.fetchGroups(api, user.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info = &UserInfo{
+		Username: a.UsernamePrefix + user.Name,
+		UID:      user.Key,
+		Groups:   groups,
+	}
+
+	a.putCached(key, info)
+
+	return info, nil
+}
+
+// ServeHTTP handles TokenReview request
+func (a *Authenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	review := &TokenReview{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(review)
+
+	if err != nil {
+		http.Error(w, "Can't decode TokenReview: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if review.APIVersion != API_VERSION || review.Kind != KIND {
+		http.Error(w, "Unsupported object "+review.APIVersion+"/"+review.Kind, http.StatusBadRequest)
+		return
+	}
+
+	token := review.Spec.Token
+	statusCode := http.StatusOK
+
+	review.Spec = TokenReviewSpec{Audiences: review.Spec.Audiences}
+	review.Status = TokenReviewStatus{}
+
+	var info *UserInfo
+
+	audiences, err := a.getAudiences(review.Spec.Audiences)
+
+	if err == nil {
+		info, err = a.Review(r.Context(), token)
+	}
+
+	switch {
+	case err == nil:
+		review.Status.Authenticated = true
+		review.Status.User = info
+		review.Status.Audiences = audiences
+	case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrInactiveUser),
+		errors.Is(err, ErrInvalidAudience):
+		review.Status.Error = err.Error()
+	default:
+		// Non-2xx responses aren't cached by API server, so transient
+		// Crowd errors don't lock users out
+		review.Status.Error = "Crowd is unavailable"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	json.NewEncoder(w).Encode(review)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// authenticate authenticates token using SSO session validation or login
+func (a *Authenticator) authenticate(api *crowd.API, token string) (*crowd.User, error) {
+	userName, password, isPassword := strings.Cut(token, ":")
+
+	if isPassword {
+		if !a.PasswordTokens || userName == "" || password == "" {
+			return nil, ErrInvalidToken
+		}
+
+		user, err := api.Login(userName, password)
+
+		if errors.Is(err, crowd.ErrInvalidCredentials) {
+			return nil, ErrInvalidToken
+		}
+
+		return user, err
+	}
+
+	session, err := api.ValidateSession(token, a.ValidationFactors...)
+
+	switch {
+	case errors.Is(err, crowd.ErrInvalidToken):
+		return nil, ErrInvalidToken
+	case err != nil:
+		return nil, err
+	case session.User == nil:
+		return nil, ErrInvalidToken
+	}
+
+	return session.User, nil
+}
+
+// fetchGroups returns names of groups exposed to Kubernetes
+func (a *Authenticator) fetchGroups(api *crowd.API, userName string) ([]string, error) {
+	groups, err := crowd.FetchAll(func(opts crowd.ListingOptions) ([]*crowd.Group, error) {
+		return api.GetUserNestedGroups(userName, opts)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	prefixLen := len(a.GroupPrefix)
+
+	for _, g := range groups {
+		// Crowd group names are case-insensitive
+		if len(g.Name) < prefixLen || !strings.EqualFold(g.Name[:prefixLen], a.GroupPrefix) {
+			continue
+		}
+
+		if a.TrimGroupPrefix {
+			result = append(result, g.Name[prefixLen:])
+		} else {
+			result = append(result, g.Name)
+		}
+	}
+
+	return result, nil
+}
+
+// getAudiences returns requested audiences token is valid for
+func (a *Authenticator) getAudiences(requested []string) ([]string, error) {
+	if len(requested) == 0 || len(a.Audiences) == 0 {
+		return nil, nil
+	}
+
+	var result []string
+
+	for _, audience := range requested {
+		if slices.Contains(a.Audiences, audience) {
+			result = append(result, audience)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, ErrInvalidAudience
+	}
+
+	return result, nil
+}
+
+// getCacheKey returns salted hash of token
+func (a *Authenticator) getCacheKey(token string) [32]byte {
+	hasher := sha256.New()
+	hasher.Write(a.salt)
+	hasher.Write([]byte(token))
+
+	var key [32]byte
+
+	hasher.Sum(key[:0])
+
+	return key
+}
+
+// getCached returns cached review result
+func (a *Authenticator) getCached(key [32]byte) *UserInfo {
+	if a.CacheTTL <= 0 {
+		return nil
+	}
+
+	info, ok := a.cache.Get(key)
+
+	if a.OnCacheLookup != nil {
+		a.OnCacheLookup(ok)
+	}
+
+	return info
+}
+
+// putCached adds review result to cache
+func (a *Authenticator) putCached(key [32]byte, info *UserInfo) {
+	if a.CacheTTL <= 0 {
+		return
+	}
+
+	a.cache.Put(key, info, time.Now().Add(a.CacheTTL))
+}
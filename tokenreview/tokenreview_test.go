@@ -0,0 +1,241 @@
+package tokenreview
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+	"github.com/essentialkaos/go-crowd/v3/internal/crowdtest"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TokenReviewSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&TokenReviewSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *TokenReviewSuite) TestHandler(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	a := New(api)
+	a.GroupPrefix = "k8s-"
+
+	w, review := serve(a, `{
+		"apiVersion": "authentication.k8s.io/v1",
+		"kind": "TokenReview",
+		"spec": {"token": "token1", "audiences": ["https://k8s.domain.com"]}
+	}`)
+
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/json")
+	c.Assert(review.Spec.Token, Equals, "")
+	c.Assert(review.Status.Authenticated, Equals, true)
+	c.Assert(review.Status.Audiences, IsNil)
+	c.Assert(review.Status.User, DeepEquals, &UserInfo{
+		Username: "john", Groups: []string{"K8S-viewers", "k8s-admins"},
+	})
+
+	a.Audiences = []string{"https://k8s.domain.com", "https://api.domain.com"}
+
+	_, review = serve(a, `{
+		"apiVersion": "authentication.k8s.io/v1",
+		"kind": "TokenReview",
+		"spec": {"token": "token1", "audiences": ["https://k8s.domain.com", "https://unknown.domain.com"]}
+	}`)
+
+	c.Assert(review.Status.Authenticated, Equals, true)
+	c.Assert(review.Status.Audiences, DeepEquals, []string{"https://k8s.domain.com"})
+
+	_, review = serve(a, `{
+		"apiVersion": "authentication.k8s.io/v1",
+		"kind": "TokenReview",
+		"spec": {"token": "token1", "audiences": ["https://unknown.domain.com"]}
+	}`)
+
+	c.Assert(review.Status.Authenticated, Equals, false)
+	c.Assert(review.Status.User, IsNil)
+	c.Assert(review.Status.Error, Equals, ErrInvalidAudience.Error())
+
+	a.Audiences = nil
+
+	// Password tokens are disabled by default
+	_, review = serve(a, newReview("mary:test1234"))
+	c.Assert(review.Status.Authenticated, Equals, false)
+
+	a.PasswordTokens = true
+
+	_, review = serve(a, newReview("mary:test1234"))
+	c.Assert(review.Status.Authenticated, Equals, true)
+	c.Assert(review.Status.User.Groups, DeepEquals, []string{"K8S-viewers"})
+
+	for _, token := range []string{"unknown", "mary:wrong", ":test", "", "expired"} {
+		w, review = serve(a, newReview(token))
+		c.Assert(w.Code, Equals, 200)
+		c.Assert(review.Status.Authenticated, Equals, false)
+		c.Assert(review.Status.User, IsNil)
+		c.Assert(review.Status.Error, Equals, ErrInvalidToken.Error())
+	}
+
+	w, _ = serve(a, `{"apiVersion": "authentication.k8s.io/v1beta1", "kind": "TokenReview"}`)
+	c.Assert(w.Code, Equals, 400)
+
+	w, _ = serve(a, `{`)
+	c.Assert(w.Code, Equals, 400)
+
+	w = httptest.NewRecorder()
+	a.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	c.Assert(w.Code, Equals, 405)
+	c.Assert(w.Header().Get("Allow"), Equals, "POST")
+}
+
+func (s *TokenReviewSuite) TestReview(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	a := New(api)
+	a.GroupPrefix = "k8s-"
+	a.TrimGroupPrefix = true
+	a.UsernamePrefix = "crowd:"
+	a.PasswordTokens = true
+	a.CacheTTL = 0
+
+	info, err := a.Review(context.Background(), "bob:test1234")
+
+	c.Assert(err, IsNil)
+	c.Assert(info, DeepEquals, &UserInfo{Username: "crowd:bob", Groups: []string{}})
+
+	// Lowercase of Kelvin sign is shorter than the sign itself
+	srv.AddGroup(&crowdtest.Group{Name: "\u212A8s-ops", Users: []string{"john"}})
+
+	info, err = a.Review(context.Background(), "john:test1234")
+
+	c.Assert(err, IsNil)
+	c.Assert(info.Groups, DeepEquals, []string{"viewers", "admins"})
+
+	_, err = a.Review(context.Background(), "alice:test1234")
+	c.Assert(err, Equals, ErrInvalidToken)
+
+	srv.AddUser(&crowdtest.User{Name: "bob", Password: "test1234"})
+	srv.AddSession(&crowdtest.Session{Token: "token2", User: "bob", Expiry: time.Now().Add(time.Hour)})
+
+	_, err = a.Review(context.Background(), "token2")
+	c.Assert(err, Equals, ErrInactiveUser)
+
+	a.ValidationFactors = []*crowd.ValidationFactor{
+		{Name: crowd.FACTOR_REMOTE_ADDRESS, Value: "10.0.0.1"},
+	}
+
+	_, err = a.Review(context.Background(), "token3")
+	c.Assert(err, IsNil)
+}
+
+func (s *TokenReviewSuite) TestCache(c *C) {
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	var lookups []bool
+
+	a := New(api)
+	a.PasswordTokens = true
+	a.OnCacheLookup = func(hit bool) { lookups = append(lookups, hit) }
+
+	a.Review(context.Background(), "token1")
+	a.Review(context.Background(), "token1")
+	c.Assert(srv.Requests(), HasLen, 2)
+	c.Assert(lookups, DeepEquals, []bool{false, true})
+
+	_, err := a.Review(context.Background(), "unknown")
+	c.Assert(err, Equals, ErrInvalidToken)
+
+	a.Review(context.Background(), "mary:test1234")
+	c.Assert(a.cache.Len(), Equals, 2)
+
+	a.putCached(a.getCacheKey("test"), &UserInfo{})
+	c.Assert(a.cache.Len(), Equals, 3)
+}
+
+func (s *TokenReviewSuite) TestErrors(c *C) {
+	api, _ := crowd.NewAPI("http://127.0.0.1:1/", "app", "test")
+
+	a := New(api)
+	a.PasswordTokens = true
+
+	for _, token := range []string{"token1", "john:test1234"} {
+		w, review := serve(a, newReview(token))
+		c.Assert(w.Code, Equals, 503)
+		c.Assert(review.Status.Authenticated, Equals, false)
+		c.Assert(review.Status.Error, Equals, "Crowd is unavailable")
+	}
+
+	srv, api := newTestServer()
+	defer srv.Close()
+
+	a = New(api)
+	srv.AddSession(&crowdtest.Session{Token: "token4", User: "unknown", Expiry: time.Now().Add(time.Hour)})
+
+	_, err := a.Review(context.Background(), "token4")
+	c.Assert(err, NotNil)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func newTestServer() (*crowdtest.Server, *crowd.API) {
+	srv := crowdtest.NewServer()
+
+	for _, name := range []string{"john", "bob", "mary"} {
+		srv.AddUser(&crowdtest.User{Name: name, Password: "test1234", IsActive: true})
+	}
+
+	srv.AddGroup(&crowdtest.Group{Name: "k8s-admins", Users: []string{"john"}})
+	srv.AddGroup(&crowdtest.Group{Name: "K8S-viewers", Groups: []string{"devs"}})
+	srv.AddGroup(&crowdtest.Group{Name: "devs", Users: []string{"john", "mary"}})
+
+	srv.AddSession(&crowdtest.Session{Token: "token1", User: "john", Expiry: time.Now().Add(time.Hour)})
+	srv.AddSession(&crowdtest.Session{Token: "expired", User: "john", Expiry: time.Now().Add(-time.Hour)})
+	srv.AddSession(&crowdtest.Session{
+		Token: "token3", User: "mary", RemoteAddress: "10.0.0.1", Expiry: time.Now().Add(time.Hour),
+	})
+
+	api, _ := crowd.NewAPI(srv.URL(), "app", "test")
+
+	return srv, api
+}
+
+func newReview(token string) string {
+	data, _ := json.Marshal(&TokenReview{
+		APIVersion: API_VERSION, Kind: KIND, Spec: TokenReviewSpec{Token: token},
+	})
+
+	return string(data)
+}
+
+func serve(h http.Handler, body string) (*httptest.ResponseRecorder, *TokenReview) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+
+	review := &TokenReview{}
+	json.Unmarshal(w.Body.Bytes(), review)
+
+	return w, review
+}
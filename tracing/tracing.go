@@ -0,0 +1,250 @@
+// Package tracing provides OpenTelemetry-style tracing for Crowd API client
+package tracing
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essentialkaos/go-crowd/v3"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Span attributes
+const (
+	ATTR_METHOD       = "http.request.method"
+	ATTR_ENDPOINT     = "crowd.endpoint"
+	ATTR_STATUS_CODE  = "http.response.status_code"
+	ATTR_ERROR_REASON = "crowd.error.reason"
+	ATTR_BODY_SIZE    = "http.response.body.size"
+	ATTR_ATTEMPTS     = "crowd.attempts"
+)
+
+// TraceparentHeader is name of W3C trace context header
+const TraceparentHeader = "traceparent"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// TraceID is trace identifier
+type TraceID [16]byte
+
+// SpanID is span identifier
+type SpanID [8]byte
+
+// SpanContext contains span identity propagated between services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Span contains info about single Crowd call
+type Span struct {
+	Name       string
+	Context    SpanContext
+	ParentID   SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]any
+	Err        error
+}
+
+// Exporter is interface for span exporters
+type Exporter interface {
+	// ExportSpan exports finished span
+	ExportSpan(span *Span)
+}
+
+// Tracer creates spans for Crowd calls
+type Tracer struct {
+	exporter Exporter
+}
+
+// MemoryExporter is exporter which stores spans in memory
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ctxKey is type for context keys
+type ctxKey struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ErrInvalidTraceparent is returned if traceparent header value is malformed
+var ErrInvalidTraceparent = errors.New("Invalid traceparent value")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewTracer creates new tracer with given exporter
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// ContextWithSpanContext returns copy of context with given span context. Spans
+// created for Crowd calls with this context will be children of this span.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, ctxKey{}, sc)
+}
+
+// SpanContextFromContext returns span context from context
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+
+	sc, ok := ctx.Value(ctxKey{}).(SpanContext)
+
+	return sc, ok
+}
+
+// ParseTraceparent parses W3C traceparent header value
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+
+	_, err1 := hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	_, err2 := hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	_, err3 := hex.Decode(flags[:], []byte(parts[3]))
+
+	if err1 != nil || err2 != nil || err3 != nil || !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// IsValid returns true if span context has non-zero trace and span IDs
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent returns W3C traceparent header value for span context
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// String returns hex representation of trace ID
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// String returns hex representation of span ID
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Instrument adds tracing interceptor to given API instance
+func (t *Tracer) Instrument(api *crowd.API) {
+	api.Interceptors = append([]crowd.Interceptor{t.Interceptor}, api.Interceptors...)
+}
+
+// Interceptor is API interceptor which creates span for every call
+func (t *Tracer) Interceptor(call *crowd.Call, next crowd.Invoker) error {
+	span := &Span{
+		Name:  "crowd " + call.Endpoint(),
+		Start: time.Now(),
+		Attributes: map[string]any{
+			ATTR_METHOD:   call.Method,
+			ATTR_ENDPOINT: call.Endpoint(),
+		},
+	}
+
+	parent, ok := SpanContextFromContext(call.Context)
+
+	if ok && parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.ParentID = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = true
+	}
+
+	rand.Read(span.Context.SpanID[:])
+
+	call.Header.Set(TraceparentHeader, span.Context.Traceparent())
+
+	err := next(call)
+
+	span.End = time.Now()
+	span.Err = err
+	span.Attributes[ATTR_ATTEMPTS] = call.Attempts
+
+	if err == nil {
+		span.Attributes[ATTR_STATUS_CODE] = call.StatusCode
+		span.Attributes[ATTR_BODY_SIZE] = len(call.ResponseBody)
+
+		if call.StatusCode >= 400 {
+			reason := crowd.ErrorReason(call.ResponseBody)
+
+			if reason != "" {
+				span.Attributes[ATTR_ERROR_REASON] = reason
+			}
+		}
+	}
+
+	if t.exporter != nil && span.Context.Sampled {
+		t.exporter.ExportSpan(span)
+	}
+
+	return err
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ExportSpan stores span in memory
+func (e *MemoryExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans returns all exported spans
+func (e *MemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset removes all stored spans
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
@@ -0,0 +1,107 @@
+package tracing
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2024 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/essentialkaos/go-crowd/v3"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TracingSuite struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var _ = Suite(&TracingSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *TracingSuite) TestTracer(c *C) {
+	var traceparent string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(TraceparentHeader)
+
+		if r.URL.Query().Get("username") == "bob" {
+			w.WriteHeader(404)
+			w.Write([]byte(`<error><reason>USER_NOT_FOUND</reason><message>User bob does not exist</message></error>`))
+			return
+		}
+
+		w.Write([]byte(`<user name="john"><active>true</active></user>`))
+	}))
+
+	defer srv.Close()
+
+	exporter := &MemoryExporter{}
+
+	api, _ := crowd.NewAPI(srv.URL+"/", "app", "test")
+	NewTracer(exporter).Instrument(api)
+
+	parent, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	c.Assert(err, IsNil)
+
+	ctx := ContextWithSpanContext(context.Background(), parent)
+
+	_, err = api.WithContext(ctx).GetUser("john", true)
+	c.Assert(err, IsNil)
+	_, err = api.GetUserAttributes("bob")
+	c.Assert(err, NotNil)
+
+	spans := exporter.Spans()
+
+	c.Assert(spans, HasLen, 2)
+
+	c.Assert(spans[0].Name, Equals, "crowd rest/usermanagement/1/user")
+	c.Assert(spans[0].Context.TraceID, Equals, parent.TraceID)
+	c.Assert(spans[0].ParentID, Equals, parent.SpanID)
+	c.Assert(spans[0].Attributes[ATTR_ENDPOINT], Equals, "rest/usermanagement/1/user")
+	c.Assert(spans[0].Attributes[ATTR_STATUS_CODE], Equals, 200)
+	c.Assert(spans[0].Attributes[ATTR_BODY_SIZE], Equals, 46)
+	c.Assert(spans[0].End.After(spans[0].Start), Equals, true)
+
+	c.Assert(spans[1].Attributes[ATTR_ENDPOINT], Equals, "rest/usermanagement/1/user/attribute")
+	c.Assert(spans[1].Attributes[ATTR_STATUS_CODE], Equals, 404)
+	c.Assert(spans[1].Attributes[ATTR_ERROR_REASON], Equals, "USER_NOT_FOUND")
+	c.Assert(spans[1].ParentID, Equals, SpanID{})
+	c.Assert(traceparent, Equals, spans[1].Context.Traceparent())
+
+	exporter.Reset()
+	c.Assert(exporter.Spans(), HasLen, 0)
+}
+
+func (s *TracingSuite) TestTraceparent(c *C) {
+	for _, v := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473X-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(v)
+		c.Assert(err, Equals, ErrInvalidTraceparent, Commentf("value: %q", v))
+	}
+
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+	c.Assert(err, IsNil)
+	c.Assert(sc.Sampled, Equals, false)
+	c.Assert(sc.Traceparent(), Equals, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+	_, ok := SpanContextFromContext(nil)
+	c.Assert(ok, Equals, false)
+}